@@ -0,0 +1,227 @@
+// +build !ao_noop
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+)
+
+// activeSpan is an entry of the active span registry.
+type activeSpan struct {
+	s     *span  // for identifying the entry when the span ends
+	v     Span   // returned by ActiveSpan
+	scope *Scope // the innermost scope of the goroutine when the span starts, if any
+}
+
+// activeSpans maps the goroutine IDs to the stacks of the spans started, and
+// not ended yet, by the goroutines. It's only populated if the registry is
+// enabled by the configuration, or with the spans started in a scope from a
+// context without a span.
+var activeSpans = struct {
+	sync.Mutex
+	m map[uint64][]activeSpan
+}{m: make(map[uint64][]activeSpan)}
+
+var goroutinePrefix = []byte("goroutine ")
+
+// goroutineID returns the ID of the current goroutine parsed from the header of
+// its stack trace, e.g., "goroutine 42 [running]:". It's not cheap, which is
+// why the registry is opt-in.
+func goroutineID() uint64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, goroutinePrefix)
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
+
+// registerActiveSpan pushes the span onto the stack of the current goroutine,
+// if the registry is enabled. Otherwise the goroutine ID is not looked up, even
+// if a scope is open, so the spans started from a span context don't pay for it.
+func registerActiveSpan(s *span, v Span) {
+	if !config.GetActiveSpanRegistry() {
+		return
+	}
+	gid := goroutineID()
+	var scope *Scope
+	if atomic.LoadInt32(&openScopes) != 0 {
+		scope = innermostScope(gid)
+	}
+	pushActiveSpan(gid, activeSpan{s: s, v: v, scope: scope})
+}
+
+// registerScopeSpan pushes a span started in the scope from a context without a
+// span onto the stack of the goroutine gid, so it becomes the parent of the next
+// such spans. It's done already if the registry is enabled.
+func registerScopeSpan(v Span, gid uint64, scope *Scope) {
+	if l, ok := v.(*layerSpan); ok && l.gid == 0 {
+		pushActiveSpan(gid, activeSpan{s: &l.span, v: l, scope: scope})
+	}
+}
+
+func pushActiveSpan(gid uint64, as activeSpan) {
+	as.s.gid = gid
+	activeSpans.Lock()
+	activeSpans.m[gid] = append(activeSpans.m[gid], as)
+	activeSpans.Unlock()
+}
+
+// unregisterActiveSpan removes the span from the stack of the goroutine which
+// started it. The span may be ended by another goroutine.
+func unregisterActiveSpan(s *span) {
+	if s.gid == 0 {
+		return
+	}
+	activeSpans.Lock()
+	defer activeSpans.Unlock()
+
+	stack := activeSpans.m[s.gid]
+	for i := len(stack) - 1; i >= 0; i-- {
+		if stack[i].s == s {
+			stack = append(stack[:i], stack[i+1:]...)
+			break
+		}
+	}
+	if len(stack) == 0 {
+		delete(activeSpans.m, s.gid)
+	} else {
+		activeSpans.m[s.gid] = stack
+	}
+	s.gid = 0
+}
+
+// ActiveSpan returns the span most recently started, and not ended yet, by the
+// current goroutine. It's meant for the legacy code which has no access to the
+// context the span is bound to. A null span is returned if there is no active
+// span.
+//
+// The lookup only works with APPOPTICS_ACTIVE_SPAN_REGISTRY enabled, as tracking
+// the spans of each goroutine adds overhead to every span. Note that the spans
+// started by a goroutine are not visible to the goroutines it spawns.
+func ActiveSpan() Span {
+	if !config.GetActiveSpanRegistry() && atomic.LoadInt32(&openScopes) == 0 {
+		return nullSpan{}
+	}
+	gid := goroutineID()
+	scope := innermostScope(gid)
+
+	activeSpans.Lock()
+	defer activeSpans.Unlock()
+	if stack := activeSpans.m[gid]; len(stack) != 0 {
+		return stack[len(stack)-1].v
+	}
+	if scope != nil {
+		return scope.span
+	}
+	return nullSpan{}
+}
+
+// Scope makes a span the implicit parent of the spans started by a goroutine,
+// see StartScope.
+type Scope struct {
+	gid  uint64
+	span Span
+}
+
+// scopes maps the goroutine IDs to the stacks of the scopes opened by them.
+var scopes = struct {
+	sync.Mutex
+	m map[uint64][]*Scope
+}{m: make(map[uint64][]*Scope)}
+
+// openScopes is the number of the scopes not ended yet, which spares looking up
+// the goroutine ID for every span if there is none.
+var openScopes int32
+
+// StartScope starts a scope of the span bound to ctx on the current goroutine.
+// Until the scope ends, the spans which the goroutine starts from a context
+// without a span, e.g. context.Background(), become the children of the span
+// most recently started that way in the scope and not ended yet, or the span of
+// the scope if there is none. It eases the migration of the code which doesn't
+// pass the contexts down the call chain:
+//   defer ao.StartScope(ctx).End()
+//   ...
+//   s, _ := ao.BeginSpan(context.Background(), "legacy") // a child of the span of ctx
+// The scope must be ended by the goroutine which starts it. The goroutines it
+// spawns are not in the scope.
+func StartScope(ctx context.Context) *Scope {
+	l, ok := fromContext(ctx)
+	if !ok || !l.ok() {
+		return &Scope{}
+	}
+	sc := &Scope{gid: goroutineID(), span: l}
+	scopes.Lock()
+	scopes.m[sc.gid] = append(scopes.m[sc.gid], sc)
+	scopes.Unlock()
+	atomic.AddInt32(&openScopes, 1)
+	return sc
+}
+
+// End ends the scope.
+func (sc *Scope) End() {
+	if sc.gid == 0 {
+		return
+	}
+	scopes.Lock()
+	defer scopes.Unlock()
+	stack := scopes.m[sc.gid]
+	for i := len(stack) - 1; i >= 0; i-- {
+		if stack[i] == sc {
+			stack = append(stack[:i], stack[i+1:]...)
+			atomic.AddInt32(&openScopes, -1)
+			break
+		}
+	}
+	if len(stack) == 0 {
+		delete(scopes.m, sc.gid)
+	} else {
+		scopes.m[sc.gid] = stack
+	}
+	sc.gid = 0
+}
+
+// innermostScope returns the scope most recently started by the goroutine, or
+// nil if it's not in any scope.
+func innermostScope(gid uint64) *Scope {
+	scopes.Lock()
+	defer scopes.Unlock()
+	if stack := scopes.m[gid]; len(stack) != 0 {
+		return stack[len(stack)-1]
+	}
+	return nil
+}
+
+// scopeParent returns the parent of a span started by the current goroutine
+// without a span in its context, along with the goroutine ID and its innermost
+// scope, or false if the goroutine is not in a scope.
+func scopeParent() (parent Span, gid uint64, scope *Scope, ok bool) {
+	if atomic.LoadInt32(&openScopes) == 0 {
+		return nil, 0, nil, false
+	}
+	gid = goroutineID()
+	if scope = innermostScope(gid); scope == nil {
+		return nil, 0, nil, false
+	}
+
+	activeSpans.Lock()
+	defer activeSpans.Unlock()
+	stack := activeSpans.m[gid]
+	for i := len(stack) - 1; i >= 0; i-- {
+		if stack[i].scope == scope {
+			return stack[i].v, gid, scope, true
+		}
+	}
+	return scope.span, gid, scope, true
+}
@@ -0,0 +1,118 @@
+// +build !ao_noop
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActiveSpan(t *testing.T) {
+	r := reporter.SetTestReporter()
+	defer r.Close(0)
+
+	// disabled by default
+	ctx := NewContext(context.Background(), NewTrace("baseSpan"))
+	assert.Equal(t, nullSpan{}, ActiveSpan())
+	EndTrace(ctx)
+
+	os.Setenv("APPOPTICS_ACTIVE_SPAN_REGISTRY", "true")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_ACTIVE_SPAN_REGISTRY")
+		config.Load()
+	}()
+
+	tr := NewTrace("baseSpan")
+	ctx = NewContext(context.Background(), tr)
+	assert.Equal(t, tr, ActiveSpan())
+
+	l, _ := BeginSpan(ctx, "child")
+	assert.Equal(t, l, ActiveSpan())
+
+	// the spans of other goroutines are not visible
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		assert.Equal(t, nullSpan{}, ActiveSpan())
+		s, _ := BeginSpan(ctx, "async")
+		assert.Equal(t, s, ActiveSpan())
+		s.End()
+	}()
+	wg.Wait()
+
+	l.End()
+	assert.Equal(t, tr, ActiveSpan())
+	tr.End()
+	assert.Equal(t, nullSpan{}, ActiveSpan())
+
+	activeSpans.Lock()
+	assert.Empty(t, activeSpans.m)
+	activeSpans.Unlock()
+}
+
+func TestScope(t *testing.T) {
+	r := reporter.SetTestReporter()
+
+	tr := NewTrace("scope")
+	ctx := NewContext(context.Background(), tr)
+	sc := StartScope(ctx)
+	assert.Equal(t, tr, ActiveSpan())
+
+	// spans started without a span context are parented by the scope
+	l1, _ := BeginSpan(context.Background(), "L1")
+	l2, _ := BeginSpan(context.Background(), "L2")
+	l2.End()
+	l1.End()
+
+	// the goroutine is not looked up for the spans started from a span context
+	l3, _ := BeginSpan(ctx, "L3")
+	assert.Zero(t, l3.(*layerSpan).gid)
+	l3.End()
+
+	// other goroutines are not in the scope
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s, _ := BeginSpan(context.Background(), "async")
+		assert.False(t, s.IsReporting())
+	}()
+	wg.Wait()
+
+	sc.End()
+	sc.End() // no-op
+	orphan, _ := BeginSpan(context.Background(), "orphan")
+	assert.False(t, orphan.IsReporting())
+	tr.End()
+
+	r.Close(8)
+	g.AssertGraph(t, r.EventBufs, 8, g.AssertNodeMap{
+		{"scope", "entry"}: {},
+		{"L1", "entry"}:    {Edges: g.Edges{{"scope", "entry"}}},
+		{"L2", "entry"}:    {Edges: g.Edges{{"L1", "entry"}}},
+		{"L2", "exit"}:     {Edges: g.Edges{{"L2", "entry"}}},
+		{"L1", "exit"}:     {Edges: g.Edges{{"L2", "exit"}, {"L1", "entry"}}},
+		{"L3", "entry"}:    {Edges: g.Edges{{"scope", "entry"}}},
+		{"L3", "exit"}:     {Edges: g.Edges{{"L3", "entry"}}},
+		{"scope", "exit"}:  {Edges: g.Edges{{"L1", "exit"}, {"L3", "exit"}, {"scope", "entry"}}},
+	})
+
+	scopes.Lock()
+	assert.Empty(t, scopes.m)
+	scopes.Unlock()
+	activeSpans.Lock()
+	assert.Empty(t, activeSpans.m)
+	activeSpans.Unlock()
+	assert.EqualValues(t, 0, openScopes)
+}
@@ -1,3 +1,5 @@
+// +build !ao_noop
+
 // Copyright (C) 2016 Librato, Inc. All rights reserved.
 
 package ao
@@ -37,6 +39,13 @@ func flushAgent() error {
 // for successful shutdown and or error when the context is canceled or the agent
 // has already been closed before.
 //
+// The events queued are sent and a final metrics message is reported before the
+// connections to the collector are closed, so short-lived processes should call
+// it before exiting to keep the tail of their traces:
+//   ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+//   defer cancel()
+//   ao.Shutdown(ctx)
+//
 // This function should be called only once.
 func Shutdown(ctx context.Context) error {
 	return reporter.Shutdown(ctx)
@@ -72,4 +81,110 @@ func SetLogOutput(w io.Writer) {
 // SetServiceKey sets the service key of the agent
 func SetServiceKey(key string) {
 	reporter.SetServiceKey(key)
-}
\ No newline at end of file
+}
+
+// ReloadConfig reloads the configuration from the config file and the
+// environment variables without restarting the process. The debug level, the
+// sampling config and the transaction filters take effect immediately, while
+// the options read at startup, e.g., the collector, are not changed. It's also
+// done on SIGHUP if APPOPTICS_RELOAD_ON_SIGHUP is enabled.
+func ReloadConfig() {
+	reporter.ReloadConfig()
+}
+
+// IDGenerator generates the task IDs (shared by all the events of a trace) and
+// the op IDs (unique to each event) of the trace context. The task ID is 20 bytes
+// long and the op ID is 8 bytes long. The implementation must be safe for
+// concurrent use.
+type IDGenerator = reporter.IDGenerator
+
+// SetIDGenerator replaces the trace ID generator of the agent, e.g., to produce
+// deterministic IDs in testing. Passing nil restores the default generator.
+// All-zero task IDs are rejected and regenerated.
+func SetIDGenerator(g IDGenerator) {
+	reporter.SetIDGenerator(g)
+}
+
+// TraceIDCollisions returns the number of trace ID collisions detected so far. The
+// detection is only available with APPOPTICS_HARDENED_TRACE_IDS enabled.
+func TraceIDCollisions() uint64 {
+	return reporter.TaskIDCollisions()
+}
+
+// Pressure indicates how saturated the event queue of the agent is.
+type Pressure = reporter.PressureLevel
+
+// The pressure levels returned by PressureLevel.
+const (
+	// PressureNormal means the events are reported as usual.
+	PressureNormal = reporter.PressureNormal
+	// PressureElevated means the event queue is filling up.
+	PressureElevated = reporter.PressureElevated
+	// PressureHigh means the event queue is nearly full and new events are
+	// likely to be dropped.
+	PressureHigh = reporter.PressureHigh
+)
+
+// PressureLevel returns the current pressure level of the event queue. The
+// instrumentation may skip the optional events, e.g., the Info events with
+// diagnostic details, when the level is above PressureNormal, rather than having
+// them dropped when the queue is full:
+//   if ao.PressureLevel() == ao.PressureNormal {
+//       span.Info("CacheStats", stats)
+//   }
+func PressureLevel() Pressure {
+	return reporter.GetPressureLevel()
+}
+
+// ReportAnnotation reports a standalone event outside of any trace, e.g., to
+// mark a deployment or a configuration change alongside the service metrics:
+//   ao.ReportAnnotation("Deploy", ao.KVMap{"Version": version, "Revision": rev})
+func ReportAnnotation(title string, kvs KVMap) error {
+	return reporter.ReportAnnotation(title, kvs)
+}
+
+// Settings is a snapshot of the sampling settings in effect, merged from the
+// settings of the collector and the local configuration.
+type Settings = reporter.Settings
+
+// CurrentSettings returns the sampling settings the agent is currently using,
+// e.g., to be shown on an admin page or asserted in tests. The second return
+// value is false if the agent hasn't received any settings from the collector.
+func CurrentSettings() (Settings, bool) {
+	return reporter.CurrentSettings()
+}
+
+// Reporter is the transport of the events of a custom reporter, e.g., to Kafka
+// or Fluentd. The events are encoded in BSON, or the format selected by the
+// reporter encoding (APPOPTICS_REPORTER_ENCODING), e.g. json.
+type Reporter = reporter.CustomReporter
+
+// ReporterFactory creates the transport of a custom reporter.
+type ReporterFactory = reporter.ReporterFactory
+
+// RegisterReporter registers a custom reporter, which is used if the reporter
+// type (APPOPTICS_REPORTER) is set to its name. As the agent starts with the
+// default reporter before the registration, it should be called at the program
+// startup before any trace is started:
+//   func init() {
+//       ao.RegisterReporter("kafka", newKafkaReporter)
+//   }
+// The custom reporters sample every request and don't report any metrics.
+func RegisterReporter(name string, factory ReporterFactory) error {
+	return reporter.RegisterReporter(name, factory)
+}
+
+// Encoder converts the events, which are built in BSON, into the format of a
+// custom reporter encoding. It must be safe for concurrent use.
+type Encoder = reporter.Encoder
+
+// EncoderFunc is an Encoder by a function.
+type EncoderFunc = reporter.EncoderFunc
+
+// RegisterEncoder adds a reporter encoding, e.g., protobuf or msgpack, which is
+// used by the UDP and the custom reporters if the reporter encoding
+// (APPOPTICS_REPORTER_ENCODING) is set to its name. Like RegisterReporter, it
+// should be called at the program startup.
+func RegisterEncoder(name string, e Encoder) {
+	reporter.RegisterEncoder(name, e)
+}
@@ -1,15 +1,21 @@
+// +build !ao_noop
+
 // Copyright (C) 2016 Librato, Inc. All rights reserved.
 
 package ao
 
 import (
 	"context"
+	"encoding/json"
+	"expvar"
 	"os"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/utils"
 	"github.com/stretchr/testify/assert"
 )
@@ -38,6 +44,29 @@ func TestShutdown(t *testing.T) {
 	assert.False(t, WaitForReady(ctx))
 }
 
+func TestReporterStatus(t *testing.T) {
+	r := reporter.SetTestReporter()
+	defer r.Close(0)
+
+	s := ReporterStatus()
+	assert.Equal(t, "test", s.Reporter)
+	assert.Equal(t, ConnectivityUnknown, s.Connectivity)
+
+	// no panic on the duplicate name by the concurrent calls
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			PublishExpvar()
+		}()
+	}
+	wg.Wait()
+	var v ReporterHealth
+	assert.Nil(t, json.Unmarshal([]byte(expvar.Get(ExpvarName).String()), &v))
+	assert.Equal(t, "test", v.Reporter)
+}
+
 func TestSetLogOutput(t *testing.T) {
 	oldLevel := GetLogLevel()
 	_ = SetLogLevel("DEBUG")
@@ -0,0 +1,65 @@
+// +build !ao_noop
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const (
+	// the time in microseconds spent in each layer of the trace, as a JSON
+	// object, reported by the exit event of the root span
+	keyBreakdown = "Breakdown"
+	// the time in microseconds spent in the root span itself, rather than in
+	// any of its child spans, reported by the exit event of the root span
+	keySelfTime = "SelfTime"
+)
+
+// breakdown accumulates the self time of the spans of a trace by layer. The
+// self time of a span is its duration less that of its direct children, so
+// the nested spans are not counted twice.
+type breakdown struct {
+	sync.Mutex
+	layers map[string]time.Duration
+}
+
+func newBreakdown() *breakdown {
+	return &breakdown{layers: make(map[string]time.Duration)}
+}
+
+func (b *breakdown) add(layer string, d time.Duration) {
+	if b == nil {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+	b.layers[layer] += d
+}
+
+// kvs returns the Breakdown and SelfTime KVs of the root span.
+func (b *breakdown) kvs(self time.Duration) []interface{} {
+	b.Lock()
+	defer b.Unlock()
+	us := make(map[string]int64, len(b.layers))
+	for layer, d := range b.layers {
+		us[layer] = int64(d / time.Microsecond)
+	}
+	kvs := []interface{}{keySelfTime, int64(self / time.Microsecond)}
+	if js, err := json.Marshal(us); err == nil {
+		kvs = append(kvs, keyBreakdown, string(js))
+	}
+	return kvs
+}
+
+// selfTime returns the part of the duration d not spent in the child spans. It
+// is zero if the async children overlap for longer than d.
+func selfTime(d, children time.Duration) time.Duration {
+	if children > d {
+		return 0
+	}
+	return d - children
+}
@@ -0,0 +1,61 @@
+// +build !ao_noop
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTraceBreakdown(t *testing.T) {
+	r := reporter.SetTestReporter()
+	ctx := NewContext(context.Background(), NewTrace("breakdown"))
+	s, sctx := BeginSpan(ctx, "handler")
+	db, _ := BeginSpan(sctx, "db")
+	time.Sleep(20 * time.Millisecond)
+	db.End()
+	cache, _ := BeginSpan(sctx, "cache")
+	time.Sleep(20 * time.Millisecond)
+	cache.End()
+	s.End()
+	EndTrace(ctx)
+	r.Close(8)
+
+	g.AssertGraph(t, r.EventBufs, 8, g.AssertNodeMap{
+		{"breakdown", "entry"}: {},
+		{"handler", "entry"}:   {Edges: g.Edges{{"breakdown", "entry"}}},
+		{"db", "entry"}:        {Edges: g.Edges{{"handler", "entry"}}},
+		{"db", "exit"}: {Edges: g.Edges{{"db", "entry"}}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, keyBreakdown)
+		}},
+		{"cache", "entry"}: {Edges: g.Edges{{"handler", "entry"}}},
+		{"cache", "exit"}:  {Edges: g.Edges{{"cache", "entry"}}},
+		{"handler", "exit"}: {Edges: g.Edges{{"db", "exit"}, {"cache", "exit"}, {"handler", "entry"}}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, keyBreakdown)
+		}},
+		{"breakdown", "exit"}: {Edges: g.Edges{{"handler", "exit"}, {"breakdown", "entry"}}, Callback: func(n g.Node) {
+			var layers map[string]int64
+			assert.NoError(t, json.Unmarshal([]byte(n.Map[keyBreakdown].(string)), &layers))
+			assert.Len(t, layers, 3)
+			// the nested spans are not counted in the time of the handler
+			assert.True(t, layers["db"] >= int64(20*time.Millisecond/time.Microsecond))
+			assert.True(t, layers["cache"] >= int64(20*time.Millisecond/time.Microsecond))
+			assert.True(t, layers["handler"] < int64(20*time.Millisecond/time.Microsecond))
+			assert.True(t, n.Map[keySelfTime].(int64) < int64(20*time.Millisecond/time.Microsecond))
+		}},
+	})
+}
+
+func TestSelfTime(t *testing.T) {
+	assert.Equal(t, 2*time.Second, selfTime(3*time.Second, time.Second))
+	// overlapping async children
+	assert.Equal(t, time.Duration(0), selfTime(time.Second, 3*time.Second))
+}
@@ -0,0 +1,82 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Command aorelay forwards the traces and metrics of the local processes to the
+// AppOptics collector over a single authenticated gRPC connection. It's meant to
+// run as a sidecar or a daemonset, next to the processes which report with the
+// UDP reporter, e.g., the very short-lived ones which don't live long enough to
+// connect to the collector themselves:
+//   APPOPTICS_SERVICE_KEY=<api token>:<service name> aorelay -listen 127.0.0.1:7831,unix:/var/run/ao.sock
+//   APPOPTICS_REPORTER=udp APPOPTICS_COLLECTOR_UDP=unix:/var/run/ao.sock ./app
+// The relay is configured like the agent, e.g., by APPOPTICS_SERVICE_KEY and
+// APPOPTICS_COLLECTOR, and requires the ssl reporter, which is the default.
+// The messages are not authenticated, so the UDP messages from outside of the
+// host are dropped unless the sender is in one of the networks of -allow, and
+// the Unix sockets are writable only by the user and the group of the relay.
+package main
+
+import (
+	"context"
+	"flag"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+)
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:7831",
+		"comma-separated UDP addresses or Unix datagram sockets (unix:/path) to listen on")
+	allow := flag.String("allow", "",
+		"comma-separated networks (CIDR) of the non-loopback UDP senders to accept the messages of")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 5*time.Second,
+		"how long to wait for the messages received to be sent when stopping")
+	flag.Parse()
+
+	var addrs []string
+	for _, addr := range strings.Split(*listen, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	var allowed []*net.IPNet
+	for _, cidr := range strings.Split(*allow, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Errorf("Invalid network to allow: %v", err)
+			os.Exit(1)
+		}
+		allowed = append(allowed, n)
+	}
+
+	relay, err := reporter.ListenRelay(addrs, allowed)
+	if err != nil {
+		log.Errorf("Failed to start the relay: %v", err)
+		os.Exit(1)
+	}
+	log.Warningf("AppOptics relay is listening on %v.", relay.Addrs())
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sig
+		relay.Close()
+	}()
+	relay.Serve()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := reporter.Shutdown(ctx); err != nil {
+		log.Warningf("Failed to flush the relay: %v", err)
+	}
+	stats := relay.Stats()
+	log.Warningf("AppOptics relay stopped, %d messages received, %d dropped.", stats.Received, stats.Dropped)
+}
@@ -1,3 +1,5 @@
+// +build !ao_noop
+
 // Copyright (C) 2016 Librato, Inc. All rights reserved.
 
 package ao
@@ -81,6 +83,11 @@ func Error(ctx context.Context, class, msg string) { runCtx(ctx, func(l Span) {
 // Err reports details error err (along with a stack trace) on the Span associated with the context ctx.
 func Err(ctx context.Context, err error) { runCtx(ctx, func(l Span) { l.Err(err) }) }
 
+// ErrorWithOpts reports an error with customized options on the Span associated with the context ctx.
+func ErrorWithOpts(ctx context.Context, opts ...ErrOpt) {
+	runCtx(ctx, func(l Span) { l.ErrorWithOpts(opts...) })
+}
+
 // MetadataString returns a representation of the Span's context for use with distributed
 // tracing (to create a remote child span). If the Span has ended, an empty string is returned.
 func MetadataString(ctx context.Context) string {
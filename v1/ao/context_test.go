@@ -1,3 +1,5 @@
+// +build !ao_noop
+
 // Copyright (C) 2016 Librato, Inc. All rights reserved.
 
 package ao
@@ -0,0 +1,51 @@
+// +build !ao_noop
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// the time left in microseconds before the deadline of the context which
+	// the span is started with, reported by the entry event
+	keyDeadlineRemaining = "DeadlineRemaining"
+	// why the context of the span is done when the span ends, reported by the
+	// exit event
+	keyContextDone = "ContextDone"
+)
+
+// the values of the ContextDone KV
+const (
+	ctxDoneCanceled         = "canceled"
+	ctxDoneDeadlineExceeded = "deadline_exceeded"
+)
+
+// deadlineKVs returns the DeadlineRemaining KV if ctx has a deadline.
+func deadlineKVs(ctx context.Context) []interface{} {
+	if ctx == nil {
+		return nil
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return nil
+	}
+	return []interface{}{keyDeadlineRemaining, int64(time.Until(deadline) / time.Microsecond)}
+}
+
+// contextDone tells why ctx is done, or returns an empty string if it's not.
+func contextDone(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	switch ctx.Err() {
+	case context.Canceled:
+		return ctxDoneCanceled
+	case context.DeadlineExceeded:
+		return ctxDoneDeadlineExceeded
+	}
+	return ""
+}
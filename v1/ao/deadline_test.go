@@ -0,0 +1,94 @@
+// +build !ao_noop
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/metrics"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpanContextDeadline(t *testing.T) {
+	r := reporter.SetTestReporter()
+	ctx := NewContext(context.Background(), NewTrace("deadline"))
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	s, _ := BeginSpan(ctx, "withDeadline")
+	cancel()
+	s.End()
+	s, _ = BeginSpan(ctx, "done")
+	s.End()
+	EndTrace(ctx)
+	r.Close(6)
+
+	g.AssertGraph(t, r.EventBufs, 6, g.AssertNodeMap{
+		{"deadline", "entry"}: {},
+		{"withDeadline", "entry"}: {Edges: g.Edges{{"deadline", "entry"}}, Callback: func(n g.Node) {
+			remaining, ok := n.Map[keyDeadlineRemaining].(int64)
+			assert.True(t, ok)
+			assert.True(t, remaining > int64(50*time.Second/time.Microsecond))
+			assert.True(t, remaining <= int64(time.Minute/time.Microsecond))
+		}},
+		{"withDeadline", "exit"}: {Edges: g.Edges{{"withDeadline", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, ctxDoneCanceled, n.Map[keyContextDone])
+		}},
+		{"done", "entry"}: {Edges: g.Edges{{"deadline", "entry"}}},
+		{"done", "exit"}:  {Edges: g.Edges{{"done", "entry"}}},
+		{"deadline", "exit"}: {Edges: g.Edges{{"withDeadline", "exit"}, {"done", "exit"}, {"deadline", "entry"}}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, keyContextDone)
+		}},
+	})
+}
+
+func TestSpanContextDeadlineExceeded(t *testing.T) {
+	r := reporter.SetTestReporter()
+	ctx := NewContext(context.Background(), NewTrace("deadline"))
+	ctx, cancel := context.WithDeadline(ctx, time.Now().Add(-time.Second))
+	defer cancel()
+	s, _ := BeginSpan(ctx, "expired")
+	s.End()
+	EndTrace(ctx)
+	r.Close(4)
+
+	g.AssertGraph(t, r.EventBufs, 4, g.AssertNodeMap{
+		{"deadline", "entry"}: {},
+		{"expired", "entry"}: {Edges: g.Edges{{"deadline", "entry"}}, Callback: func(n g.Node) {
+			assert.True(t, n.Map[keyDeadlineRemaining].(int64) < 0)
+		}},
+		{"expired", "exit"}: {Edges: g.Edges{{"expired", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, ctxDoneDeadlineExceeded, n.Map[keyContextDone])
+		}},
+		{"deadline", "exit"}: {Edges: g.Edges{{"expired", "exit"}, {"deadline", "entry"}}},
+	})
+}
+
+func TestTraceClientAborted(t *testing.T) {
+	r := reporter.SetTestReporter()
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/aborted", nil).WithContext(ctx)
+	tr, w, _ := TraceFromHTTPRequestResponse("aborted", httptest.NewRecorder(), req)
+	cancel()
+	w.WriteHeader(http.StatusInternalServerError)
+	tr.End()
+	r.Close(3)
+
+	assert.Len(t, r.SpanMessages, 1)
+	msg := r.SpanMessages[0].(*metrics.HTTPSpanMessage)
+	assert.False(t, msg.HasError)
+	assert.Equal(t, ErrClassClientAborted, msg.ErrorClass)
+
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"aborted", "entry"}: {},
+		{"aborted", "exit"}: {Edges: g.Edges{{"aborted", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, ctxDoneCanceled, n.Map[keyContextDone])
+		}},
+	})
+}
@@ -0,0 +1,74 @@
+// +build !ao_noop
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// keyErrorBody is the KV of the error event carrying the beginning of a 5xx
+// response body.
+const keyErrorBody = "ErrorBody"
+
+// errorBody captures the first max bytes of a response body.
+type errorBody struct {
+	buf []byte
+	max int
+}
+
+func (b *errorBody) write(p []byte) {
+	n := b.max - len(b.buf)
+	if n <= 0 {
+		return
+	}
+	if len(p) > n {
+		p = p[:n]
+	}
+	b.buf = append(b.buf, p...)
+}
+
+// the patterns of the sensitive data masked in the captured bodies, applied
+// in order.
+var errorBodyScrubbers = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	// the values of the sensitive fields in JSON, forms and headers
+	{regexp.MustCompile(`(?i)("?\b(?:password|passwd|pwd|secret|token|api[_-]?key|access[_-]?key|authorization|cookie)"?\s*[:=]\s*"?)(?:(?:bearer|basic)\s+)?[^"&\s,;}]+`),
+		"${1}[REDACTED]"},
+	{regexp.MustCompile(`(?i)\bbearer\s+[a-z0-9._~+/=-]+`), "Bearer [REDACTED]"},
+	{regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), "[EMAIL]"},
+	// card numbers and the like
+	{regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), "[NUMBER]"},
+}
+
+// scrubErrorBody returns the captured body as a valid UTF-8 string with the
+// credentials, the email addresses and the long numbers masked.
+func scrubErrorBody(b []byte) string {
+	s := dropInvalidUTF8(string(b))
+	for _, sc := range errorBodyScrubbers {
+		s = sc.re.ReplaceAllString(s, sc.repl)
+	}
+	return s
+}
+
+// dropInvalidUTF8 returns s without the invalid UTF-8 bytes, e.g., those of a
+// character cut off by the capture limit.
+func dropInvalidUTF8(s string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+	var sb strings.Builder
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		if r != utf8.RuneError || size > 1 {
+			sb.WriteString(s[:size])
+		}
+		s = s[size:]
+	}
+	return sb.String()
+}
@@ -0,0 +1,37 @@
+// +build !ao_noop
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorBodyWrite(t *testing.T) {
+	b := &errorBody{max: 8}
+	b.write([]byte("hello "))
+	b.write([]byte("world"))
+	b.write([]byte("!"))
+	assert.Equal(t, "hello wo", string(b.buf))
+}
+
+func TestScrubErrorBody(t *testing.T) {
+	for _, c := range []struct{ in, out string }{
+		{`upstream timeout`, `upstream timeout`},
+		{`{"password": "hunter2", "ok": false}`, `{"password": "[REDACTED]", "ok": false}`},
+		{`api_key=s3cr3t&page=2`, `api_key=[REDACTED]&page=2`},
+		{`Authorization: Bearer eyJhbGciOi.x.y`, `Authorization: [REDACTED]`},
+		{`invalid bearer eyJhbGciOi.x.y`, `invalid Bearer [REDACTED]`},
+		{`no user john.doe@example.com`, `no user [EMAIL]`},
+		{`card 4111 1111 1111 1111 declined`, `card [NUMBER] declined`},
+		{`order 12345 failed`, `order 12345 failed`},
+		{"bad \xff byte", "bad  byte"},
+		{"cut off caf\xc3", "cut off caf"},
+		{"kept \ufffd café", "kept \ufffd café"},
+	} {
+		assert.Equal(t, c.out, scrubErrorBody([]byte(c.in)), c.in)
+	}
+}
@@ -1,10 +1,14 @@
+// +build !ao_noop
+
 // Copyright (C) 2016 Librato, Inc. All rights reserved.
 // AppOptics HTTP instrumentation for Go
 
 package ao
 
 import (
+	"net"
 	"net/http"
+	"net/url"
 
 	"context"
 )
@@ -26,7 +30,7 @@ type HTTPClientSpan struct{ Span }
 func BeginHTTPClientSpan(ctx context.Context, req *http.Request) HTTPClientSpan {
 	if req != nil {
 		l := BeginRemoteURLSpan(ctx, "http.Client", req.URL.String(), "HTTPMethod", req.Method)
-		req.Header.Set(HTTPHeaderName, l.MetadataString())
+		setOutgoingHeaders(req.Header, l.MetadataString())
 		return HTTPClientSpan{Span: l}
 	}
 	return HTTPClientSpan{Span: nullSpan{}}
@@ -36,9 +40,10 @@ func BeginHTTPClientSpan(ctx context.Context, req *http.Request) HTTPClientSpan
 // response headers and propagate any valid distributed trace context from the end of the HTTP
 // server's span to this one.
 func (l HTTPClientSpan) AddHTTPResponse(resp *http.Response, err error) {
-	if l.ok() {
+	if l.Span != nil && l.ok() {
 		if err != nil {
-			l.Err(err)
+			l.ErrorWithOpts(WithErrType(ErrTypeException), WithErrClass(ErrClassError),
+				WithErrMsg(err.Error()), WithErrBackTrace(true), WithErrRetryable(isRetryableHTTPErr(err)))
 		}
 		if resp != nil {
 			l.AddEndArgs(keyRemoteStatus, resp.StatusCode, keyContentLength, resp.ContentLength)
@@ -48,3 +53,64 @@ func (l HTTPClientSpan) AddHTTPResponse(resp *http.Response, err error) {
 		}
 	}
 }
+
+// NewRoundTripper returns an http.RoundTripper which traces the requests sent
+// through rt, or http.DefaultTransport if rt is nil. For each request with a
+// span in its context, it begins an http.Client span, adds the trace context
+// headers to a copy of the request and reports the response, e.g.,
+//   client := &http.Client{Transport: ao.NewRoundTripper(nil)}
+//   req, _ := http.NewRequest("GET", "http://example.com", nil)
+//   resp, err := client.Do(req.WithContext(ctx))
+// The 5xx responses are reported as errors. The span ends once the response
+// headers are received, so it doesn't cover reading the body.
+func NewRoundTripper(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &roundTripper{rt: rt}
+}
+
+type roundTripper struct {
+	rt http.RoundTripper
+}
+
+func (t *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	s := BeginRemoteURLSpan(ctx, "http.Client", req.URL.String(), "HTTPMethod", req.Method)
+	if !s.IsReporting() {
+		return t.rt.RoundTrip(req)
+	}
+	l := HTTPClientSpan{Span: s}
+	defer l.End()
+	// a RoundTripper must not modify the request
+	r2 := *req
+	r2.Header = cloneHeader(req.Header)
+	req = r2.WithContext(ctx)
+	setOutgoingHeaders(req.Header, l.MetadataString())
+
+	resp, err := t.rt.RoundTrip(req)
+	l.AddHTTPResponse(resp, err)
+	if resp != nil && resp.StatusCode >= http.StatusInternalServerError {
+		l.ErrorWithOpts(WithErrType(ErrTypeStatus), WithErrMsg(resp.Status))
+	}
+	return resp, err
+}
+
+// cloneHeader returns a deep copy of h, as http.Header.Clone of Go 1.13.
+func cloneHeader(h http.Header) http.Header {
+	h2 := make(http.Header, len(h))
+	for k, vv := range h {
+		h2[k] = append([]string(nil), vv...)
+	}
+	return h2
+}
+
+// isRetryableHTTPErr reports whether a failed client request may succeed if retried,
+// which is the case for network timeouts.
+func isRetryableHTTPErr(err error) bool {
+	if ue, ok := err.(*url.Error); ok {
+		err = ue.Err
+	}
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
@@ -1,3 +1,4 @@
+// +build !ao_noop
 // +build go1.7
 // Copyright (C) 2016 Librato, Inc. All rights reserved.
 // AppOptics HTTP instrumentation for Go
@@ -7,12 +8,14 @@ package ao
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"reflect"
 	"runtime"
 	"runtime/debug"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
@@ -29,15 +32,46 @@ const (
 	// HTTPHeaderXTraceOptionsSignature is a constant for the HTTP headers to propagate
 	// X-Trace-Options-Signature values. It contains the response codes for X-Trace-Options
 	HTTPHeaderXTraceOptionsSignature = reporter.HTTPHeaderXTraceOptionsSignature
-	httpHandlerSpanName              = "http.HandlerFunc"
+	// HTTPHeaderXTraceOptionsResponse is a constant for the HTTP header to respond to
+	// X-Trace-Options, e.g. "trigger-trace=ok".
+	HTTPHeaderXTraceOptionsResponse = reporter.HTTPHeaderXTraceOptionsResponse
+	httpHandlerSpanName             = "http.HandlerFunc"
+)
+
+// the KVs of the request body reported in the exit event
+const (
+	keyRequestBodyBytes  = "RequestBodyBytes"
+	keyRequestReadTime   = "RequestReadTime"
+	keyExpect100Continue = "Expect100Continue"
 )
 
 // key used for HTTP span to indicate a new context
 var httpSpanKey = contextKeyT("github.com/appoptics/appoptics-apm-go/v1/ao.HTTPSpan")
 
+// txnNameFunc holds the func(*http.Request) string set by SetTransactionNameFunc.
+var txnNameFunc atomic.Value
+
+// SetTransactionNameFunc sets the function which names the transactions of the
+// HTTP requests in place of the default naming by the handler function, the
+// ServeMux pattern or the first two segments of the path, e.g., to collapse the
+// IDs in REST paths:
+//   ao.SetTransactionNameFunc(func(r *http.Request) string {
+//       if strings.HasPrefix(r.URL.Path, "/users/") {
+//           return "/users/:id"
+//       }
+//       return "" // fall back to the default naming
+//   })
+// The custom transaction names set by SetTransactionName or the configuration
+// still take precedence. Passing nil restores the default naming.
+func SetTransactionNameFunc(f func(r *http.Request) string) {
+	txnNameFunc.Store(f)
+}
+
 // HTTPHandler wraps an http.HandlerFunc with entry / exit events,
 // returning a new handler that can be used in its place.
 //   http.HandleFunc("/path", ao.HTTPHandler(myHandler))
+// With Go 1.23+, the transaction is named after the pattern of the ServeMux
+// which routes the request to the handler.
 func HTTPHandler(handler func(http.ResponseWriter, *http.Request), opts ...SpanOpt) func(http.ResponseWriter, *http.Request) {
 	// At wrap time (when binding handler to router): get name of wrapped handler func
 	var endArgs []interface{}
@@ -58,6 +92,12 @@ func HTTPHandler(handler func(http.ResponseWriter, *http.Request), opts ...SpanO
 		t, w, r := TraceFromHTTPRequestResponse(httpHandlerSpanName, w, r, opts...)
 		defer t.End(endArgs...)
 
+		// name the transaction after the ServeMux pattern, e.g., "GET /users/{id}",
+		// rather than the path, unless the handler sets the name.
+		if pattern := requestPattern(r); pattern != "" && !namedByFunc(t) {
+			t.SetTransactionName(pattern)
+		}
+
 		defer func() { // catch and report panic, if one occurs
 			if err := recover(); err != nil {
 				t.Error("panic", fmt.Sprintf("%v", err))
@@ -69,6 +109,13 @@ func HTTPHandler(handler func(http.ResponseWriter, *http.Request), opts ...SpanO
 	}
 }
 
+// namedByFunc tells if the function set by SetTransactionNameFunc names the
+// transaction of the trace.
+func namedByFunc(t Trace) bool {
+	at, ok := t.(*aoTrace)
+	return ok && at.httpSpan.pathTxnName != ""
+}
+
 // TraceFromHTTPRequestResponse returns a Trace, a wrapped http.ResponseWriter, and a modified
 // http.Request, given a http.ResponseWriter and http.Request. If a distributed trace is described
 // in the HTTP request headers, the trace's context will be continued. The returned http.ResponseWriter
@@ -91,10 +138,21 @@ func TraceFromHTTPRequestResponse(spanName string, w http.ResponseWriter, r *htt
 	}
 
 	t := traceFromHTTPRequest(spanName, r, isNewContext, opts...)
+	if at, ok := t.(*aoTrace); ok && isNewContext {
+		// to tell if the request is abandoned by the client when the trace ends
+		at.ctx = r.Context()
+	}
 
 	// Associate the trace with http.Request to expose it to the handler
 	r = r.WithContext(NewContext(r.Context(), t))
 
+	// measure the request body and profile the slow request only once if the
+	// handlers are nested
+	if isNewContext {
+		r = measureRequestBody(r, t)
+		profileIfSlow(t)
+	}
+
 	wrapper := newResponseWriter(w, t) // wrap writer with response-observing writer
 	for k, v := range t.HTTPRspHeaders() {
 		wrapper.Header().Set(k, v)
@@ -110,12 +168,17 @@ type HTTPResponseWriter struct {
 	t           Trace
 	StatusCode  int
 	WroteHeader bool
+
+	errBody *errorBody // the 5xx response body captured, if enabled
 }
 
 func (w *HTTPResponseWriter) Write(p []byte) (n int, err error) {
 	if !w.WroteHeader {
 		w.WriteHeader(w.StatusCode)
 	}
+	if w.errBody != nil && isServerError(w.StatusCode) {
+		w.errBody.write(p)
+	}
 	return w.Writer.Write(p)
 }
 
@@ -147,9 +210,68 @@ func newResponseWriter(writer http.ResponseWriter, t Trace) *HTTPResponseWriter
 		// add/replace response header metadata with this trace's
 		w.Header().Set(HTTPHeaderName, t.ExitMetadata())
 	}
+	// capture the 5xx response body for the error event of a sampled request
+	if n := config.GetErrorBodyBytes(); n > 0 && t.IsSampled() {
+		if at, ok := t.(*aoTrace); ok {
+			w.errBody = &errorBody{max: n}
+			at.onEnd(w.reportErrorBody)
+		}
+	}
 	return w
 }
 
+// reportErrorBody reports the error event with the captured 5xx response
+// body, if any.
+func (w *HTTPResponseWriter) reportErrorBody() {
+	if !isServerError(w.StatusCode) || len(w.errBody.buf) == 0 {
+		return
+	}
+	w.t.ErrorWithOpts(WithErrType(ErrTypeStatus),
+		WithErrMsg(fmt.Sprintf("%d %s", w.StatusCode, http.StatusText(w.StatusCode))),
+		withErrKVs(keyErrorBody, scrubErrorBody(w.errBody.buf)))
+}
+
+func isServerError(status int) bool {
+	return status >= 500 && status < 600
+}
+
+// requestBodyReader counts the bytes read from the request body and the time
+// spent in reading them.
+type requestBodyReader struct {
+	io.ReadCloser
+	bytes    int64 // reported as RequestBodyBytes
+	readTime int64 // reported as RequestReadTime, in microseconds
+	elapsed  time.Duration
+}
+
+func (b *requestBodyReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := b.ReadCloser.Read(p)
+	b.elapsed += time.Since(start)
+	b.bytes += int64(n)
+	b.readTime = int64(b.elapsed / time.Microsecond)
+	return n, err
+}
+
+// measureRequestBody wraps the request body to report its size and read time
+// in the exit event of the trace. Only the time spent in reading the body is
+// measured, which excludes the time before the handler starts reading it. With
+// "Expect: 100-continue", the 100 Continue response is sent upon the first read,
+// so the read time includes the client's round trip to send the body, which is
+// flagged by the Expect100Continue KV.
+func measureRequestBody(r *http.Request, t Trace) *http.Request {
+	if r.Body == nil || r.Body == http.NoBody || !t.IsReporting() {
+		return r
+	}
+	rb := &requestBodyReader{ReadCloser: r.Body}
+	t.AddEndArgs(keyRequestBodyBytes, &rb.bytes, keyRequestReadTime, &rb.readTime)
+	if strings.EqualFold(r.Header.Get("Expect"), "100-continue") {
+		t.AddEndArgs(keyExpect100Continue, true)
+	}
+	r.Body = rb
+	return r
+}
+
 // traceFromHTTPRequest returns a Trace, given an http.Request. If a distributed trace is described
 // in the "X-Trace" header, this context will be continued.
 func traceFromHTTPRequest(spanName string, r *http.Request, isNewContext bool, opts ...SpanOpt) Trace {
@@ -172,8 +294,10 @@ func traceFromHTTPRequest(spanName string, r *http.Request, isNewContext bool, o
 	t := NewTraceWithOptions(spanName, SpanOptions{
 		WithBackTrace: false,
 		ContextOptions: reporter.ContextOptions{
-			MdStr:                  r.Header.Get(HTTPHeaderName),
+			MdStr:                  incomingMetadata(r.Header),
 			URL:                    r.URL.EscapedPath(),
+			Method:                 r.Method,
+			SampledHint:            r.Header.Get(HTTPHeaderXTraceSampled),
 			XTraceOptions:          r.Header.Get(HTTPHeaderXTraceOptions),
 			XTraceOptionsSignature: r.Header.Get(HTTPHeaderXTraceOptionsSignature),
 			CB: func() KVMap {
@@ -223,6 +347,10 @@ func traceFromHTTPRequest(spanName string, r *http.Request, isNewContext bool, o
 	// Clear the start time if it is not a new context
 	if !isNewContext {
 		t.SetStartTime(time.Time{})
+	} else if f, _ := txnNameFunc.Load().(func(*http.Request) string); f != nil {
+		if at, ok := t.(*aoTrace); ok {
+			at.httpSpan.pathTxnName = f(r)
+		}
 	}
 
 	// update incoming metadata in request headers for any downstream readers
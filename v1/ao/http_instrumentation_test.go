@@ -1,3 +1,4 @@
+// +build !ao_noop
 // +build go1.7
 // Copyright (C) 2016 Librato, Inc. All rights reserved.
 
@@ -27,6 +28,7 @@ import (
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/mgo.v2/bson"
 )
 
 func handler404(w http.ResponseWriter, r *http.Request) { w.WriteHeader(404) }
@@ -150,6 +152,39 @@ func TestHTTPHandler200(t *testing.T) {
 	})
 }
 
+func handlerReadBody(w http.ResponseWriter, r *http.Request) {
+	ioutil.ReadAll(r.Body)
+}
+
+func TestHTTPHandlerRequestBody(t *testing.T) {
+	r := reporter.SetTestReporter() // set up test reporter
+	h := http.HandlerFunc(ao.HTTPHandler(handlerReadBody))
+	req, _ := http.NewRequest("POST", "http://test.com/upload", strings.NewReader("hello world"))
+	req.Header.Set("Expect", "100-continue")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	r.Close(2)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {Edges: g.Edges{}},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.EqualValues(t, 11, n.Map["RequestBodyBytes"])
+			assert.Contains(t, n.Map, "RequestReadTime")
+			assert.Equal(t, true, n.Map["Expect100Continue"])
+		}},
+	})
+
+	// no body KVs without a body
+	r = reporter.SetTestReporter()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://test.com/hello", nil))
+	r.Close(2)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {Edges: g.Edges{}},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, "RequestBodyBytes")
+		}},
+	})
+}
+
 func TestHTTPHandlerNoTrace(t *testing.T) {
 	r := reporter.SetTestReporter(reporter.TestReporterDisableTracing())
 	httpTest(handler404)
@@ -350,6 +385,25 @@ func testHTTPClientB(t *testing.T, ctx context.Context, method, url string) (*ht
 	return resp, err
 }
 
+// make an HTTP request through the client traced by ao.NewRoundTripper
+func testHTTPClientC(t *testing.T, ctx context.Context, method, url string) (*http.Response, error) {
+	httpClient := &http.Client{Transport: ao.NewRoundTripper(nil)}
+	httpReq, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := httpClient.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		return resp, err
+	}
+	defer resp.Body.Close()
+
+	// the request is not modified by the RoundTripper
+	assert.Empty(t, httpReq.Header.Get(ao.HTTPHeaderName))
+	return resp, err
+}
+
 type testClientFn func(t *testing.T, ctx context.Context, method, url string) (*http.Response, error)
 type testServerFn struct {
 	serverFn func(t *testing.T, list net.Listener)
@@ -385,6 +439,11 @@ func TestTraceHTTPPanic(t *testing.T)         { testHTTP(t, "GET", false, testHT
 func TestTraceHTTPPanicA(t *testing.T)        { testHTTP(t, "GET", false, testHTTPClientA, testHTTPSvrPanic) }
 func TestTraceHTTPPanicB(t *testing.T)        { testHTTP(t, "GET", false, testHTTPClientB, testHTTPSvrPanic) }
 
+func TestTraceHTTPRT(t *testing.T)       { testHTTP(t, "GET", false, testHTTPClientC, testHTTPSvr) }
+func TestTraceHTTPRT403(t *testing.T)    { testHTTP(t, "GET", false, testHTTPClientC, testHTTPSvr403) }
+func TestTraceHTTPRTPost(t *testing.T)   { testHTTP(t, "POST", false, testHTTPClientC, testHTTPSvr) }
+func TestTraceHTTPRTBadReq(t *testing.T) { testHTTP(t, "GET", true, testHTTPClientC, testHTTPSvr) }
+
 // launch a test HTTP server and trace an HTTP request to it
 func testHTTP(t *testing.T, method string, badReq bool, clientFn testClientFn, server testServerFn) {
 	ln, err := net.Listen("tcp", ":0") // pick an unallocated port
@@ -498,6 +557,7 @@ func TestTraceHTTPErrorB(t *testing.T)           { testTraceHTTPError(t, "GET",
 func TestTraceHTTPErrorBadRequest(t *testing.T)  { testTraceHTTPError(t, "GET", true, testHTTPClient) }
 func TestTraceHTTPErrorABadRequest(t *testing.T) { testTraceHTTPError(t, "GET", true, testHTTPClientA) }
 func TestTraceHTTPErrorBBadRequest(t *testing.T) { testTraceHTTPError(t, "GET", true, testHTTPClientB) }
+func TestTraceHTTPErrorRT(t *testing.T)          { testTraceHTTPError(t, "GET", false, testHTTPClientC) }
 
 // test making an HTTP request that causes http.Client.Do() to fail
 func testTraceHTTPError(t *testing.T, method string, badReq bool, clientFn testClientFn) {
@@ -539,6 +599,47 @@ func testTraceHTTPError(t *testing.T, method string, badReq bool, clientFn testC
 	})
 }
 
+func TestHTTPRoundTripper5xx(t *testing.T) {
+	var xtrace string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		xtrace = r.Header.Get(ao.HTTPHeaderName)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer svr.Close()
+
+	r := reporter.SetTestReporter() // set up test reporter
+	ctx := ao.NewContext(context.Background(), ao.NewTrace("httpTest"))
+	resp, err := testHTTPClientC(t, ctx, "GET", svr.URL)
+	ao.EndTrace(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	r.Close(5)
+	g.AssertGraph(t, r.EventBufs, 5, g.AssertNodeMap{
+		{"httpTest", "entry"}: {},
+		{"http.Client", "entry"}: {Edges: g.Edges{{"httpTest", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, svr.URL, n.Map["RemoteURL"])
+			assert.Equal(t, "GET", n.Map["HTTPMethod"])
+			assert.Equal(t, n.Map["X-Trace"], xtrace)
+		}},
+		{"http.Client", "error"}: {Edges: g.Edges{{"http.Client", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, ao.ErrClassHTTPError, n.Map["ErrorClass"])
+			assert.Equal(t, "503 Service Unavailable", n.Map["ErrorMsg"])
+		}},
+		{"http.Client", "exit"}: {Edges: g.Edges{{"http.Client", "error"}}, Callback: func(n g.Node) {
+			assert.Equal(t, http.StatusServiceUnavailable, n.Map["RemoteStatus"])
+		}},
+		{"httpTest", "exit"}: {Edges: g.Edges{{"http.Client", "exit"}, {"httpTest", "entry"}}},
+	})
+
+	// the requests out of any trace are passed through
+	xtrace = "none"
+	resp, err = testHTTPClientC(t, context.Background(), "GET", svr.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Empty(t, xtrace)
+}
+
 func TestDoubleWrappedHTTPRequest(t *testing.T) {
 	list, err := net.Listen("tcp", ":0") // pick an unallocated port
 	assert.NoError(t, err)
@@ -831,3 +932,108 @@ func TestHTTPHandlerOpts(t *testing.T) {
 		}},
 	})
 }
+
+func TestSetTransactionNameFunc(t *testing.T) {
+	os.Setenv("APPOPTICS_PREPEND_DOMAIN", "false")
+	config.Load()
+	ao.SetTransactionNameFunc(func(r *http.Request) string {
+		if strings.HasPrefix(r.URL.Path, "/users/") {
+			return "/users/:id"
+		}
+		return ""
+	})
+	defer ao.SetTransactionNameFunc(nil)
+
+	r := reporter.SetTestReporter() // set up test reporter
+	httpTestWithEndpoint(handler200, "http://test.com/users/123/orders")
+	httpTestWithEndpoint(handler200, "http://test.com/hello")
+	httpTestWithEndpoint(handler200CustomTxnName, "http://test.com/users/456")
+
+	r.Close(6)
+	var names []string
+	for _, buf := range r.EventBufs {
+		m := make(map[string]interface{})
+		bson.Unmarshal(buf, m)
+		if name, ok := m["TransactionName"].(string); ok {
+			names = append(names, name)
+		}
+	}
+	require.Len(t, names, 3)
+	assert.Equal(t, "/users/:id", names[0])
+	assert.Equal(t, "ao_test.handler200", names[1])
+	assert.True(t, strings.HasPrefix(names[2], "final-my-custom-transaction-name"), names[2])
+}
+
+func TestHTTPHandlerErrorBody(t *testing.T) {
+	os.Setenv("APPOPTICS_ERROR_BODY_BYTES", "32")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_ERROR_BODY_BYTES")
+		config.Load()
+	}()
+
+	r := reporter.SetTestReporter() // set up test reporter
+	response := httpTest(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `{"error":"db down","token":"abc123","user":"a@b.com","trace":"..."}`)
+	})
+	assert.Equal(t, http.StatusInternalServerError, response.Code)
+
+	r.Close(3)
+	g.AssertGraph(t, r.EventBufs, 3, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {},
+		{"http.HandlerFunc", "error"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, ao.ErrClassHTTPError, n.Map["ErrorClass"])
+			assert.Equal(t, "500 Internal Server Error", n.Map["ErrorMsg"])
+			assert.Equal(t, `{"error":"db down","token":"[REDACTED]`, n.Map["ErrorBody"])
+		}},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "error"}}, Callback: func(n g.Node) {
+			assert.EqualValues(t, 500, n.Map["Status"])
+		}},
+	})
+
+	// the bodies of the other responses are not captured
+	r = reporter.SetTestReporter()
+	httpTest(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "bad request")
+	})
+	r.Close(2)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {},
+		{"http.HandlerFunc", "exit"}:  {Edges: g.Edges{{"http.HandlerFunc", "entry"}}},
+	})
+}
+
+func TestHTTPHandlerSlowProfile(t *testing.T) {
+	os.Setenv("APPOPTICS_SLOW_PROFILE_THRESHOLD", "20")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_SLOW_PROFILE_THRESHOLD")
+		config.Load()
+	}()
+
+	r := reporter.SetTestReporter() // set up test reporter
+	httpTest(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	})
+	r.Close(2)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "goroutine", n.Map["ProfileType"])
+			assert.NotEmpty(t, n.Map["Profile"])
+		}},
+	})
+
+	// the fast requests are not profiled
+	r = reporter.SetTestReporter()
+	httpTest(handler200)
+	r.Close(2)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, "Profile")
+		}},
+	})
+}
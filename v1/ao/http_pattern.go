@@ -0,0 +1,14 @@
+// +build !ao_noop
+// +build !go1.23
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import "net/http"
+
+// requestPattern returns an empty string as http.Request has no Pattern before
+// Go 1.23.
+func requestPattern(r *http.Request) string {
+	return ""
+}
@@ -0,0 +1,14 @@
+// +build !ao_noop
+// +build go1.23
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import "net/http"
+
+// requestPattern returns the pattern of the http.ServeMux which matched the
+// request, or an empty string if the request is not routed by a ServeMux.
+func requestPattern(r *http.Request) string {
+	return r.Pattern
+}
@@ -0,0 +1,35 @@
+// +build !ao_noop
+// +build go1.23
+
+//go:debug httpmuxgo121=0
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPHandlerPatternTxnName(t *testing.T) {
+	r := reporter.SetTestReporter() // set up test reporter
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{id}", ao.HTTPHandler(handler200))
+
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://test.com/users/42", nil))
+
+	r.Close(2)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "GET /users/{id}", n.Map["TransactionName"])
+		}},
+	})
+}
@@ -16,11 +16,13 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
 	"reflect"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
 	"github.com/pkg/errors"
@@ -40,6 +42,26 @@ const (
 	maxTokenBucketRate =4
 )
 
+// the known collectors of the data residency regions, the others have to be
+// set by RegionCollectors.
+var regionCollectors = map[string][]string{
+	"us": {defaultSSLCollector},
+}
+
+// MaxTagValueLength is the max length of the metrics tag values accepted by
+// the collector.
+const MaxTagValueLength = 255
+
+// MaxErrorBodyBytes is the max number of bytes of the 5xx response bodies
+// captured.
+const MaxErrorBodyBytes = 4096
+
+// The runtime profiles taken of the slow requests.
+const (
+	SlowProfileGoroutine = "goroutine"
+	SlowProfileCPU       = "cpu"
+)
+
 // The environment variables
 const (
 	envAppOpticsCollector             = "APPOPTICS_COLLECTOR"
@@ -78,24 +100,38 @@ type Config struct {
 	// Collector defines the host and port of the AppOptics collector
 	Collector string `yaml:"Collector,omitempty" env:"APPOPTICS_COLLECTOR" default:"collector.appoptics.com:443"`
 
+	// The data residency region, e.g., eu. When it's set, the reporter only
+	// sends the data to the collectors of the region.
+	DataResidency string `yaml:"DataResidency,omitempty" env:"APPOPTICS_DATA_RESIDENCY"`
+
+	// The host and port of the collectors of the data residency region. The
+	// first one is connected to unless Collector is one of them, and the
+	// redirections are only followed to the others.
+	RegionCollectors []string `yaml:"RegionCollectors,omitempty" env:"APPOPTICS_REGION_COLLECTORS"`
+
 	// ServiceKey defines the service key and service name
 	ServiceKey string `yaml:"ServiceKey,omitempty" env:"APPOPTICS_SERVICE_KEY"`
 
 	// The file path of the cert file for gRPC connection
 	TrustedPath string `yaml:"TrustedPath,omitempty" env:"APPOPTICS_TRUSTEDPATH"`
 
-	// The host and port of the UDP collector
+	// The host and port of the UDP collector, or a comma-separated list of them
+	// to send the events to all of them
 	CollectorUDP string `yaml:"CollectorUDP,omitempty" env:"APPOPTICS_COLLECTOR_UDP"`
 
 	// The reporter type, ssl or udp
 	ReporterType string `yaml:"ReporterType,omitempty" env:"APPOPTICS_REPORTER" default:"ssl"`
+	// The reporter type as configured if it's not a built-in one, which may
+	// name a custom reporter registered later than the configuration is loaded.
+	customReporterType string `yaml:"-"`
 
 	Sampling *SamplingConfig `yaml:"Sampling,omitempty"`
 
 	// Whether the domain should be prepended to the transaction name.
 	PrependDomain bool `yaml:"PrependDomain,omitempty" env:"APPOPTICS_PREPEND_DOMAIN"`
 
-	// The alias of the hostname
+	// The alias of the hostname. It can be a template of the host metadata, e.g.,
+	// {{.K8sNamespace}}-{{.PodName}}.
 	HostAlias string `yaml:"HostAlias,omitempty" env:"APPOPTICS_HOSTNAME_ALIAS"`
 
 	// The precision of the histogram
@@ -110,18 +146,31 @@ type Config struct {
 	// The transaction filtering config
 	TransactionSettings []TransactionFilter `yaml:"TransactionSettings,omitempty"`
 
+	// The regular expressions of the URLs not to be traced, e.g. ^/health$.
+	// They are applied after TransactionSettings.
+	ExcludedURLs []string `yaml:"ExcludedURLs,omitempty" env:"APPOPTICS_EXCLUDED_URLS"`
+
+	// The extensions of the URLs not to be traced, e.g. js,css,png. They are
+	// applied after TransactionSettings.
+	ExcludedExtensions []string `yaml:"ExcludedExtensions,omitempty" env:"APPOPTICS_EXCLUDED_EXTENSIONS"`
+
 	Disabled bool `yaml:"Disabled,omitempty" env:"APPOPTICS_DISABLED"`
 
 	// EC2 metadata retrieval timeout in milliseconds
 	Ec2MetadataTimeout int `yaml:"Ec2MetadataTimeout,omitempty" env:"APPOPTICS_EC2_METADATA_TIMEOUT" default:"1000"`
 
+	// Whether to skip the EC2 metadata retrieval, e.g., on the non-AWS hosts
+	DisableEc2Metadata bool `yaml:"DisableEc2Metadata,omitempty" env:"APPOPTICS_DISABLE_EC2_METADATA"`
+
 	// The default log level. It should follow the level defined in log.DefaultLevel
 	DebugLevel string `yaml:"DebugLevel,omitempty" env:"APPOPTICS_DEBUG_LEVEL" default:"warn"`
 
 	// The flag for trigger trace. It's enabled by default.
 	TriggerTrace bool `yaml:"TriggerTrace" env:"APPOPTICS_TRIGGER_TRACE" default:"true"`
 
-	// Url of the HTTP/HTTPS proxy in the format of "scheme://<username>:<password>@<host>:<port>"
+	// Url of the HTTP/HTTPS or SOCKS5 proxy in the format of "scheme://<username>:<password>@<host>:<port>",
+	// which the reporter connects to the collector through. The EC2 metadata is
+	// still fetched directly, as it's only served to the instance itself.
 	Proxy string `yaml:"Proxy,omitempty" env:"APPOPTICS_PROXY"`
 	// Cert path for the HTTP/HTTPS proxy
 	ProxyCertPath string `yaml:"ProxyCertPath" env:"APPOPTICS_PROXY_CERT_PATH"`
@@ -133,6 +182,114 @@ type Config struct {
 	TokenBucketRate   float64 `yaml:"TokenBucketRate" env:"APPOPTICS_TOKEN_BUCKET_RATE" default:"0.17"`
 	// The user-defined transaction name. It's only available in the AWS Lambda environment.
 	TransactionName string `yaml:"TransactionName" env:"APPOPTICS_TRANSACTION_NAME"`
+	// The transaction name patterns (in the syntax of path.Match) to be excluded from the
+	// metrics. The matched transactions are still traced.
+	MetricsExclusion []string `yaml:"MetricsExclusion,omitempty" env:"APPOPTICS_METRICS_EXCLUSION"`
+	// How the HTTP status is tagged in the transaction metrics: exact, class or both
+	HTTPStatusTagging StatusTagging `yaml:"HTTPStatusTagging,omitempty" env:"APPOPTICS_HTTP_STATUS_TAGGING" default:"exact"`
+	// Generate the trace IDs with a per-process prefix and check them for collisions
+	HardenedTraceIDs bool `yaml:"HardenedTraceIDs,omitempty" env:"APPOPTICS_HARDENED_TRACE_IDS"`
+	// The names of the layers (spans) which should not be reported
+	DisabledLayers []string `yaml:"DisabledLayers,omitempty" env:"APPOPTICS_DISABLED_LAYERS"`
+	// The set built from DisabledLayers for fast lookup
+	disabledLayers map[string]struct{} `yaml:"-"`
+	// Track the active spans of each goroutine for the lookup without a context
+	ActiveSpanRegistry bool `yaml:"ActiveSpanRegistry,omitempty" env:"APPOPTICS_ACTIVE_SPAN_REGISTRY"`
+	// Attach the IDs of the sampled traces to the response time metrics as exemplars
+	ReportExemplars bool `yaml:"ReportExemplars,omitempty" env:"APPOPTICS_REPORT_EXEMPLARS"`
+	// The max percentage of the CPU time (of GOMAXPROCS) the agent may spend on
+	// reporting events before it degrades to metrics-only. 0 means no limit.
+	OverheadBudget float64 `yaml:"OverheadBudget,omitempty" env:"APPOPTICS_OVERHEAD_BUDGET"`
+	// The max length in bytes of the string values of event KVs. 0 means no limit.
+	MaxKVValueLength int `yaml:"MaxKVValueLength,omitempty" env:"APPOPTICS_MAX_KV_VALUE_LENGTH"`
+	// The max length in bytes of the metrics tag values. 0 means MaxTagValueLength.
+	MaxTagValueLength int `yaml:"MaxTagValueLength,omitempty" env:"APPOPTICS_MAX_TAG_VALUE_LENGTH"`
+	// The Apdex threshold in milliseconds of all the transactions. 0 disables
+	// the Apdex measurements unless a transaction matches ApdexThresholds.
+	ApdexThreshold int `yaml:"ApdexThreshold,omitempty" env:"APPOPTICS_APDEX_THRESHOLD"`
+	// The per-transaction Apdex thresholds in the format of pattern=milliseconds,
+	// where the pattern is a transaction name pattern in the syntax of path.Match.
+	// The first match takes precedence over ApdexThreshold.
+	ApdexThresholds []string `yaml:"ApdexThresholds,omitempty" env:"APPOPTICS_APDEX_THRESHOLDS"`
+	// The rules parsed from ApdexThresholds
+	apdexRules []latencyRule `yaml:"-"`
+	// The per-layer sample rates of the Info events in the format of layer=N,
+	// which reports only the first of every N Info events of the layer in a trace.
+	InfoEventSampling []string `yaml:"InfoEventSampling,omitempty" env:"APPOPTICS_INFO_EVENT_SAMPLING"`
+	// The layer name to N map parsed from InfoEventSampling
+	infoEventSampling map[string]int `yaml:"-"`
+	// The wire format of the events and metrics sent by the UDP and custom
+	// reporters, e.g. bson or json. Empty means bson. The gRPC reporter
+	// always sends BSON.
+	ReporterEncoding string `yaml:"ReporterEncoding,omitempty" env:"APPOPTICS_REPORTER_ENCODING"`
+	// The per-transaction latency SLO targets in the format of pattern=milliseconds,
+	// where the pattern is a transaction name pattern in the syntax of path.Match.
+	// The requests of a transaction within its target are counted as good events.
+	SLOTargets []string `yaml:"SLOTargets,omitempty" env:"APPOPTICS_SLO_TARGETS"`
+	// The rules parsed from SLOTargets
+	sloRules []latencyRule `yaml:"-"`
+	// The number of metrics flush cycles a transaction can stay idle before its
+	// histogram and measurements are evicted. Zero means they are dropped at
+	// every flush.
+	MetricsIdleCycles int `yaml:"MetricsIdleCycles,omitempty" env:"APPOPTICS_METRICS_IDLE_CYCLES"`
+	// The tag keys allowed in the measurements. A measurement with any other tag
+	// is dropped from the metrics reports. Empty means all the tags are allowed.
+	MetricsTagAllowList []string `yaml:"MetricsTagAllowList,omitempty" env:"APPOPTICS_METRICS_TAG_ALLOW_LIST"`
+	// The tag keys denied in the measurements, e.g. HttpStatus. A measurement with
+	// any of them is dropped from the metrics reports.
+	MetricsTagDenyList []string `yaml:"MetricsTagDenyList,omitempty" env:"APPOPTICS_METRICS_TAG_DENY_LIST"`
+	// The sets built from MetricsTagAllowList and MetricsTagDenyList
+	metricsTagAllow map[string]struct{} `yaml:"-"`
+	metricsTagDeny  map[string]struct{} `yaml:"-"`
+	// The unit of the values recorded by the response time histograms, us or
+	// ms. Empty means us.
+	HistogramUnit string `yaml:"HistogramUnit,omitempty" env:"APPOPTICS_HISTOGRAM_UNIT"`
+	// The highest response time in seconds the histograms can track. The longer
+	// ones are recorded as this value. Zero means an hour.
+	HistogramMaxSeconds int `yaml:"HistogramMaxSeconds,omitempty" env:"APPOPTICS_HISTOGRAM_MAX_SECONDS"`
+	// The number of consecutive failed attempts to send data to the collector
+	// before the agent raises the send failure alert. Zero means 30.
+	SendFailureThreshold int `yaml:"SendFailureThreshold,omitempty" env:"APPOPTICS_SEND_FAILURE_THRESHOLD"`
+	// The directory the event batches which fail to be sent are spooled to, to
+	// be sent again once the collector is reachable. Empty disables spooling.
+	SpoolDir string `yaml:"SpoolDir,omitempty" env:"APPOPTICS_SPOOL_DIR"`
+	// The max size in MB of the spool directory, the oldest batches are dropped
+	// beyond it. Zero means 100.
+	SpoolMaxMB int `yaml:"SpoolMaxMB,omitempty" env:"APPOPTICS_SPOOL_MAX_MB"`
+	// The time in seconds the spooled batches are kept for. Zero means an hour.
+	SpoolTTLSeconds int `yaml:"SpoolTTLSeconds,omitempty" env:"APPOPTICS_SPOOL_TTL_SECONDS"`
+	// The number of bytes of the 5xx response bodies reported, scrubbed, by the
+	// error events of the sampled requests, up to MaxErrorBodyBytes. Zero
+	// disables the capture.
+	ErrorBodyBytes int `yaml:"ErrorBodyBytes,omitempty" env:"APPOPTICS_ERROR_BODY_BYTES"`
+	// The duration in milliseconds after which a sampled HTTP request is
+	// profiled, with the profile attached to its exit event. Zero disables it.
+	SlowProfileThreshold int `yaml:"SlowProfileThreshold,omitempty" env:"APPOPTICS_SLOW_PROFILE_THRESHOLD"`
+	// The type of the profile taken of the slow requests, goroutine (default)
+	// or cpu.
+	SlowProfileType string `yaml:"SlowProfileType,omitempty" env:"APPOPTICS_SLOW_PROFILE_TYPE"`
+	// The minimum time in seconds between two profiles of the slow requests.
+	// Zero means a minute.
+	SlowProfileIntervalSeconds int `yaml:"SlowProfileIntervalSeconds,omitempty" env:"APPOPTICS_SLOW_PROFILE_INTERVAL_SECONDS"`
+	// The secret the trace tokens are signed with, shared by the instances of
+	// the service. No trace tokens are issued or accepted if it's empty.
+	TraceTokenSecret string `yaml:"TraceTokenSecret,omitempty" env:"APPOPTICS_TRACE_TOKEN_SECRET"`
+	// Send the sampling decision in the X-Trace-Sampled header (1 or 0) along
+	// with X-Trace, and honor it in the requests without any trace context.
+	SampledHeader bool `yaml:"SampledHeader,omitempty" env:"APPOPTICS_SAMPLED_HEADER"`
+	// The directory the traces mirrored through TraceMirrorHandler are written
+	// to. Empty disables the mirroring through the handler.
+	TraceMirrorDir string `yaml:"TraceMirrorDir,omitempty" env:"APPOPTICS_TRACE_MIRROR_DIR"`
+
+	// Reload the configuration on SIGHUP. It's read only at startup.
+	ReloadOnSIGHUP bool `yaml:"ReloadOnSIGHUP,omitempty" env:"APPOPTICS_RELOAD_ON_SIGHUP"`
+}
+
+// latencyRule is the latency threshold, e.g., the Apdex threshold or the SLO
+// target, of the transactions matching the pattern.
+type latencyRule struct {
+	pattern   string
+	threshold time.Duration
 }
 
 // SamplingConfig defines the configuration options for the sampling decision
@@ -156,7 +313,20 @@ const (
 	URL FilterType = "url"
 )
 
-// TracingMode defines the tracing mode which is either `enabled` or `disabled`
+// StatusTagging defines how the HTTP status is tagged in the metrics
+type StatusTagging string
+
+const (
+	// ExactStatusTagging tags the metrics with the exact HTTP status, e.g., 404
+	ExactStatusTagging StatusTagging = "exact"
+	// ClassStatusTagging tags the metrics with the HTTP status class, e.g., 4xx
+	ClassStatusTagging StatusTagging = "class"
+	// BothStatusTagging tags the metrics with both the exact status and the class
+	BothStatusTagging StatusTagging = "both"
+)
+
+// TracingMode defines the tracing mode which is either `enabled`, `disabled`,
+// `metrics-only` or `dry-run`
 type TracingMode string
 
 const (
@@ -164,16 +334,25 @@ const (
 	EnabledTracingMode TracingMode = "enabled"
 	// DisabledTracingMode means tracing is disabled
 	DisabledTracingMode TracingMode = "disabled"
+	// MetricsOnlyTracingMode means no requests are traced but the inbound
+	// metrics and histograms are still reported
+	MetricsOnlyTracingMode TracingMode = "metrics-only"
+	// DryRunTracingMode means the sampling decisions are made and counted by
+	// transaction, but no requests are traced
+	DryRunTracingMode TracingMode = "dry-run"
 
 	UnknownTracingMode TracingMode = "unknown"
 )
 
 // TransactionFilter defines the transaction filtering based on a filter type.
+// The type is url if it's omitted.
 type TransactionFilter struct {
 	Type       FilterType  `yaml:"Type"`
 	RegEx      string      `yaml:"RegEx,omitempty"`
 	Extensions []string    `yaml:"Extensions,omitempty"`
 	Tracing    TracingMode `yaml:"Tracing"`
+	// The HTTP methods the filter applies to. Empty means all the methods.
+	Methods []string `yaml:"Methods,omitempty"`
 }
 
 // TransactionFilter unmarshal errors
@@ -191,11 +370,15 @@ func (f *TransactionFilter) UnmarshalYAML(unmarshal func(interface{}) error) err
 		RegEx      string      `yaml:"RegEx,omitempty"`
 		Extensions []string    `yaml:"Extensions,omitempty"`
 		Tracing    TracingMode `yaml:"Tracing"`
+		Methods    []string    `yaml:"Methods,omitempty"`
 	}{}
 
 	if err := unmarshal(&aux); err != nil {
 		return errors.Wrap(err, "failed to unmarshal TransactionFilter")
 	}
+	if aux.Type == "" {
+		aux.Type = URL
+	}
 	if aux.Type != URL {
 		return ErrTFInvalidType
 	}
@@ -210,6 +393,7 @@ func (f *TransactionFilter) UnmarshalYAML(unmarshal func(interface{}) error) err
 	f.RegEx = aux.RegEx
 	f.Extensions = aux.Extensions
 	f.Tracing = aux.Tracing
+	f.Methods = aux.Methods
 	return nil
 }
 
@@ -342,6 +526,18 @@ func hasLambdaEnv() bool {
 }
 
 func (c *Config) validate() error {
+	c.DataResidency = strings.ToLower(strings.TrimSpace(c.DataResidency))
+	var collectors []string
+	for _, rc := range c.RegionCollectors {
+		rc = strings.TrimSpace(rc)
+		if ok := IsValidHost(rc); !ok {
+			log.Warning(InvalidEnv("RegionCollectors", rc))
+			continue
+		}
+		collectors = append(collectors, rc)
+	}
+	c.RegionCollectors = collectors
+
 	if ok := IsValidHost(c.Collector); !ok {
 		log.Info(InvalidEnv("Collector", c.Collector))
 		c.Collector = getFieldDefaultValue(c, "Collector")
@@ -363,8 +559,10 @@ func (c *Config) validate() error {
 	} else {
 		c.ReporterType = strings.ToLower(strings.TrimSpace(c.ReporterType))
 	}
+	c.customReporterType = ""
 	if ok := IsValidReporterType(c.ReporterType); !ok {
 		log.Info(InvalidEnv("ReporterType", c.ReporterType))
+		c.customReporterType = c.ReporterType
 		c.ReporterType = getFieldDefaultValue(c, "ReporterType")
 	}
 
@@ -384,11 +582,83 @@ func (c *Config) validate() error {
 
 	c.Sampling.validate()
 
+	c.MetricsExclusion = validTransactionPatterns(c.MetricsExclusion)
+
+	if valid := IsValidApdexThreshold(c.ApdexThreshold); !valid {
+		log.Warning(InvalidEnv("ApdexThreshold", strconv.Itoa(c.ApdexThreshold)))
+		c.ApdexThreshold = 0
+	}
+	c.apdexRules = parseLatencyRules("ApdexThresholds", c.ApdexThresholds)
+	c.sloRules = parseLatencyRules("SLOTargets", c.SLOTargets)
+	c.HistogramUnit = strings.ToLower(strings.TrimSpace(c.HistogramUnit))
+	if ok := IsValidHistogramUnit(c.HistogramUnit); !ok {
+		log.Warning(InvalidEnv("HistogramUnit", c.HistogramUnit))
+		c.HistogramUnit = ""
+	}
+	if c.HistogramMaxSeconds < 0 {
+		log.Warning(InvalidEnv("HistogramMaxSeconds", strconv.Itoa(c.HistogramMaxSeconds)))
+		c.HistogramMaxSeconds = 0
+	}
+	if ok := IsValidProxy(c.Proxy); !ok {
+		// the url may carry the credentials
+		log.Warning(InvalidEnv("Proxy", "<redacted>"))
+		c.Proxy = ""
+	}
+	c.SpoolDir = strings.TrimSpace(c.SpoolDir)
+	if c.SpoolMaxMB < 0 {
+		log.Warning(InvalidEnv("SpoolMaxMB", strconv.Itoa(c.SpoolMaxMB)))
+		c.SpoolMaxMB = 0
+	}
+	if c.SpoolTTLSeconds < 0 {
+		log.Warning(InvalidEnv("SpoolTTLSeconds", strconv.Itoa(c.SpoolTTLSeconds)))
+		c.SpoolTTLSeconds = 0
+	}
+	if valid := IsValidErrorBodyBytes(c.ErrorBodyBytes); !valid {
+		log.Warning(InvalidEnv("ErrorBodyBytes", strconv.Itoa(c.ErrorBodyBytes)))
+		c.ErrorBodyBytes = 0
+	}
+	if c.SlowProfileThreshold < 0 {
+		log.Warning(InvalidEnv("SlowProfileThreshold", strconv.Itoa(c.SlowProfileThreshold)))
+		c.SlowProfileThreshold = 0
+	}
+	if valid := IsValidSlowProfileType(c.SlowProfileType); !valid {
+		log.Warning(InvalidEnv("SlowProfileType", c.SlowProfileType))
+		c.SlowProfileType = ""
+	}
+	if c.SlowProfileIntervalSeconds < 0 {
+		log.Warning(InvalidEnv("SlowProfileIntervalSeconds", strconv.Itoa(c.SlowProfileIntervalSeconds)))
+		c.SlowProfileIntervalSeconds = 0
+	}
+	if c.SendFailureThreshold < 0 {
+		log.Warning(InvalidEnv("SendFailureThreshold", strconv.Itoa(c.SendFailureThreshold)))
+		c.SendFailureThreshold = 0
+	}
+	if c.MetricsIdleCycles < 0 {
+		log.Warning(InvalidEnv("MetricsIdleCycles", strconv.Itoa(c.MetricsIdleCycles)))
+		c.MetricsIdleCycles = 0
+	}
+	c.infoEventSampling = parseInfoEventSampling(c.InfoEventSampling)
+
+	c.disabledLayers = toSet(c.DisabledLayers)
+	c.metricsTagAllow = toSet(c.MetricsTagAllowList)
+	c.metricsTagDeny = toSet(c.MetricsTagDenyList)
+
+	c.HTTPStatusTagging = StatusTagging(strings.ToLower(strings.TrimSpace(string(c.HTTPStatusTagging))))
+	if ok := IsValidStatusTagging(c.HTTPStatusTagging); !ok {
+		log.Warning(InvalidEnv("HTTPStatusTagging", string(c.HTTPStatusTagging)))
+		c.HTTPStatusTagging = StatusTagging(getFieldDefaultValue(c, "HTTPStatusTagging"))
+	}
+
 	if ok := IsValidHostnameAlias(c.HostAlias); !ok {
 		log.Warning(InvalidEnv("HostAlias", c.HostAlias))
 		c.HostAlias = getFieldDefaultValue(c, "HostAlias")
 	}
 
+	if ok := IsValidHistogramPrecision(c.Precision); !ok {
+		log.Warning(InvalidEnv("Precision", strconv.Itoa(c.Precision)))
+		c.Precision, _ = strconv.Atoi(getFieldDefaultValue(c, "Precision"))
+	}
+
 	if _, valid := log.ToLogLevel(c.DebugLevel); !valid {
 		log.Warning(InvalidEnv("DebugLevel", c.DebugLevel))
 		c.DebugLevel = getFieldDefaultValue(c, "DebugLevel")
@@ -412,6 +682,21 @@ func (c *Config) validate() error {
 		}
 	}
 
+	if valid := IsValidOverheadBudget(c.OverheadBudget); !valid {
+		log.Warning(InvalidEnv("OverheadBudget", fmt.Sprintf("%f", c.OverheadBudget)))
+		c.OverheadBudget = 0
+	}
+
+	if valid := IsValidMaxKVValueLength(c.MaxKVValueLength); !valid {
+		log.Warning(InvalidEnv("MaxKVValueLength", strconv.Itoa(c.MaxKVValueLength)))
+		c.MaxKVValueLength = 0
+	}
+
+	if valid := IsValidMaxTagValueLength(c.MaxTagValueLength); !valid {
+		log.Warning(InvalidEnv("MaxTagValueLength", strconv.Itoa(c.MaxTagValueLength)))
+		c.MaxTagValueLength = 0
+	}
+
 	return c.ReporterProperties.validate()
 }
 
@@ -444,6 +729,31 @@ func (c *Config) Load(opts ...Option) *Config {
 	return c
 }
 
+// Reload reads the config file and the environment variables into a fresh
+// Config, and copies the options which can be changed at runtime, i.e., the
+// debug level, the local sampling config and the transaction filters, into c.
+// The other options are only read at startup. c is left unchanged if the new
+// configuration can't be loaded.
+func (c *Config) Reload() error {
+	nc := newConfig().reset()
+	if err := nc.loadConfigFile(); err != nil {
+		return errors.Wrap(err, "config file load error")
+	}
+	nc.loadEnvs()
+	if err := nc.validate(); err != nil {
+		return errors.Wrap(err, "validation error")
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.DebugLevel = nc.DebugLevel
+	c.Sampling = nc.Sampling
+	c.TransactionSettings = nc.TransactionSettings
+	c.ExcludedURLs = nc.ExcludedURLs
+	c.ExcludedExtensions = nc.ExcludedExtensions
+	return nil
+}
+
 func (c *Config) resetThenDisable() *Config {
 	c.reset()
 	c.Disabled = true
@@ -481,6 +791,9 @@ func (d *Delta) sanitize() *Delta {
 		if d.delta[idx].key == "ServiceKey" {
 			d.delta[idx].value = MaskServiceKey(d.delta[idx].value)
 		}
+		if d.delta[idx].key == "TraceTokenSecret" {
+			d.delta[idx].value = "<redacted>"
+		}
 	}
 	return d
 }
@@ -709,6 +1022,11 @@ func (c *Config) loadYaml(path string) error {
 	if err != nil {
 		return errors.Wrap(err, fmt.Sprintf("loadYaml: %s", path))
 	}
+	// The unknown keys, e.g., a misspelled option, are ignored by the loader
+	// above, so check them against the schema and report them at startup.
+	if err = yaml.UnmarshalStrict(data, &Config{}); err != nil {
+		log.Warningf("config file %s: %s", path, err)
+	}
 
 	if c.Sampling == nil {
 		c.Sampling = origSampling
@@ -745,7 +1063,7 @@ func (c *Config) loadConfigFile() error {
 	ext := filepath.Ext(path)
 
 	switch ext {
-	case ".yml", ".yaml":
+	case ".yml", ".yaml", ".json": // JSON is a subset of YAML
 		return c.loadYaml(path)
 	default:
 		return errors.Wrap(ErrUnsupportedFormat, path)
@@ -759,6 +1077,28 @@ func (c *Config) GetCollector() string {
 	return c.Collector
 }
 
+// GetDataResidency returns the data residency region
+func (c *Config) GetDataResidency() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.DataResidency
+}
+
+// GetRegionCollectors returns the collectors of the data residency region,
+// which are the configured ones or else the known ones of the region. It's
+// empty if the data residency is not set or the region is unknown.
+func (c *Config) GetRegionCollectors() []string {
+	c.RLock()
+	defer c.RUnlock()
+	if c.DataResidency == "" {
+		return nil
+	}
+	if len(c.RegionCollectors) != 0 {
+		return c.RegionCollectors
+	}
+	return regionCollectors[c.DataResidency]
+}
+
 // GetServiceKey returns the service key
 func (c *Config) GetServiceKey() string {
 	c.RLock()
@@ -780,6 +1120,24 @@ func (c *Config) GetReporterType() string {
 	return c.ReporterType
 }
 
+// GetRequestedReporterType returns the reporter type as configured, even if
+// it's not a built-in one and ReporterType falls back to the default.
+func (c *Config) GetRequestedReporterType() string {
+	c.RLock()
+	defer c.RUnlock()
+	if c.customReporterType != "" {
+		return c.customReporterType
+	}
+	return c.ReporterType
+}
+
+// GetReporterEncoding returns the wire format of the UDP and custom reporters
+func (c *Config) GetReporterEncoding() string {
+	c.RLock()
+	defer c.RUnlock()
+	return strings.ToLower(strings.TrimSpace(c.ReporterEncoding))
+}
+
 // GetCollectorUDP returns the UDP collector host
 func (c *Config) GetCollectorUDP() string {
 	c.RLock()
@@ -787,6 +1145,19 @@ func (c *Config) GetCollectorUDP() string {
 	return c.CollectorUDP
 }
 
+// GetCollectorUDPAddrs returns the host and port of each UDP collector
+func (c *Config) GetCollectorUDPAddrs() []string {
+	c.RLock()
+	defer c.RUnlock()
+	var addrs []string
+	for _, addr := range strings.Split(c.CollectorUDP, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
 // GetTracingMode returns the local tracing mode
 func (c *Config) GetTracingMode() TracingMode {
 	c.RLock()
@@ -850,6 +1221,13 @@ func (c *Config) GetEc2MetadataTimeout() int {
 	return c.Ec2MetadataTimeout
 }
 
+// GetDisableEc2Metadata returns whether the EC2 metadata retrieval is disabled
+func (c *Config) GetDisableEc2Metadata() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.DisableEc2Metadata
+}
+
 // GetDebugLevel returns the global logging level. Note that it may return an
 // empty string
 func (c *Config) GetDebugLevel() string {
@@ -865,7 +1243,7 @@ func (c *Config) GetTriggerTrace() bool {
 	return c.TriggerTrace
 }
 
-// GetProxy returns the HTTP/HTTPS proxy url
+// GetProxy returns the HTTP/HTTPS or SOCKS5 proxy url
 func (c *Config) GetProxy() string {
 	c.RLock()
 	defer c.RUnlock()
@@ -907,11 +1285,280 @@ func (c *Config) GetReportQueryString() bool {
 	return c.ReportQueryString
 }
 
-// GetTransactionFiltering returns the transaction filtering config
+// GetTransactionFiltering returns the transaction filtering config, with the
+// filters of ExcludedURLs and ExcludedExtensions appended.
 func (c *Config) GetTransactionFiltering() []TransactionFilter {
 	c.RLock()
 	defer c.RUnlock()
-	return c.TransactionSettings
+	if len(c.ExcludedURLs) == 0 && len(c.ExcludedExtensions) == 0 {
+		return c.TransactionSettings
+	}
+
+	filters := append([]TransactionFilter(nil), c.TransactionSettings...)
+	for _, re := range c.ExcludedURLs {
+		filters = append(filters, TransactionFilter{Type: URL, RegEx: re, Tracing: DisabledTracingMode})
+	}
+	if len(c.ExcludedExtensions) != 0 {
+		filters = append(filters, TransactionFilter{
+			Type:       URL,
+			Extensions: c.ExcludedExtensions,
+			Tracing:    DisabledTracingMode,
+		})
+	}
+	return filters
+}
+
+// GetMetricsExclusion returns the transaction name patterns excluded from metrics
+func (c *Config) GetMetricsExclusion() []string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.MetricsExclusion
+}
+
+// GetHTTPStatusTagging returns how the HTTP status is tagged in the metrics
+func (c *Config) GetHTTPStatusTagging() StatusTagging {
+	c.RLock()
+	defer c.RUnlock()
+	return c.HTTPStatusTagging
+}
+
+// GetSampledHeader returns if the X-Trace-Sampled header is sent and honored
+func (c *Config) GetSampledHeader() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.SampledHeader
+}
+
+// GetReloadOnSIGHUP returns if the configuration is reloaded on SIGHUP
+func (c *Config) GetReloadOnSIGHUP() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.ReloadOnSIGHUP
+}
+
+// GetHardenedTraceIDs returns if the hardened trace ID generation is enabled
+func (c *Config) GetHardenedTraceIDs() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.HardenedTraceIDs
+}
+
+// GetActiveSpanRegistry returns if the active spans are tracked per goroutine
+func (c *Config) GetActiveSpanRegistry() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.ActiveSpanRegistry
+}
+
+// GetReportExemplars returns if the trace IDs are attached to the metrics
+func (c *Config) GetReportExemplars() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.ReportExemplars
+}
+
+// GetOverheadBudget returns the overhead budget in percentage
+func (c *Config) GetOverheadBudget() float64 {
+	c.RLock()
+	defer c.RUnlock()
+	return c.OverheadBudget
+}
+
+// GetApdexThreshold returns the Apdex threshold of the transaction, or 0 if
+// the Apdex is not measured for it.
+func (c *Config) GetApdexThreshold(transaction string) time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+	for _, r := range c.apdexRules {
+		if matched, _ := path.Match(r.pattern, transaction); matched {
+			return r.threshold
+		}
+	}
+	return time.Duration(c.ApdexThreshold) * time.Millisecond
+}
+
+// GetSLOTarget returns the latency SLO target of the transaction, or 0 if it
+// has none.
+func (c *Config) GetSLOTarget(transaction string) time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+	for _, r := range c.sloRules {
+		if matched, _ := path.Match(r.pattern, transaction); matched {
+			return r.threshold
+		}
+	}
+	return 0
+}
+
+// GetHistogramUnit returns the unit of the values recorded by the histograms
+func (c *Config) GetHistogramUnit() time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+	if c.HistogramUnit == histogramUnitMs {
+		return time.Millisecond
+	}
+	return time.Microsecond
+}
+
+// GetHistogramMaxValue returns the highest response time the histograms can
+// track.
+func (c *Config) GetHistogramMaxValue() time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+	if c.HistogramMaxSeconds == 0 {
+		return time.Hour
+	}
+	return time.Duration(c.HistogramMaxSeconds) * time.Second
+}
+
+// GetSendFailureThreshold returns the number of consecutive send failures which
+// raise the send failure alert.
+func (c *Config) GetSendFailureThreshold() int {
+	c.RLock()
+	defer c.RUnlock()
+	if c.SendFailureThreshold == 0 {
+		return 30
+	}
+	return c.SendFailureThreshold
+}
+
+// GetSpoolDir returns the directory the failed event batches are spooled to.
+func (c *Config) GetSpoolDir() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.SpoolDir
+}
+
+// GetSpoolMaxBytes returns the max size of the spooled event batches.
+func (c *Config) GetSpoolMaxBytes() int64 {
+	c.RLock()
+	defer c.RUnlock()
+	if c.SpoolMaxMB == 0 {
+		return 100 << 20
+	}
+	return int64(c.SpoolMaxMB) << 20
+}
+
+// GetSpoolTTL returns how long the spooled event batches are kept for.
+func (c *Config) GetSpoolTTL() time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+	if c.SpoolTTLSeconds == 0 {
+		return time.Hour
+	}
+	return time.Duration(c.SpoolTTLSeconds) * time.Second
+}
+
+// GetErrorBodyBytes returns the number of bytes of the 5xx response bodies
+// captured, or zero if the capture is disabled.
+func (c *Config) GetErrorBodyBytes() int {
+	c.RLock()
+	defer c.RUnlock()
+	return c.ErrorBodyBytes
+}
+
+// GetTraceMirrorDir returns the directory of the trace mirroring toggled at
+// runtime.
+func (c *Config) GetTraceMirrorDir() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.TraceMirrorDir
+}
+
+// GetTraceTokenSecret returns the secret the trace tokens are signed with, or
+// an empty string if the trace tokens are disabled.
+func (c *Config) GetTraceTokenSecret() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.TraceTokenSecret
+}
+
+// GetSlowProfileThreshold returns the duration after which a sampled HTTP
+// request is profiled, or zero if disabled.
+func (c *Config) GetSlowProfileThreshold() time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+	return time.Duration(c.SlowProfileThreshold) * time.Millisecond
+}
+
+// GetSlowProfileType returns the type of the profile taken of the slow requests.
+func (c *Config) GetSlowProfileType() string {
+	c.RLock()
+	defer c.RUnlock()
+	if c.SlowProfileType == "" {
+		return SlowProfileGoroutine
+	}
+	return strings.ToLower(c.SlowProfileType)
+}
+
+// GetSlowProfileInterval returns the minimum time between two profiles of the
+// slow requests.
+func (c *Config) GetSlowProfileInterval() time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+	if c.SlowProfileIntervalSeconds == 0 {
+		return time.Minute
+	}
+	return time.Duration(c.SlowProfileIntervalSeconds) * time.Second
+}
+
+// GetMetricsIdleCycles returns the number of flush cycles the metrics of an idle
+// transaction are kept for.
+func (c *Config) GetMetricsIdleCycles() int {
+	c.RLock()
+	defer c.RUnlock()
+	return c.MetricsIdleCycles
+}
+
+// GetInfoEventSampling returns N if only one of every N Info events of the
+// layer is reported in a trace, or 1 if all of them are reported.
+func (c *Config) GetInfoEventSampling(layer string) int {
+	c.RLock()
+	defer c.RUnlock()
+	if n, ok := c.infoEventSampling[layer]; ok {
+		return n
+	}
+	return 1
+}
+
+// GetMaxKVValueLength returns the max length of the string values of event KVs
+func (c *Config) GetMaxKVValueLength() int {
+	c.RLock()
+	defer c.RUnlock()
+	return c.MaxKVValueLength
+}
+
+// GetMaxTagValueLength returns the max length of the metrics tag values
+func (c *Config) GetMaxTagValueLength() int {
+	c.RLock()
+	defer c.RUnlock()
+	if c.MaxTagValueLength == 0 {
+		return MaxTagValueLength
+	}
+	return c.MaxTagValueLength
+}
+
+// IsLayerDisabled returns if the layer is configured to be disabled
+func (c *Config) IsLayerDisabled(layer string) bool {
+	c.RLock()
+	defer c.RUnlock()
+	_, ok := c.disabledLayers[layer]
+	return ok
+}
+
+// IsMetricsTagAllowed returns if the measurements tagged with the key are
+// reported. The deny list takes precedence over the allow list.
+func (c *Config) IsMetricsTagAllowed(key string) bool {
+	c.RLock()
+	defer c.RUnlock()
+	if _, ok := c.metricsTagDeny[key]; ok {
+		return false
+	}
+	if c.metricsTagAllow == nil {
+		return true
+	}
+	_, ok := c.metricsTagAllow[key]
+	return ok
 }
 
 // GetTransactionName returns the user-defined transaction name. It's only available
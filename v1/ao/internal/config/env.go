@@ -109,6 +109,15 @@ func stringToValue(s string, typ reflect.Type) (reflect.Value, error) {
 	case reflect.Slice:
 		if s == "" {
 			return reflect.Zero(typ), nil
+		} else if typ.Elem().Kind() == reflect.String {
+			// a comma-separated list of strings
+			var items []string
+			for _, item := range strings.Split(s, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			val = items
 		} else {
 			panic(fmt.Sprintf("Slice with non-empty value is not supported"))
 		}
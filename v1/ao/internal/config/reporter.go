@@ -3,7 +3,11 @@
 package config
 
 import (
+	"strconv"
 	"sync/atomic"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
 )
 
 // ReporterOptions defines the options of a reporter. The fields of it
@@ -27,11 +31,15 @@ type ReporterOptions struct {
 	// Ping interval in seconds
 	PingInterval int64 `yaml:"PingInterval,omitempty" default:"20"`
 
-	// Retry backoff initial delay
-	RetryDelayInitial int64 `yaml:"RetryDelayInitial,omitempty" default:"500"`
+	// Retry backoff initial delay in milliseconds
+	RetryDelayInitial int64 `yaml:"RetryDelayInitial,omitempty" env:"APPOPTICS_RETRY_DELAY_INITIAL" default:"500"`
+
+	// Maximum retry delay in seconds
+	RetryDelayMax int `yaml:"RetryDelayMax,omitempty" env:"APPOPTICS_RETRY_DELAY_MAX" default:"60"`
 
-	// Maximum retry delay
-	RetryDelayMax int `yaml:"RetryDelayMax,omitempty" default:"60"`
+	// The fraction of the retry delay randomized, from 0 to 1, so the agents
+	// don't retry at the same time after a collector restart
+	RetryJitter float64 `yaml:"RetryJitter,omitempty" env:"APPOPTICS_RETRY_JITTER" default:"0.2"`
 
 	// Maximum redirect times
 	RedirectMax int `yaml:"RedirectMax,omitempty" default:"20"`
@@ -40,7 +48,7 @@ type ReporterOptions struct {
 	RetryLogThreshold int `yaml:"RetryLogThreshold,omitempty" default:"10"`
 
 	// The maximum retries
-	MaxRetries int `yaml:"MaxRetries,omitempty" default:"20"`
+	MaxRetries int `yaml:"MaxRetries,omitempty" env:"APPOPTICS_MAX_RETRIES" default:"20"`
 }
 
 // SetEventFlushInterval sets the event flush interval to i
@@ -63,7 +71,44 @@ func (r *ReporterOptions) GetMaxReqBytes() int64 {
 	return atomic.LoadInt64(&r.MaxReqBytes)
 }
 
+// GetRetryDelayInitial returns the delay before the first retry of a failed
+// RPC call.
+func (r *ReporterOptions) GetRetryDelayInitial() time.Duration {
+	return time.Duration(atomic.LoadInt64(&r.RetryDelayInitial)) * time.Millisecond
+}
+
+// GetRetryDelayMax returns the maximum delay between the retries.
+func (r *ReporterOptions) GetRetryDelayMax() time.Duration {
+	return time.Duration(r.RetryDelayMax) * time.Second
+}
+
+// GetRetryJitter returns the fraction of the retry delay randomized.
+func (r *ReporterOptions) GetRetryJitter() float64 {
+	return r.RetryJitter
+}
+
+// GetMaxRetries returns the number of retries before a failed RPC call is
+// given up.
+func (r *ReporterOptions) GetMaxRetries() int {
+	return r.MaxRetries
+}
+
 func (r *ReporterOptions) validate() error {
-	// TODO
+	if r.RetryDelayInitial <= 0 {
+		log.Warning(InvalidEnv("RetryDelayInitial", strconv.FormatInt(r.RetryDelayInitial, 10)))
+		r.RetryDelayInitial, _ = strconv.ParseInt(getFieldDefaultValue(r, "RetryDelayInitial"), 10, 64)
+	}
+	if r.RetryDelayMax <= 0 {
+		log.Warning(InvalidEnv("RetryDelayMax", strconv.Itoa(r.RetryDelayMax)))
+		r.RetryDelayMax = ToInteger(getFieldDefaultValue(r, "RetryDelayMax"))
+	}
+	if r.RetryJitter < 0 || r.RetryJitter > 1 {
+		log.Warning(InvalidEnv("RetryJitter", strconv.FormatFloat(r.RetryJitter, 'f', -1, 64)))
+		r.RetryJitter, _ = strconv.ParseFloat(getFieldDefaultValue(r, "RetryJitter"), 64)
+	}
+	if r.MaxRetries < 0 {
+		log.Warning(InvalidEnv("MaxRetries", strconv.Itoa(r.MaxRetries)))
+		r.MaxRetries = ToInteger(getFieldDefaultValue(r, "MaxRetries"))
+	}
 	return nil
 }
@@ -4,10 +4,16 @@ package config
 
 import (
 	"fmt"
+	"net/url"
+	"path"
 	"regexp"
 	"strconv"
 	"strings"
+	"text/template"
+	"time"
 	"unicode/utf8"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
 )
 
 // InvalidEnv returns a string indicating invalid environment variables
@@ -103,9 +109,44 @@ func IsValidEc2MetadataTimeout(t int) bool {
 	return t >= 0 && t <= 3000
 }
 
+// IsValidHistogramPrecision checks if the histogram precision is within the
+// range supported by the histograms
+func IsValidHistogramPrecision(p int) bool {
+	return p >= 0 && p <= 5
+}
+
+// the units of the histogram values
+const (
+	histogramUnitUs = "us"
+	histogramUnitMs = "ms"
+)
+
+// IsValidHistogramUnit checks if the histogram unit is supported, or empty for
+// the default one
+func IsValidHistogramUnit(u string) bool {
+	return u == "" || u == histogramUnitUs || u == histogramUnitMs
+}
+
+// IsValidProxy checks if the proxy url has a supported scheme, or is empty
+// for no proxy
+func IsValidProxy(p string) bool {
+	if p == "" {
+		return true
+	}
+	u, err := url.Parse(p)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "http" || u.Scheme == "https" || u.Scheme == "socks5"
+}
+
 // IsValidTracingMode checks if the mode is valid
 func IsValidTracingMode(m TracingMode) bool {
-	return m == EnabledTracingMode || m == DisabledTracingMode
+	switch m {
+	case EnabledTracingMode, DisabledTracingMode, MetricsOnlyTracingMode, DryRunTracingMode:
+		return true
+	}
+	return false
 }
 
 // IsValidSampleRate checks if the rate is valid
@@ -121,6 +162,37 @@ func IsValidTokenBucketCap(cap float64) bool {
 	return cap >= 0 && cap <= maxTokenBucketCapacity
 }
 
+// IsValidOverheadBudget checks if the overhead budget is a valid percentage
+func IsValidOverheadBudget(budget float64) bool {
+	return budget >= 0 && budget <= 100
+}
+
+// IsValidMaxKVValueLength checks if the max length of KV values is valid
+func IsValidMaxKVValueLength(l int) bool {
+	return l >= 0
+}
+
+// IsValidMaxTagValueLength checks if the max length of tag values is valid
+func IsValidMaxTagValueLength(l int) bool {
+	return l >= 0 && l <= MaxTagValueLength
+}
+
+// IsValidErrorBodyBytes checks if the number of bytes of the response bodies
+// captured is valid
+func IsValidErrorBodyBytes(n int) bool {
+	return n >= 0 && n <= MaxErrorBodyBytes
+}
+
+// IsValidSlowProfileType checks if the type of the slow request profiles is
+// valid. Empty means the default type.
+func IsValidSlowProfileType(t string) bool {
+	switch strings.ToLower(t) {
+	case "", SlowProfileGoroutine, SlowProfileCPU:
+		return true
+	}
+	return false
+}
+
 // NormalizeTracingMode converts an old-style tracing mode (always/never) to a
 // new-style tracing mode (enabled/disabled).
 func NormalizeTracingMode(m TracingMode) TracingMode {
@@ -136,9 +208,92 @@ func NormalizeTracingMode(m TracingMode) TracingMode {
 	return mode
 }
 
-// IsValidHostnameAlias checks if the alias is valid
+// IsValidHostnameAlias checks if the alias is valid. It can be a template of the
+// host metadata, e.g., {{.K8sNamespace}}-{{.PodName}}.
 func IsValidHostnameAlias(a string) bool {
-	return true
+	_, err := template.New("HostAlias").Parse(a)
+	return err == nil
+}
+
+// IsValidStatusTagging checks if the HTTP status tagging option is valid
+func IsValidStatusTagging(t StatusTagging) bool {
+	return t == ExactStatusTagging || t == ClassStatusTagging || t == BothStatusTagging
+}
+
+// validTransactionPatterns returns the patterns with the malformed ones dropped
+func validTransactionPatterns(patterns []string) []string {
+	var valid []string
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			log.Warning(InvalidEnv("MetricsExclusion", p))
+			continue
+		}
+		valid = append(valid, p)
+	}
+	return valid
+}
+
+// toSet builds a set of the strings for fast lookup. It returns nil if there
+// is none.
+func toSet(list []string) map[string]struct{} {
+	var set map[string]struct{}
+	for _, s := range list {
+		if set == nil {
+			set = make(map[string]struct{})
+		}
+		set[s] = struct{}{}
+	}
+	return set
+}
+
+// IsValidApdexThreshold checks if the Apdex threshold is valid
+func IsValidApdexThreshold(t int) bool {
+	return t >= 0
+}
+
+// parseLatencyRules parses the per-transaction latency thresholds of the
+// config option in the format of pattern=milliseconds, with the malformed ones
+// dropped.
+func parseLatencyRules(option string, thresholds []string) []latencyRule {
+	var rules []latencyRule
+	for _, t := range thresholds {
+		idx := strings.LastIndex(t, "=")
+		if idx <= 0 {
+			log.Warning(InvalidEnv(option, t))
+			continue
+		}
+		pattern := strings.TrimSpace(t[:idx])
+		ms, err := strconv.Atoi(strings.TrimSpace(t[idx+1:]))
+		if _, e := path.Match(pattern, ""); e != nil || err != nil || ms <= 0 {
+			log.Warning(InvalidEnv(option, t))
+			continue
+		}
+		rules = append(rules, latencyRule{pattern: pattern, threshold: time.Duration(ms) * time.Millisecond})
+	}
+	return rules
+}
+
+// parseInfoEventSampling parses the per-layer Info event sample rates in the
+// format of layer=N, with the malformed ones dropped.
+func parseInfoEventSampling(rates []string) map[string]int {
+	var sampling map[string]int
+	for _, r := range rates {
+		idx := strings.LastIndex(r, "=")
+		if idx <= 0 {
+			log.Warning(InvalidEnv("InfoEventSampling", r))
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(r[idx+1:]))
+		if err != nil || n <= 0 {
+			log.Warning(InvalidEnv("InfoEventSampling", r))
+			continue
+		}
+		if sampling == nil {
+			sampling = make(map[string]int)
+		}
+		sampling[strings.TrimSpace(r[:idx])] = n
+	}
+	return sampling
 }
 
 // ToInteger converts a string to an integer
@@ -59,6 +59,8 @@ func TestMaskServiceKey(t *testing.T) {
 func TestIsValidTracingMode(t *testing.T) {
 	assert.Equal(t, true, IsValidTracingMode("enabled"))
 	assert.Equal(t, true, IsValidTracingMode("disabled"))
+	assert.Equal(t, true, IsValidTracingMode("metrics-only"))
+	assert.Equal(t, true, IsValidTracingMode("dry-run"))
 	assert.Equal(t, false, IsValidTracingMode("abc"))
 	assert.Equal(t, false, IsValidTracingMode(""))
 	assert.Equal(t, false, IsValidTracingMode("ENABLED"))
@@ -9,6 +9,12 @@ var conf = NewConfig()
 // GetCollector is a wrapper to the method of the global config
 var GetCollector = conf.GetCollector
 
+// GetDataResidency is a wrapper to the method of the global config
+var GetDataResidency = conf.GetDataResidency
+
+// GetRegionCollectors is a wrapper to the method of the global config
+var GetRegionCollectors = conf.GetRegionCollectors
+
 // GetServiceKey is a wrapper to the method of the global config
 var GetServiceKey = conf.GetServiceKey
 
@@ -27,9 +33,18 @@ var GetSampleRate = conf.GetSampleRate
 // SamplingConfigured is a wrapper to the method of the global config
 var SamplingConfigured = conf.SamplingConfigured
 
+// GetRequestedReporterType is a wrapper to the method of the global config
+var GetRequestedReporterType = conf.GetRequestedReporterType
+
+// GetReporterEncoding is a wrapper to the method of the global config
+var GetReporterEncoding = conf.GetReporterEncoding
+
 // GetCollectorUDP is a wrapper to the method of the global config
 var GetCollectorUDP = conf.GetCollectorUDP
 
+// GetCollectorUDPAddrs is a wrapper to the method of the global config
+var GetCollectorUDPAddrs = conf.GetCollectorUDPAddrs
+
 // GetPrependDomain is a wrapper to the method of the global config
 var GetPrependDomain = conf.GetPrependDomain
 
@@ -48,6 +63,9 @@ var ReporterOpts = conf.GetReporter
 // GetEc2MetadataTimeout is a wrapper to the method of the global config
 var GetEc2MetadataTimeout = conf.GetEc2MetadataTimeout
 
+// GetDisableEc2Metadata is a wrapper to the method of the global config
+var GetDisableEc2Metadata = conf.GetDisableEc2Metadata
+
 // DebugLevel is a wrapper to the method of the global config
 var DebugLevel = conf.GetDebugLevel
 
@@ -72,12 +90,99 @@ var GetTransactionFiltering = conf.GetTransactionFiltering
 
 var GetTransactionName = conf.GetTransactionName
 
+// GetMetricsExclusion is a wrapper to the method of the global config
+var GetMetricsExclusion = conf.GetMetricsExclusion
+
+// GetHTTPStatusTagging is a wrapper to the method of the global config
+var GetHTTPStatusTagging = conf.GetHTTPStatusTagging
+
+// GetSampledHeader is a wrapper to the method of the global config
+var GetSampledHeader = conf.GetSampledHeader
+
+// GetReloadOnSIGHUP is a wrapper to the method of the global config
+var GetReloadOnSIGHUP = conf.GetReloadOnSIGHUP
+
+// GetHardenedTraceIDs is a wrapper to the method of the global config
+var GetHardenedTraceIDs = conf.GetHardenedTraceIDs
+
+// GetActiveSpanRegistry is a wrapper to the method of the global config
+var GetActiveSpanRegistry = conf.GetActiveSpanRegistry
+
+// GetReportExemplars is a wrapper to the method of the global config
+var GetReportExemplars = conf.GetReportExemplars
+
+// GetOverheadBudget is a wrapper to the method of the global config
+var GetOverheadBudget = conf.GetOverheadBudget
+
+// GetApdexThreshold is a wrapper to the method of the global config
+var GetApdexThreshold = conf.GetApdexThreshold
+
+// GetSLOTarget is a wrapper to the method of the global config
+var GetSLOTarget = conf.GetSLOTarget
+
+// GetHistogramUnit is a wrapper to the method of the global config
+var GetHistogramUnit = conf.GetHistogramUnit
+
+// GetHistogramMaxValue is a wrapper to the method of the global config
+var GetHistogramMaxValue = conf.GetHistogramMaxValue
+
+// GetSendFailureThreshold is a wrapper to the method of the global config
+var GetSendFailureThreshold = conf.GetSendFailureThreshold
+
+// GetSpoolDir is a wrapper to the method of the global config
+var GetSpoolDir = conf.GetSpoolDir
+
+// GetSpoolMaxBytes is a wrapper to the method of the global config
+var GetSpoolMaxBytes = conf.GetSpoolMaxBytes
+
+// GetSpoolTTL is a wrapper to the method of the global config
+var GetSpoolTTL = conf.GetSpoolTTL
+
+// GetErrorBodyBytes is a wrapper to the method of the global config
+var GetErrorBodyBytes = conf.GetErrorBodyBytes
+
+// GetTraceMirrorDir is a wrapper to the method of the global config
+var GetTraceMirrorDir = conf.GetTraceMirrorDir
+
+// GetTraceTokenSecret is a wrapper to the method of the global config
+var GetTraceTokenSecret = conf.GetTraceTokenSecret
+
+// GetSlowProfileThreshold is a wrapper to the method of the global config
+var GetSlowProfileThreshold = conf.GetSlowProfileThreshold
+
+// GetSlowProfileType is a wrapper to the method of the global config
+var GetSlowProfileType = conf.GetSlowProfileType
+
+// GetSlowProfileInterval is a wrapper to the method of the global config
+var GetSlowProfileInterval = conf.GetSlowProfileInterval
+
+// GetMetricsIdleCycles is a wrapper to the method of the global config
+var GetMetricsIdleCycles = conf.GetMetricsIdleCycles
+
+// IsMetricsTagAllowed is a wrapper to the method of the global config
+var IsMetricsTagAllowed = conf.IsMetricsTagAllowed
+
+// GetInfoEventSampling is a wrapper to the method of the global config
+var GetInfoEventSampling = conf.GetInfoEventSampling
+
+// GetMaxKVValueLength is a wrapper to the method of the global config
+var GetMaxKVValueLength = conf.GetMaxKVValueLength
+
+// GetMaxTagValueLength is a wrapper to the method of the global config
+var GetMaxTagValueLength = conf.GetMaxTagValueLength
+
+// IsLayerDisabled is a wrapper to the method of the global config
+var IsLayerDisabled = conf.IsLayerDisabled
+
 // GetSQLSanitize is a wrapper to method GetSQLSanitize of the global variable config.
 var GetSQLSanitize = conf.GetSQLSanitize
 
 // Load reads the customized configurations
 var Load = conf.Load
 
+// Reload is a wrapper to the method of the global config
+var Reload = conf.Reload
+
 var GetDelta = conf.GetDelta
 
 func init() {
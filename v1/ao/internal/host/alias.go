@@ -0,0 +1,58 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package host
+
+import (
+	"strings"
+	"text/template"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+)
+
+// AliasData is the host metadata available to the hostname alias template,
+// e.g., {{.K8sNamespace}}-{{.PodName}}.
+type AliasData struct {
+	Hostname       string
+	Pid            int
+	EC2Id          string
+	EC2Zone        string
+	ContainerId    string
+	HerokuId       string
+	AzureAppInstId string
+	K8sNamespace   string
+	PodName        string
+}
+
+// resolveAlias executes the alias template with the current host metadata. It
+// doesn't wait for the host ID to be ready, so the fields still being fetched
+// are empty. The hostname is returned if the template fails.
+func resolveAlias(alias string) string {
+	t, err := template.New("HostAlias").Parse(alias)
+	if err != nil {
+		log.Warningf("Invalid hostname alias template %s: %s", alias, err)
+		return Hostname()
+	}
+
+	id := BestEffortCurrentID()
+	data := AliasData{
+		Hostname:       id.Hostname(),
+		Pid:            id.Pid(),
+		EC2Id:          id.EC2Id(),
+		EC2Zone:        id.EC2Zone(),
+		ContainerId:    id.ContainerId(),
+		HerokuId:       id.HerokuId(),
+		AzureAppInstId: id.AzureAppInstId(),
+		K8sNamespace:   getK8sNamespace(),
+		PodName:        getK8sPodName(),
+	}
+	if data.Hostname == "" {
+		data.Hostname = Hostname()
+	}
+
+	var sb strings.Builder
+	if err := t.Execute(&sb, data); err != nil {
+		log.Warningf("Failed to resolve hostname alias template %s: %s", alias, err)
+		return Hostname()
+	}
+	return sb.String()
+}
@@ -0,0 +1,119 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package host
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+	"github.com/pkg/errors"
+)
+
+const (
+	// the environment variable of the task metadata endpoint (v4), which is set
+	// by the ECS agent and by Fargate in every container of a task
+	envECSMetadataURI = "ECS_CONTAINER_METADATA_URI_V4"
+
+	// the endpoint is local to the task so it should respond quickly
+	ecsMetadataTimeout = time.Second
+)
+
+// the keys of the ECS metadata reported with the metrics
+const (
+	KeyECSTaskARN     = "ECSTaskARN"
+	KeyECSCluster     = "ECSCluster"
+	KeyECSContainerID = "ECSContainerID"
+)
+
+type ecsMetadata struct {
+	taskARN     string
+	cluster     string
+	containerID string
+}
+
+// the cache of the ECS metadata and its sync.Once protector
+var (
+	ecsMeta     ecsMetadata
+	ecsMetaOnce sync.Once
+)
+
+// getECSMetadata returns the metadata of the ECS task and container which the
+// process runs in, which is fetched only once as it doesn't change.
+func getECSMetadata() ecsMetadata {
+	ecsMetaOnce.Do(func() {
+		uri := os.Getenv(envECSMetadataURI)
+		if uri == "" {
+			return
+		}
+		var err error
+		if ecsMeta, err = fetchECSMetadata(uri); err != nil {
+			log.Debugf("Failed to get the ECS metadata from %s: %v", uri, err)
+		}
+		log.Debugf("Got and cached ECS metadata: %+v", ecsMeta)
+	})
+	return ecsMeta
+}
+
+// fetchECSMetadata requests the metadata of the container from uri, and that of
+// its task from uri/task. The metadata fetched is returned even on an error.
+func fetchECSMetadata(uri string) (ecsMetadata, error) {
+	// This request requires no proxy (and shouldn't).
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: nil},
+		Timeout:   ecsMetadataTimeout,
+	}
+	uri = strings.TrimSuffix(uri, "/")
+
+	var md ecsMetadata
+	var container struct {
+		DockerId string
+	}
+	if err := getJSON(client, uri, &container); err != nil {
+		return md, err
+	}
+	md.containerID = container.DockerId
+
+	var task struct {
+		TaskARN string
+		Cluster string
+	}
+	if err := getJSON(client, uri+"/task", &task); err != nil {
+		return md, err
+	}
+	md.taskARN, md.cluster = task.TaskARN, task.Cluster
+	return md, nil
+}
+
+func getJSON(client *http.Client, url string, v interface{}) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("%s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// ECSMetadata returns the metadata of the ECS task which the process runs in,
+// keyed by KeyECSTaskARN, etc. The unknown ones are left out, so it's empty
+// outside of ECS.
+func ECSMetadata() map[string]string {
+	md := make(map[string]string)
+	add := func(k, v string) {
+		if v != "" {
+			md[k] = v
+		}
+	}
+	meta := getECSMetadata()
+	add(KeyECSTaskARN, meta.taskARN)
+	add(KeyECSCluster, meta.cluster)
+	add(KeyECSContainerID, meta.containerID)
+	return md
+}
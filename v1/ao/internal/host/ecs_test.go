@@ -0,0 +1,52 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package host
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchECSMetadata(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v4/abc":
+			w.Write([]byte(`{"DockerId":"cd189a933e5849daa93386466019ab50-2495160603","Name":"web"}`))
+		case "/v4/abc/task":
+			w.Write([]byte(`{"Cluster":"arn:aws:ecs:us-west-2:111122223333:cluster/prod",` +
+				`"TaskARN":"arn:aws:ecs:us-west-2:111122223333:task/prod/cd189a933e5849daa93386466019ab50"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	md, err := fetchECSMetadata(srv.URL + "/v4/abc")
+	assert.NoError(t, err)
+	assert.Equal(t, ecsMetadata{
+		taskARN:     "arn:aws:ecs:us-west-2:111122223333:task/prod/cd189a933e5849daa93386466019ab50",
+		cluster:     "arn:aws:ecs:us-west-2:111122223333:cluster/prod",
+		containerID: "cd189a933e5849daa93386466019ab50-2495160603",
+	}, md)
+
+	md, err = fetchECSMetadata(srv.URL + "/v4/unknown")
+	assert.Error(t, err)
+	assert.Equal(t, ecsMetadata{}, md)
+}
+
+func TestECSMetadata(t *testing.T) {
+	ecsMetaOnce.Do(func() {})
+	defer func() { ecsMeta = ecsMetadata{} }()
+
+	ecsMeta = ecsMetadata{}
+	assert.Empty(t, ECSMetadata())
+
+	ecsMeta = ecsMetadata{taskARN: "arn:task", cluster: "prod"}
+	assert.Equal(t, map[string]string{
+		KeyECSTaskARN: "arn:task",
+		KeyECSCluster: "prod",
+	}, ECSMetadata())
+}
@@ -8,6 +8,7 @@ package host
 import (
 	"net"
 	"os"
+	"strings"
 	"sync"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
@@ -92,9 +93,14 @@ func Stop() {
 	})
 }
 
-// ConfiguredHostname returns the hostname configured by user
+// ConfiguredHostname returns the hostname configured by user. The alias can be
+// a template of the host metadata, see AliasData.
 func ConfiguredHostname() string {
-	return config.GetHostAlias()
+	alias := config.GetHostAlias()
+	if !strings.Contains(alias, "{{") {
+		return alias
+	}
+	return resolveAlias(alias)
 }
 
 // Hostname returns the hostname
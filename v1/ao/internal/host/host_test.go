@@ -89,6 +89,41 @@ func TestConfiguredHostname(t *testing.T) {
 	}
 }
 
+func TestConfiguredHostnameTemplate(t *testing.T) {
+	old, has := os.LookupEnv("APPOPTICS_HOSTNAME_ALIAS")
+	defer func() {
+		if has {
+			os.Setenv("APPOPTICS_HOSTNAME_ALIAS", old)
+		} else {
+			os.Unsetenv("APPOPTICS_HOSTNAME_ALIAS")
+		}
+		config.Load()
+	}()
+
+	k8sNamespaceOnce.Do(func() {})
+	k8sPodNameOnce.Do(func() {})
+	k8sNamespace, k8sPodName = "prod", "web-5d8f7"
+	defer func() { k8sNamespace, k8sPodName = "", "" }()
+
+	os.Setenv("APPOPTICS_HOSTNAME_ALIAS", "{{.K8sNamespace}}-{{.PodName}}")
+	config.Load()
+	assert.Equal(t, "prod-web-5d8f7", ConfiguredHostname())
+
+	os.Setenv("APPOPTICS_HOSTNAME_ALIAS", "{{.Hostname}}")
+	config.Load()
+	assert.Equal(t, Hostname(), ConfiguredHostname())
+
+	// an unknown field fails the execution
+	os.Setenv("APPOPTICS_HOSTNAME_ALIAS", "{{.Unknown}}")
+	config.Load()
+	assert.Equal(t, Hostname(), ConfiguredHostname())
+
+	// malformed templates are discarded by the config
+	os.Setenv("APPOPTICS_HOSTNAME_ALIAS", "{{.PodName")
+	config.Load()
+	assert.Equal(t, "", ConfiguredHostname())
+}
+
 func TestPID(t *testing.T) {
 	assert.Equal(t, os.Getpid(), PID())
 }
@@ -26,6 +26,9 @@ var (
 	ec2Zone     string
 	ec2ZoneOnce sync.Once
 
+	// set once the EC2 metadata service is found unreachable
+	ec2Unreachable int32
+
 	// the Heroku DYNO id
 	dyno     string
 	dynoOnce sync.Once
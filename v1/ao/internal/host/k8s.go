@@ -0,0 +1,121 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package host
+
+import (
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	// the file of the namespace of the pod mounted by Kubernetes
+	k8sNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+	// the environment variables of the namespace and name of the pod and the
+	// name of the node, which can be set through the downward API of Kubernetes
+	envK8sNamespace = "POD_NAMESPACE"
+	envK8sPodName   = "POD_NAME"
+	envK8sNodeName  = "NODE_NAME"
+
+	// set by Kubernetes in every container of a pod
+	envK8sServiceHost = "KUBERNETES_SERVICE_HOST"
+)
+
+// the keys of the Kubernetes metadata reported with the metrics and traces
+const (
+	KeyK8sPodName    = "K8sPodName"
+	KeyK8sNamespace  = "K8sNamespace"
+	KeyK8sNodeName   = "K8sNodeName"
+	KeyK8sDeployment = "K8sDeployment"
+)
+
+// the caches of the Kubernetes metadata and their sync.Once protectors
+var (
+	k8sNamespace     string
+	k8sNamespaceOnce sync.Once
+
+	k8sPodName     string
+	k8sPodNameOnce sync.Once
+
+	k8sNodeName     string
+	k8sNodeNameOnce sync.Once
+
+	k8sMeta     map[string]string
+	k8sMetaOnce sync.Once
+)
+
+// the name of a pod of a deployment: the name of the deployment, the hash of
+// the pod template of its ReplicaSet, and a random suffix, both of which are
+// made of the alphabet below.
+var deploymentPodName = regexp.MustCompile(`^(.+)-[bcdfghjklmnpqrstvwxz2456789]{6,10}-[bcdfghjklmnpqrstvwxz2456789]{5}$`)
+
+func getK8sNamespace() string {
+	k8sNamespaceOnce.Do(func() {
+		if ns, has := os.LookupEnv(envK8sNamespace); has {
+			k8sNamespace = ns
+		} else if b, err := ioutil.ReadFile(k8sNamespaceFile); err == nil {
+			k8sNamespace = strings.TrimSpace(string(b))
+		}
+	})
+	return k8sNamespace
+}
+
+// getK8sPodName returns the pod name, which is also the hostname of the pod
+// unless it's overridden by the pod spec.
+func getK8sPodName() string {
+	k8sPodNameOnce.Do(func() {
+		if name, has := os.LookupEnv(envK8sPodName); has {
+			k8sPodName = name
+		} else if _, has := os.LookupEnv(envK8sServiceHost); has {
+			k8sPodName = Hostname()
+		}
+	})
+	return k8sPodName
+}
+
+// getK8sNodeName returns the node name, which is only known if it's passed in
+// through the downward API.
+func getK8sNodeName() string {
+	k8sNodeNameOnce.Do(func() {
+		k8sNodeName = os.Getenv(envK8sNodeName)
+	})
+	return k8sNodeName
+}
+
+// k8sDeployment derives the name of the deployment from the name of its pod.
+// It's empty if the pod is not named as the pods of a deployment.
+func k8sDeployment(podName string) string {
+	if m := deploymentPodName.FindStringSubmatch(podName); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// K8sMetadata returns the Kubernetes metadata of the pod which the process runs
+// in, keyed by KeyK8sPodName, etc. The unknown ones are left out, so it's empty
+// outside of Kubernetes. The map is built only once as the metadata doesn't
+// change, so it must not be modified.
+func K8sMetadata() map[string]string {
+	k8sMetaOnce.Do(func() {
+		k8sMeta = k8sMetadata()
+	})
+	return k8sMeta
+}
+
+func k8sMetadata() map[string]string {
+	md := make(map[string]string)
+	add := func(k, v string) {
+		if v != "" {
+			md[k] = v
+		}
+	}
+	pod := getK8sPodName()
+	add(KeyK8sPodName, pod)
+	add(KeyK8sNamespace, getK8sNamespace())
+	add(KeyK8sNodeName, getK8sNodeName())
+	add(KeyK8sDeployment, k8sDeployment(pod))
+	return md
+}
@@ -0,0 +1,48 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package host
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestK8sDeployment(t *testing.T) {
+	assert.Equal(t, "web", k8sDeployment("web-5d8f7c9b4d-x2v7q"))
+	assert.Equal(t, "order-api", k8sDeployment("order-api-7f9c8b6d5-zt4pk"))
+	// StatefulSet, DaemonSet and bare pods
+	assert.Equal(t, "", k8sDeployment("db-0"))
+	assert.Equal(t, "", k8sDeployment("fluentd-x2v7q"))
+	assert.Equal(t, "", k8sDeployment("web"))
+	assert.Equal(t, "", k8sDeployment(""))
+}
+
+func TestK8sMetadata(t *testing.T) {
+	k8sNamespaceOnce.Do(func() {})
+	k8sPodNameOnce.Do(func() {})
+	k8sNodeNameOnce.Do(func() {})
+	defer func() { k8sNamespace, k8sPodName, k8sNodeName = "", "", "" }()
+
+	k8sNamespace, k8sPodName, k8sNodeName = "", "", ""
+	assert.Empty(t, k8sMetadata())
+
+	k8sNamespace, k8sPodName = "prod", "web-5d8f7c9b4d-x2v7q"
+	assert.Equal(t, map[string]string{
+		KeyK8sPodName:    "web-5d8f7c9b4d-x2v7q",
+		KeyK8sNamespace:  "prod",
+		KeyK8sDeployment: "web",
+	}, k8sMetadata())
+
+	k8sNodeName = "ip-10-0-1-23.ec2.internal"
+	assert.Equal(t, "ip-10-0-1-23.ec2.internal", k8sMetadata()[KeyK8sNodeName])
+
+	// cached once built
+	k8sMetaOnce = sync.Once{}
+	defer func() { k8sMetaOnce, k8sMeta = sync.Once{}, nil }()
+	md := K8sMetadata()
+	k8sNodeName = ""
+	assert.Equal(t, md, K8sMetadata())
+	assert.Equal(t, "ip-10-0-1-23.ec2.internal", K8sMetadata()[KeyK8sNodeName])
+}
@@ -6,9 +6,11 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"regexp"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
@@ -22,6 +24,12 @@ const (
 	ec2IDURL   = "http://169.254.169.254/latest/meta-data/instance-id"
 	ec2ZoneURL = "http://169.254.169.254/latest/meta-data/placement/availability-zone"
 
+	// the IMDSv2 session token, which is requested from the same host
+	ec2TokenPath      = "/latest/api/token"
+	ec2TokenHeader    = "X-aws-ec2-metadata-token"
+	ec2TokenTTLHeader = "X-aws-ec2-metadata-token-ttl-seconds"
+	ec2TokenTTL       = "21600"
+
 	// the interval to update the metadata periodically
 	observeInterval = time.Minute
 
@@ -150,16 +158,24 @@ func getPid() int {
 	return os.Getpid()
 }
 
-// getAWSMeta fetches the metadata from a specific AWS URL and cache it into
-// a provided variable.
+// getAWSMeta fetches the metadata from a specific AWS URL. It uses a session
+// token of IMDSv2 and falls back to IMDSv1 if the token is not available, e.g.,
+// the PUT request is dropped by a proxy or a hop limit. The metadata service is
+// not requested any more once the plain request fails too, so the non-AWS hosts
+// don't wait for the timeout for each piece of metadata.
 func getAWSMeta(url string) (meta string) {
+	if config.GetDisableEc2Metadata() {
+		return
+	}
 	timeout := config.GetEc2MetadataTimeout()
 	if timeout == 0 {
 		log.Warning("EC2 metadata retrieval disabled.")
 		return
 	}
-	// Fetch it from the specified URL if the cache is uninitialized or no
-	// cache at all. This request requires no proxy (and shouldn't).
+	if atomic.LoadInt32(&ec2Unreachable) == 1 {
+		return
+	}
+	// This request requires no proxy (and shouldn't).
 	t := &http.Transport{
 		Proxy: nil,
 		DialContext: (&net.Dialer{
@@ -171,14 +187,32 @@ func getAWSMeta(url string) (meta string) {
 		TLSHandshakeTimeout:   10 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
 	}
+	client := &http.Client{Transport: t, Timeout: time.Millisecond * time.Duration(timeout)}
+
+	token, err := getEC2Token(client, url)
+	if err != nil {
+		log.Debugf("No IMDSv2 token (%v), falling back to IMDSv1", err)
+	}
 
-	client := http.Client{Transport: t, Timeout: time.Millisecond * time.Duration(timeout)}
-	resp, err := client.Get(url)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
-		log.Debugf("Failed to get AWS metadata from %s", url)
+		log.Debugf("Invalid AWS metadata URL %s: %v", url, err)
+		return
+	}
+	if token != "" {
+		req.Header.Set(ec2TokenHeader, token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		atomic.StoreInt32(&ec2Unreachable, 1)
+		log.Debugf("EC2 metadata service is unreachable: %v", err)
 		return
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Debugf("Failed to get AWS metadata from %s: %s", url, resp.Status)
+		return
+	}
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		log.Debugf("Failed to read AWS metadata response: %s", url)
@@ -189,6 +223,37 @@ func getAWSMeta(url string) (meta string) {
 	return
 }
 
+// getEC2Token requests an IMDSv2 session token from the metadata service of
+// the metadata URL. It returns an empty token without error if the service
+// responds but doesn't issue tokens, in which case IMDSv1 is used.
+func getEC2Token(client *http.Client, metaURL string) (string, error) {
+	u, err := url.Parse(metaURL)
+	if err != nil {
+		return "", err
+	}
+	u.Path = ec2TokenPath
+
+	req, err := http.NewRequest(http.MethodPut, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set(ec2TokenTTLHeader, ec2TokenTTL)
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Debugf("No IMDSv2 token (%s), falling back to IMDSv1", resp.Status)
+		return "", nil
+	}
+	token, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", nil
+	}
+	return string(token), nil
+}
+
 // gets the AWS instance ID (or empty string if not an AWS instance)
 func getEC2ID() string {
 	ec2IdOnce.Do(func() {
@@ -207,12 +272,16 @@ func getEC2Zone() string {
 	return ec2Zone
 }
 
-// getContainerID fetches the container ID by reading '/proc/self/cgroup'
+// getContainerID fetches the container ID by reading '/proc/self/cgroup', or
+// from the ECS task metadata endpoint on Fargate, which has no docker cgroups.
 func getContainerID() (id string) {
 	containerIdOnce.Do(func() {
 		containerId = getContainerIDFromString(func(keyword string) string {
 			return utils.GetLineByKeyword("/proc/self/cgroup", keyword)
 		})
+		if containerId == "" {
+			containerId = getECSMetadata().containerID
+		}
 		log.Debugf("Got and cached container id: %s", containerId)
 	})
 
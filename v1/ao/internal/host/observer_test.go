@@ -7,12 +7,15 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"regexp"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/utils"
 
@@ -51,6 +54,8 @@ func TestInitContainerID(t *testing.T) {
 func TestGetAWSMetadata(t *testing.T) {
 	testEc2MetadataZoneURL := "http://localhost:8880/latest/meta-data/placement/availability-zone"
 	testEc2MetadataInstanceIDURL := "http://localhost:8880/latest/meta-data/instance-id"
+	// the real metadata service may have been found unreachable by the observer
+	atomic.StoreInt32(&ec2Unreachable, 0)
 
 	sm := http.NewServeMux()
 	sm.HandleFunc("/latest/meta-data/instance-id", func(w http.ResponseWriter, r *http.Request) {
@@ -80,6 +85,89 @@ func TestGetAWSMetadata(t *testing.T) {
 	assert.Equal(t, "us-east-7", zone)
 }
 
+func TestGetAWSMetadataIMDSv2(t *testing.T) {
+	atomic.StoreInt32(&ec2Unreachable, 0)
+	var ttl string
+	sm := http.NewServeMux()
+	sm.HandleFunc(ec2TokenPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		ttl = r.Header.Get(ec2TokenTTLHeader)
+		fmt.Fprint(w, "t0ken")
+	})
+	sm.HandleFunc("/latest/meta-data/instance-id", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(ec2TokenHeader) != "t0ken" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, "unauthorized")
+			return
+		}
+		fmt.Fprint(w, "i-12345678")
+	})
+	s := httptest.NewServer(sm)
+	defer s.Close()
+
+	assert.Equal(t, "i-12345678", getAWSMeta(s.URL+"/latest/meta-data/instance-id"))
+	assert.Equal(t, ec2TokenTTL, ttl)
+	// the error responses are not taken as the metadata
+	assert.Empty(t, getAWSMeta(s.URL+"/latest/meta-data/placement/availability-zone"))
+}
+
+func TestGetAWSMetadataTokenFailure(t *testing.T) {
+	atomic.StoreInt32(&ec2Unreachable, 0)
+	sm := http.NewServeMux()
+	// the token request is dropped, e.g., by a proxy or the hop limit
+	sm.HandleFunc(ec2TokenPath, func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		require.NoError(t, err)
+		conn.Close()
+	})
+	sm.HandleFunc("/latest/meta-data/instance-id", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "i-12345678")
+	})
+	s := httptest.NewServer(sm)
+	defer s.Close()
+
+	assert.Equal(t, "i-12345678", getAWSMeta(s.URL+"/latest/meta-data/instance-id"))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&ec2Unreachable))
+}
+
+func TestGetAWSMetadataUnreachable(t *testing.T) {
+	defer atomic.StoreInt32(&ec2Unreachable, 0)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "i-12345678")
+	}))
+	defer s.Close()
+
+	ln, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	ln.Close()
+	assert.Empty(t, getAWSMeta("http://"+ln.Addr().String()+"/latest/meta-data/instance-id"))
+	// no more requests once it's unreachable
+	assert.Empty(t, getAWSMeta(s.URL+"/latest/meta-data/instance-id"))
+
+	atomic.StoreInt32(&ec2Unreachable, 0)
+	assert.Equal(t, "i-12345678", getAWSMeta(s.URL+"/latest/meta-data/instance-id"))
+}
+
+func TestGetAWSMetadataDisabled(t *testing.T) {
+	atomic.StoreInt32(&ec2Unreachable, 0)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "i-12345678")
+	}))
+	defer s.Close()
+
+	os.Setenv("APPOPTICS_DISABLE_EC2_METADATA", "true")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_DISABLE_EC2_METADATA")
+		config.Load()
+	}()
+	assert.Empty(t, getAWSMeta(s.URL+"/latest/meta-data/instance-id"))
+}
+
 func TestGetContainerID(t *testing.T) {
 	id := getContainerID()
 	if utils.GetLineByKeyword("/proc/self/cgroup", "/docker/") != "" ||
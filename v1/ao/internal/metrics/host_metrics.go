@@ -0,0 +1,81 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package metrics
+
+import (
+	"sync"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/bson"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+)
+
+// HostMetricsCollector collects the host metrics reported with the built-in
+// metrics. Each method returns false if the metric is not available, in which
+// case it's not reported. The default collector reads /proc on Linux and
+// collects nothing on the other platforms.
+type HostMetricsCollector interface {
+	// Load1 returns the system load average of the last minute.
+	Load1() (float64, bool)
+	// TotalRAM returns the total memory of the host in bytes.
+	TotalRAM() (int64, bool)
+	// FreeRAM returns the free memory of the host in bytes.
+	FreeRAM() (int64, bool)
+	// ProcessRAM returns the memory used by the process in bytes.
+	ProcessRAM() (int64, bool)
+}
+
+var hostMetricsCollector = struct {
+	sync.RWMutex
+	c HostMetricsCollector
+}{c: defaultHostMetricsCollector}
+
+// SetHostMetricsCollector replaces the collector of the host metrics, e.g., for
+// the platforms or the containers in which /proc is not available. A nil
+// collector restores the default one.
+func SetHostMetricsCollector(c HostMetricsCollector) {
+	if c == nil {
+		c = defaultHostMetricsCollector
+	}
+	hostMetricsCollector.Lock()
+	defer hostMetricsCollector.Unlock()
+	hostMetricsCollector.c = c
+}
+
+func getHostMetricsCollector() HostMetricsCollector {
+	hostMetricsCollector.RLock()
+	defer hostMetricsCollector.RUnlock()
+	return hostMetricsCollector.c
+}
+
+// noHostMetrics is the collector of the platforms without a default one.
+type noHostMetrics struct{}
+
+func (noHostMetrics) Load1() (float64, bool)    { return 0, false }
+func (noHostMetrics) TotalRAM() (int64, bool)   { return 0, false }
+func (noHostMetrics) FreeRAM() (int64, bool)    { return 0, false }
+func (noHostMetrics) ProcessRAM() (int64, bool) { return 0, false }
+
+// appends the host metrics to a BSON buffer
+// bbuf		the BSON buffer to append the metrics to
+// index	a running integer (0,1,2,...) which is needed for BSON arrays
+func addHostMetrics(bbuf *bson.Buffer, index *int) {
+	c := getHostMetricsCollector()
+	defer func() {
+		if err := recover(); err != nil {
+			log.Errorf("Host metrics collector panicked: %v", err)
+		}
+	}()
+
+	if load, ok := c.Load1(); ok {
+		addMetricsValue(bbuf, index, "Load1", load)
+	}
+	if total, ok := c.TotalRAM(); ok {
+		addMetricsValue(bbuf, index, "TotalRAM", total)
+	}
+	if free, ok := c.FreeRAM(); ok {
+		addMetricsValue(bbuf, index, "FreeRAM", free)
+	}
+	if p, ok := c.ProcessRAM(); ok {
+		addMetricsValue(bbuf, index, "ProcessRAM", int(p))
+	}
+}
@@ -3,7 +3,6 @@
 package metrics
 
 import (
-	"os"
 	"runtime"
 	"sort"
 	"strconv"
@@ -13,6 +12,7 @@ import (
 	"time"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/bson"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/hdrhist"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/host"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
@@ -24,8 +24,7 @@ const (
 	metricsTransactionsMaxDefault = 200 // default max amount of transaction names we allow per cycle
 	metricsHistPrecisionDefault   = 2   // default histogram precision
 
-	metricsTagNameLengthMax  = 64  // max number of characters for tag names
-	metricsTagValueLengthMax = 255 // max number of characters for tag values
+	metricsTagNameLengthMax = 64 // max number of bytes for tag names
 
 	// MaxTagsCount is the maximum number of tags allowed
 	MaxTagsCount = 50
@@ -41,6 +40,48 @@ const (
 	maxPathLenForTransactionName = 3
 )
 
+// ErrorCountName is the name of the measurement counting transaction errors by class
+const ErrorCountName = "ErrorCount"
+
+// ApdexCountName is the name of the measurement counting the requests of each
+// transaction by the Apdex zone: satisfied, tolerating or frustrated.
+const ApdexCountName = "ApdexCount"
+
+// The names of the measurements counting the requests and the traced requests
+// of each transaction.
+const (
+	TransactionRequestCountName = "TransactionRequestCount"
+	TransactionTraceCountName   = "TransactionTraceCount"
+)
+
+// TransactionDryRunTraceCountName is the name of the measurement counting the
+// requests of each transaction which would be traced if the tracing mode was
+// not dry-run. It predicts the trace volume before tracing is enabled.
+const TransactionDryRunTraceCountName = "TransactionDryRunTraceCount"
+
+// SLOCountName is the name of the measurement counting the requests of each
+// transaction with a latency SLO target by the SLOStatus: good if the request
+// is within the target, or bad otherwise.
+const SLOCountName = "SLOCount"
+
+// HistogramClampedName is the name of the measurement counting the durations
+// out of the range of a response time histogram, which are recorded as the
+// highest trackable value instead.
+const HistogramClampedName = "HistogramClamped"
+
+// The SLO statuses
+const (
+	SLOGood = "good"
+	SLOBad  = "bad"
+)
+
+// The Apdex zones
+const (
+	ApdexSatisfied  = "satisfied"
+	ApdexTolerating = "tolerating"
+	ApdexFrustrated = "frustrated"
+)
+
 // Request counters definition
 const (
 	RequestCount               = "RequestCount"
@@ -76,16 +117,42 @@ type SpanMessage interface {
 type BaseSpanMessage struct {
 	Duration time.Duration // duration of the span (nanoseconds)
 	HasError bool          // boolean flag whether this transaction contains an error or not
+	// the class of the first error reported by this transaction, used to tag the ErrorCount
+	// measurement. It's empty if no error is reported.
+	ErrorClass string
+	// the ID of the trace if this transaction is sampled, which is attached to
+	// the TransactionResponseTime measurements as an exemplar.
+	TraceID string
+	// whether this transaction is traced
+	Sampled bool
+	// whether this transaction would be traced if the tracing mode was not
+	// dry-run
+	DryRunSampled bool
 }
 
-// HTTPSpanMessage is used for inbound metrics
+// The entry types of transactions other than HTTP. The inbound metrics of these
+// transactions are tagged by the TransactionType rather than the HTTP method and
+// status.
+const (
+	TransactionTypeGRPC     = "grpc"
+	TransactionTypeConsumer = "consumer"
+	TransactionTypeCron     = "cron"
+)
+
+// HTTPSpanMessage is used for inbound metrics of all entry types. The fields
+// specific to HTTP are ignored if TransactionType is set.
 type HTTPSpanMessage struct {
 	BaseSpanMessage
-	Transaction string // transaction name (e.g. controller.action)
-	Path        string // the url path which will be processed and used as transaction (if Transaction is empty)
-	Status      int    // HTTP status code (e.g. 200, 500, ...)
-	Host        string // HTTP-Host
-	Method      string // HTTP method (e.g. GET, POST, ...)
+	Transaction     string // transaction name (e.g. controller.action)
+	TransactionType string // the entry type (e.g. grpc, consumer, cron), empty for HTTP
+	Path            string // the url path which will be processed and used as transaction (if Transaction is empty)
+	Status          int    // HTTP status code (e.g. 200, 500, ...)
+	Host            string // HTTP-Host
+	Method          string // HTTP method (e.g. GET, POST, ...)
+	// Sibling is set if the transaction is started alongside another one by
+	// the same request. It's left out of the overall response time histogram
+	// so that the request is not counted twice.
+	Sibling bool
 }
 
 // Measurement is a single measurement for reporting
@@ -95,6 +162,36 @@ type Measurement struct {
 	Count     int               // count of this measurement
 	Sum       float64           // sum for this measurement
 	ReportSum bool              // include the sum in the report?
+	Exemplars []Exemplar        // sampled requests of this measurement, if any
+	idle      int               // the number of flush cycles without any data
+}
+
+// Exemplar links a measurement to a trace of a sampled request which contributed
+// to it, so a latency spike can be followed to the representative traces.
+type Exemplar struct {
+	TraceID string  // the task ID of the trace in hex
+	Value   float64 // the value recorded by the request
+}
+
+// the max number of exemplars kept by a measurement in a flush interval
+const maxExemplars = 3
+
+// addExemplar keeps the exemplars of the largest values, as the slow requests
+// are the ones worth looking into.
+func (me *Measurement) addExemplar(traceID string, value float64) {
+	if len(me.Exemplars) < maxExemplars {
+		me.Exemplars = append(me.Exemplars, Exemplar{TraceID: traceID, Value: value})
+		return
+	}
+	min := 0
+	for i := range me.Exemplars {
+		if me.Exemplars[i].Value < me.Exemplars[min].Value {
+			min = i
+		}
+	}
+	if value > me.Exemplars[min].Value {
+		me.Exemplars[min] = Exemplar{TraceID: traceID, Value: value}
+	}
 }
 
 // Measurements are a collection of mutex-protected measurements
@@ -119,13 +216,43 @@ func NewMeasurements(isCustom bool, flushInterval int32, maxCount int32) *Measur
 type histogram struct {
 	hist *hdrhist.Hist     // internal representation of a histogram (see hdrhist package)
 	tags map[string]string // map of KVs
+	idle int               // the number of flush cycles without any data
+	// the number of values clamped to the highest trackable value
+	clamped int
 }
 
 // a collection of histograms
 type histograms struct {
 	histograms map[string]*histogram
-	precision  int        // histogram precision (a value between 0-5)
-	lock       sync.Mutex // protect access to this collection
+	precision  int           // histogram precision (a value between 0-5)
+	unit       time.Duration // the unit of the recorded values, microsecond if zero
+	maxValue   time.Duration // the highest trackable value, an hour if zero
+	lock       sync.Mutex    // protect access to this collection
+}
+
+// toValue converts the duration to a value in the unit of the histograms.
+func (hi *histograms) toValue(d time.Duration) int64 {
+	if hi.unit == 0 {
+		return int64(d / time.Microsecond)
+	}
+	return int64(d / hi.unit)
+}
+
+// toDuration converts a value in the unit of the histograms to a duration.
+func (hi *histograms) toDuration(v int64) time.Duration {
+	if hi.unit == 0 {
+		return time.Duration(v) * time.Microsecond
+	}
+	return time.Duration(v) * hi.unit
+}
+
+// highestTrackable returns the highest trackable value in the unit of the
+// histograms.
+func (hi *histograms) highestTrackable() int64 {
+	if hi.maxValue == 0 {
+		return hi.toValue(time.Hour)
+	}
+	return hi.toValue(hi.maxValue)
 }
 
 // EventQueueStats is the counters of the event queue stats
@@ -134,6 +261,8 @@ type EventQueueStats struct {
 	numSent       int64 // number of messages that were successfully sent
 	numOverflowed int64 // number of messages that overflowed the queue
 	numFailed     int64 // number of messages that failed to send
+	numRetried    int64 // number of messages that were resent after a failure
+	numDropped    int64 // number of messages that were given up after the retries
 	totalEvents   int64 // number of messages queued to send
 	queueLargest  int64 // maximum number of messages that were in the queue at one time
 }
@@ -150,6 +279,14 @@ func (s *EventQueueStats) NumFailedAdd(n int64) {
 	atomic.AddInt64(&s.numFailed, n)
 }
 
+func (s *EventQueueStats) NumRetriedAdd(n int64) {
+	atomic.AddInt64(&s.numRetried, n)
+}
+
+func (s *EventQueueStats) NumDroppedAdd(n int64) {
+	atomic.AddInt64(&s.numDropped, n)
+}
+
 func (s *EventQueueStats) TotalEventsAdd(n int64) {
 	atomic.AddInt64(&s.totalEvents, n)
 }
@@ -310,23 +447,15 @@ var metricsHTTPHistograms = &histograms{
 	precision:  metricsHistPrecisionDefault,
 }
 
-// TODO: use config package, and add validator (0-5)
-// initialize values according to env variables
+// initialize the precision and the range from the config, which is validated
+// by the config package
 func init() {
-	pEnv := "APPOPTICS_HISTOGRAM_PRECISION"
-	precision := os.Getenv(pEnv)
-	if precision != "" {
-		log.Infof("Non-default APPOPTICS_HISTOGRAM_PRECISION: %s", precision)
-		if p, err := strconv.Atoi(precision); err == nil {
-			if p >= 0 && p <= 5 {
-				metricsHTTPHistograms.precision = p
-			} else {
-				log.Errorf("value of %v must be between 0 and 5: %v", pEnv, precision)
-			}
-		} else {
-			log.Errorf("value of %v is not an int: %v", pEnv, precision)
-		}
+	if p := config.GetPrecision(); p != metricsHistPrecisionDefault {
+		log.Infof("Non-default histogram precision: %d", p)
+		metricsHTTPHistograms.precision = p
 	}
+	metricsHTTPHistograms.unit = config.GetHistogramUnit()
+	metricsHTTPHistograms.maxValue = config.GetHistogramMaxValue()
 }
 
 // addRequestCounters add various request-related counters to the metrics message buffer.
@@ -380,7 +509,9 @@ func BuildMessage(m *Measurements, serverless bool) []byte {
 	index := 0
 
 	for _, measurement := range m.m {
-		addMeasurementToBSON(bbuf, &index, measurement)
+		if tagsAllowed(measurement.Tags) {
+			addMeasurementToBSON(bbuf, &index, measurement)
+		}
 	}
 
 	bbuf.AppendFinishObject(start)
@@ -410,12 +541,45 @@ func (m *Measurements) CopyAndReset(flushInterval int32) *Measurements {
 	}
 
 	clone := m.Clone()
-	m.m = make(map[string]*Measurement)
+	if cycles := config.GetMetricsIdleCycles(); cycles > 0 {
+		clone.m = m.resetActive(cycles)
+	} else {
+		m.m = make(map[string]*Measurement)
+	}
 	m.transMap.Reset()
+	// the kept measurements still count towards the limit of the next cycle
+	for id := range m.m {
+		if !strings.HasPrefix(id, OtherMetricIDPrefix) && !m.transMap.IsWithinLimit(id) {
+			delete(m.m, id)
+		}
+	}
 	m.FlushInterval = flushInterval
+	if len(clone.m) == 0 {
+		return nil
+	}
 	return clone
 }
 
+// resetActive returns copies of the measurements which received data in this
+// cycle and resets them in place, so the busy transactions don't reallocate
+// their measurements at each flush. The measurements idle for more than the
+// given number of cycles are evicted.
+func (m *Measurements) resetActive(idleCycles int) map[string]*Measurement {
+	active := make(map[string]*Measurement)
+	for id, me := range m.m {
+		if me.Count == 0 {
+			if me.idle++; me.idle > idleCycles {
+				delete(m.m, id)
+			}
+			continue
+		}
+		c := *me
+		active[id] = &c
+		me.Count, me.Sum, me.Exemplars, me.idle = 0, 0, nil, 0
+	}
+	return active
+}
+
 // Clone returns a shallow copy
 func (m *Measurements) Clone() *Measurements {
 	return &Measurements{
@@ -426,6 +590,79 @@ func (m *Measurements) Clone() *Measurements {
 	}
 }
 
+// FlushedMetrics is a snapshot of the measurements to be sent in a flush cycle.
+type FlushedMetrics struct {
+	IsCustom      bool          // true for custom metrics, false for the built-in HTTP metrics
+	FlushInterval int32         // the flush interval in seconds
+	Measurements  []Measurement // copies of the measurements
+}
+
+// FlushCallback is invoked with the measurements about to be sent at each flush
+type FlushCallback func(FlushedMetrics)
+
+var flushCallbacks = struct {
+	sync.RWMutex
+	cbs []FlushCallback
+}{}
+
+// AddFlushCallback registers a callback to be invoked at each metrics flush.
+func AddFlushCallback(cb FlushCallback) {
+	if cb == nil {
+		return
+	}
+	flushCallbacks.Lock()
+	defer flushCallbacks.Unlock()
+	flushCallbacks.cbs = append(flushCallbacks.cbs, cb)
+}
+
+// ResetFlushCallbacks removes all the registered flush callbacks.
+func ResetFlushCallbacks() {
+	flushCallbacks.Lock()
+	defer flushCallbacks.Unlock()
+	flushCallbacks.cbs = nil
+}
+
+// RunFlushCallbacks passes a snapshot of the measurements to each registered
+// flush callback. It's a no-op if m is nil or no callback is registered.
+func RunFlushCallbacks(m *Measurements) {
+	if m == nil {
+		return
+	}
+	flushCallbacks.RLock()
+	cbs := flushCallbacks.cbs
+	flushCallbacks.RUnlock()
+	if len(cbs) == 0 {
+		return
+	}
+
+	m.Lock()
+	fm := FlushedMetrics{
+		IsCustom:      m.IsCustom,
+		FlushInterval: m.FlushInterval,
+		Measurements:  make([]Measurement, 0, len(m.m)),
+	}
+	for _, me := range m.m {
+		c := *me
+		c.Tags = utils.CopyMap(&me.Tags)
+		c.Exemplars = append([]Exemplar(nil), me.Exemplars...)
+		fm.Measurements = append(fm.Measurements, c)
+	}
+	m.Unlock()
+
+	for _, cb := range cbs {
+		runFlushCallback(cb, fm)
+	}
+}
+
+func runFlushCallback(cb FlushCallback, fm FlushedMetrics) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Errorf("Metrics flush callback panicked: %v", err)
+		}
+	}()
+	cb(fm)
+}
+
 // Summary submits the summary measurement to the reporter.
 func (m *Measurements) Summary(name string, value float64, opts MetricOptions) error {
 	if err := opts.validate(); err != nil {
@@ -523,10 +760,25 @@ func BuildBuiltinMetricsMessage(m *Measurements, qs *EventQueueStats,
 		addMetricsValue(bbuf, &index, "NumSent", qs.numSent)
 		addMetricsValue(bbuf, &index, "NumOverflowed", qs.numOverflowed)
 		addMetricsValue(bbuf, &index, "NumFailed", qs.numFailed)
+		addMetricsValue(bbuf, &index, "NumRetried", qs.numRetried)
+		addMetricsValue(bbuf, &index, "NumDropped", qs.numDropped)
 		addMetricsValue(bbuf, &index, "TotalEvents", qs.totalEvents)
 		addMetricsValue(bbuf, &index, "QueueLargest", qs.queueLargest)
 	}
 
+	// the data cut to the length limits since the last flush
+	addMetricsValue(bbuf, &index, "TruncatedKVValues", atomic.SwapInt64(&truncatedKVValues, 0))
+	addMetricsValue(bbuf, &index, "TruncatedTags", atomic.SwapInt64(&truncatedTags, 0))
+	addMetricsValue(bbuf, &index, "ActiveTransactions", activeTransactions())
+
+	// the collector connectivity, available after the first successful ping
+	if rtt := atomic.LoadInt64(&collectorRTT); rtt >= 0 {
+		addMetricsValue(bbuf, &index, "CollectorRTT", rtt)
+	}
+	if offset, ok := CollectorClockOffset(); ok {
+		addMetricsValue(bbuf, &index, "CollectorClockOffset", int64(offset/time.Microsecond))
+	}
+
 	addHostMetrics(bbuf, &index)
 
 	if runtimeMetrics {
@@ -535,9 +787,14 @@ func BuildBuiltinMetricsMessage(m *Measurements, qs *EventQueueStats,
 	}
 
 	for _, measurement := range m.m {
-		addMeasurementToBSON(bbuf, &index, measurement)
+		if tagsAllowed(measurement.Tags) {
+			addMeasurementToBSON(bbuf, &index, measurement)
+		}
 	}
 
+	addSLOCounts(bbuf, &index)
+	addClampedCounts(bbuf, &index)
+
 	bbuf.AppendFinishObject(start)
 	// ==========================================
 
@@ -548,10 +805,25 @@ func BuildBuiltinMetricsMessage(m *Measurements, qs *EventQueueStats,
 
 	metricsHTTPHistograms.lock.Lock()
 
-	for _, h := range metricsHTTPHistograms.histograms {
-		addHistogramToBSON(bbuf, &index, h)
+	if cycles := config.GetMetricsIdleCycles(); cycles > 0 {
+		for id, h := range metricsHTTPHistograms.histograms {
+			if h.hist.TotalCount() == 0 {
+				if h.idle++; h.idle > cycles {
+					delete(metricsHTTPHistograms.histograms, id)
+				}
+				continue
+			}
+			addHistogramToBSON(bbuf, &index, h)
+			h.hist.Clear()
+			h.idle = 0
+			h.clamped = 0
+		}
+	} else {
+		for _, h := range metricsHTTPHistograms.histograms {
+			addHistogramToBSON(bbuf, &index, h)
+		}
+		metricsHTTPHistograms.histograms = make(map[string]*histogram) // clear histograms
 	}
-	metricsHTTPHistograms.histograms = make(map[string]*histogram) // clear histograms
 
 	metricsHTTPHistograms.lock.Unlock()
 	bbuf.AppendFinishObject(start)
@@ -559,6 +831,9 @@ func BuildBuiltinMetricsMessage(m *Measurements, qs *EventQueueStats,
 
 	if m.transMap.Overflow() {
 		bbuf.AppendBool("TransactionNameOverflow", true)
+		atomic.AddInt64(&transactionNameOverflows, 1)
+		limit := m.transMap.Cap()
+		runTransactionLimitCallbacks(TransactionLimitEvent{Limit: limit, Previous: limit, Overflow: true})
 	}
 
 	bbuf.Finish()
@@ -574,6 +849,23 @@ func appendHostId(bbuf *bson.Buffer) {
 	appendUname(bbuf)
 	bbuf.AppendString("Distro", host.Distro())
 	appendIPAddresses(bbuf)
+	appendHostMetadata(bbuf, host.K8sMetadata())
+	appendHostMetadata(bbuf, host.ECSMetadata())
+}
+
+// appends the Kubernetes or ECS metadata, if any, to a BSON buffer in the order
+// of the keys
+// bbuf	the BSON buffer to append the KVs to
+// md	the metadata
+func appendHostMetadata(bbuf *bson.Buffer, md map[string]string) {
+	keys := make([]string, 0, len(md))
+	for k := range md {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		bbuf.AppendString(k, md[k])
+	}
 }
 
 // gets and appends IP addresses to a BSON buffer
@@ -660,26 +952,116 @@ func GetTransactionFromPath(path string) string {
 
 // Process processes an HttpSpanMessage
 func (s *HTTPSpanMessage) Process(m *Measurements) {
-	// always add to overall histogram
-	recordHistogram(metricsHTTPHistograms, "", s.Duration)
+	// always add to overall histogram, unless the request has been counted by
+	// another transaction
+	if !s.Sibling {
+		recordHistogram(metricsHTTPHistograms, "", s.Duration)
+	}
 
 	// only record the transaction-specific histogram and measurements if we are still within the limit
 	// otherwise report it as an 'other' measurement
 	if err, reusableTags := s.processMeasurements(nil, m); err == ErrExceedsMetricsCountLimit {
 		s.Transaction = OtherTransactionName
 		s.processMeasurements(reusableTags, m)
+		s.recordErrorCount(m)
+		s.recordApdex(m)
+		s.recordSamplingCounts(m)
 		return
 	}
 
+	s.recordErrorCount(m)
+	s.recordApdex(m)
+	s.recordSamplingCounts(m)
 	recordHistogram(metricsHTTPHistograms, s.Transaction, s.Duration)
 }
 
+// recordApdex increments the ApdexCount measurement tagged by the transaction
+// name and the Apdex zone of this request, if an Apdex threshold T is set for
+// the transaction. A request is satisfied within T, tolerating within 4T, and
+// frustrated otherwise or if it fails with a server error.
+func (s *HTTPSpanMessage) recordApdex(m *Measurements) {
+	threshold := config.GetApdexThreshold(s.Transaction)
+	if threshold <= 0 {
+		return
+	}
+	zone := apdexZone(s.Duration, threshold, s.Status)
+	tags := map[string]string{
+		"TransactionName": s.Transaction,
+		"ApdexZone":       zone,
+	}
+	if err := m.recordWithSoloTags(ApdexCountName, tags, 0, 1, false); err == ErrExceedsMetricsCountLimit {
+		tags["TransactionName"] = OtherTransactionName
+		m.recordWithSoloTags(ApdexCountName, tags, 0, 1, false)
+	}
+}
+
+func apdexZone(d time.Duration, threshold time.Duration, status int) string {
+	switch {
+	case status >= 500:
+		return ApdexFrustrated
+	case d <= threshold:
+		return ApdexSatisfied
+	case d <= 4*threshold:
+		return ApdexTolerating
+	}
+	return ApdexFrustrated
+}
+
+// recordSamplingCounts increments the TransactionRequestCount measurement, and the
+// TransactionTraceCount measurement if the request is traced, so the transactions
+// which are rarely traced, e.g., due to the token bucket, can be told apart. In
+// the dry-run mode the TransactionDryRunTraceCount measurement is incremented
+// instead if the request would be traced.
+func (s *HTTPSpanMessage) recordSamplingCounts(m *Measurements) {
+	names := []string{TransactionRequestCountName}
+	if s.Sampled {
+		names = append(names, TransactionTraceCountName)
+	}
+	if s.DryRunSampled {
+		names = append(names, TransactionDryRunTraceCountName)
+	}
+	for _, name := range names {
+		tags := map[string]string{"TransactionName": s.Transaction}
+		if err := m.recordWithSoloTags(name, tags, 0, 1, false); err == ErrExceedsMetricsCountLimit {
+			tags["TransactionName"] = OtherTransactionName
+			m.recordWithSoloTags(name, tags, 0, 1, false)
+		}
+	}
+}
+
+// recordErrorCount increments the ErrorCount measurement tagged by the transaction
+// name and the error class, if this transaction reports an error.
+func (s *HTTPSpanMessage) recordErrorCount(m *Measurements) {
+	if s.ErrorClass == "" {
+		return
+	}
+	tags := map[string]string{
+		"TransactionName": s.Transaction,
+		"ErrorClass":      s.ErrorClass,
+	}
+	if err := m.recordWithSoloTags(ErrorCountName, tags, 0, 1, false); err == ErrExceedsMetricsCountLimit {
+		tags["TransactionName"] = OtherTransactionName
+		m.recordWithSoloTags(ErrorCountName, tags, 0, 1, false)
+	}
+}
+
 func (s *HTTPSpanMessage) produceTagsList() []map[string]string {
 	var tagsList []map[string]string
 
 	// primary key: TransactionName
 	primaryTags := make(map[string]string)
 	primaryTags["TransactionName"] = s.Transaction
+	if s.TransactionType != "" {
+		// non-HTTP entry points: no HTTP method or status to tag with
+		primaryTags["TransactionType"] = s.TransactionType
+		tagsList = append(tagsList, primaryTags)
+		if s.HasError {
+			withErrorTags := utils.CopyMap(&primaryTags)
+			withErrorTags["Errors"] = "true"
+			tagsList = append(tagsList, withErrorTags)
+		}
+		return tagsList
+	}
 	tagsList = append(tagsList, primaryTags)
 
 	// secondary keys: HttpMethod, HttpStatus, Errors
@@ -687,9 +1069,17 @@ func (s *HTTPSpanMessage) produceTagsList() []map[string]string {
 	withMethodTags["HttpMethod"] = s.Method
 	tagsList = append(tagsList, withMethodTags)
 
-	withStatusTags := utils.CopyMap(&primaryTags)
-	withStatusTags["HttpStatus"] = strconv.Itoa(s.Status)
-	tagsList = append(tagsList, withStatusTags)
+	tagging := config.GetHTTPStatusTagging()
+	if tagging != config.ClassStatusTagging {
+		withStatusTags := utils.CopyMap(&primaryTags)
+		withStatusTags["HttpStatus"] = strconv.Itoa(s.Status)
+		tagsList = append(tagsList, withStatusTags)
+	}
+	if tagging == config.ClassStatusTagging || tagging == config.BothStatusTagging {
+		withStatusClassTags := utils.CopyMap(&primaryTags)
+		withStatusClassTags["HttpStatusClass"] = strconv.Itoa(s.Status/100) + "xx"
+		tagsList = append(tagsList, withStatusClassTags)
+	}
 
 	if s.HasError {
 		withErrorTags := utils.CopyMap(&primaryTags)
@@ -711,7 +1101,7 @@ func (s *HTTPSpanMessage) processMeasurements(tagsList []map[string]string,
 		tagsList = s.produceTagsList()
 	}
 
-	err := m.record(name, tagsList, duration, 1, true)
+	err := m.recordWithExemplar(name, tagsList, duration, 1, true, s.TraceID)
 
 	if err != nil {
 		return err, tagsList
@@ -732,30 +1122,20 @@ func (m *Measurements) recordWithSoloTags(name string, tags map[string]string,
 // reportValue	should the sum of all values be reported?
 func (m *Measurements) record(name string, tagsList []map[string]string,
 	value float64, count int, reportValue bool) error {
+	return m.recordWithExemplar(name, tagsList, value, count, reportValue, "")
+}
+
+// recordWithExemplar records a measurement like record, and adds the trace as an
+// exemplar of it if traceID is not empty.
+func (m *Measurements) recordWithExemplar(name string, tagsList []map[string]string,
+	value float64, count int, reportValue bool, traceID string) error {
 	if len(tagsList) == 0 {
 		return nil
 	}
 
 	idTagsMap := make(map[string]map[string]string)
-	idPrefixList := []string{name, strconv.FormatBool(reportValue)}
-
 	for _, tags := range tagsList {
-		idList := append(idPrefixList[:0:0], idPrefixList...)
-		if tags != nil {
-			// tags are part of the ID but since there's no guarantee that the map items
-			// are always iterated in the same order, we need to sort them ourselves
-			var tagsSorted []string
-			for k, v := range tags {
-				tagsSorted = append(tagsSorted, k+TagsKVSeparator+v)
-			}
-			sort.Strings(tagsSorted)
-
-			idList = append(idList, tagsSorted...)
-		}
-		idList = append(idList, "")
-		id := strings.Join(idList, MetricIDSeparator)
-
-		idTagsMap[id] = tags
+		idTagsMap[metricID(name, tags, reportValue)] = tags
 	}
 
 	var me *Measurement
@@ -783,10 +1163,91 @@ func (m *Measurements) record(name string, tagsList []map[string]string,
 		// add count and value
 		me.Count += count
 		me.Sum += value
+		if traceID != "" {
+			me.addExemplar(traceID, value)
+		}
 	}
 	return nil
 }
 
+// the max number of interned measurement IDs, the cache is dropped once it's full
+const maxInternedMetricIDs = 10000
+
+// internKey identifies a measurement with at most two tags, which covers the
+// fixed combinations of the primary and secondary keys of the HTTP metrics.
+// The tags are stored in order so no sorting is needed to build it.
+type internKey struct {
+	name        string
+	reportValue bool
+	k1, v1      string
+	k2, v2      string
+}
+
+// internedIDs caches the measurement IDs so the per-request cost of the common
+// measurements is a map lookup rather than sorting and joining the tags.
+var internedIDs = struct {
+	sync.RWMutex
+	ids map[internKey]string
+}{ids: make(map[internKey]string)}
+
+// metricID returns the ID of a measurement, which is built from its name, the
+// reportValue flag and the sorted tags.
+func metricID(name string, tags map[string]string, reportValue bool) string {
+	if len(tags) > 2 {
+		return buildMetricID(name, tags, reportValue)
+	}
+
+	key := internKey{name: name, reportValue: reportValue}
+	for k, v := range tags {
+		if k == "" {
+			// it can't be told from an absent tag
+			return buildMetricID(name, tags, reportValue)
+		}
+		if key.k1 == "" {
+			key.k1, key.v1 = k, v
+		} else if k < key.k1 {
+			key.k2, key.v2 = key.k1, key.v1
+			key.k1, key.v1 = k, v
+		} else {
+			key.k2, key.v2 = k, v
+		}
+	}
+
+	internedIDs.RLock()
+	id, ok := internedIDs.ids[key]
+	internedIDs.RUnlock()
+	if ok {
+		return id
+	}
+
+	id = buildMetricID(name, tags, reportValue)
+	internedIDs.Lock()
+	if len(internedIDs.ids) >= maxInternedMetricIDs {
+		internedIDs.ids = make(map[internKey]string)
+	}
+	internedIDs.ids[key] = id
+	internedIDs.Unlock()
+	return id
+}
+
+// buildMetricID builds the measurement ID without the cache.
+func buildMetricID(name string, tags map[string]string, reportValue bool) string {
+	idList := []string{name, strconv.FormatBool(reportValue)}
+	if tags != nil {
+		// tags are part of the ID but since there's no guarantee that the map items
+		// are always iterated in the same order, we need to sort them ourselves
+		var tagsSorted []string
+		for k, v := range tags {
+			tagsSorted = append(tagsSorted, k+TagsKVSeparator+v)
+		}
+		sort.Strings(tagsSorted)
+
+		idList = append(idList, tagsSorted...)
+	}
+	idList = append(idList, "")
+	return strings.Join(idList, MetricIDSeparator)
+}
+
 // records a histogram
 // hi		collection of histograms that this histogram should be added to
 // name		key name
@@ -807,6 +1268,10 @@ func recordHistogram(hi *histograms, name string, duration time.Duration) {
 	if name != "" {
 		tags["TransactionName"] = name
 	}
+	// the values are in microseconds unless stated otherwise
+	if hi.unit == time.Millisecond {
+		tags["Unit"] = "ms"
+	}
 
 	var h *histogram
 	var ok bool
@@ -816,7 +1281,7 @@ func recordHistogram(hi *histograms, name string, duration time.Duration) {
 		h = &histogram{
 			hist: hdrhist.WithConfig(hdrhist.Config{
 				LowestDiscernible: 1,
-				HighestTrackable:  3600000000,
+				HighestTrackable:  hi.highestTrackable(),
 				SigFigs:           int32(hi.precision),
 			}),
 			tags: tags,
@@ -824,8 +1289,125 @@ func recordHistogram(hi *histograms, name string, duration time.Duration) {
 		histograms[id] = h
 	}
 
+	// clamp the outliers so they still register in the histogram
+	v := hi.toValue(duration)
+	if max := h.hist.GetConfig().HighestTrackable; v > max {
+		v = max
+		h.clamped++
+	}
+
 	// record histogram
-	h.hist.Record(int64(duration / time.Microsecond))
+	h.hist.Record(v)
+}
+
+// addClampedCounts adds the number of the clamped values of each histogram
+// since the last flush.
+func addClampedCounts(bbuf *bson.Buffer, index *int) {
+	hi := metricsHTTPHistograms
+	hi.lock.Lock()
+	defer hi.lock.Unlock()
+
+	for _, h := range hi.histograms {
+		if h.clamped == 0 || !tagsAllowed(h.tags) {
+			continue
+		}
+		addMeasurementToBSON(bbuf, index, &Measurement{
+			Name:  HistogramClampedName,
+			Tags:  h.tags,
+			Count: h.clamped,
+		})
+	}
+}
+
+// addSLOCounts adds the good and bad event counts of the transactions with a
+// latency SLO target, which are computed from the response time histograms
+// before they are flushed.
+func addSLOCounts(bbuf *bson.Buffer, index *int) {
+	hi := metricsHTTPHistograms
+	hi.lock.Lock()
+	defer hi.lock.Unlock()
+
+	for name, h := range hi.histograms {
+		if name == "" {
+			continue
+		}
+		target := config.GetSLOTarget(name)
+		total := h.hist.TotalCount()
+		if target <= 0 || total == 0 {
+			continue
+		}
+		good := h.hist.Val(hi.toValue(target)).CumCount
+		for status, count := range map[string]int64{SLOGood: good, SLOBad: total - good} {
+			tags := map[string]string{"TransactionName": name, "SLOStatus": status}
+			if !tagsAllowed(tags) {
+				continue
+			}
+			addMeasurementToBSON(bbuf, index, &Measurement{
+				Name:  SLOCountName,
+				Tags:  tags,
+				Count: int(count),
+			})
+		}
+	}
+}
+
+// tagsAllowed returns if a measurement with the tags passes the tag allow and
+// deny lists of the configuration.
+func tagsAllowed(tags map[string]string) bool {
+	for k := range tags {
+		if !config.IsMetricsTagAllowed(k) {
+			return false
+		}
+	}
+	return true
+}
+
+// activeTransactions returns the number of transactions which have received
+// requests since the last flush.
+func activeTransactions() int64 {
+	hi := metricsHTTPHistograms
+	hi.lock.Lock()
+	defer hi.lock.Unlock()
+
+	var n int64
+	for name, h := range hi.histograms {
+		if name != "" && h.hist.TotalCount() > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// LatencyPercentiles is the response time percentiles of a transaction
+// recorded since the last metrics flush.
+type LatencyPercentiles struct {
+	Count int64
+	P50   time.Duration
+	P95   time.Duration
+	P99   time.Duration
+}
+
+// GetLatencyPercentiles returns the response time percentiles of the
+// transaction, or of all the transactions if it's empty. It returns false if
+// no response time of the transaction has been recorded since the last flush.
+func GetLatencyPercentiles(transaction string) (LatencyPercentiles, bool) {
+	hi := metricsHTTPHistograms
+	hi.lock.Lock()
+	defer hi.lock.Unlock()
+
+	h, ok := hi.histograms[transaction]
+	if !ok || h.hist.TotalCount() == 0 {
+		return LatencyPercentiles{}, false
+	}
+	pct := func(p float64) time.Duration {
+		return hi.toDuration(h.hist.PercentileVal(p).Value)
+	}
+	return LatencyPercentiles{
+		Count: h.hist.TotalCount(),
+		P50:   pct(50),
+		P95:   pct(95),
+		P99:   pct(99),
+	}, true
 }
 
 // adds a measurement to a BSON buffer
@@ -842,15 +1424,16 @@ func addMeasurementToBSON(bbuf *bson.Buffer, index *int, m *Measurement) {
 	}
 
 	if len(m.Tags) > 0 {
-		start := bbuf.AppendStartObject("tags")
-		for k, v := range m.Tags {
-			if len(k) > metricsTagNameLengthMax {
-				k = k[0:metricsTagNameLengthMax]
-			}
-			if len(v) > metricsTagValueLengthMax {
-				v = v[0:metricsTagValueLengthMax]
-			}
-			bbuf.AppendString(k, v)
+		addTagsToBSON(bbuf, m.Tags)
+	}
+
+	if len(m.Exemplars) > 0 {
+		start := bbuf.AppendStartArray("exemplars")
+		for i, e := range m.Exemplars {
+			es := bbuf.AppendStartObject(strconv.Itoa(i))
+			bbuf.AppendString("trace_id", e.TraceID)
+			bbuf.AppendFloat64("value", e.Value)
+			bbuf.AppendFinishObject(es)
 		}
 		bbuf.AppendFinishObject(start)
 	}
@@ -878,23 +1461,157 @@ func addHistogramToBSON(bbuf *bson.Buffer, index *int, h *histogram) {
 
 	// append tags
 	if len(h.tags) > 0 {
-		start := bbuf.AppendStartObject("tags")
-		for k, v := range h.tags {
-			if len(k) > metricsTagNameLengthMax {
-				k = k[0:metricsTagNameLengthMax]
-			}
-			if len(v) > metricsTagValueLengthMax {
-				v = v[0:metricsTagValueLengthMax]
-			}
-			bbuf.AppendString(k, v)
-		}
-		bbuf.AppendFinishObject(start)
+		addTagsToBSON(bbuf, h.tags)
 	}
 
 	bbuf.AppendFinishObject(start)
 	*index += 1
 }
 
+// the number of event KV values and metrics tags truncated since the last flush
+var (
+	truncatedKVValues int64
+	truncatedTags     int64
+)
+
+// CountTruncatedKVValue counts an event KV value truncated to the max length.
+func CountTruncatedKVValue() {
+	atomic.AddInt64(&truncatedKVValues, 1)
+}
+
+// the number of the metrics cycles in which the transaction names overflowed,
+// since the process started
+var transactionNameOverflows int64
+
+// TransactionNameOverflows returns the number of the metrics cycles in which
+// the transaction names reached the limit and the rest of them were reported
+// as "other".
+func TransactionNameOverflows() int64 {
+	return atomic.LoadInt64(&transactionNameOverflows)
+}
+
+// the max number of the transaction names of a metrics cycle, which is set by
+// the collector settings
+var transactionLimit int32 = metricsTransactionsMaxDefault
+
+// TransactionLimitEvent is passed to the transaction limit callbacks when the
+// collector changes the max number of the transaction names of a metrics cycle,
+// or when the transaction names of a cycle exceed the limit.
+type TransactionLimitEvent struct {
+	Limit    int32 // the max number of the transaction names of a metrics cycle
+	Previous int32 // the limit before the change, or the same as Limit
+	Overflow bool  // true if the transaction names exceeded the limit
+}
+
+// TransactionLimitCallback is invoked with a TransactionLimitEvent
+type TransactionLimitCallback func(TransactionLimitEvent)
+
+var transactionLimitCallbacks = struct {
+	sync.RWMutex
+	cbs []TransactionLimitCallback
+}{}
+
+// AddTransactionLimitCallback registers a callback to be invoked when the
+// transaction limit changes or is exceeded.
+func AddTransactionLimitCallback(cb TransactionLimitCallback) {
+	if cb == nil {
+		return
+	}
+	transactionLimitCallbacks.Lock()
+	defer transactionLimitCallbacks.Unlock()
+	transactionLimitCallbacks.cbs = append(transactionLimitCallbacks.cbs, cb)
+}
+
+// ResetTransactionLimitCallbacks removes all the registered transaction limit
+// callbacks.
+func ResetTransactionLimitCallbacks() {
+	transactionLimitCallbacks.Lock()
+	defer transactionLimitCallbacks.Unlock()
+	transactionLimitCallbacks.cbs = nil
+}
+
+func runTransactionLimitCallbacks(e TransactionLimitEvent) {
+	transactionLimitCallbacks.RLock()
+	cbs := transactionLimitCallbacks.cbs
+	transactionLimitCallbacks.RUnlock()
+
+	for _, cb := range cbs {
+		func() {
+			defer func() {
+				if err := recover(); err != nil {
+					log.Errorf("Transaction limit callback panicked: %v", err)
+				}
+			}()
+			cb(e)
+		}()
+	}
+}
+
+// TransactionLimit returns the max number of the transaction names of a
+// metrics cycle set by the collector.
+func TransactionLimit() int32 {
+	return atomic.LoadInt32(&transactionLimit)
+}
+
+// UpdateTransactionLimit records the transaction limit received from the
+// collector. It returns the previous limit and whether it's changed, in which
+// case the callbacks are invoked.
+func UpdateTransactionLimit(limit int32) (int32, bool) {
+	prev := atomic.SwapInt32(&transactionLimit, limit)
+	if prev == limit {
+		return prev, false
+	}
+	runTransactionLimitCallbacks(TransactionLimitEvent{Limit: limit, Previous: prev})
+	return prev, true
+}
+
+// the round-trip time and the collector clock offset in microseconds measured
+// by the last ping to the collector. The offset is valid only if collectorSynced
+// is not zero.
+var (
+	collectorRTT         int64 = -1
+	collectorClockOffset int64
+	collectorSynced      int32
+)
+
+// RecordCollectorRTT records the round-trip time of the last collector ping.
+func RecordCollectorRTT(rtt time.Duration) {
+	atomic.StoreInt64(&collectorRTT, int64(rtt/time.Microsecond))
+}
+
+// RecordCollectorClockOffset records how far the clock of the collector is ahead
+// of the local clock. A negative offset means the collector clock is behind.
+func RecordCollectorClockOffset(offset time.Duration) {
+	atomic.StoreInt64(&collectorClockOffset, int64(offset/time.Microsecond))
+	atomic.StoreInt32(&collectorSynced, 1)
+}
+
+// CollectorClockOffset returns the clock offset of the collector measured by the
+// last ping, and false if it is not measured yet.
+func CollectorClockOffset() (time.Duration, bool) {
+	if atomic.LoadInt32(&collectorSynced) == 0 {
+		return 0, false
+	}
+	return time.Duration(atomic.LoadInt64(&collectorClockOffset)) * time.Microsecond, true
+}
+
+// addTagsToBSON appends the tags to the BSON buffer, truncating the names and
+// values which exceed the length limits.
+func addTagsToBSON(bbuf *bson.Buffer, tags map[string]string) {
+	maxValue := config.GetMaxTagValueLength()
+	start := bbuf.AppendStartObject("tags")
+	for k, v := range tags {
+		var kCut, vCut bool
+		k, kCut = utils.Truncate(k, metricsTagNameLengthMax)
+		v, vCut = utils.Truncate(v, maxValue)
+		if kCut || vCut {
+			atomic.AddInt64(&truncatedTags, 1)
+		}
+		bbuf.AppendString(k, v)
+	}
+	bbuf.AppendFinishObject(start)
+}
+
 func (s *EventQueueStats) SetQueueLargest(count int64) {
 	newVal := count
 
@@ -915,6 +1632,8 @@ func (s *EventQueueStats) CopyAndReset() *EventQueueStats {
 
 	c.numSent = atomic.SwapInt64(&s.numSent, 0)
 	c.numFailed = atomic.SwapInt64(&s.numFailed, 0)
+	c.numRetried = atomic.SwapInt64(&s.numRetried, 0)
+	c.numDropped = atomic.SwapInt64(&s.numDropped, 0)
 	c.totalEvents = atomic.SwapInt64(&s.totalEvents, 0)
 	c.numOverflowed = atomic.SwapInt64(&s.numOverflowed, 0)
 	c.queueLargest = atomic.SwapInt64(&s.queueLargest, 0)
@@ -26,45 +26,53 @@ func appendUname(bbuf *bson.Buffer) {
 	}
 }
 
-func addHostMetrics(bbuf *bson.Buffer, index *int) {
-	// system load of last minute
+// the default collector of the host metrics, which reads them from /proc
+var defaultHostMetricsCollector HostMetricsCollector = procHostMetrics{}
+
+type procHostMetrics struct{}
+
+// Load1 returns the system load of last minute.
+func (procHostMetrics) Load1() (float64, bool) {
 	if s := utils.GetStrByKeyword("/proc/loadavg", ""); s != "" {
 		load, err := strconv.ParseFloat(strings.Fields(s)[0], 64)
 		if err == nil {
-			addMetricsValue(bbuf, index, "Load1", load)
+			return load, true
 		}
 	}
+	return 0, false
+}
 
-	// system total memory
-	if s := utils.GetStrByKeyword("/proc/meminfo", "MemTotal"); s != "" {
-		memTotal := strings.Fields(s) // MemTotal: 7657668 kB
-		if len(memTotal) == 3 {
-			if total, err := strconv.Atoi(memTotal[1]); err == nil {
-				addMetricsValue(bbuf, index, "TotalRAM", int64(total*1024))
-			}
-		}
-	}
+// TotalRAM returns the system total memory.
+func (procHostMetrics) TotalRAM() (int64, bool) {
+	return memInfo("MemTotal") // MemTotal: 7657668 kB
+}
 
-	// free memory
-	if s := utils.GetStrByKeyword("/proc/meminfo", "MemFree"); s != "" {
-		memFree := strings.Fields(s) // MemFree: 161396 kB
-		if len(memFree) == 3 {
-			if free, err := strconv.Atoi(memFree[1]); err == nil {
-				addMetricsValue(bbuf, index, "FreeRAM", int64(free*1024)) // bytes
+// FreeRAM returns the free memory.
+func (procHostMetrics) FreeRAM() (int64, bool) {
+	return memInfo("MemFree") // MemFree: 161396 kB
+}
+
+// memInfo returns an item of /proc/meminfo in bytes.
+func memInfo(keyword string) (int64, bool) {
+	if s := utils.GetStrByKeyword("/proc/meminfo", keyword); s != "" {
+		fields := strings.Fields(s)
+		if len(fields) == 3 {
+			if kb, err := strconv.Atoi(fields[1]); err == nil {
+				return int64(kb * 1024), true
 			}
 		}
 	}
+	return 0, false
+}
 
-	// process memory
+// ProcessRAM returns the process memory.
+func (procHostMetrics) ProcessRAM() (int64, bool) {
 	if s := utils.GetStrByKeyword("/proc/self/statm", ""); s != "" {
-		processRAM := strings.Fields(s)
-		if len(processRAM) != 0 {
-			for _, ps := range processRAM {
-				if p, err := strconv.Atoi(ps); err == nil {
-					addMetricsValue(bbuf, index, "ProcessRAM", p*os.Getpagesize())
-					break
-				}
+		for _, ps := range strings.Fields(s) {
+			if p, err := strconv.Atoi(ps); err == nil {
+				return int64(p * os.Getpagesize()), true
 			}
 		}
 	}
+	return 0, false
 }
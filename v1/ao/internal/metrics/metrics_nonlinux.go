@@ -8,4 +8,4 @@ import "github.com/appoptics/appoptics-apm-go/v1/ao/internal/bson"
 
 func appendUname(bbuf *bson.Buffer) {}
 
-func addHostMetrics(bbuf *bson.Buffer, index *int) {}
+var defaultHostMetricsCollector HostMetricsCollector = noHostMetrics{}
@@ -9,10 +9,13 @@ import (
 	"os"
 	"runtime"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/bson"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/hdrhist"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/host"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
@@ -250,7 +253,72 @@ func TestRecordHistogram(t *testing.T) {
 	log.SetOutput(&buf)
 	recordHistogram(hi, "hist2", time.Duration(4531224545454563))
 	log.SetOutput(os.Stderr)
-	assert.Contains(t, buf.String(), "Failed to record histogram: value to large")
+	assert.Empty(t, buf.String())
+	h = hi.histograms["hist2"]
+	assert.Equal(t, int64(1), h.hist.TotalCount())
+	assert.Equal(t, 1, h.clamped)
+}
+
+func TestHistogramRange(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	// a multi-hour batch transaction is out of the default range
+	hi := &histograms{histograms: make(map[string]*histogram)}
+	recordHistogram(hi, "batch", 5*time.Hour)
+	assert.Empty(t, buf.String())
+	assert.Equal(t, 1, hi.histograms["batch"].clamped)
+
+	buf.Reset()
+	hi = &histograms{
+		histograms: make(map[string]*histogram),
+		precision:  2,
+		unit:       time.Millisecond,
+		maxValue:   24 * time.Hour,
+	}
+	recordHistogram(hi, "batch", 5*time.Hour)
+	assert.Empty(t, buf.String())
+	h := hi.histograms["batch"]
+	assert.Equal(t, "ms", h.tags["Unit"])
+	assert.Equal(t, int64(1), h.hist.TotalCount())
+	assert.InDelta(t, float64(5*time.Hour), float64(hi.toDuration(h.hist.Max())), float64(5*time.Hour)/100)
+	assert.Zero(t, h.clamped)
+}
+
+func TestHistogramClamped(t *testing.T) {
+	hi := metricsHTTPHistograms
+	hi.lock.Lock()
+	saved := hi.histograms
+	hi.histograms = make(map[string]*histogram)
+	hi.lock.Unlock()
+	defer func() {
+		hi.lock.Lock()
+		hi.histograms = saved
+		hi.lock.Unlock()
+	}()
+
+	recordHistogram(hi, "slow", 2*time.Hour)
+	recordHistogram(hi, "slow", 3*time.Hour)
+	recordHistogram(hi, "slow", time.Second)
+	recordHistogram(hi, "fast", time.Second)
+
+	h := hi.histograms["slow"]
+	assert.Equal(t, int64(3), h.hist.TotalCount())
+	assert.Equal(t, 2, h.clamped)
+	assert.InDelta(t, float64(time.Hour), float64(hi.toDuration(h.hist.Max())), float64(time.Hour)/100)
+
+	bbuf := bson.NewBuffer()
+	start := bbuf.AppendStartArray("measurements")
+	index := 0
+	addClampedCounts(bbuf, &index)
+	bbuf.AppendFinishObject(start)
+	bbuf.Finish()
+	m := bsonToMap(bbuf)["measurements"].([]interface{})
+	assert.Len(t, m, 1)
+	assert.Equal(t, HistogramClampedName, m[0].(map[string]interface{})["name"])
+	assert.Equal(t, 2, m[0].(map[string]interface{})["count"])
+	assert.Equal(t, "slow", m[0].(map[string]interface{})["tags"].(map[string]interface{})["TransactionName"])
 }
 
 func TestAddMeasurementToBSON(t *testing.T) {
@@ -309,6 +377,51 @@ func TestAddMeasurementToBSON(t *testing.T) {
 	assert.Equal(t, veryLongTagValueTrimmed, t2[veryLongTagNameTrimmed])
 }
 
+func TestCollectorPingMetrics(t *testing.T) {
+	defer func() {
+		atomic.StoreInt64(&collectorRTT, -1)
+		atomic.StoreInt32(&collectorSynced, 0)
+	}()
+	_, ok := CollectorClockOffset()
+	assert.False(t, ok)
+
+	RecordCollectorRTT(30 * time.Millisecond)
+	RecordCollectorClockOffset(-2 * time.Second)
+	offset, ok := CollectorClockOffset()
+	assert.True(t, ok)
+	assert.Equal(t, -2*time.Second, offset)
+
+	bbuf := bson.WithBuf(BuildBuiltinMetricsMessage(NewMeasurements(false, 60, metricsTransactionsMaxDefault),
+		nil, nil, false))
+	values := make(map[string]interface{})
+	for _, mt := range bsonToMap(bbuf)["measurements"].([]interface{}) {
+		values[mt.(map[string]interface{})["name"].(string)] = mt.(map[string]interface{})["value"]
+	}
+	assert.Equal(t, int64(30000), values["CollectorRTT"])
+	assert.Equal(t, int64(-2000000), values["CollectorClockOffset"])
+}
+
+func TestTagTruncation(t *testing.T) {
+	os.Setenv("APPOPTICS_MAX_TAG_VALUE_LENGTH", "6")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_MAX_TAG_VALUE_LENGTH")
+		config.Load()
+	}()
+	atomic.StoreInt64(&truncatedTags, 0)
+
+	bbuf := bson.NewBuffer()
+	// the multi-byte character is not split
+	addTagsToBSON(bbuf, map[string]string{"short": "abc", "long": "abcdeé..."})
+	bbuf.Finish()
+	m := bsonToMap(bbuf)
+
+	tags := m["tags"].(map[string]interface{})
+	assert.Equal(t, "abc", tags["short"])
+	assert.Equal(t, "abcde", tags["long"])
+	assert.EqualValues(t, 1, atomic.LoadInt64(&truncatedTags))
+}
+
 func TestAddHistogramToBSON(t *testing.T) {
 	veryLongTagName := "verylongnameAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"
 	veryLongTagValue := "verylongtagAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA" +
@@ -404,8 +517,13 @@ func TestGenerateMetricsMessage(t *testing.T) {
 		{"NumSent", int64(1)},
 		{"NumOverflowed", int64(1)},
 		{"NumFailed", int64(1)},
+		{"NumRetried", int64(1)},
+		{"NumDropped", int64(1)},
 		{"TotalEvents", int64(1)},
 		{"QueueLargest", int64(1)},
+		{"TruncatedKVValues", int64(0)},
+		{"TruncatedTags", int64(0)},
+		{"ActiveTransactions", int64(0)},
 	}
 	if runtime.GOOS == "linux" {
 		testCases = append(testCases, []testCase{
@@ -459,11 +577,44 @@ func TestGenerateMetricsMessage(t *testing.T) {
 		}
 	}
 
+	overflows := TransactionNameOverflows()
 	m = bsonToMap(bson.WithBuf(BuildBuiltinMetricsMessage(testMetrics, &EventQueueStats{},
 		map[string]*RateCounts{RCRegular: {}, RCRelaxedTriggerTrace: {}, RCStrictTriggerTrace: {}}, true)))
 
 	assert.NotNil(t, m["TransactionNameOverflow"])
 	assert.True(t, m["TransactionNameOverflow"].(bool))
+	assert.Equal(t, overflows+1, TransactionNameOverflows())
+}
+
+type testHostMetrics struct{ noHostMetrics }
+
+func (testHostMetrics) Load1() (float64, bool)  { return 0.5, true }
+func (testHostMetrics) FreeRAM() (int64, bool)  { return 1 << 30, true }
+func (testHostMetrics) TotalRAM() (int64, bool) { panic("no RAM") }
+
+func TestSetHostMetricsCollector(t *testing.T) {
+	SetHostMetricsCollector(testHostMetrics{})
+	defer SetHostMetricsCollector(nil)
+
+	values := func() map[string]interface{} {
+		bbuf := bson.WithBuf(BuildBuiltinMetricsMessage(NewMeasurements(false, 60, metricsTransactionsMaxDefault),
+			nil, nil, false))
+		values := make(map[string]interface{})
+		for _, mt := range bsonToMap(bbuf)["measurements"].([]interface{}) {
+			values[mt.(map[string]interface{})["name"].(string)] = mt.(map[string]interface{})["value"]
+		}
+		return values
+	}
+
+	// the metrics collected before the panic are reported
+	v := values()
+	assert.Equal(t, 0.5, v["Load1"])
+	assert.NotContains(t, v, "TotalRAM")
+	assert.NotContains(t, v, "FreeRAM")
+	assert.NotContains(t, v, "ProcessRAM")
+
+	SetHostMetricsCollector(nil)
+	assert.Equal(t, defaultHostMetricsCollector, getHostMetricsCollector())
 }
 
 func TestEventQueueStats(t *testing.T) {
@@ -477,6 +628,12 @@ func TestEventQueueStats(t *testing.T) {
 	es.NumFailedAdd(1)
 	assert.EqualValues(t, 1, es.numFailed)
 
+	es.NumRetriedAdd(2)
+	assert.EqualValues(t, 2, es.numRetried)
+
+	es.NumDroppedAdd(1)
+	assert.EqualValues(t, 1, es.numDropped)
+
 	es.TotalEventsAdd(1)
 	assert.EqualValues(t, 1, es.totalEvents)
 
@@ -531,3 +688,441 @@ func TestHTTPSpanMessageProcess(t *testing.T) {
 	assert.NotNil(t, m)
 	assert.EqualValues(t, "TransactionResponseTime", measurement.Name)
 }
+
+func TestHTTPSpanMessageErrorCount(t *testing.T) {
+	s := HTTPSpanMessage{
+		BaseSpanMessage: BaseSpanMessage{Duration: time.Second, HasError: true, ErrorClass: "timeout"},
+		Transaction:     "transaction",
+		Status:          500,
+		Method:          "GET",
+	}
+
+	m := NewMeasurements(false, 60, metricsTransactionsMaxDefault)
+	s.Process(m)
+	s.Process(m)
+	measurement, ok := m.m["ErrorCount&false&ErrorClass:timeout&TransactionName:transaction&"]
+	assert.True(t, ok)
+	assert.Equal(t, 2, measurement.Count)
+	assert.False(t, measurement.ReportSum)
+
+	s.ErrorClass = ""
+	m = NewMeasurements(false, 60, metricsTransactionsMaxDefault)
+	s.Process(m)
+	for id := range m.m {
+		assert.False(t, strings.HasPrefix(id, ErrorCountName))
+	}
+}
+
+func TestHTTPSpanMessageApdex(t *testing.T) {
+	os.Setenv("APPOPTICS_APDEX_THRESHOLD", "100")
+	os.Setenv("APPOPTICS_APDEX_THRESHOLDS", "search*=500")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_APDEX_THRESHOLD")
+		os.Unsetenv("APPOPTICS_APDEX_THRESHOLDS")
+		config.Load()
+	}()
+
+	m := NewMeasurements(false, 60, metricsTransactionsMaxDefault)
+	for _, s := range []HTTPSpanMessage{
+		{BaseSpanMessage: BaseSpanMessage{Duration: 50 * time.Millisecond}, Transaction: "home", Status: 200},
+		{BaseSpanMessage: BaseSpanMessage{Duration: 300 * time.Millisecond}, Transaction: "home", Status: 200},
+		{BaseSpanMessage: BaseSpanMessage{Duration: time.Second}, Transaction: "home", Status: 200},
+		{BaseSpanMessage: BaseSpanMessage{Duration: 50 * time.Millisecond}, Transaction: "home", Status: 503},
+		{BaseSpanMessage: BaseSpanMessage{Duration: 300 * time.Millisecond}, Transaction: "search-api", Status: 200},
+	} {
+		s.Method = "GET"
+		s.Process(m)
+	}
+
+	count := func(txn, zone string) int {
+		me, ok := m.m[ApdexCountName+"&false&ApdexZone:"+zone+"&TransactionName:"+txn+"&"]
+		if !ok {
+			return 0
+		}
+		return me.Count
+	}
+	assert.Equal(t, 1, count("home", ApdexSatisfied))
+	assert.Equal(t, 1, count("home", ApdexTolerating))
+	assert.Equal(t, 2, count("home", ApdexFrustrated))
+	assert.Equal(t, 1, count("search-api", ApdexSatisfied))
+}
+
+func TestApdexDisabled(t *testing.T) {
+	s := HTTPSpanMessage{
+		BaseSpanMessage: BaseSpanMessage{Duration: time.Second},
+		Transaction:     "transaction",
+		Status:          200,
+		Method:          "GET",
+	}
+	m := NewMeasurements(false, 60, metricsTransactionsMaxDefault)
+	s.Process(m)
+	for id := range m.m {
+		assert.False(t, strings.HasPrefix(id, ApdexCountName))
+	}
+}
+
+func TestNonHTTPSpanMessage(t *testing.T) {
+	s := HTTPSpanMessage{
+		BaseSpanMessage: BaseSpanMessage{Duration: 2 * time.Second, HasError: true},
+		Transaction:     "orders-consumer",
+		TransactionType: TransactionTypeConsumer,
+	}
+	m := NewMeasurements(false, 60, metricsTransactionsMaxDefault)
+	s.Process(m)
+
+	assert.Len(t, m.m, 3) // response time, with errors, and the request count
+	me, ok := m.m["TransactionResponseTime&true&TransactionName:orders-consumer&TransactionType:consumer&"]
+	assert.True(t, ok)
+	assert.Equal(t, 1, me.Count)
+	assert.Equal(t, float64(2000000), me.Sum)
+	_, ok = m.m["TransactionResponseTime&true&Errors:true&TransactionName:orders-consumer&TransactionType:consumer&"]
+	assert.True(t, ok)
+
+	p, ok := GetLatencyPercentiles("orders-consumer")
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), p.Count)
+}
+
+func TestTransactionSamplingCounts(t *testing.T) {
+	m := NewMeasurements(false, 60, metricsTransactionsMaxDefault)
+	for i := 0; i < 5; i++ {
+		s := HTTPSpanMessage{
+			BaseSpanMessage: BaseSpanMessage{Duration: time.Millisecond, Sampled: i%2 == 0, DryRunSampled: i == 1},
+			Transaction:     "checkout",
+			Status:          200,
+			Method:          "GET",
+		}
+		s.Process(m)
+	}
+	count := func(name string) int {
+		if me, ok := m.m[name+"&false&TransactionName:checkout&"]; ok {
+			return me.Count
+		}
+		return 0
+	}
+	assert.Equal(t, 5, count(TransactionRequestCountName))
+	assert.Equal(t, 3, count(TransactionTraceCountName))
+	assert.Equal(t, 1, count(TransactionDryRunTraceCountName))
+}
+
+func TestSiblingSpanMessage(t *testing.T) {
+	metricsHTTPHistograms.lock.Lock()
+	metricsHTTPHistograms.histograms = make(map[string]*histogram)
+	metricsHTTPHistograms.lock.Unlock()
+
+	m := NewMeasurements(false, 60, metricsTransactionsMaxDefault)
+	s := HTTPSpanMessage{
+		BaseSpanMessage: BaseSpanMessage{Duration: time.Millisecond},
+		Transaction:     "audit",
+		Sibling:         true,
+	}
+	s.Process(m)
+
+	_, ok := GetLatencyPercentiles("audit")
+	assert.True(t, ok)
+	_, ok = GetLatencyPercentiles("")
+	assert.False(t, ok)
+}
+
+func TestSLOCounts(t *testing.T) {
+	os.Setenv("APPOPTICS_SLO_TARGETS", "checkout=100")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_SLO_TARGETS")
+		config.Load()
+	}()
+
+	metricsHTTPHistograms.lock.Lock()
+	metricsHTTPHistograms.histograms = make(map[string]*histogram)
+	metricsHTTPHistograms.lock.Unlock()
+	for _, ms := range []int{20, 50, 100, 300} {
+		recordHistogram(metricsHTTPHistograms, "", time.Duration(ms)*time.Millisecond)
+		recordHistogram(metricsHTTPHistograms, "checkout", time.Duration(ms)*time.Millisecond)
+		recordHistogram(metricsHTTPHistograms, "search", time.Duration(ms)*time.Millisecond)
+	}
+
+	m := NewMeasurements(false, 60, metricsTransactionsMaxDefault)
+	bbuf := bson.WithBuf(BuildBuiltinMetricsMessage(m, &EventQueueStats{}, nil, false))
+	counts := make(map[string]int)
+	for _, v := range bsonToMap(bbuf)["measurements"].([]interface{}) {
+		mt := v.(map[string]interface{})
+		if mt["name"] != SLOCountName {
+			continue
+		}
+		tags := mt["tags"].(map[string]interface{})
+		assert.Equal(t, "checkout", tags["TransactionName"])
+		counts[tags["SLOStatus"].(string)] = mt["count"].(int)
+	}
+	assert.Equal(t, map[string]int{SLOGood: 3, SLOBad: 1}, counts)
+}
+
+func TestIdleTransactionEviction(t *testing.T) {
+	os.Setenv("APPOPTICS_METRICS_IDLE_CYCLES", "1")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_METRICS_IDLE_CYCLES")
+		config.Load()
+	}()
+
+	metricsHTTPHistograms.lock.Lock()
+	metricsHTTPHistograms.histograms = make(map[string]*histogram)
+	metricsHTTPHistograms.lock.Unlock()
+
+	m := NewMeasurements(false, 60, metricsTransactionsMaxDefault)
+	flush := func() (int, int64) {
+		c := m.CopyAndReset(60)
+		if c == nil {
+			c = NewMeasurements(false, 60, metricsTransactionsMaxDefault)
+		}
+		msg := bsonToMap(bson.WithBuf(BuildBuiltinMetricsMessage(c, &EventQueueStats{}, nil, false)))
+		var active int64 = -1
+		for _, v := range msg["measurements"].([]interface{}) {
+			if mt := v.(map[string]interface{}); mt["name"] == "ActiveTransactions" {
+				active = mt["value"].(int64)
+			}
+		}
+		hists, _ := msg["histograms"].([]interface{})
+		return len(hists), active
+	}
+	record := func(names ...string) {
+		for _, name := range names {
+			recordHistogram(metricsHTTPHistograms, "", time.Millisecond)
+			recordHistogram(metricsHTTPHistograms, name, time.Millisecond)
+			assert.Nil(t, m.Increment(name, MetricOptions{Count: 1}))
+		}
+	}
+
+	record("checkout", "search")
+	hists, active := flush()
+	assert.Equal(t, 3, hists)
+	assert.EqualValues(t, 2, active)
+	assert.Len(t, m.m, 2)
+	assert.Equal(t, 0, m.m[metricID("checkout", nil, false)].Count)
+
+	record("checkout")
+	hists, active = flush()
+	assert.Equal(t, 2, hists)
+	assert.EqualValues(t, 1, active)
+	assert.Len(t, m.m, 2)
+	assert.Len(t, metricsHTTPHistograms.histograms, 3)
+
+	// search has been idle for two cycles
+	record("checkout")
+	flush()
+	assert.Len(t, m.m, 1)
+	assert.Len(t, metricsHTTPHistograms.histograms, 2)
+	_, ok := metricsHTTPHistograms.histograms["search"]
+	assert.False(t, ok)
+}
+
+func TestMetricsTagFilter(t *testing.T) {
+	os.Setenv("APPOPTICS_METRICS_TAG_DENY_LIST", "HttpStatus")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_METRICS_TAG_DENY_LIST")
+		os.Unsetenv("APPOPTICS_METRICS_TAG_ALLOW_LIST")
+		config.Load()
+	}()
+
+	m := NewMeasurements(true, 60, 100)
+	assert.Nil(t, m.Increment("plain", MetricOptions{Count: 1}))
+	assert.Nil(t, m.Increment("byStatus", MetricOptions{Count: 1, Tags: map[string]string{"HttpStatus": "200"}}))
+	assert.Nil(t, m.Increment("byMethod", MetricOptions{Count: 1, Tags: map[string]string{"HttpMethod": "GET"}}))
+	names := func() []string {
+		var names []string
+		for _, v := range bsonToMap(bson.WithBuf(BuildMessage(m, false)))["measurements"].([]interface{}) {
+			names = append(names, v.(map[string]interface{})["name"].(string))
+		}
+		return names
+	}
+	assert.ElementsMatch(t, []string{"plain", "byMethod"}, names())
+
+	os.Setenv("APPOPTICS_METRICS_TAG_ALLOW_LIST", "HttpStatus,HttpMethod")
+	config.Load()
+	assert.ElementsMatch(t, []string{"plain", "byMethod"}, names())
+
+	os.Unsetenv("APPOPTICS_METRICS_TAG_DENY_LIST")
+	os.Setenv("APPOPTICS_METRICS_TAG_ALLOW_LIST", "HttpStatus")
+	config.Load()
+	assert.ElementsMatch(t, []string{"plain", "byStatus"}, names())
+}
+
+func TestRunFlushCallbacks(t *testing.T) {
+	defer ResetFlushCallbacks()
+
+	m := NewMeasurements(true, 60, 100)
+	assert.Nil(t, m.Increment("hits", MetricOptions{Count: 2, Tags: map[string]string{"k": "v"}}))
+
+	var flushed []FlushedMetrics
+	AddFlushCallback(func(fm FlushedMetrics) { flushed = append(flushed, fm) })
+	AddFlushCallback(func(fm FlushedMetrics) { panic("should be recovered") })
+	AddFlushCallback(nil)
+
+	RunFlushCallbacks(nil)
+	assert.Empty(t, flushed)
+
+	snapshot := m.CopyAndReset(60)
+	RunFlushCallbacks(snapshot)
+	assert.Len(t, flushed, 1)
+	assert.True(t, flushed[0].IsCustom)
+	assert.EqualValues(t, 60, flushed[0].FlushInterval)
+	assert.Equal(t, []Measurement{{Name: "hits", Tags: map[string]string{"k": "v"}, Count: 2}},
+		flushed[0].Measurements)
+
+	// the callbacks get copies of the tags
+	flushed[0].Measurements[0].Tags["k"] = "changed"
+	for _, me := range snapshot.m {
+		assert.Equal(t, "v", me.Tags["k"])
+	}
+}
+
+func TestTransactionLimitCallbacks(t *testing.T) {
+	defer ResetTransactionLimitCallbacks()
+	defer UpdateTransactionLimit(metricsTransactionsMaxDefault)
+
+	var events []TransactionLimitEvent
+	AddTransactionLimitCallback(func(e TransactionLimitEvent) { events = append(events, e) })
+	AddTransactionLimitCallback(func(e TransactionLimitEvent) { panic("should be recovered") })
+	AddTransactionLimitCallback(nil)
+
+	_, changed := UpdateTransactionLimit(metricsTransactionsMaxDefault)
+	assert.False(t, changed)
+	assert.Empty(t, events)
+
+	prev, changed := UpdateTransactionLimit(2)
+	assert.True(t, changed)
+	assert.EqualValues(t, metricsTransactionsMaxDefault, prev)
+	assert.EqualValues(t, 2, TransactionLimit())
+	assert.Equal(t, []TransactionLimitEvent{{Limit: 2, Previous: metricsTransactionsMaxDefault}}, events)
+
+	m := NewMeasurements(false, 60, 2)
+	for _, name := range []string{"a", "b", "c"} {
+		m.transMap.IsWithinLimit(name)
+	}
+	BuildBuiltinMetricsMessage(m, &EventQueueStats{}, nil, false)
+	assert.Len(t, events, 2)
+	assert.Equal(t, TransactionLimitEvent{Limit: 2, Previous: 2, Overflow: true}, events[1])
+}
+
+func TestMetricID(t *testing.T) {
+	cases := []map[string]string{
+		nil,
+		{},
+		{"TransactionName": "t"},
+		{"TransactionName": "t", "HttpMethod": "GET"},
+		{"HttpMethod": "GET", "TransactionName": "t"},
+		{"TransactionName": "t", "HttpStatus": "200", "HttpMethod": "GET"},
+		{"": "empty", "TransactionName": "t"},
+	}
+	for _, tags := range cases {
+		for _, rv := range []bool{true, false} {
+			// the second call hits the cache
+			assert.Equal(t, buildMetricID("name", tags, rv), metricID("name", tags, rv))
+			assert.Equal(t, buildMetricID("name", tags, rv), metricID("name", tags, rv))
+		}
+	}
+	assert.Equal(t, "name&true&HttpMethod:GET&TransactionName:t&",
+		metricID("name", map[string]string{"TransactionName": "t", "HttpMethod": "GET"}, true))
+}
+
+func BenchmarkRecord(b *testing.B) {
+	s := HTTPSpanMessage{
+		BaseSpanMessage: BaseSpanMessage{Duration: time.Second, HasError: true},
+		Transaction:     "transaction",
+		Status:          500,
+		Method:          "GET",
+	}
+	m := NewMeasurements(false, 60, metricsTransactionsMaxDefault)
+	tagsList := s.produceTagsList()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.record("TransactionResponseTime", tagsList, 1, 1, true)
+	}
+}
+
+func TestHTTPStatusTagging(t *testing.T) {
+	defer func() {
+		os.Unsetenv("APPOPTICS_HTTP_STATUS_TAGGING")
+		config.Load()
+	}()
+	s := HTTPSpanMessage{
+		BaseSpanMessage: BaseSpanMessage{Duration: time.Second},
+		Transaction:     "transaction",
+		Status:          404,
+		Method:          "GET",
+	}
+
+	for tagging, expected := range map[string][]string{
+		"exact": {"TransactionResponseTime&true&HttpStatus:404&TransactionName:transaction&"},
+		"class": {"TransactionResponseTime&true&HttpStatusClass:4xx&TransactionName:transaction&"},
+		"both": {"TransactionResponseTime&true&HttpStatus:404&TransactionName:transaction&",
+			"TransactionResponseTime&true&HttpStatusClass:4xx&TransactionName:transaction&"},
+	} {
+		os.Setenv("APPOPTICS_HTTP_STATUS_TAGGING", tagging)
+		config.Load()
+		m := NewMeasurements(false, 60, metricsTransactionsMaxDefault)
+		s.Process(m)
+
+		var ids []string
+		for id := range m.m {
+			if strings.Contains(id, "HttpStatus") {
+				ids = append(ids, id)
+			}
+		}
+		assert.ElementsMatch(t, expected, ids, tagging)
+	}
+}
+
+func TestMeasurementExemplars(t *testing.T) {
+	me := NewMeasurements(false, 60, 100)
+	for i, id := range []string{"A", "B", "", "C", "D", "E"} {
+		s := &HTTPSpanMessage{
+			BaseSpanMessage: BaseSpanMessage{Duration: time.Duration(i+1) * time.Millisecond, TraceID: id},
+			Transaction:     "txn",
+			Status:          200,
+			Method:          "GET",
+		}
+		s.Process(me)
+	}
+
+	m := me.m[metricID("TransactionResponseTime", map[string]string{"TransactionName": "txn"}, true)]
+	assert.NotNil(t, m)
+	assert.Equal(t, 6, m.Count)
+	// the exemplars of the slowest requests are kept
+	assert.ElementsMatch(t, []Exemplar{{"C", 4000}, {"D", 5000}, {"E", 6000}}, m.Exemplars)
+
+	index := 0
+	bbuf := bson.NewBuffer()
+	addMeasurementToBSON(bbuf, &index, m)
+	bbuf.Finish()
+	bm := bsonToMap(bbuf)["0"].(map[string]interface{})
+	exemplars := bm["exemplars"].([]interface{})
+	assert.Len(t, exemplars, 3)
+	e := exemplars[0].(map[string]interface{})
+	assert.NotEmpty(t, e["trace_id"])
+	assert.NotZero(t, e["value"])
+}
+
+func TestGetLatencyPercentiles(t *testing.T) {
+	metricsHTTPHistograms.lock.Lock()
+	metricsHTTPHistograms.histograms = make(map[string]*histogram)
+	metricsHTTPHistograms.lock.Unlock()
+
+	_, ok := GetLatencyPercentiles("latency-txn")
+	assert.False(t, ok)
+
+	for i := 1; i <= 100; i++ {
+		recordHistogram(metricsHTTPHistograms, "latency-txn", time.Duration(i)*time.Millisecond)
+	}
+	p, ok := GetLatencyPercentiles("latency-txn")
+	assert.True(t, ok)
+	assert.EqualValues(t, 100, p.Count)
+	assert.InDelta(t, 50*time.Millisecond, p.P50, float64(time.Millisecond))
+	assert.InDelta(t, 95*time.Millisecond, p.P95, float64(time.Millisecond))
+	assert.InDelta(t, 99*time.Millisecond, p.P99, float64(time.Millisecond))
+
+	_, ok = GetLatencyPercentiles("other-txn")
+	assert.False(t, ok)
+}
@@ -15,6 +15,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/host"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
 	"github.com/pkg/errors"
 )
@@ -77,9 +79,32 @@ type transactionContext struct {
 	name string
 	// if the trace/transaction is enabled (defined by per-URL transaction filtering)
 	enabled bool
+	// if the transaction would have been sampled but for the dry-run mode
+	dryRunSampled bool
+	// the number of Info events of each layer seen in this trace, for the
+	// layers with a configured Info event sample rate
+	infoEvents map[string]int
 	sync.RWMutex
 }
 
+// sampleInfoEvent tells if an Info event of the layer should be reported. Only
+// the first of every N Info events of a layer is reported in a trace if the
+// layer is configured with N by InfoEventSampling.
+func (t *transactionContext) sampleInfoEvent(layer string) bool {
+	n := config.GetInfoEventSampling(layer)
+	if n <= 1 {
+		return true
+	}
+	t.Lock()
+	defer t.Unlock()
+	if t.infoEvents == nil {
+		t.infoEvents = make(map[string]int)
+	}
+	seen := t.infoEvents[layer]
+	t.infoEvents[layer] = seen + 1
+	return seen%n == 0
+}
+
 type KVMap map[string]interface{}
 
 // ContextOptions defines the options of creating a context.
@@ -88,6 +113,13 @@ type ContextOptions struct {
 	MdStr string
 	// URL is used to do the URL-based transaction filtering.
 	URL string
+	// Method is the HTTP method of the request, which is matched along with
+	// the URL by the transaction filtering.
+	Method string
+	// SampledHint is the X-Trace-Sampled header, which is the sampling decision
+	// of an upstream service without a full agent. It's only used if MdStr is
+	// empty and the SampledHeader option is enabled.
+	SampledHint string
 	// XTraceOptions represents the X-Trace-Options header.
 	XTraceOptions string
 	// XTraceOptionsSignature represents the X-Trace-Options-Signature header.
@@ -126,7 +158,7 @@ func (md *oboeMetadata) SetRandom() error {
 		return errors.New("md.SetRandom: nil md")
 	}
 
-	if err := md.SetRandomTaskID(randReader); err != nil {
+	if err := md.setTaskID(getIDGenerator()); err != nil {
 		return err
 	}
 	return md.SetRandomOpID()
@@ -134,10 +166,16 @@ func (md *oboeMetadata) SetRandom() error {
 
 // SetRandomTaskID randomize the task ID. It will retry if the random reader returns
 // an error or produced task ID is all-zero, which rarely happens though.
-func (md *oboeMetadata) SetRandomTaskID(rand io.Reader) (err error) {
+func (md *oboeMetadata) SetRandomTaskID(rand io.Reader) error {
+	return md.setTaskID(readerIDGenerator{r: rand})
+}
+
+// setTaskID sets the task ID from the generator with the same retry policy as
+// SetRandomTaskID.
+func (md *oboeMetadata) setTaskID(g IDGenerator) (err error) {
 	retried := 0
 	for retried < 2 {
-		if _, err = rand.Read(md.ids.taskID); err != nil {
+		if err = g.TaskID(md.ids.taskID); err != nil {
 			break
 		}
 
@@ -152,8 +190,7 @@ func (md *oboeMetadata) SetRandomTaskID(rand io.Reader) (err error) {
 }
 
 func (md *oboeMetadata) SetRandomOpID() error {
-	_, err := randReader.Read(md.ids.opID)
-	return err
+	return getIDGenerator().OpID(md.ids.opID)
 }
 
 func (ids *oboeIDs) setOpID(opID []byte) {
@@ -326,6 +363,7 @@ type Context interface {
 	SetSampled(trace bool)
 	SetEnabled(enabled bool)
 	GetEnabled() bool
+	GetDryRunSampled() bool
 	SetTransactionName(name string)
 	GetTransactionName() string
 	MetadataString() string
@@ -354,6 +392,7 @@ func (e *nullContext) IsSampled() bool                                       { r
 func (e *nullContext) SetSampled(trace bool)                                 {}
 func (e *nullContext) SetEnabled(enabled bool)                               {}
 func (e *nullContext) GetEnabled() bool                                      { return true }
+func (e *nullContext) GetDryRunSampled() bool                                { return false }
 func (e *nullContext) SetTransactionName(name string)                        {}
 func (e *nullContext) GetTransactionName() string                            { return "" }
 func (e *nullContext) MetadataString() string                                { return "" }
@@ -577,6 +616,26 @@ func NewContext(layer string, reportEntry bool, opts ContextOptions,
 
 	continuedTrace := false
 
+	// the request is not traced as the upstream service didn't sample it
+	notSampled := func() (Context, bool, map[string]string) {
+		setting, has := getSetting(layer)
+		if !has {
+			SetHeaders(ttSettingsNotAvailable)
+			return ctx, false, headers
+		}
+
+		_, flags, _ := mergeURLSetting(setting, opts.Method, opts.URL)
+		ctx.SetEnabled(flags.Enabled())
+
+		if tMode.Requested() {
+			SetHeaders(ttIgnored)
+		} else {
+			SetHeaders(ttNotRequested)
+		}
+
+		return ctx, true, headers
+	}
+
 	if opts.MdStr != "" {
 		var err error
 		if ctx, err = newContextFromMetadataString(opts.MdStr); err != nil {
@@ -588,22 +647,19 @@ func NewContext(layer string, reportEntry bool, opts ContextOptions,
 			addCtxEdge = true
 			continuedTrace = true
 		} else {
-			setting, has := getSetting(layer)
-			if !has {
-				SetHeaders(ttSettingsNotAvailable)
-				return ctx, false, headers
-			}
-
-			_, flags, _ := mergeURLSetting(setting, opts.URL)
-			ctx.SetEnabled(flags.Enabled())
-
-			if tMode.Requested() {
-				SetHeaders(ttIgnored)
-			} else {
-				SetHeaders(ttNotRequested)
-			}
-
-			return ctx, true, headers
+			return notSampled()
+		}
+	} else if opts.SampledHint != "" && config.GetSampledHeader() {
+		// start a new trace, but follow the upstream sampling decision as if
+		// it was continued from an X-Trace ID, without an edge to the parent.
+		switch strings.TrimSpace(opts.SampledHint) {
+		case "1":
+			ctx = newContext(true)
+			traced = true
+		case "0":
+			ctx = newContext(true)
+			ctx.SetSampled(false)
+			return notSampled()
 		}
 	}
 
@@ -611,9 +667,17 @@ func NewContext(layer string, reportEntry bool, opts ContextOptions,
 		ctx = newContext(true)
 	}
 
-	decision := shouldTraceRequestWithURL(layer, traced, opts.URL, tMode)
+	decision := shouldTraceRequestWithURL(layer, traced, opts.Method, opts.URL, tMode)
 	ctx.SetEnabled(decision.enabled)
 
+	// the decision is counted by transaction but not acted on in the dry-run mode
+	if decision.trace && config.GetTracingMode() == config.DryRunTracingMode {
+		if oc, ok := ctx.(*oboeContext); ok {
+			oc.txCtx.dryRunSampled = true
+		}
+		decision.trace = false
+	}
+
 	if decision.trace {
 		if reportEntry {
 			var kvs map[string]interface{}
@@ -627,6 +691,13 @@ func NewContext(layer string, reportEntry bool, opts ContextOptions,
 			for k, v := range tKVs {
 				kvs[k] = v
 			}
+			// map the trace to the workload which served it, unless the KVs of
+			// the same keys are reported already
+			for k, v := range host.K8sMetadata() {
+				if _, ok := kvs[k]; !ok {
+					kvs[k] = v
+				}
+			}
 
 			if !continuedTrace {
 				kvs["SampleRate"] = decision.rate
@@ -656,6 +727,31 @@ func NewContext(layer string, reportEntry bool, opts ContextOptions,
 	return ctx, true, headers
 }
 
+// NewSiblingContext starts a trace of another transaction in the trace of ctx.
+// The new context shares the task ID and the sampling decision of ctx, but has
+// its own transaction name and reports an entry event without an edge, so the
+// trace has two roots. It's not ok if ctx is not a valid context.
+func NewSiblingContext(ctx Context, layer string, cb func() KVMap) (Context, bool) {
+	oc, ok := ctx.(*oboeContext)
+	if !ok {
+		return &nullContext{}, false
+	}
+	sibling := oc.Copy().(*oboeContext)
+	sibling.txCtx = &transactionContext{enabled: oc.GetEnabled(), dryRunSampled: oc.GetDryRunSampled()}
+	if !sibling.IsSampled() {
+		return sibling, true
+	}
+
+	var kvs map[string]interface{}
+	if cb != nil {
+		kvs = cb()
+	}
+	if err := sibling.reportEventMap(LabelEntry, layer, false, kvs); err != nil {
+		return &nullContext{}, false
+	}
+	return sibling, true
+}
+
 func (ctx *oboeContext) Copy() Context {
 	md := oboeMetadata{}
 	md.Init()
@@ -682,6 +778,12 @@ func (ctx *oboeContext) GetEnabled() bool {
 	return ctx.txCtx.enabled
 }
 
+// GetDryRunSampled returns if the transaction would have been sampled if the
+// tracing mode was not dry-run.
+func (ctx *oboeContext) GetDryRunSampled() bool {
+	return ctx.txCtx.dryRunSampled
+}
+
 func (ctx *oboeContext) SetTransactionName(name string) {
 	ctx.txCtx.Lock()
 	defer ctx.txCtx.Unlock()
@@ -734,6 +836,9 @@ func (ctx *oboeContext) ReportEvent(label Label, layer string, args ...interface
 
 // Create and report an event using KVs from variadic args
 func (ctx *oboeContext) reportEvent(label Label, layer string, addCtxEdge bool, args ...interface{}) error {
+	if label == LabelInfo && !ctx.txCtx.sampleInfoEvent(layer) {
+		return nil
+	}
 	// create new event from context
 	e, err := ctx.newEvent(label, layer)
 	if err != nil { // error creating event (e.g. couldn't init random IDs)
@@ -744,6 +849,7 @@ func (ctx *oboeContext) reportEvent(label Label, layer string, addCtxEdge bool,
 
 // report an event using KVs from variadic args
 func (ctx *oboeContext) report(e *event, addCtxEdge bool, args ...interface{}) error {
+	defer overhead.record(overhead.start())
 	for i := 0; i+1 < len(args); i += 2 {
 		if err := e.AddKV(args[i], args[i+1]); err != nil {
 			return err
@@ -7,13 +7,16 @@ import (
 	"crypto/rand"
 	"errors"
 	"io"
+	"os"
 	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
 	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	mbson "gopkg.in/mgo.v2/bson"
 )
 
 func TestMetadata(t *testing.T) {
@@ -228,6 +231,35 @@ func TestReportEventMap(t *testing.T) {
 	})
 }
 
+func TestInfoEventSampling(t *testing.T) {
+	os.Setenv("APPOPTICS_INFO_EVENT_SAMPLING", "cache=3")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_INFO_EVENT_SAMPLING")
+		config.Load()
+	}()
+
+	r := SetTestReporter()
+	ctx := newTestContext(t)
+	for i := 0; i < 7; i++ {
+		assert.NoError(t, ctx.ReportEvent(LabelInfo, "cache", "Seq", i))
+	}
+	// the Info events of other layers and the other events are not sampled
+	assert.NoError(t, ctx.Copy().ReportEvent(LabelInfo, "db"))
+	assert.NoError(t, ctx.ReportEvent(LabelExit, "cache"))
+	r.Close(5)
+
+	var seqs []int
+	for _, buf := range r.EventBufs {
+		m := make(map[string]interface{})
+		assert.NoError(t, mbson.Unmarshal(buf, m))
+		if m["Layer"] == "cache" && m["Label"] == "info" {
+			seqs = append(seqs, m["Seq"].(int))
+		}
+	}
+	assert.Equal(t, []int{0, 3, 6}, seqs)
+}
+
 func TestNewContextForURL(t *testing.T) {
 	r := SetTestReporter()
 
@@ -285,6 +317,33 @@ func TestNewContextForURLTracingDisabled(t *testing.T) {
 	r.Close(0)
 }
 
+func TestNewContextDryRun(t *testing.T) {
+	os.Setenv("APPOPTICS_TRACING_MODE", "dry-run")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_TRACING_MODE")
+		config.Load()
+	}()
+	r := SetTestReporter()
+
+	ctx, ok, _ := NewContext("testLayer", true, ContextOptions{}, nil)
+	assert.True(t, ok)
+	assert.False(t, ctx.IsSampled())
+	assert.True(t, ctx.GetEnabled())
+	assert.True(t, ctx.GetDryRunSampled())
+
+	sibling, ok := NewSiblingContext(ctx, "testSibling", nil)
+	assert.True(t, ok)
+	assert.True(t, sibling.GetDryRunSampled())
+	r.Close(0)
+
+	// not counted if it wouldn't be sampled
+	r = SetTestReporter(TestReporterDisableTracing())
+	ctx, _, _ = NewContext("testLayer", true, ContextOptions{}, nil)
+	assert.False(t, ctx.GetDryRunSampled())
+	r.Close(0)
+}
+
 // TestNullContext asserts properties of nullContext structs.
 func TestNullContext(t *testing.T) {
 	r := SetTestReporter()
@@ -0,0 +1,73 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Encoder converts the events and the metrics messages, which are built in
+// BSON, into the wire format of a reporter. It must be safe for concurrent use.
+type Encoder interface {
+	Encode(doc []byte) ([]byte, error)
+}
+
+// EncoderFunc is an Encoder by a function.
+type EncoderFunc func(doc []byte) ([]byte, error)
+
+// Encode implements the Encoder interface.
+func (f EncoderFunc) Encode(doc []byte) ([]byte, error) { return f(doc) }
+
+// the built-in encodings
+const (
+	EncodingBSON = "bson"
+	EncodingJSON = "json"
+)
+
+var encoders = struct {
+	sync.RWMutex
+	m map[string]Encoder
+}{m: map[string]Encoder{
+	EncodingBSON: EncoderFunc(encodeBSON),
+	EncodingJSON: EncoderFunc(encodeJSON),
+}}
+
+// RegisterEncoder adds an encoding which can be selected by the reporter
+// encoding of the configuration, e.g. protobuf or msgpack.
+func RegisterEncoder(name string, e Encoder) {
+	encoders.Lock()
+	defer encoders.Unlock()
+	encoders.m[strings.ToLower(name)] = e
+}
+
+// getEncoder returns the encoder of the configured reporter encoding, which
+// falls back to BSON if it's unknown.
+func getEncoder() Encoder {
+	name := config.GetReporterEncoding()
+	if name == "" {
+		name = EncodingBSON
+	}
+	encoders.RLock()
+	defer encoders.RUnlock()
+	if e, ok := encoders.m[name]; ok {
+		return e
+	}
+	log.Warningf("Unknown reporter encoding %s, using %s.", name, EncodingBSON)
+	return encoders.m[EncodingBSON]
+}
+
+func encodeBSON(doc []byte) ([]byte, error) { return doc, nil }
+
+func encodeJSON(doc []byte) ([]byte, error) {
+	m := bson.M{}
+	if err := bson.Unmarshal(doc, m); err != nil {
+		return nil, err
+	}
+	return json.Marshal(m)
+}
@@ -0,0 +1,55 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/bson"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncoders(t *testing.T) {
+	bbuf := bson.NewBuffer()
+	bbuf.AppendString("Layer", "encoder")
+	bbuf.AppendInt("Status", 200)
+	bbuf.Finish()
+	doc := bbuf.GetBuf()
+
+	defer func() {
+		os.Unsetenv("APPOPTICS_REPORTER_ENCODING")
+		config.Load()
+	}()
+
+	// BSON by default
+	buf, err := getEncoder().Encode(doc)
+	assert.NoError(t, err)
+	assert.Equal(t, doc, buf)
+
+	os.Setenv("APPOPTICS_REPORTER_ENCODING", "JSON")
+	config.Load()
+	buf, err = getEncoder().Encode(doc)
+	require.NoError(t, err)
+	m := make(map[string]interface{})
+	require.NoError(t, json.Unmarshal(buf, &m))
+	assert.Equal(t, map[string]interface{}{"Layer": "encoder", "Status": float64(200)}, m)
+
+	_, err = getEncoder().Encode([]byte("invalid"))
+	assert.Error(t, err)
+
+	RegisterEncoder("Upper", EncoderFunc(func(doc []byte) ([]byte, error) { return []byte("upper"), nil }))
+	os.Setenv("APPOPTICS_REPORTER_ENCODING", "upper")
+	config.Load()
+	buf, _ = getEncoder().Encode(doc)
+	assert.Equal(t, "upper", string(buf))
+
+	// falls back to BSON
+	os.Setenv("APPOPTICS_REPORTER_ENCODING", "unknown")
+	config.Load()
+	buf, _ = getEncoder().Encode(doc)
+	assert.Equal(t, doc, buf)
+}
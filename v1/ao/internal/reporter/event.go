@@ -9,15 +9,20 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"time"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/bson"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/metrics"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/utils"
 )
 
 type event struct {
-	metadata oboeMetadata
-	bbuf     *bson.Buffer
+	metadata  oboeMetadata
+	bbuf      *bson.Buffer
+	timestamp time.Time // the caller-provided timestamp, time.Now is used if zero
+	label     Label
 }
 
 // Label is a required event attribute.
@@ -30,8 +35,15 @@ const (
 	LabelInfo  = "info"
 	LabelError = "error"
 	EdgeKey    = "Edge"
+
+	LabelProfileEntry = "profile_entry"
+	LabelProfileExit  = "profile_exit"
 )
 
+// KeyTimestamp is the key of the event timestamp. The timestamp is set to the
+// time the event is reported, unless a time.Time value is provided with this key.
+const KeyTimestamp = "Timestamp_u"
+
 const (
 	eventHeader = "1"
 )
@@ -117,7 +129,10 @@ func newTracingMode(mode config.TracingMode) tracingMode {
 	switch mode {
 	case config.DisabledTracingMode:
 		return TRACE_DISABLED
-	case config.EnabledTracingMode:
+	case config.EnabledTracingMode, config.MetricsOnlyTracingMode, config.DryRunTracingMode:
+		// the metrics-only and dry-run modes keep the flags for the metrics to
+		// be recorded and the sampling decisions to be made, but no requests
+		// are traced.
 		return TRACE_ENABLED
 	default:
 	}
@@ -191,15 +206,34 @@ func newEvent(md *oboeMetadata, label Label, layer string) (*event, error) {
 }
 
 func (e *event) addLabelLayer(label Label, layer string) {
+	e.label = label
 	e.AddString("Label", string(label))
 	if layer != "" {
 		e.AddString("Layer", layer)
 	}
 }
 
+// optional tells if the event only annotates a span, i.e., it can be dropped
+// without breaking the structure of the trace.
+func (e *event) optional() bool {
+	return e.label == LabelInfo
+}
+
 // Adds string key/value to event. BSON strings are assumed to be Unicode.
 func (e *event) AddString(key, value string) { e.bbuf.AppendString(key, value) }
 
+// addStringValue adds a string key/value provided by the user, with the value
+// truncated to the max length of KV values.
+func (e *event) addStringValue(key, value string) {
+	if max := config.GetMaxKVValueLength(); max > 0 {
+		var cut bool
+		if value, cut = utils.Truncate(value, max); cut {
+			metrics.CountTruncatedKVValue()
+		}
+	}
+	e.AddString(key, value)
+}
+
 // Adds a binary buffer as a key/value to this event. This uses a binary-safe BSON buffer type.
 func (e *event) AddBinary(key string, value []byte) { e.bbuf.AppendBinary(key, value) }
 
@@ -248,11 +282,17 @@ func (e *event) AddKV(key, value interface{}) error {
 	}
 	// load value and add KV to event
 	switch v := value.(type) {
+	case time.Time:
+		if k == KeyTimestamp {
+			e.timestamp = v
+		} else {
+			log.Debugf("Ignoring unrecognized Event key %v val %v valType %T", k, v, v)
+		}
 	case string:
 		if k == EdgeKey {
 			e.AddEdgeFromMetadataString(v)
 		} else {
-			e.AddString(k, v)
+			e.addStringValue(k, v)
 		}
 	case []byte:
 		e.AddBinary(k, v)
@@ -291,7 +331,7 @@ func (e *event) AddKV(key, value interface{}) error {
 			if k == EdgeKey {
 				e.AddEdgeFromMetadataString(*v)
 			} else {
-				e.AddString(k, *v)
+				e.addStringValue(k, *v)
 			}
 		}
 	case *[]byte:
@@ -348,7 +388,10 @@ func (e *event) AddKV(key, value interface{}) error {
 func (e *event) ReportUsing(c *oboeContext, r reporter, channel reporterChannel) error {
 	if channel == EVENTS {
 		if e.metadata.isSampled() {
-			return r.reportEvent(c, e)
+			if err := r.reportEvent(c, e); err != nil {
+				return err
+			}
+			mirrorEvent(&e.metadata, e.bbuf.GetBuf())
 		}
 	} else if channel == METRICS {
 		return r.reportStatus(c, e)
@@ -4,6 +4,7 @@ package reporter
 
 import (
 	"math"
+	"os"
 	"testing"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
@@ -179,6 +180,34 @@ func TestEventNoEdge(t *testing.T) {
 	})
 }
 
+func TestEventKVValueTruncation(t *testing.T) {
+	os.Setenv("APPOPTICS_MAX_KV_VALUE_LENGTH", "5")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_MAX_KV_VALUE_LENGTH")
+		config.Load()
+	}()
+
+	r := SetTestReporter()
+	ctx := newTestContext(t)
+	e, err := ctx.newEvent(LabelEntry, testLayer)
+	assert.NoError(t, err)
+	long := "0123456789"
+	assert.NoError(t, e.AddKV("Short", "abc"))
+	assert.NoError(t, e.AddKV("Long", long))
+	assert.NoError(t, e.AddKV("LongPtr", &long))
+	assert.NoError(t, e.Report(ctx))
+
+	r.Close(1)
+	g.AssertGraph(t, r.EventBufs, 1, g.AssertNodeMap{
+		{testLayer, "entry"}: {Callback: func(n g.Node) {
+			assert.Equal(t, "abc", n.Map["Short"])
+			assert.Equal(t, "01234", n.Map["Long"])
+			assert.Equal(t, "01234", n.Map["LongPtr"])
+		}},
+	})
+}
+
 func TestSettingTypeToSampleSource(t *testing.T) {
 	assert.Equal(t, SAMPLE_SOURCE_DEFAULT, TYPE_DEFAULT.toSampleSource())
 	assert.Equal(t, SAMPLE_SOURCE_LAYER, TYPE_LAYER.toSampleSource())
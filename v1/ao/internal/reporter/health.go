@@ -0,0 +1,189 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/metrics"
+)
+
+// Connectivity describes the state of the connection to the collector.
+type Connectivity string
+
+// The connectivity states reported by ReporterHealth.
+const (
+	// ConnectivityConnected means the data is being sent to the collector.
+	ConnectivityConnected Connectivity = "connected"
+	// ConnectivityDisconnected means the connection is lost or the latest
+	// attempts to send data have failed.
+	ConnectivityDisconnected Connectivity = "disconnected"
+	// ConnectivityClosed means the reporter is shut down or disabled.
+	ConnectivityClosed Connectivity = "closed"
+	// ConnectivityUnknown is for the reporters which don't talk to the
+	// collector, e.g., the UDP and the serverless reporters.
+	ConnectivityUnknown Connectivity = "unknown"
+)
+
+// ReporterHealth is a snapshot of the health of the reporter. The counts are
+// accumulated since the process started, unlike the ones reported in the
+// metrics which are reset on each cycle.
+type ReporterHealth struct {
+	Reporter      string       `json:"reporter"`
+	Connectivity  Connectivity `json:"connectivity"`
+	QueueDepth    int          `json:"queueDepth"`
+	QueueCapacity int          `json:"queueCapacity"`
+	NumSent       int64        `json:"numSent"`
+	NumFailed     int64        `json:"numFailed"`
+	NumOverflowed int64        `json:"numOverflowed"`
+	LastSent      time.Time    `json:"lastSent,omitempty"`
+}
+
+// The token buckets of the sampling decisions, i.e., the keys of
+// AgentStats.RateCounts.
+const (
+	RateCountsRegular        = "regular"
+	RateCountsRelaxedTrigger = "triggerRelaxed"
+	RateCountsStrictTrigger  = "triggerStrict"
+)
+
+// the keys of the rate counts flushed from the token buckets
+var rateCountsKeys = map[string]string{
+	metrics.RCRegular:             RateCountsRegular,
+	metrics.RCRelaxedTriggerTrace: RateCountsRelaxedTrigger,
+	metrics.RCStrictTriggerTrace:  RateCountsStrictTrigger,
+}
+
+// RateCountTotals are the numbers of the requests for which a token bucket
+// made the sampling decisions.
+type RateCountTotals struct {
+	Requested int64 `json:"requested"`
+	Sampled   int64 `json:"sampled"`
+	Limited   int64 `json:"limited"`
+	Traced    int64 `json:"traced"`
+	Through   int64 `json:"through"`
+}
+
+// AgentStats are the internal counters of the agent, accumulated since the
+// process started. The rate counts and the transaction name overflows are
+// only updated when the metrics are flushed, i.e., once a minute by default.
+type AgentStats struct {
+	ReporterHealth
+	RateCounts map[string]RateCountTotals `json:"rateCounts"`
+	// TransactionNameOverflows is the number of the metrics cycles in which
+	// the transaction names exceeded the limit.
+	TransactionNameOverflows int64 `json:"transactionNameOverflows"`
+}
+
+// reporterStats accumulates the counts of the messages sent to the collector.
+// It's updated along with the EventQueueStats of the connections.
+type reporterStats struct {
+	numSent       int64
+	numFailed     int64
+	numOverflowed int64
+	lastSent      int64 // unix nanoseconds
+
+	rcLock     sync.Mutex
+	rateCounts map[string]RateCountTotals
+}
+
+var globalStats = &reporterStats{}
+
+func (s *reporterStats) sent(n int64) {
+	atomic.AddInt64(&s.numSent, n)
+	atomic.StoreInt64(&s.lastSent, time.Now().UnixNano())
+}
+
+func (s *reporterStats) failed(n int64) {
+	atomic.AddInt64(&s.numFailed, n)
+}
+
+func (s *reporterStats) overflowed(n int64) {
+	atomic.AddInt64(&s.numOverflowed, n)
+}
+
+func (s *reporterStats) addRateCounts(rcs map[string]*metrics.RateCounts) {
+	s.rcLock.Lock()
+	defer s.rcLock.Unlock()
+	if s.rateCounts == nil {
+		s.rateCounts = make(map[string]RateCountTotals)
+	}
+	for name, rc := range rcs {
+		key, ok := rateCountsKeys[name]
+		if !ok || rc == nil {
+			continue
+		}
+		t := s.rateCounts[key]
+		t.Requested += rc.Requested()
+		t.Sampled += rc.Sampled()
+		t.Limited += rc.Limited()
+		t.Traced += rc.Traced()
+		t.Through += rc.Through()
+		s.rateCounts[key] = t
+	}
+}
+
+func (s *reporterStats) rateCountTotals() map[string]RateCountTotals {
+	s.rcLock.Lock()
+	defer s.rcLock.Unlock()
+	totals := make(map[string]RateCountTotals, len(s.rateCounts))
+	for k, v := range s.rateCounts {
+		totals[k] = v
+	}
+	return totals
+}
+
+func (s *reporterStats) fill(st *ReporterHealth) {
+	st.NumSent = atomic.LoadInt64(&s.numSent)
+	st.NumFailed = atomic.LoadInt64(&s.numFailed)
+	st.NumOverflowed = atomic.LoadInt64(&s.numOverflowed)
+	if ns := atomic.LoadInt64(&s.lastSent); ns != 0 {
+		st.LastSent = time.Unix(0, ns)
+	}
+}
+
+// GetReporterHealth returns the health of the current reporter.
+func GetReporterHealth() ReporterHealth {
+	return healthOf(globalReporter)
+}
+
+func healthOf(r reporter) ReporterHealth {
+	st := ReporterHealth{Connectivity: ConnectivityUnknown}
+	globalStats.fill(&st)
+
+	switch rr := r.(type) {
+	case *grpcReporter:
+		st.Reporter = "ssl"
+		st.QueueDepth, st.QueueCapacity = len(rr.eventMessages), cap(rr.eventMessages)
+		if rr.conn.isActive() && GetSendFailureStatus().ConsecutiveFailures == 0 {
+			st.Connectivity = ConnectivityConnected
+		} else {
+			st.Connectivity = ConnectivityDisconnected
+		}
+	case *udpReporter:
+		st.Reporter = "udp"
+	case *serverlessReporter:
+		st.Reporter = "serverless"
+	case *customReporter:
+		st.Reporter = rr.name
+	case *TestReporter:
+		st.Reporter = "test"
+	case *nullReporter:
+		st.Reporter = "none"
+	}
+	if r.Closed() {
+		st.Connectivity = ConnectivityClosed
+	}
+	return st
+}
+
+// GetAgentStats returns the internal counters of the agent.
+func GetAgentStats() AgentStats {
+	return AgentStats{
+		ReporterHealth:           GetReporterHealth(),
+		RateCounts:               globalStats.rateCountTotals(),
+		TransactionNameOverflows: metrics.TransactionNameOverflows(),
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReporterStats(t *testing.T) {
+	s := &reporterStats{}
+	var h ReporterHealth
+	s.fill(&h)
+	assert.Equal(t, ReporterHealth{}, h)
+
+	s.sent(3)
+	s.sent(2)
+	s.failed(1)
+	s.overflowed(4)
+	s.fill(&h)
+	assert.EqualValues(t, 5, h.NumSent)
+	assert.EqualValues(t, 1, h.NumFailed)
+	assert.EqualValues(t, 4, h.NumOverflowed)
+	assert.WithinDuration(t, time.Now(), h.LastSent, time.Second)
+}
+
+func TestHealthOf(t *testing.T) {
+	r := &grpcReporter{
+		conn:          &grpcConnection{atomicActive: 1},
+		eventMessages: make(chan []byte, 4),
+		done:          make(chan struct{}),
+	}
+	r.eventMessages <- []byte("event")
+	globalSendFailures.succeed("test") // cleared as left by the other tests
+	h := healthOf(r)
+	assert.Equal(t, "ssl", h.Reporter)
+	assert.Equal(t, ConnectivityConnected, h.Connectivity)
+	assert.Equal(t, 1, h.QueueDepth)
+	assert.Equal(t, 4, h.QueueCapacity)
+
+	r.conn.setActive(false)
+	assert.Equal(t, ConnectivityDisconnected, healthOf(r).Connectivity)
+
+	close(r.done)
+	assert.Equal(t, ConnectivityClosed, healthOf(r).Connectivity)
+
+	h = healthOf(&nullReporter{})
+	assert.Equal(t, "none", h.Reporter)
+	assert.Equal(t, ConnectivityClosed, h.Connectivity)
+
+	h = healthOf(&udpReporter{})
+	assert.Equal(t, "udp", h.Reporter)
+	assert.Equal(t, ConnectivityUnknown, h.Connectivity)
+}
+
+func TestAddRateCounts(t *testing.T) {
+	s := &reporterStats{}
+	assert.Empty(t, s.rateCountTotals())
+
+	rc := &metrics.RateCounts{}
+	rc.RequestedInc()
+	rc.RequestedInc()
+	rc.SampledInc()
+	rc.TracedInc()
+	rcs := map[string]*metrics.RateCounts{
+		metrics.RCRegular:            rc,
+		metrics.RCStrictTriggerTrace: {},
+	}
+	s.addRateCounts(rcs)
+	s.addRateCounts(rcs)
+	totals := s.rateCountTotals()
+	assert.Equal(t, RateCountTotals{Requested: 4, Sampled: 2, Traced: 2}, totals[RateCountsRegular])
+	assert.Equal(t, RateCountTotals{}, totals[RateCountsStrictTrigger])
+	assert.NotContains(t, totals, RateCountsRelaxedTrigger)
+}
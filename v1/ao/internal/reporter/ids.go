@@ -0,0 +1,147 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"crypto/rand"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+)
+
+const (
+	// the length of the per-process prefix of the hardened task IDs
+	taskIDPrefixLen = 4
+	// the number of recent task IDs kept for collision detection
+	maxRecentTaskIDs = 8192
+	// the max number of attempts to generate a task ID without collision
+	maxTaskIDAttempts = 3
+)
+
+// IDGenerator generates the task IDs (trace IDs) and op IDs of the X-Trace
+// metadata. The implementation must be safe for concurrent use.
+type IDGenerator interface {
+	// TaskID fills b with a new task ID.
+	TaskID(b []byte) error
+	// OpID fills b with a new op ID.
+	OpID(b []byte) error
+}
+
+// readerIDGenerator reads the IDs from a random source. It reads from
+// randReader if r is nil.
+type readerIDGenerator struct {
+	r io.Reader
+}
+
+func (g readerIDGenerator) reader() io.Reader {
+	if g.r == nil {
+		return randReader
+	}
+	return g.r
+}
+
+func (g readerIDGenerator) TaskID(b []byte) error {
+	_, err := g.reader().Read(b)
+	return err
+}
+
+func (g readerIDGenerator) OpID(b []byte) error {
+	_, err := g.reader().Read(b)
+	return err
+}
+
+// hardenedIDGenerator reads the IDs from crypto/rand. The task IDs start
+// with a per-process random prefix and are checked against the recently
+// generated ones for collisions.
+type hardenedIDGenerator struct {
+	prefix [taskIDPrefixLen]byte
+	recent atomic.Value // *recentTaskIDs
+}
+
+// recentTaskIDs is a generation of the recent task IDs, which is replaced by
+// an empty one once it's full, so the traces don't contend on a lock.
+type recentTaskIDs struct {
+	ids sync.Map
+	n   int64 // accessed atomically
+}
+
+func newHardenedIDGenerator() (*hardenedIDGenerator, error) {
+	g := &hardenedIDGenerator{}
+	g.recent.Store(&recentTaskIDs{})
+	if _, err := rand.Read(g.prefix[:]); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func (g *hardenedIDGenerator) TaskID(b []byte) error {
+	if len(b) <= taskIDPrefixLen {
+		_, err := rand.Read(b)
+		return err
+	}
+	copy(b, g.prefix[:])
+
+	recent := g.recent.Load().(*recentTaskIDs)
+	for attempt := 0; ; attempt++ {
+		if _, err := rand.Read(b[taskIDPrefixLen:]); err != nil {
+			return err
+		}
+		if _, loaded := recent.ids.LoadOrStore(string(b), struct{}{}); !loaded || attempt+1 >= maxTaskIDAttempts {
+			break
+		}
+		atomic.AddUint64(&taskIDCollisions, 1)
+	}
+
+	// only one caller reaches the limit of a generation
+	if atomic.AddInt64(&recent.n, 1) == maxRecentTaskIDs {
+		g.recent.Store(&recentTaskIDs{})
+	}
+	return nil
+}
+
+func (g *hardenedIDGenerator) OpID(b []byte) error {
+	_, err := rand.Read(b)
+	return err
+}
+
+var (
+	idGenerator atomic.Value // IDGenerator
+
+	// the number of task ID collisions detected by the hardened generator
+	taskIDCollisions uint64
+)
+
+func init() {
+	if !config.GetHardenedTraceIDs() {
+		SetIDGenerator(nil)
+		return
+	}
+	g, err := newHardenedIDGenerator()
+	if err != nil {
+		log.Warningf("Failed to initialize the hardened trace ID generator: %v", err)
+		SetIDGenerator(nil)
+		return
+	}
+	SetIDGenerator(g)
+}
+
+// SetIDGenerator replaces the generator of the task IDs and op IDs. The
+// default generator is restored if g is nil.
+func SetIDGenerator(g IDGenerator) {
+	if g == nil {
+		g = readerIDGenerator{}
+	}
+	idGenerator.Store(&g)
+}
+
+func getIDGenerator() IDGenerator {
+	return *idGenerator.Load().(*IDGenerator)
+}
+
+// TaskIDCollisions returns the number of task ID collisions detected so far.
+func TaskIDCollisions() uint64 {
+	return atomic.LoadUint64(&taskIDCollisions)
+}
@@ -0,0 +1,69 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"bytes"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type seqIDGenerator struct{ n byte }
+
+func (g *seqIDGenerator) TaskID(b []byte) error {
+	g.n++
+	for i := range b {
+		b[i] = g.n
+	}
+	return nil
+}
+
+func (g *seqIDGenerator) OpID(b []byte) error {
+	g.n++
+	for i := range b {
+		b[i] = g.n
+	}
+	return nil
+}
+
+func TestSetIDGenerator(t *testing.T) {
+	defer SetIDGenerator(nil)
+
+	SetIDGenerator(&seqIDGenerator{})
+	md := &oboeMetadata{}
+	md.Init()
+	require.NoError(t, md.SetRandom())
+	assert.Equal(t, bytes.Repeat([]byte{1}, oboeMaxTaskIDLen), md.ids.taskID)
+	assert.Equal(t, bytes.Repeat([]byte{2}, oboeMaxOpIDLen), md.ids.opID)
+
+	SetIDGenerator(nil)
+	assert.IsType(t, readerIDGenerator{}, getIDGenerator())
+}
+
+func TestHardenedIDGenerator(t *testing.T) {
+	g, err := newHardenedIDGenerator()
+	require.NoError(t, err)
+
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		b := make([]byte, oboeMaxTaskIDLen)
+		require.NoError(t, g.TaskID(b))
+		assert.Equal(t, g.prefix[:], b[:taskIDPrefixLen])
+		assert.False(t, seen[string(b)])
+		seen[string(b)] = true
+	}
+	assert.EqualValues(t, 100, g.recent.Load().(*recentTaskIDs).n)
+
+	// a new generation once it's full
+	atomic.StoreInt64(&g.recent.Load().(*recentTaskIDs).n, maxRecentTaskIDs-1)
+	require.NoError(t, g.TaskID(make([]byte, oboeMaxTaskIDLen)))
+	assert.Zero(t, g.recent.Load().(*recentTaskIDs).n)
+
+	// the op IDs have no prefix
+	op := make([]byte, oboeMaxOpIDLen)
+	require.NoError(t, g.OpID(op))
+	assert.NotEqual(t, make([]byte, oboeMaxOpIDLen), op)
+}
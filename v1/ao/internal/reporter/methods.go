@@ -5,10 +5,13 @@ package reporter
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"time"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter/collector"
 	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 )
 
 // Method defines the interface of an RPC call
@@ -399,6 +402,9 @@ type PingMethod struct {
 	Resp       *collector.MessageResult
 	err        error
 	rtt        time.Duration
+	// the offset of the collector clock, valid only if synced is true
+	offset time.Duration
+	synced bool
 }
 
 func newPingMethod(key string, conn string) *PingMethod {
@@ -445,16 +451,41 @@ func (p *PingMethod) Call(ctx context.Context,
 	request := &collector.PingRequest{
 		ApiKey: p.serviceKey,
 	}
+	var header metadata.MD
 	start := time.Now()
-	p.Resp, p.err = c.Ping(ctx, request)
+	p.Resp, p.err = c.Ping(ctx, request, grpc.Header(&header))
 	p.rtt = time.Now().Sub(start)
+	if p.err == nil {
+		p.offset, p.synced = clockOffset(header, start, p.rtt)
+	}
 	return p.err
 }
 
+// clockOffset estimates how far the collector clock is ahead of the local
+// clock from the Date header of the response, if the collector (or the proxy
+// in front of it) sends one. The collector time is assumed to be taken in the
+// middle of the round trip. As the Date header is in seconds, the middle of that
+// second is used, which makes the estimate accurate to about half a second.
+func clockOffset(header metadata.MD, start time.Time, rtt time.Duration) (time.Duration, bool) {
+	dates := header.Get("date")
+	if len(dates) == 0 {
+		return 0, false
+	}
+	remote, err := http.ParseTime(dates[0])
+	if err != nil {
+		return 0, false
+	}
+	remote = remote.Add(500 * time.Millisecond)
+	return remote.Sub(start.Add(rtt / 2)), true
+}
+
 // CallSummary returns a string representation for the RPC call result. It is
 // mainly used for debug printing.
 func (p *PingMethod) CallSummary() string {
 	rsp := resultRespStr(p.Resp, p.err)
+	if p.synced {
+		return fmt.Sprintf("[%s] ping back, rtt=%v, clock offset=%v, rsp=%s", p, p.rtt, p.offset, rsp)
+	}
 	return fmt.Sprintf("[%s] ping back, rtt=%v, rsp=%s", p, p.rtt, rsp)
 }
 
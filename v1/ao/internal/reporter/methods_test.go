@@ -4,7 +4,9 @@ package reporter
 
 import (
 	"context"
+	"net/http"
 	"testing"
+	"time"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/host"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter/collector"
@@ -12,6 +14,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/metadata"
 )
 
 func TestPostEventsMethod(t *testing.T) {
@@ -118,7 +121,7 @@ func TestPingMethod(t *testing.T) {
 
 	result := &collector.MessageResult{}
 	mockTC := &mocks.TraceCollectorClient{}
-	mockTC.On("Ping", mock.Anything, mock.Anything).
+	mockTC.On("Ping", mock.Anything, mock.Anything, mock.Anything).
 		Return(result, nil)
 
 	err := pe.Call(context.Background(), mockTC)
@@ -129,6 +132,20 @@ func TestPingMethod(t *testing.T) {
 	assert.Equal(t, "", pe.Arg())
 }
 
+func TestClockOffset(t *testing.T) {
+	start := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	_, ok := clockOffset(nil, start, time.Millisecond)
+	assert.False(t, ok)
+	_, ok = clockOffset(metadata.Pairs("date", "yesterday"), start, time.Millisecond)
+	assert.False(t, ok)
+
+	// the collector clock is 3 seconds ahead
+	header := metadata.Pairs("date", start.Add(3*time.Second).Format(http.TimeFormat))
+	offset, ok := clockOffset(header, start, 200*time.Millisecond)
+	assert.True(t, ok)
+	assert.Equal(t, 3400*time.Millisecond, offset)
+}
+
 func TestGenericMethod(t *testing.T) {
 	// test CallSummary before making the RPC call
 	pe := newPingMethod("test-ket", "testConn")
@@ -137,7 +154,7 @@ func TestGenericMethod(t *testing.T) {
 	// test CallSummary when the RPC call fails
 	mockTC := &mocks.TraceCollectorClient{}
 	err := errors.New("err connection aborted")
-	mockTC.On("Ping", mock.Anything, mock.Anything).
+	mockTC.On("Ping", mock.Anything, mock.Anything, mock.Anything).
 		Return(nil, err)
 	pe.Call(context.Background(), mockTC)
 	assert.Contains(t, pe.CallSummary(), err.Error())
@@ -0,0 +1,178 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// The upper bound of the mirroring window, so a forgotten toggle won't keep
+// writing to the disk forever.
+const maxTraceMirrorWindow = time.Hour
+
+var (
+	errMirrorDirEmpty      = errors.New("the mirror directory is empty")
+	errMirrorInvalidSample = errors.New("the sample rate must be positive")
+	errMirrorInvalidWindow = errors.New("the mirror window must be positive")
+)
+
+// TraceMirrorStatus describes the state of the trace mirroring.
+type TraceMirrorStatus struct {
+	Enabled bool      `json:"enabled"`
+	Dir     string    `json:"dir,omitempty"`
+	Every   int       `json:"every,omitempty"`
+	Until   time.Time `json:"until,omitempty"`
+	Written int64     `json:"written"`
+}
+
+// the max number of the events queued to be written to the trace files, beyond
+// which the events are not mirrored.
+const traceMirrorQueueSize = 1024
+
+// traceMirror writes 1-in-N sampled traces to local disk, in addition to the
+// normal reporting, as decoded JSON events. It's for debugging the
+// instrumentation only. The events are checked without any lock, and written
+// by a single goroutine so the tracing is never blocked on the disk.
+type traceMirror struct {
+	sync.Mutex // serializes the toggles
+	active     int32
+	dir        atomic.Value // string
+	every      uint32
+	until      int64 // Unix nanoseconds
+	written    int64
+
+	queue      chan mirroredEvent
+	writerOnce sync.Once
+}
+
+// mirroredEvent is an event to be appended to the file of its trace.
+type mirroredEvent struct {
+	path string
+	buf  []byte
+}
+
+var mirror = &traceMirror{queue: make(chan mirroredEvent, traceMirrorQueueSize)}
+
+// StartTraceMirror starts writing one out of every `every` traces into the
+// directory dir, one file per trace, until the window elapses. A window longer
+// than one hour is truncated to one hour.
+func StartTraceMirror(dir string, every int, window time.Duration) error {
+	if dir == "" {
+		return errMirrorDirEmpty
+	}
+	if every <= 0 {
+		return errMirrorInvalidSample
+	}
+	if window <= 0 {
+		return errMirrorInvalidWindow
+	}
+	if window > maxTraceMirrorWindow {
+		window = maxTraceMirrorWindow
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrap(err, "failed to create the mirror directory")
+	}
+	mirror.writerOnce.Do(func() { go mirror.writer() })
+
+	mirror.Lock()
+	defer mirror.Unlock()
+	until := time.Now().Add(window)
+	mirror.dir.Store(dir)
+	atomic.StoreUint32(&mirror.every, uint32(every))
+	atomic.StoreInt64(&mirror.until, until.UnixNano())
+	atomic.StoreInt64(&mirror.written, 0)
+	atomic.StoreInt32(&mirror.active, 1)
+	log.Warningf("Trace mirroring is enabled: 1 in %d traces to %s until %v",
+		every, dir, until)
+	return nil
+}
+
+// StopTraceMirror stops the trace mirroring.
+func StopTraceMirror() {
+	mirror.Lock()
+	defer mirror.Unlock()
+	if atomic.SwapInt32(&mirror.active, 0) == 1 {
+		log.Warningf("Trace mirroring is disabled, %d events written",
+			atomic.LoadInt64(&mirror.written))
+	}
+}
+
+// GetTraceMirrorStatus returns the current state of the trace mirroring.
+func GetTraceMirrorStatus() TraceMirrorStatus {
+	written := atomic.LoadInt64(&mirror.written)
+	if !mirror.enabled() {
+		return TraceMirrorStatus{Written: written}
+	}
+	dir, _ := mirror.dir.Load().(string)
+	return TraceMirrorStatus{
+		Enabled: true,
+		Dir:     dir,
+		Every:   int(atomic.LoadUint32(&mirror.every)),
+		Until:   time.Unix(0, atomic.LoadInt64(&mirror.until)),
+		Written: written,
+	}
+}
+
+func (m *traceMirror) enabled() bool {
+	return atomic.LoadInt32(&m.active) == 1 && time.Now().UnixNano() < atomic.LoadInt64(&m.until)
+}
+
+// mirrorEvent queues the event to be written to the trace file if its trace is
+// picked. The decision is made by the task ID so all the events of a trace go
+// together. The event is dropped if the queue is full.
+func mirrorEvent(md *oboeMetadata, buf []byte) {
+	if !mirror.enabled() || md.taskLen < 4 {
+		return
+	}
+	taskID := md.ids.taskID[:md.taskLen]
+	if binary.BigEndian.Uint32(taskID[md.taskLen-4:])%atomic.LoadUint32(&mirror.every) != 0 {
+		return
+	}
+	dir, _ := mirror.dir.Load().(string)
+	e := mirroredEvent{
+		path: filepath.Join(dir, hex.EncodeToString(taskID)+".json"),
+		buf:  append([]byte(nil), buf...),
+	}
+	select {
+	case mirror.queue <- e:
+	default:
+		log.Debug("The trace mirror queue is full, event dropped.")
+	}
+}
+
+// writer appends the queued events to their trace files.
+func (m *traceMirror) writer() {
+	for e := range m.queue {
+		doc := bson.M{}
+		if err := bson.Unmarshal(e.buf, doc); err != nil {
+			log.Debugf("Failed to decode the event for mirroring: %v", err)
+			continue
+		}
+		line, err := json.Marshal(doc)
+		if err != nil {
+			log.Debugf("Failed to encode the event for mirroring: %v", err)
+			continue
+		}
+
+		f, err := os.OpenFile(e.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Debugf("Failed to open the mirror file: %v", err)
+			continue
+		}
+		if _, err = f.Write(append(line, '\n')); err == nil {
+			atomic.AddInt64(&m.written, 1)
+		}
+		f.Close()
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTraceMirror(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ao-mirror")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.Equal(t, errMirrorDirEmpty, StartTraceMirror("", 1, time.Minute))
+	assert.Equal(t, errMirrorInvalidSample, StartTraceMirror(dir, 0, time.Minute))
+	assert.Equal(t, errMirrorInvalidWindow, StartTraceMirror(dir, 1, 0))
+
+	r := SetTestReporter()
+	require.NoError(t, StartTraceMirror(dir, 1, 2*time.Hour))
+	st := GetTraceMirrorStatus()
+	assert.True(t, st.Enabled)
+	assert.True(t, st.Until.Before(time.Now().Add(maxTraceMirrorWindow+time.Second)))
+
+	ctx := newTestContext(t)
+	assert.NoError(t, ctx.ReportEvent(LabelEntry, "myLayer"))
+	assert.NoError(t, ctx.ReportEvent(LabelExit, "myLayer", "testK", "testV"))
+	r.Close(2)
+	StopTraceMirror()
+	assert.False(t, GetTraceMirrorStatus().Enabled)
+	// the events are written in the background
+	assert.Eventually(t, func() bool { return GetTraceMirrorStatus().Written == 2 },
+		time.Second, 10*time.Millisecond)
+
+	taskID := ctx.metadata.ids.taskID[:ctx.metadata.taskLen]
+	f, err := os.Open(filepath.Join(dir, hex.EncodeToString(taskID)+".json"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	var labels []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		m := make(map[string]interface{})
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &m))
+		assert.Equal(t, "myLayer", m["Layer"])
+		labels = append(labels, m["Label"].(string))
+	}
+	assert.Equal(t, []string{"entry", "exit"}, labels)
+}
@@ -34,6 +34,7 @@ func FlushRateCounts() map[string]*metrics.RateCounts {
 	rcs[metrics.RCRegular] = setting.bucket.FlushRateCounts()
 	rcs[metrics.RCRelaxedTriggerTrace] = setting.triggerTraceRelaxedBucket.FlushRateCounts()
 	rcs[metrics.RCStrictTriggerTrace] = setting.triggerTraceStrictBucket.FlushRateCounts()
+	globalStats.addRateCounts(rcs)
 
 	return rcs
 }
@@ -44,6 +45,10 @@ type oboeSettings struct {
 	flags settingFlag
 	// the original flags retrieved from the remote collector.
 	originalFlags settingFlag
+	// the original sample rate and source, to merge the local config again
+	// after it's reloaded.
+	originalValue  int
+	originalSource sampleSource
 	// The sample rate. It could be the original value got from remote server
 	// or a new value after negotiating with local config
 	value int
@@ -89,6 +94,12 @@ func (b *tokenBucket) reset() {
 	b.last = time.Time{}
 }
 
+func (b *tokenBucket) rateCap() (rate, cap float64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.ratePerSec, b.capacity
+}
+
 func (b *tokenBucket) setRateCap(rate, cap float64) {
 	b.lock.Lock()
 	defer b.lock.Unlock()
@@ -160,6 +171,32 @@ func sendInitMessage() {
 	}
 }
 
+// ReportAnnotation sends a standalone event, e.g., a deploy marker, on the
+// status channel. It doesn't belong to any trace and is not subject to the
+// sampling decisions.
+func ReportAnnotation(title string, kvs KVMap) error {
+	if Closed() {
+		return ErrReporterIsClosed
+	}
+	c, ok := newContext(true).(*oboeContext)
+	if !ok {
+		return nil
+	}
+	e, err := c.newEvent("single", "annotation")
+	if err != nil {
+		return err
+	}
+
+	_ = e.AddKV("__Annotation", 1)
+	_ = e.AddKV("Title", title)
+	for k, v := range kvs {
+		if err := e.AddKV(k, v); err != nil {
+			return err
+		}
+	}
+	return e.ReportStatus(c)
+}
+
 func (b *tokenBucket) count(sampled, hasMetadata, rateLimit bool) bool {
 	b.RequestedInc()
 
@@ -278,7 +315,7 @@ func (tm TriggerTraceMode) Requested() bool {
 	}
 }
 
-func oboeSampleRequest(layer string, traced bool, url string, triggerTrace TriggerTraceMode) SampleDecision {
+func oboeSampleRequest(layer string, traced bool, method, url string, triggerTrace TriggerTraceMode) SampleDecision {
 	if usingTestReporter {
 		if r, ok := globalReporter.(*TestReporter); ok {
 			if !r.UseSettings {
@@ -296,7 +333,20 @@ func oboeSampleRequest(layer string, traced bool, url string, triggerTrace Trigg
 	retval := false
 	doRateLimiting := false
 
-	sampleRate, flags, source := mergeURLSetting(setting, url)
+	sampleRate, flags, source := mergeURLSetting(setting, method, url)
+
+	// metrics-only if it's configured so, or if the agent overhead is over
+	// the budget
+	if metricsOnly := config.GetTracingMode() == config.MetricsOnlyTracingMode; metricsOnly || !overheadAllowsSampling() {
+		rsp := ttNotRequested
+		if triggerTrace.Requested() {
+			rsp = ttRateExceeded
+			if metricsOnly {
+				rsp = ttTracingDisabled
+			}
+		}
+		return SampleDecision{false, sampleRate, source, flags.Enabled(), rsp, 0, 0}
+	}
 
 	// Choose an appropriate bucket
 	bucket := setting.bucket
@@ -449,12 +499,12 @@ func mergeLocalSetting(remote *oboeSettings) *oboeSettings {
 
 // mergeURLSetting merges the service level setting (merged from remote and local
 // settings) and the per-URL sampling flags, if any.
-func mergeURLSetting(setting *oboeSettings, url string) (int, settingFlag, sampleSource) {
+func mergeURLSetting(setting *oboeSettings, method, url string) (int, settingFlag, sampleSource) {
 	if url == "" {
 		return setting.value, setting.flags, setting.source
 	}
 
-	urlTracingMode := urls.getTracingMode(url)
+	urlTracingMode := urls.getTracingMode(method, url)
 	if urlTracingMode.isUnknown() {
 		return setting.value, setting.flags, setting.source
 	}
@@ -490,6 +540,7 @@ func updateSetting(sType int32, layer string, flags []byte, value int64, ttl int
 	ns.flags = flagStringToBin(string(flags))
 	ns.originalFlags = ns.flags
 	ns.value = adjustSampleRate(value)
+	ns.originalValue, ns.originalSource = ns.value, ns.source
 	ns.ttl = ttl
 	ns.layer = layer
 
@@ -519,6 +570,20 @@ func updateSetting(sType int32, layer string, flags []byte, value int64, ttl int
 	globalSettingsCfg.lock.Unlock()
 }
 
+// mergeLocalSettings merges the local config again into the settings retrieved
+// from the collector, after the config is reloaded. The settings are replaced
+// rather than modified as they are read without the lock.
+func (sc *oboeSettingsCfg) mergeLocalSettings() {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+
+	for k, s := range sc.settings {
+		ns := *s
+		ns.flags, ns.value, ns.source = ns.originalFlags, ns.originalValue, ns.originalSource
+		sc.settings[k] = mergeLocalSetting(&ns)
+	}
+}
+
 // Used for tests only
 func resetSettings() {
 	FlushRateCounts()
@@ -56,6 +56,24 @@ func TestInitMessageUDP(t *testing.T) {
 	assertInitMessage(t, bufs)
 }
 
+func TestReportAnnotation(t *testing.T) {
+	r := SetTestReporter()
+
+	assert.NoError(t, ReportAnnotation("Deploy v1.2.3", KVMap{
+		"Revision": "4a287b7",
+		"Canary":   true,
+	}))
+	r.Close(1)
+	g.AssertGraph(t, r.EventBufs, 1, g.AssertNodeMap{
+		{"annotation", "single"}: {Edges: g.Edges{}, Callback: func(n g.Node) {
+			assert.Equal(t, 1, n.Map["__Annotation"])
+			assert.Equal(t, "Deploy v1.2.3", n.Map["Title"])
+			assert.Equal(t, "4a287b7", n.Map["Revision"])
+			assert.Equal(t, true, n.Map["Canary"])
+		}},
+	})
+}
+
 func TestTokenBucket(t *testing.T) {
 	b := newTokenBucket(5, 2)
 	c := b
@@ -268,8 +286,11 @@ func TestSampleFlags(t *testing.T) {
 		{Type: "url", RegEx: `user\d{3}`, Tracing: config.DisabledTracingMode},
 		{Type: "url", Extensions: []string{".png", ".jpg"}, Tracing: config.DisabledTracingMode},
 	})
-	decision := shouldTraceRequestWithURL(testLayer, false, "http://test.com/user123", ModeTriggerTraceNotPresent)
+	traced := c.Traced()
+	decision := shouldTraceRequestWithURL(testLayer, false, "", "http://test.com/user123", ModeTriggerTraceNotPresent)
 	assert.False(t, decision.trace)
+	// the filtered requests don't take tokens from the bucket
+	assert.Equal(t, traced, c.Traced())
 
 	resetSettings()
 
@@ -459,6 +480,11 @@ func TestCheckSettingsTimeout(t *testing.T) {
 }
 
 func TestMergeRemoteSettingWithLocalConfig(t *testing.T) {
+	defer func() {
+		_ = os.Unsetenv("APPOPTICS_SAMPLE_RATE")
+		_ = os.Unsetenv("APPOPTICS_TRACING_MODE")
+		_ = config.Load()
+	}()
 	// No remote setting
 	resetSettings()
 	trace, rate, source, _ := shouldTraceRequest(testLayer, false)
@@ -541,6 +567,32 @@ func TestMergeRemoteSettingWithLocalConfig(t *testing.T) {
 	assert.Equal(t, 10000, rate)
 }
 
+func TestSampleMetricsOnly(t *testing.T) {
+	_ = os.Setenv("APPOPTICS_TRACING_MODE", "metrics-only")
+	_ = config.Load()
+	defer func() {
+		_ = os.Unsetenv("APPOPTICS_TRACING_MODE")
+		_ = config.Load()
+	}()
+	r := SetTestReporter()
+	defer r.Close(0)
+
+	// not sampled, but enabled for the metrics
+	decision := shouldTraceRequestWithURL(testLayer, false, "", "", ModeTriggerTraceNotPresent)
+	assert.False(t, decision.trace)
+	assert.True(t, decision.enabled)
+	decision = shouldTraceRequestWithURL(testLayer, true, "", "", ModeTriggerTraceNotPresent)
+	assert.False(t, decision.trace)
+	decision = shouldTraceRequestWithURL(testLayer, false, "", "", ModeRelaxedTriggerTrace)
+	assert.False(t, decision.trace)
+	assert.Equal(t, ttTracingDisabled, decision.xTraceOptsRsp)
+
+	s, ok := CurrentSettings()
+	require.True(t, ok)
+	assert.Equal(t, "metrics-only", s.TracingMode)
+	assert.True(t, s.MetricsOnly)
+}
+
 func TestAdjustSampleRate(t *testing.T) {
 	assert.Equal(t, maxSamplingRate, adjustSampleRate(maxSamplingRate+1))
 	assert.Equal(t, 0, adjustSampleRate(-1))
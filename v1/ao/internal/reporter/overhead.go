@@ -0,0 +1,135 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+)
+
+const (
+	// the interval over which the overhead is measured
+	overheadWindow = 10 * time.Second
+	// the number of windows the breaker stays open before tracing is retried
+	overheadCooldownWindows = 3
+)
+
+// overheadBreaker is a circuit breaker on the time spent by the agent in
+// reporting events. It opens when the overhead of a window exceeds the budget,
+// and the agent degrades to metrics-only: no requests are sampled but the
+// metrics are still collected. As the overhead can't be measured without
+// tracing, the breaker closes after a cooldown to probe if the load has
+// subsided, and opens again if the overhead is still over the budget.
+type overheadBreaker struct {
+	spent       int64 // nanoseconds spent in the current window, accessed atomically
+	windowStart int64 // unix nanoseconds, accessed atomically
+	open        int32 // accessed atomically
+	// set if there is a budget, as of the last sampling decision, so the time
+	// is not measured without one. Accessed atomically.
+	enabled int32
+	trips   uint64
+
+	mu        sync.Mutex
+	openUntil time.Time
+
+	// for testing
+	now      func() time.Time
+	capacity func() int
+}
+
+func newOverheadBreaker() *overheadBreaker {
+	b := &overheadBreaker{
+		now:      time.Now,
+		capacity: func() int { return runtime.GOMAXPROCS(0) },
+	}
+	b.windowStart = b.now().UnixNano()
+	return b
+}
+
+var overhead = newOverheadBreaker()
+
+// start returns the time to be passed to record, or the zero time if there is
+// no budget, in which case nothing is recorded.
+func (b *overheadBreaker) start() time.Time {
+	if atomic.LoadInt32(&b.enabled) == 0 {
+		return time.Time{}
+	}
+	return b.now()
+}
+
+// record adds the time spent since start to the overhead.
+func (b *overheadBreaker) record(start time.Time) {
+	if start.IsZero() {
+		return
+	}
+	atomic.AddInt64(&b.spent, int64(b.now().Sub(start)))
+}
+
+// allow returns false if the breaker is open and the request should not be
+// sampled.
+func (b *overheadBreaker) allow(budget float64) bool {
+	if budget <= 0 {
+		if atomic.LoadInt32(&b.enabled) == 1 {
+			atomic.StoreInt32(&b.enabled, 0)
+		}
+		// the budget may have been removed while the breaker is open
+		if atomic.LoadInt32(&b.open) == 1 {
+			atomic.StoreInt32(&b.open, 0)
+		}
+		return true
+	}
+	if atomic.LoadInt32(&b.enabled) == 0 {
+		atomic.StoreInt32(&b.enabled, 1)
+	}
+	now := b.now()
+	if now.UnixNano()-atomic.LoadInt64(&b.windowStart) >= int64(overheadWindow) {
+		b.evaluate(now, budget)
+	}
+	return atomic.LoadInt32(&b.open) == 0
+}
+
+// evaluate computes the overhead of the window ending now and opens or closes
+// the breaker accordingly.
+func (b *overheadBreaker) evaluate(now time.Time, budget float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	start := atomic.LoadInt64(&b.windowStart)
+	elapsed := now.UnixNano() - start
+	if elapsed < int64(overheadWindow) {
+		return // evaluated by another goroutine
+	}
+	spent := atomic.SwapInt64(&b.spent, 0)
+	atomic.StoreInt64(&b.windowStart, now.UnixNano())
+
+	pct := float64(spent) / float64(elapsed*int64(b.capacity())) * 100
+	if atomic.LoadInt32(&b.open) == 0 {
+		if pct > budget {
+			atomic.StoreInt32(&b.open, 1)
+			b.trips++
+			b.openUntil = now.Add(overheadCooldownWindows * overheadWindow)
+			log.Warningf("The agent overhead (%.2f%%) exceeds the budget (%.2f%%), "+
+				"tracing is paused and only metrics are collected.", pct, budget)
+		}
+	} else if !now.Before(b.openUntil) {
+		atomic.StoreInt32(&b.open, 0)
+		log.Info("Tracing is resumed after being paused for the agent overhead.")
+	}
+}
+
+// overheadAllowsSampling returns false if the agent is in metrics-only mode
+// because of the overhead.
+func overheadAllowsSampling() bool {
+	return overhead.allow(config.GetOverheadBudget())
+}
+
+// overheadBreakerOpen returns if tracing is paused because the agent overhead
+// exceeds the budget.
+func overheadBreakerOpen() bool {
+	return atomic.LoadInt32(&overhead.open) == 1
+}
@@ -0,0 +1,49 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOverheadBreaker(t *testing.T) {
+	now := time.Unix(1000, 0)
+	b := newOverheadBreaker()
+	b.now = func() time.Time { return now }
+	b.capacity = func() int { return 2 }
+	b.windowStart = now.UnixNano()
+
+	// 400ms of 2 CPUs in 10s is 2%
+	b.record(now.Add(-400 * time.Millisecond))
+	now = now.Add(overheadWindow)
+	assert.True(t, b.allow(3))
+
+	// 800ms is 4%
+	b.record(now.Add(-800 * time.Millisecond))
+	assert.True(t, b.allow(3), "evaluated at the end of the window only")
+	now = now.Add(overheadWindow)
+	assert.False(t, b.allow(3))
+	assert.EqualValues(t, 1, b.trips)
+
+	// stays open during the cooldown
+	now = now.Add(overheadWindow)
+	assert.False(t, b.allow(3))
+	now = now.Add((overheadCooldownWindows - 1) * overheadWindow)
+	assert.True(t, b.allow(3))
+
+	// no limit without a budget
+	b.record(now.Add(-5 * time.Second))
+	now = now.Add(overheadWindow)
+	assert.False(t, b.allow(3))
+	assert.True(t, b.allow(0))
+
+	// nothing is measured without a budget
+	assert.True(t, b.start().IsZero())
+	b.record(b.start())
+	assert.Zero(t, b.spent)
+	assert.True(t, b.allow(3))
+	assert.Equal(t, now, b.start())
+}
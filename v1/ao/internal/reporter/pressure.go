@@ -0,0 +1,73 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+// PressureLevel indicates how saturated the event queue of the reporter is.
+type PressureLevel int
+
+// The pressure levels, in the order of increasing saturation.
+const (
+	// PressureNormal means the events are reported as usual.
+	PressureNormal PressureLevel = iota
+	// PressureElevated means the event queue is filling up. Optional events
+	// may be skipped to ease the pressure.
+	PressureElevated
+	// PressureHigh means the event queue is nearly full and the events are
+	// about to be dropped.
+	PressureHigh
+)
+
+// the queue usage thresholds of the pressure levels
+const (
+	pressureElevatedThreshold = 0.5
+	pressureHighThreshold     = 0.9
+)
+
+// String returns the name of the pressure level.
+func (l PressureLevel) String() string {
+	switch l {
+	case PressureNormal:
+		return "normal"
+	case PressureElevated:
+		return "elevated"
+	case PressureHigh:
+		return "high"
+	default:
+		return "unknown"
+	}
+}
+
+// eventQueuer is implemented by the reporters with an event queue.
+type eventQueuer interface {
+	// eventQueueUsage returns the ratio of the queued events to the queue
+	// capacity, between 0 and 1.
+	eventQueueUsage() float64
+}
+
+func (r *grpcReporter) eventQueueUsage() float64 {
+	if cap(r.eventMessages) == 0 {
+		return 0
+	}
+	return float64(len(r.eventMessages)) / float64(cap(r.eventMessages))
+}
+
+// GetPressureLevel returns the pressure level of the event queue. It's always
+// PressureNormal for the reporters without a queue.
+func GetPressureLevel() PressureLevel {
+	return pressureLevelOf(globalReporter)
+}
+
+func pressureLevelOf(r reporter) PressureLevel {
+	q, ok := r.(eventQueuer)
+	if !ok {
+		return PressureNormal
+	}
+	switch usage := q.eventQueueUsage(); {
+	case usage >= pressureHighThreshold:
+		return PressureHigh
+	case usage >= pressureElevatedThreshold:
+		return PressureElevated
+	default:
+		return PressureNormal
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPressureLevel(t *testing.T) {
+	assert.Equal(t, PressureNormal, pressureLevelOf(&nullReporter{}))
+
+	r := &grpcReporter{eventMessages: make(chan []byte, 10)}
+	assert.Equal(t, PressureNormal, pressureLevelOf(r))
+
+	for i := 0; i < 5; i++ {
+		r.eventMessages <- nil
+	}
+	assert.Equal(t, PressureElevated, pressureLevelOf(r))
+
+	for i := 0; i < 4; i++ {
+		r.eventMessages <- nil
+	}
+	assert.Equal(t, PressureHigh, pressureLevelOf(r))
+	assert.Equal(t, "high", PressureHigh.String())
+}
+
+func TestOptionalEventsDroppedFirst(t *testing.T) {
+	r := &grpcReporter{
+		eventMessages: make(chan []byte, 10),
+		conn:          &grpcConnection{queueStats: &metrics.EventQueueStats{}},
+		done:          make(chan struct{}),
+	}
+	ctx := newTestContext(t)
+	report := func(label Label) error {
+		e, err := ctx.newEvent(label, testLayer)
+		assert.NoError(t, err)
+		return r.reportEvent(ctx, e)
+	}
+
+	for i := 0; i < 8; i++ {
+		assert.NoError(t, report(LabelInfo))
+	}
+	// the rest of the queue is reserved for the span events
+	assert.Error(t, report(LabelInfo))
+	assert.NoError(t, report(LabelEntry))
+	assert.NoError(t, report(LabelError))
+	assert.Error(t, report(LabelExit))
+	assert.Len(t, r.eventMessages, 10)
+}
@@ -0,0 +1,252 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/metrics"
+	"github.com/pkg/errors"
+	mbson "gopkg.in/mgo.v2/bson"
+)
+
+// unixAddrPrefix marks the address of a Unix datagram socket, e.g.,
+// unix:/var/run/appoptics.sock, in the UDP collector addresses.
+const unixAddrPrefix = "unix:"
+
+// the max size of a message received by the relay, which is larger than a UDP
+// datagram can be.
+const maxRelayMessageSize = 128 * 1024
+
+// the permission of the Unix sockets of the relay, which allows only the owner
+// and the group to send messages
+const relaySocketMode = 0660
+
+var errRelayNoGRPCReporter = errors.New("the relay requires the ssl reporter")
+
+// RelayStats is the number of the messages received by the relay, and of those
+// dropped as they are malformed or the queues are full.
+type RelayStats struct {
+	Received int64
+	Dropped  int64
+}
+
+// Relay receives the events, status messages and span messages sent by the UDP
+// reporters of the local processes (APPOPTICS_REPORTER=udp), and forwards them
+// to the collector through the gRPC reporter of its own process. The processes
+// share a single authenticated connection, and the metrics of their spans are
+// aggregated by the relay, which suits the sidecars, the daemonsets and the
+// short-lived processes.
+//
+// The messages are not authenticated: anyone who can send to the relay can
+// report under its service key. The Unix sockets are restricted to the owner
+// and the group of the relay by their permission, and the UDP messages are
+// accepted only from the loopback addresses and the allowed networks, so the
+// relay should listen on a non-loopback address only in a trusted network.
+type Relay struct {
+	r       *grpcReporter
+	conns   []net.PacketConn
+	allowed []*net.IPNet
+	wg      sync.WaitGroup
+	closed  int32
+
+	received int64
+	dropped  int64
+}
+
+// ListenRelay listens on each of the addresses, which is either a UDP address,
+// e.g., 127.0.0.1:7831, or a Unix datagram socket, e.g., unix:/tmp/ao.sock. The
+// UDP messages from outside of the host are dropped unless the sender is in one
+// of the allowed networks. It requires the gRPC reporter
+// (APPOPTICS_REPORTER=ssl) to forward the messages.
+func ListenRelay(addrs []string, allowed []*net.IPNet) (*Relay, error) {
+	r, ok := globalReporter.(*grpcReporter)
+	if !ok {
+		return nil, errRelayNoGRPCReporter
+	}
+	return listenRelay(r, addrs, allowed)
+}
+
+func listenRelay(r *grpcReporter, addrs []string, allowed []*net.IPNet) (*Relay, error) {
+	rl := &Relay{r: r, allowed: allowed}
+	for _, addr := range addrs {
+		var conn net.PacketConn
+		var err error
+		if path := strings.TrimPrefix(addr, unixAddrPrefix); path != addr {
+			conn, err = listenUnixgram(path)
+		} else {
+			conn, err = net.ListenPacket("udp", addr)
+		}
+		if err != nil {
+			rl.Close()
+			return nil, errors.Wrap(err, addr)
+		}
+		rl.conns = append(rl.conns, conn)
+	}
+	return rl, nil
+}
+
+// listenUnixgram listens on the Unix datagram socket of the path, replacing the
+// socket left by a previous run. Any other file at the path is kept.
+func listenUnixgram(path string) (net.PacketConn, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, errors.New("the path exists and is not a socket")
+		}
+		os.Remove(path)
+	}
+	conn, err := net.ListenPacket("unixgram", path)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(path, relaySocketMode); err != nil {
+		conn.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Addrs returns the addresses the relay listens on.
+func (rl *Relay) Addrs() []net.Addr {
+	var addrs []net.Addr
+	for _, conn := range rl.conns {
+		addrs = append(addrs, conn.LocalAddr())
+	}
+	return addrs
+}
+
+// Serve receives and forwards the messages until the relay is closed.
+func (rl *Relay) Serve() {
+	for _, conn := range rl.conns {
+		rl.wg.Add(1)
+		go rl.receive(conn)
+	}
+	rl.wg.Wait()
+}
+
+// Close stops receiving messages. The messages received already are sent by
+// the reporter, which should be shut down afterwards to flush them.
+func (rl *Relay) Close() error {
+	if !atomic.CompareAndSwapInt32(&rl.closed, 0, 1) {
+		return nil
+	}
+	for _, conn := range rl.conns {
+		conn.Close()
+		if addr, ok := conn.LocalAddr().(*net.UnixAddr); ok {
+			os.Remove(addr.Name)
+		}
+	}
+	return nil
+}
+
+// Stats returns the number of the messages received and dropped.
+func (rl *Relay) Stats() RelayStats {
+	return RelayStats{
+		Received: atomic.LoadInt64(&rl.received),
+		Dropped:  atomic.LoadInt64(&rl.dropped),
+	}
+}
+
+func (rl *Relay) receive(conn net.PacketConn) {
+	defer rl.wg.Done()
+	// reused for each message, which is copied if it's queued
+	buf := make([]byte, maxRelayMessageSize)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if atomic.LoadInt32(&rl.closed) == 0 {
+				log.Warningf("Relay stopped receiving on %s: %v", conn.LocalAddr(), err)
+			}
+			return
+		}
+		atomic.AddInt64(&rl.received, 1)
+		if !rl.allowedSender(from) {
+			atomic.AddInt64(&rl.dropped, 1)
+			log.Debugf("Relay dropped a message from %s", from)
+			continue
+		}
+		if err := rl.forward(buf[:n]); err != nil {
+			atomic.AddInt64(&rl.dropped, 1)
+			log.Debugf("Relay dropped a message: %v", err)
+		}
+	}
+}
+
+// allowedSender returns whether the messages from the address are accepted,
+// i.e., it's a Unix socket, a loopback address or in one of the allowed
+// networks.
+func (rl *Relay) allowedSender(addr net.Addr) bool {
+	udp, ok := addr.(*net.UDPAddr)
+	if !ok || udp.IP.IsLoopback() {
+		return true
+	}
+	for _, n := range rl.allowed {
+		if n.Contains(udp.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// forward puts the message on the queue of the reporter by its kind, which is
+// told by the keys only the messages of that kind have. buf is copied if it's
+// queued, so it can be reused by the caller.
+func (rl *Relay) forward(buf []byte) error {
+	if rl.r.Closed() {
+		return ErrReporterIsClosed
+	}
+	var m mbson.M
+	if err := mbson.Unmarshal(buf, &m); err != nil {
+		return errors.Wrap(err, "malformed message")
+	}
+
+	switch {
+	case m["__Init"] != nil || m["__Annotation"] != nil:
+		select {
+		case rl.r.statusMessages <- copyBytes(buf):
+			return nil
+		default:
+			return errors.New("status message queue is full")
+		}
+	case m["X-Trace"] != nil:
+		select {
+		case rl.r.eventMessages <- copyBytes(buf):
+			rl.r.conn.queueStats.TotalEventsAdd(int64(1))
+			return nil
+		default:
+			rl.r.conn.queueStats.NumOverflowedAdd(int64(1))
+			globalStats.overflowed(1)
+			return errors.New("event message queue is full")
+		}
+	case m["transaction"] != nil:
+		return rl.r.reportSpan(spanFromRelay(m))
+	}
+	return errors.New("unknown message")
+}
+
+func copyBytes(b []byte) []byte {
+	return append([]byte(nil), b...)
+}
+
+// spanFromRelay decodes the span message sent by the UDP reporter.
+func spanFromRelay(m mbson.M) *metrics.HTTPSpanMessage {
+	s := &metrics.HTTPSpanMessage{}
+	s.Transaction, _ = m["transaction"].(string)
+	s.Path, _ = m["url"].(string)
+	s.Method, _ = m["method"].(string)
+	s.HasError, _ = m["hasError"].(bool)
+	if status, ok := m["status"].(int); ok {
+		s.Status = status
+	}
+	if d, ok := m["duration"].(int64); ok {
+		s.Duration = time.Duration(d)
+	}
+	return s
+}
@@ -0,0 +1,127 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	mbson "gopkg.in/mgo.v2/bson"
+)
+
+func TestRelay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aorelay")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "ao.sock")
+
+	r := &grpcReporter{
+		conn:           &grpcConnection{queueStats: &metrics.EventQueueStats{}},
+		eventMessages:  make(chan []byte, 1),
+		spanMessages:   make(chan metrics.SpanMessage, 1),
+		statusMessages: make(chan []byte, 1),
+		done:           make(chan struct{}),
+	}
+	relay, err := listenRelay(r, []string{"127.0.0.1:0", unixAddrPrefix + sock}, nil)
+	require.NoError(t, err)
+	fi, err := os.Stat(sock)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(relaySocketMode), fi.Mode().Perm())
+	served := make(chan struct{})
+	go func() {
+		relay.Serve()
+		close(served)
+	}()
+
+	conns, err := dialUDP([]string{relay.Addrs()[0].String()})
+	require.NoError(t, err)
+	udp := &udpReporter{conns: conns}
+	conns, err = dialUDP([]string{unixAddrPrefix + sock})
+	require.NoError(t, err)
+	unix := &udpReporter{conns: conns}
+
+	// an event over UDP
+	ctx := newTestContext(t)
+	e, err := ctx.newEvent(LabelEntry, "relay")
+	require.NoError(t, err)
+	require.NoError(t, udp.reportEvent(ctx, e))
+	evt := make(mbson.M)
+	require.NoError(t, mbson.Unmarshal(<-r.eventMessages, evt))
+	assert.Equal(t, "relay", evt["Layer"])
+
+	// a status message and a span over the Unix socket
+	e, err = ctx.newEvent("single", "annotation")
+	require.NoError(t, err)
+	e.AddKV("__Annotation", 1)
+	require.NoError(t, unix.reportStatus(ctx, e))
+	status := make(mbson.M)
+	require.NoError(t, mbson.Unmarshal(<-r.statusMessages, status))
+	assert.Equal(t, 1, status["__Annotation"])
+
+	require.NoError(t, unix.reportSpan(&metrics.HTTPSpanMessage{
+		BaseSpanMessage: metrics.BaseSpanMessage{Duration: time.Second, HasError: true},
+		Transaction:     "checkout",
+		Path:            "/checkout",
+		Status:          503,
+		Method:          "POST",
+	}))
+	assert.Equal(t, &metrics.HTTPSpanMessage{
+		BaseSpanMessage: metrics.BaseSpanMessage{Duration: time.Second, HasError: true},
+		Transaction:     "checkout",
+		Path:            "/checkout",
+		Status:          503,
+		Method:          "POST",
+	}, <-r.spanMessages)
+
+	// the malformed messages are dropped
+	_, err = unix.conns[0].Write([]byte("not bson"))
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return relay.Stats().Dropped == 1 }, time.Second, 10*time.Millisecond)
+	assert.EqualValues(t, 4, relay.Stats().Received)
+
+	udp.ShutdownNow()
+	unix.ShutdownNow()
+	assert.NoError(t, relay.Close())
+	<-served
+	_, err = os.Stat(sock)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestListenRelay(t *testing.T) {
+	r := SetTestReporter()
+	defer r.Close(0)
+	_, err := ListenRelay([]string{"127.0.0.1:0"}, nil)
+	assert.Equal(t, errRelayNoGRPCReporter, err)
+
+	_, err = listenRelay(&grpcReporter{}, []string{"127.0.0.1:0", "invalid"}, nil)
+	assert.Error(t, err)
+
+	// a file which is not a socket is not replaced
+	f, err := ioutil.TempFile("", "aorelay")
+	require.NoError(t, err)
+	f.Close()
+	defer os.Remove(f.Name())
+	_, err = listenRelay(&grpcReporter{}, []string{unixAddrPrefix + f.Name()}, nil)
+	assert.Error(t, err)
+	assert.FileExists(t, f.Name())
+}
+
+func TestRelayAllowedSender(t *testing.T) {
+	_, n, err := net.ParseCIDR("10.1.0.0/16")
+	require.NoError(t, err)
+	rl := &Relay{allowed: []*net.IPNet{n}}
+
+	assert.True(t, rl.allowedSender(&net.UnixAddr{Name: "@", Net: "unixgram"}))
+	assert.True(t, rl.allowedSender(&net.UDPAddr{IP: net.ParseIP("127.0.0.1")}))
+	assert.True(t, rl.allowedSender(&net.UDPAddr{IP: net.ParseIP("::1")}))
+	assert.True(t, rl.allowedSender(&net.UDPAddr{IP: net.ParseIP("10.1.2.3")}))
+	assert.False(t, rl.allowedSender(&net.UDPAddr{IP: net.ParseIP("10.2.2.3")}))
+	assert.False(t, (&Relay{}).allowedSender(&net.UDPAddr{IP: net.ParseIP("10.1.2.3")}))
+}
@@ -0,0 +1,44 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+)
+
+// ReloadConfig reloads the configuration from the config file and the
+// environment variables, then applies the debug level, the local sampling
+// config and the transaction filters to the running agent. The options read
+// only at startup, e.g., the service key and the collector, are not changed.
+// The running configuration is kept if the new one can't be loaded, e.g., the
+// config file is malformed.
+func ReloadConfig() {
+	if err := config.Reload(); err != nil {
+		log.Warningf("AppOptics configuration is not reloaded: %v", err)
+		return
+	}
+	log.SetLevelFromStr(config.DebugLevel())
+	urls.LoadConfig(config.GetTransactionFiltering())
+	globalSettingsCfg.mergeLocalSettings()
+	log.Info("AppOptics configuration reloaded.")
+}
+
+// reloadOnSIGHUP reloads the configuration whenever the process receives a
+// SIGHUP, if it's enabled.
+func reloadOnSIGHUP() {
+	if !config.GetReloadOnSIGHUP() {
+		return
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	go func() {
+		for range c {
+			ReloadConfig()
+		}
+	}()
+}
@@ -0,0 +1,51 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"os"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloadConfig(t *testing.T) {
+	r := SetTestReporter()
+	defer r.Close(0)
+	level := log.Level()
+	defer func() {
+		os.Unsetenv("APPOPTICS_TRACING_MODE")
+		os.Unsetenv("APPOPTICS_DEBUG_LEVEL")
+		os.Unsetenv("APPOPTICS_EXCLUDED_URLS")
+		config.Load()
+		ReloadURLsConfig(config.GetTransactionFiltering())
+		log.SetLevel(level)
+	}()
+
+	ReloadConfig()
+	traced0, _, source0, _ := shouldTraceRequest(testLayer, false)
+	assert.Equal(t, TRACE_UNKNOWN, urls.getTracingMode("GET", "/health"))
+
+	os.Setenv("APPOPTICS_TRACING_MODE", "disabled")
+	os.Setenv("APPOPTICS_DEBUG_LEVEL", "debug")
+	os.Setenv("APPOPTICS_EXCLUDED_URLS", "^/health$")
+	ReloadConfig()
+
+	traced, _, source, _ := shouldTraceRequest(testLayer, false)
+	assert.False(t, traced)
+	assert.Equal(t, SAMPLE_SOURCE_FILE, source)
+	assert.Equal(t, log.DEBUG, log.Level())
+	assert.Equal(t, TRACE_DISABLED, urls.getTracingMode("GET", "/health"))
+
+	// the settings from the collector are restored
+	os.Unsetenv("APPOPTICS_TRACING_MODE")
+	os.Unsetenv("APPOPTICS_EXCLUDED_URLS")
+	ReloadConfig()
+
+	traced, _, source, _ = shouldTraceRequest(testLayer, false)
+	assert.Equal(t, traced0, traced)
+	assert.Equal(t, source0, source)
+	assert.Equal(t, TRACE_UNKNOWN, urls.getTracingMode("GET", "/health"))
+}
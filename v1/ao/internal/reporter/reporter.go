@@ -95,6 +95,7 @@ func init() {
 	log.SetLevelFromStr(config.DebugLevel())
 	initReporter()
 	sendInitMessage()
+	reloadOnSIGHUP()
 }
 
 func initReporter() {
@@ -114,6 +115,11 @@ func setGlobalReporter(reporterType string) {
 		globalReporter.ShutdownNow()
 	}
 
+	if f, ok := lookupCustomReporter(reporterType); ok {
+		globalReporter = newCustomReporter(reporterType, f)
+		return
+	}
+
 	switch strings.ToLower(reporterType) {
 	case "ssl":
 		fallthrough // using fallthrough since the SSL reporter (gRPC) is our default reporter
@@ -159,6 +165,13 @@ func ReportSpan(span metrics.SpanMessage) error {
 	return globalReporter.reportSpan(span)
 }
 
+// clockSkewThreshold is the collector clock offset beyond which the events are
+// annotated with the offset.
+const clockSkewThreshold = 5 * time.Second
+
+// keyClockOffset is the key of the collector clock offset in microseconds.
+const keyClockOffset = "ClockOffset"
+
 // check if context and event are valid, add general keys like Timestamp, or hostname
 // ctx		oboe context
 // e		event to be prepared for sending
@@ -179,12 +192,22 @@ func prepareEvent(ctx *oboeContext, e *event) error {
 		return errors.New("invalid event, same as context")
 	}
 
-	us := time.Now().UnixNano() / 1000
-	e.AddInt64("Timestamp_u", us)
+	ts := e.timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	e.AddInt64(KeyTimestamp, ts.UnixNano()/1000)
 
 	e.AddString("Hostname", host.Hostname())
 	e.AddInt("PID", host.PID())
 
+	// flag the timestamp if the local clock drifts too far away from the
+	// collector's, so the event timing can be adjusted.
+	if offset, ok := metrics.CollectorClockOffset(); ok &&
+		(offset > clockSkewThreshold || offset < -clockSkewThreshold) {
+		e.AddInt64(keyClockOffset, int64(offset/time.Microsecond))
+	}
+
 	// Update the context's op_id to that of the event
 	ctx.metadata.ids.setOpID(e.metadata.ids.opID)
 
@@ -192,13 +215,13 @@ func prepareEvent(ctx *oboeContext, e *event) error {
 	return nil
 }
 
-func shouldTraceRequestWithURL(layer string, traced bool, url string, triggerTrace TriggerTraceMode) SampleDecision {
-	return oboeSampleRequest(layer, traced, url, triggerTrace)
+func shouldTraceRequestWithURL(layer string, traced bool, method, url string, triggerTrace TriggerTraceMode) SampleDecision {
+	return oboeSampleRequest(layer, traced, method, url, triggerTrace)
 }
 
 // Determines if request should be traced, based on sample rate settings.
 func shouldTraceRequest(layer string, traced bool) (bool, int, sampleSource, bool) {
-	d := shouldTraceRequestWithURL(layer, traced, "", ModeTriggerTraceNotPresent)
+	d := shouldTraceRequestWithURL(layer, traced, "", "", ModeTriggerTraceNotPresent)
 	return d.trace, d.rate, d.source, d.enabled
 }
 
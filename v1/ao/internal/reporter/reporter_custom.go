@@ -0,0 +1,158 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/metrics"
+	"github.com/pkg/errors"
+)
+
+// CustomReporter is the transport of the events of a reporter registered by
+// RegisterReporter, e.g., to Kafka or Fluentd. Its methods may be called
+// concurrently.
+type CustomReporter interface {
+	// WriteEvent sends an event in the configured encoding, BSON by default.
+	WriteEvent(buf []byte) error
+	// WriteStatus sends a status message, e.g., the __Init message, in the
+	// configured encoding.
+	WriteStatus(buf []byte) error
+	// Close flushes the events and closes the transport. It's called only once.
+	Close(ctx context.Context) error
+}
+
+// ReporterFactory creates the transport of a custom reporter.
+type ReporterFactory func() (CustomReporter, error)
+
+// errors of registering a custom reporter
+var (
+	ErrInvalidReporterName = errors.New("invalid reporter name")
+	ErrNilReporterFactory  = errors.New("nil reporter factory")
+)
+
+// the reporter types which can't be taken by a custom reporter
+var builtinReporterTypes = map[string]bool{"ssl": true, "udp": true, "none": true, "serverless": true}
+
+var customReporters = struct {
+	sync.Mutex
+	m map[string]ReporterFactory
+}{m: make(map[string]ReporterFactory)}
+
+// RegisterReporter registers the factory of a custom reporter by its name. If
+// the reporter type (APPOPTICS_REPORTER) is the name, the custom reporter is
+// created and replaces the current one. It should be called at the program
+// startup before any trace is started.
+func RegisterReporter(name string, factory ReporterFactory) error {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || builtinReporterTypes[name] {
+		return errors.Wrap(ErrInvalidReporterName, name)
+	}
+	if factory == nil {
+		return ErrNilReporterFactory
+	}
+
+	customReporters.Lock()
+	customReporters.m[name] = factory
+	customReporters.Unlock()
+
+	if !config.GetDisabled() && config.GetRequestedReporterType() == name {
+		setGlobalReporter(name)
+		sendInitMessage()
+	}
+	return nil
+}
+
+func lookupCustomReporter(name string) (ReporterFactory, bool) {
+	customReporters.Lock()
+	defer customReporters.Unlock()
+	f, ok := customReporters.m[strings.ToLower(name)]
+	return f, ok
+}
+
+// customReporter adapts a CustomReporter to the reporter interface. The
+// metrics are not reported as it's up to the transport to send the events.
+type customReporter struct {
+	name    string
+	w       CustomReporter
+	encoder Encoder
+	closed  int32 // set atomically by Shutdown
+}
+
+func newCustomReporter(name string, f ReporterFactory) reporter {
+	w, err := f()
+	if err != nil || w == nil {
+		log.Errorf("AppOptics failed to initialize the %s reporter: %v", name, err)
+		return &nullReporter{}
+	}
+	addLocalDefaultSetting()
+	return &customReporter{name: name, w: w, encoder: getEncoder()}
+}
+
+func (r *customReporter) reportEvent(ctx *oboeContext, e *event) error {
+	if r.Closed() {
+		return ErrReporterIsClosed
+	}
+	if err := prepareEvent(ctx, e); err != nil {
+		return err
+	}
+	buf, err := r.encoder.Encode((*e).bbuf.GetBuf())
+	if err != nil {
+		return err
+	}
+	return r.w.WriteEvent(buf)
+}
+
+func (r *customReporter) reportStatus(ctx *oboeContext, e *event) error {
+	if r.Closed() {
+		return ErrReporterIsClosed
+	}
+	if err := prepareEvent(ctx, e); err != nil {
+		return err
+	}
+	buf, err := r.encoder.Encode((*e).bbuf.GetBuf())
+	if err != nil {
+		return err
+	}
+	return r.w.WriteStatus(buf)
+}
+
+func (r *customReporter) reportSpan(span metrics.SpanMessage) error { return nil }
+
+// Shutdown closes the transport.
+func (r *customReporter) Shutdown(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&r.closed, 0, 1) {
+		return ErrShutdownClosedReporter
+	}
+	return r.w.Close(ctx)
+}
+
+// ShutdownNow closes the transport without waiting for it to flush.
+func (r *customReporter) ShutdownNow() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return r.Shutdown(ctx)
+}
+
+// Closed returns if the reporter is already closed.
+func (r *customReporter) Closed() bool {
+	return atomic.LoadInt32(&r.closed) == 1
+}
+
+func (r *customReporter) WaitForReady(context.Context) bool { return true }
+
+func (r *customReporter) CustomSummaryMetric(name string, value float64, opts metrics.MetricOptions) error {
+	return nil
+}
+
+func (r *customReporter) CustomIncrementMetric(name string, opts metrics.MetricOptions) error {
+	return nil
+}
+
+func (r *customReporter) Flush() error         { return nil }
+func (r *customReporter) SetServiceKey(string) {}
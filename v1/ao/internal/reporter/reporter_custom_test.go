@@ -0,0 +1,84 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memReporter struct {
+	sync.Mutex
+	events   [][]byte
+	statuses [][]byte
+	closed   bool
+}
+
+func (m *memReporter) WriteEvent(buf []byte) error {
+	m.Lock()
+	defer m.Unlock()
+	m.events = append(m.events, buf)
+	return nil
+}
+
+func (m *memReporter) WriteStatus(buf []byte) error {
+	m.Lock()
+	defer m.Unlock()
+	m.statuses = append(m.statuses, buf)
+	return nil
+}
+
+func (m *memReporter) Close(ctx context.Context) error {
+	m.Lock()
+	defer m.Unlock()
+	m.closed = true
+	return nil
+}
+
+func TestRegisterReporter(t *testing.T) {
+	old := globalReporter
+	globalReporter = newNullReporter()
+	defer func() { globalReporter = old }()
+
+	mem := &memReporter{}
+	factory := func() (CustomReporter, error) { return mem, nil }
+	assert.Error(t, RegisterReporter("ssl", factory))
+	assert.Error(t, RegisterReporter(" ", factory))
+	assert.Equal(t, ErrNilReporterFactory, RegisterReporter("memory", nil))
+
+	// not used unless it's the configured reporter type
+	assert.NoError(t, RegisterReporter("other", factory))
+	assert.IsType(t, &nullReporter{}, globalReporter)
+
+	os.Setenv("APPOPTICS_REPORTER", "Memory")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_REPORTER")
+		config.Load()
+	}()
+	assert.Equal(t, "ssl", config.GetReporterType())
+
+	require.NoError(t, RegisterReporter("memory", factory))
+	r, ok := globalReporter.(*customReporter)
+	require.True(t, ok)
+	assert.Len(t, mem.statuses, 1) // the __Init message
+	assert.Contains(t, string(mem.statuses[0]), "__Init")
+
+	ctx := newTestContext(t)
+	ev, err := ctx.newEvent(LabelInfo, testLayer)
+	require.NoError(t, err)
+	assert.NoError(t, r.reportEvent(ctx, ev))
+	assert.Len(t, mem.events, 1)
+
+	assert.NoError(t, r.Shutdown(context.Background()))
+	assert.True(t, mem.closed)
+	assert.True(t, r.Closed())
+	assert.Equal(t, ErrReporterIsClosed, r.reportEvent(ctx, ev))
+	assert.Equal(t, ErrShutdownClosedReporter, r.Shutdown(context.Background()))
+}
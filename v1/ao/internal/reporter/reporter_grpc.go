@@ -11,6 +11,7 @@ import (
 	"io"
 	"io/ioutil"
 	"math"
+	"math/rand"
 	"net"
 	"net/http"
 	"net/http/httputil"
@@ -23,6 +24,7 @@ import (
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/metrics"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/utils"
 	"github.com/pkg/errors"
+	xproxy "golang.org/x/net/proxy"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/encoding/gzip"
 
@@ -76,15 +78,16 @@ ftgwcxyEq5SkiR+6BCwdzAMqADV37TzXDHLjwSrMIrgLV5xZM20Kk6chxI5QAr/f
 	grpcGetSettingsIntervalDefault          = 30               // default settings retrieval interval in seconds
 	grpcSettingsTimeoutCheckIntervalDefault = 10               // default check interval for timed out settings in seconds
 	grpcPingIntervalDefault                 = 20               // default interval for keep alive pings in seconds
-	grpcRetryDelayInitial                   = 500              // initial connection/send retry delay in milliseconds
+	grpcClockSyncIntervalDefault            = 300              // default interval for clock sync pings in seconds
 	grpcRetryDelayMultiplier                = 1.5              // backoff multiplier for unsuccessful retries
-	grpcRetryDelayMax                       = 60               // max connection/send retry delay in seconds
 	grpcCtxTimeout                          = 10 * time.Second // gRPC method invocation timeout in seconds
 	grpcRedirectMax                         = 20               // max allowed collector redirects
 	grpcRetryLogThreshold                   = 10               // log prints after this number of retries (about 56.7s)
-	grpcMaxRetries                          = 20               // The message will be dropped after this number of retries
 )
 
+// the usage of the event queue above which the info events are dropped
+const optionalEventQueueLimit = 0.8
+
 type reporterChannel int
 
 // a channel the reporter is listening on for messages from the agent
@@ -108,6 +111,10 @@ type grpcConnection struct {
 	proxy            string
 	proxyTLSCertPath string
 
+	// the collectors of the data residency region, which are the only ones the
+	// connection can be redirected to. Empty means any collector.
+	regionCollectors []string
+
 	// atomicActive indicates if the underlying connection is active. It should
 	// be reconnected or redirected to a new address in case of inactive. The
 	// value 0 represents false and a value other than 0 (usually 1) means true
@@ -154,6 +161,14 @@ func WithMaxReqBytes(size int64) GrpcConnOpt {
 	}
 }
 
+// WithRegionCollectors returns a function that restricts the redirections to
+// the collectors of the data residency region
+func WithRegionCollectors(addrs []string) GrpcConnOpt {
+	return func(c *grpcConnection) {
+		c.regionCollectors = addrs
+	}
+}
+
 // WithDialer returns a function that sets the Dialer option
 func WithDialer(d Dialer) GrpcConnOpt {
 	return func(c *grpcConnection) {
@@ -220,6 +235,9 @@ type grpcReporter struct {
 	httpMetrics   *metrics.Measurements
 	customMetrics *metrics.Measurements
 
+	// the event batches failed to be sent, nil if spooling is disabled
+	spool *spool
+
 	// The reporter is considered ready if there is a valid default setting for sampling.
 	// It should be accessed atomically.
 	ready int32
@@ -260,6 +278,22 @@ func newGRPCReporter() reporter {
 	addr := config.GetCollector()
 
 	var opts []GrpcConnOpt
+	// never fall back to the collectors out of the data residency region
+	if region := config.GetDataResidency(); region != "" {
+		collectors := config.GetRegionCollectors()
+		if len(collectors) == 0 {
+			log.Errorf("No collector is known for the data residency region %s, "+
+				"please set APPOPTICS_REGION_COLLECTORS.", region)
+			return &nullReporter{}
+		}
+		if !inRegion(collectors, addr) {
+			log.Infof("The collector %s is out of the data residency region %s, using %s instead.",
+				addr, region, collectors[0])
+			addr = collectors[0]
+		}
+		opts = append(opts, WithRegionCollectors(collectors))
+	}
+
 	// certificate override
 	if certPath := config.GetTrustedPath(); certPath != "" {
 		var err error
@@ -299,6 +333,7 @@ func newGRPCReporter() reporter {
 		statusMessages: make(chan []byte, 100),
 		httpMetrics:    metrics.NewMeasurements(false, grpcMetricIntervalDefault, 200),
 		customMetrics:  metrics.NewMeasurements(true, grpcMetricIntervalDefault, 500), // TODO configurable
+		spool:          newSpool(),
 
 		cond: sync.NewCond(&sync.Mutex{}),
 		done: make(chan struct{}),
@@ -469,6 +504,22 @@ func (r *grpcReporter) WaitForReady(ctx context.Context) bool {
 	}
 }
 
+// redirectAllowed checks if the connection can be redirected to addr, which is
+// in the data residency region if it's set.
+func (c *grpcConnection) redirectAllowed(addr string) bool {
+	return len(c.regionCollectors) == 0 || inRegion(c.regionCollectors, addr)
+}
+
+// inRegion checks if addr is one of the collectors of a region.
+func inRegion(collectors []string, addr string) bool {
+	for _, collector := range collectors {
+		if strings.EqualFold(collector, addr) {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *grpcConnection) setAddress(addr string) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
@@ -537,11 +588,13 @@ func (r *grpcReporter) periodicTasks() {
 	collectMetricsTicker := time.NewTimer(r.collectMetricsNextInterval())
 	getSettingsTicker := time.NewTimer(0)
 	settingsTimeoutCheckTicker := time.NewTimer(time.Duration(r.settingsTimeoutCheckInterval) * time.Second)
+	clockSyncTicker := time.NewTimer(time.Duration(grpcClockSyncIntervalDefault) * time.Second)
 
 	defer func() {
 		collectMetricsTicker.Stop()
 		getSettingsTicker.Stop()
 		settingsTimeoutCheckTicker.Stop()
+		clockSyncTicker.Stop()
 		r.conn.pingTicker.Stop()
 	}()
 
@@ -611,6 +664,16 @@ func (r *grpcReporter) periodicTasks() {
 					r.ShutdownNow()
 				}
 			}()
+		case <-clockSyncTicker.C: // ping on event connection (round-trip time and clock offset)
+			// the keep alive ping is not sent on a busy connection, so the
+			// collector is pinged periodically to keep the measurements fresh.
+			clockSyncTicker.Reset(time.Duration(grpcClockSyncIntervalDefault) * time.Second)
+			r.conn.resetPing()
+			go func() {
+				if r.conn.ping(r.done, r.serviceKey.Load()) == errInvalidServiceKey {
+					r.ShutdownNow()
+				}
+			}()
 		}
 	}
 }
@@ -618,20 +681,39 @@ func (r *grpcReporter) periodicTasks() {
 type Backoff func(retries int, wait func(d time.Duration)) error
 
 // DefaultBackoff calls the wait function to sleep for a certain time based on
-// the retries value. It returns immediately if the retries exceeds a threshold.
+// the retries value. The delay grows exponentially from the initial retry delay
+// up to the maximum, and a random fraction of it, up to the retry jitter, is
+// cut off so the agents don't retry all at once after a collector restart. It
+// returns immediately if the retries exceeds the maximum.
 func DefaultBackoff(retries int, wait func(d time.Duration)) error {
-	if retries > grpcMaxRetries {
+	opts := config.ReporterOpts()
+	if retries > opts.GetMaxRetries() {
 		return errGiveUpAfterRetries
 	}
-	delay := int(grpcRetryDelayInitial * math.Pow(grpcRetryDelayMultiplier, float64(retries-1)))
-	if delay > grpcRetryDelayMax*1000 {
-		delay = grpcRetryDelayMax * 1000
+	delay := time.Duration(float64(opts.GetRetryDelayInitial()) *
+		math.Pow(grpcRetryDelayMultiplier, float64(retries-1)))
+	if max := opts.GetRetryDelayMax(); delay > max {
+		delay = max
+	}
+	if jitter := opts.GetRetryJitter(); jitter > 0 {
+		delay -= time.Duration(jitter * rand.Float64() * float64(delay))
 	}
 
-	wait(time.Duration(delay) * time.Millisecond)
+	wait(delay)
 	return nil
 }
 
+// isTransient tells if the RPC error may go away by retrying, e.g., while the
+// collector is restarting. The errors caused by the request itself never do.
+func isTransient(err error) bool {
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.NotFound, codes.PermissionDenied,
+		codes.Unauthenticated, codes.Unimplemented, codes.OutOfRange:
+		return false
+	}
+	return true
+}
+
 // ================================ Event Handling ====================================
 
 // prepares the given event and puts it on the channel so it can be consumed by the
@@ -649,12 +731,21 @@ func (r *grpcReporter) reportEvent(ctx *oboeContext, e *event) error {
 		return err
 	}
 
+	// the info events are dropped once the queue is mostly full, leaving the
+	// rest of it to the entry, exit and error events.
+	if e.optional() && r.eventQueueUsage() >= optionalEventQueueLimit {
+		r.conn.queueStats.NumOverflowedAdd(int64(1))
+		globalStats.overflowed(1)
+		return errors.New("event message queue is reserved for span events")
+	}
+
 	select {
 	case r.eventMessages <- (*e).bbuf.GetBuf():
 		r.conn.queueStats.TotalEventsAdd(int64(1))
 		return nil
 	default:
 		r.conn.queueStats.NumOverflowedAdd(int64(1))
+		globalStats.overflowed(1)
 		return errors.New("event message queue is full")
 	}
 }
@@ -762,8 +853,10 @@ func (r *grpcReporter) eventBatchSender(batches <-chan [][]byte) {
 				r.ShutdownNow()
 			case nil:
 				log.Info(method.CallSummary())
+				r.replaySpool()
 			default:
 				log.Warningf("eventBatchSender: %s", err)
+				r.spoolEvents(messages, err)
 			}
 		}
 
@@ -773,6 +866,36 @@ func (r *grpcReporter) eventBatchSender(batches <-chan [][]byte) {
 	}
 }
 
+// spoolEvents spools the batch failed to be sent, unless it would never be
+// accepted by the collector, e.g., it's too big, or it must not leave the data
+// residency region.
+func (r *grpcReporter) spoolEvents(messages [][]byte, err error) {
+	if r.spool == nil {
+		return
+	}
+	switch errors.Cause(err) {
+	case errNoRetryOnErr, errRedirectOutOfRegion:
+		return
+	}
+	if err = r.spool.write(messages); err != nil {
+		log.Warningf("Failed to spool %d events: %v", len(messages), err)
+		return
+	}
+	log.Infof("Spooled %d events.", len(messages))
+}
+
+// replaySpool sends the spooled batches in the background, as the collector
+// is reachable again. It's a no-op while a previous replay is still running.
+func (r *grpcReporter) replaySpool() {
+	if r.spool == nil {
+		return
+	}
+	r.spool.tryReplay(func(messages [][]byte) error {
+		method := newPostEventsMethod(r.serviceKey.Load(), messages)
+		return r.conn.InvokeRPC(r.done, method)
+	})
+}
+
 // ================================ Metrics Handling ====================================
 
 // calculates the interval from now until the next time we need to collect metrics
@@ -794,13 +917,17 @@ func (r *grpcReporter) collectMetrics(collectReady chan bool) {
 
 	var messages [][]byte
 	// generate a new metrics message
-	builtin := metrics.BuildBuiltinMetricsMessage(r.httpMetrics.CopyAndReset(i),
+	httpMetrics := r.httpMetrics.CopyAndReset(i)
+	metrics.RunFlushCallbacks(httpMetrics)
+	builtin := metrics.BuildBuiltinMetricsMessage(httpMetrics,
 		r.conn.queueStats.CopyAndReset(), FlushRateCounts(), config.GetRuntimeMetrics())
 	if builtin != nil {
 		messages = append(messages, builtin)
 	}
 
-	custom := metrics.BuildMessage(r.customMetrics.CopyAndReset(i), false)
+	customMetrics := r.customMetrics.CopyAndReset(i)
+	metrics.RunFlushCallbacks(customMetrics)
+	custom := metrics.BuildMessage(customMetrics, false)
 	if custom != nil {
 		messages = append(messages, custom)
 	}
@@ -885,6 +1012,11 @@ func (r *grpcReporter) updateSettings(settings *collector.SettingsResult) {
 		// update MaxTransactions
 		mt := parseInt32(s.Arguments, kvMaxTransactions, r.httpMetrics.Cap())
 		r.httpMetrics.SetCap(mt)
+		if prev, changed := metrics.UpdateTransactionLimit(mt); changed {
+			log.Infof("The max number of transaction names is changed from %d to %d by the collector, "+
+				"effective from the next metrics cycle.", prev, mt)
+			_ = ReportAnnotation("TransactionLimitChanged", KVMap{"Limit": mt, "Previous": prev})
+		}
 
 		maxCustomMetrics := parseInt32(s.Arguments, kvMaxCustomMetrics, r.httpMetrics.Cap())
 		r.customMetrics.SetCap(maxCustomMetrics)
@@ -1029,6 +1161,12 @@ func (c *grpcConnection) ping(exit chan struct{}, key string) error {
 	method := newPingMethod(key, c.name)
 	err := c.InvokeRPC(exit, method)
 	log.Debug(method.CallSummary())
+	if err == nil {
+		metrics.RecordCollectorRTT(method.rtt)
+		if method.synced {
+			metrics.RecordCollectorClockOffset(method.offset)
+		}
+	}
 	return err
 }
 
@@ -1049,6 +1187,10 @@ var (
 	// The destination returned by the collector is not valid.
 	errInvalidRedirectTarget = errors.New("redirection target is empty.")
 
+	// The destination returned by the collector is out of the data residency
+	// region and the message will be dropped.
+	errRedirectOutOfRegion = errors.New("redirection target is out of the data residency region")
+
 	// the operation or loop cannot continue as the reporter is exiting.
 	errReporterExiting = errors.New("reporter is exiting")
 
@@ -1133,6 +1275,7 @@ func (c *grpcConnection) InvokeRPC(exit chan struct{}, m Method) error {
 		if err != nil {
 			// gRPC handles the reconnection automatically.
 			failsNum++
+			globalSendFailures.fail(c.name, err)
 			if failsNum == grpcRetryLogThreshold {
 				log.Warningf("[%s] invocation error: %v.", m, err)
 			} else {
@@ -1148,14 +1291,20 @@ func (c *grpcConnection) InvokeRPC(exit chan struct{}, m Method) error {
 			switch result, _ := m.ResultCode(); result {
 			case collector.ResultCode_OK:
 				c.queueStats.NumSentAdd(m.MessageLen())
+				globalStats.sent(m.MessageLen())
+				globalSendFailures.succeed(c.name)
 				return nil
 
 			case collector.ResultCode_TRY_LATER:
 				log.Info(m.CallSummary())
 				c.queueStats.NumFailedAdd(m.MessageLen())
+				globalStats.failed(m.MessageLen())
+				globalSendFailures.fail(c.name, errors.New(result.String()))
 			case collector.ResultCode_LIMIT_EXCEEDED:
 				log.Info(m.CallSummary())
 				c.queueStats.NumFailedAdd(m.MessageLen())
+				globalStats.failed(m.MessageLen())
+				globalSendFailures.fail(c.name, errors.New(result.String()))
 			case collector.ResultCode_INVALID_API_KEY:
 				log.Error(m.CallSummary())
 				return errInvalidServiceKey
@@ -1165,6 +1314,9 @@ func (c *grpcConnection) InvokeRPC(exit chan struct{}, m Method) error {
 
 				if redirects > grpcRedirectMax {
 					return errTooManyRedirections
+				} else if m.Arg() != "" && !c.redirectAllowed(m.Arg()) {
+					log.Warningf("[%s] Refused the redirection to %s", c.name, m.Arg())
+					return errRedirectOutOfRegion
 				} else if m.Arg() != "" {
 					c.setAddress(m.Arg())
 					// a proper redirect shouldn't cause delays
@@ -1181,8 +1333,9 @@ func (c *grpcConnection) InvokeRPC(exit chan struct{}, m Method) error {
 			c.reconnect()
 		}
 
-		if !m.RetryOnErr(err) {
+		if !m.RetryOnErr(err) || !isTransient(err) {
 			if err != nil {
+				c.queueStats.NumDroppedAdd(m.MessageLen())
 				return errors.Wrap(errNoRetryOnErr, err.Error())
 			} else {
 				return errNoRetryOnErr
@@ -1194,8 +1347,10 @@ func (c *grpcConnection) InvokeRPC(exit chan struct{}, m Method) error {
 			time.Sleep(d)
 		})
 		if err != nil {
+			c.queueStats.NumDroppedAdd(m.MessageLen())
 			return err
 		}
+		c.queueStats.NumRetriedAdd(m.MessageLen())
 	}
 }
 
@@ -1333,6 +1488,8 @@ func newGRPCProxyDialer(p DialParams) func(context.Context, string) (net.Conn, e
 			if err != nil {
 				return nil, errors.Wrap(err, "failed to dial the http proxy")
 			}
+		} else if proxy.Scheme == "socks5" {
+			return socks5Dial(ctx, addr, proxy)
 		} else {
 			return nil, fmt.Errorf("proxy scheme not supported: %s", proxy.Scheme)
 		}
@@ -1341,6 +1498,25 @@ func newGRPCProxyDialer(p DialParams) func(context.Context, string) (net.Conn, e
 	}
 }
 
+// socks5Dial connects to the server through the SOCKS5 proxy, authenticating
+// with the username and password of the proxy url, if any.
+func socks5Dial(ctx context.Context, server string, proxy *url.URL) (net.Conn, error) {
+	var auth *xproxy.Auth
+	if t := proxy.User; t != nil {
+		p, _ := t.Password()
+		auth = &xproxy.Auth{User: t.Username(), Password: p}
+	}
+	d, err := xproxy.SOCKS5("tcp", proxy.Host, auth, &net.Dialer{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create the socks5 dialer")
+	}
+	conn, err := d.(xproxy.ContextDialer).DialContext(ctx, "tcp", server)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to dial through the socks5 proxy")
+	}
+	return conn, nil
+}
+
 func httpConnectHandshake(ctx context.Context, conn net.Conn, server string, proxy *url.URL) (net.Conn, error) {
 	req := &http.Request{
 		Method: http.MethodConnect,
@@ -3,6 +3,7 @@ package reporter
 import (
 	"context"
 	"io"
+	"sync/atomic"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
@@ -16,6 +17,8 @@ type serverlessReporter struct {
 	logWriter FlushWriter
 	// the http span
 	span metrics.HTTPSpanMessage
+	// set atomically by Shutdown
+	closed int32
 }
 
 func newServerlessReporter(writer io.Writer) reporter {
@@ -74,19 +77,30 @@ func (sr *serverlessReporter) reportSpan(span metrics.SpanMessage) error {
 	return nil
 }
 
-// Shutdown closes the reporter.
+// Shutdown writes out the metrics and the events buffered, and closes the
+// reporter.
 func (sr *serverlessReporter) Shutdown(ctx context.Context) error {
-	return sr.ShutdownNow()
+	if sr.Closed() {
+		return ErrShutdownClosedReporter
+	}
+	err := sr.Flush()
+	if e := sr.ShutdownNow(); err == nil {
+		err = e
+	}
+	return err
 }
 
 // ShutdownNow closes the reporter immediately
 func (sr *serverlessReporter) ShutdownNow() error {
+	if !atomic.CompareAndSwapInt32(&sr.closed, 0, 1) {
+		return ErrShutdownClosedReporter
+	}
 	return nil
 }
 
 // Closed returns if the reporter is already closed.
 func (sr *serverlessReporter) Closed() bool {
-	return false
+	return atomic.LoadInt32(&sr.closed) == 1
 }
 
 // WaitForReady waits until the reporter becomes ready or the context is canceled.
@@ -1,6 +1,7 @@
 package reporter
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"os"
@@ -64,4 +65,17 @@ func TestServerlessShutdown(t *testing.T) {
 	globalReporter = newServerlessReporter(os.Stderr)
 	r := globalReporter.(*serverlessReporter)
 	assert.Nil(t, r.ShutdownNow())
+	assert.True(t, r.Closed())
+
+	// Shutdown writes out the buffered events
+	var sb utils.SafeBuffer
+	r = newServerlessReporter(&sb).(*serverlessReporter)
+	ctx := newTestContext(t)
+	ev, err := ctx.newEvent(LabelInfo, "layer1")
+	assert.NoError(t, err)
+	assert.NoError(t, r.reportEvent(ctx, ev))
+	assert.Nil(t, r.Shutdown(context.Background()))
+	assert.Contains(t, sb.String(), "events")
+	assert.True(t, r.Closed())
+	assert.Equal(t, ErrShutdownClosedReporter, r.Shutdown(context.Background()))
 }
@@ -22,6 +22,7 @@ import (
 	pb "github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter/collector"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter/mocks"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/utils"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -78,6 +79,30 @@ func TestReportEvent(t *testing.T) {
 	})
 }
 
+func TestReportEventClockOffset(t *testing.T) {
+	defer metrics.RecordCollectorClockOffset(0)
+
+	for _, offset := range []time.Duration{time.Second, -10 * time.Second} {
+		metrics.RecordCollectorClockOffset(offset)
+		r := SetTestReporter()
+		ctx := newTestContext(t)
+		ev, err := ctx.newEvent(LabelExit, testLayer)
+		assert.NoError(t, err)
+		assert.NoError(t, r.reportEvent(ctx, ev))
+		r.Close(1)
+
+		g.AssertGraph(t, r.EventBufs, 1, g.AssertNodeMap{
+			{"go_test", "exit"}: {Callback: func(n g.Node) {
+				if offset > clockSkewThreshold || offset < -clockSkewThreshold {
+					assert.EqualValues(t, offset/time.Microsecond, n.Map[keyClockOffset])
+				} else {
+					assert.NotContains(t, n.Map, keyClockOffset)
+				}
+			}},
+		})
+	}
+}
+
 func TestReportMetric(t *testing.T) {
 	r := SetTestReporter()
 	spanMsg := &metrics.HTTPSpanMessage{
@@ -163,6 +188,39 @@ func startTestUDPListener(t *testing.T, bufs *[][]byte, numbufs int) chan struct
 	return done
 }
 
+func TestUDPReporterDestinations(t *testing.T) {
+	var addrs []string
+	var listeners []*net.UDPConn
+	for i := 0; i < 2; i++ {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		require.NoError(t, err)
+		defer conn.Close()
+		listeners = append(listeners, conn)
+		addrs = append(addrs, conn.LocalAddr().String())
+	}
+
+	conns, err := dialUDP(addrs)
+	require.NoError(t, err)
+	r := &udpReporter{conns: conns}
+	assert.NoError(t, r.reportSpan(&metrics.HTTPSpanMessage{Transaction: "udp"}))
+
+	for _, l := range listeners {
+		buf := make([]byte, 1024)
+		l.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := l.ReadFromUDP(buf)
+		assert.NoError(t, err)
+		assert.Contains(t, string(buf[:n]), "udp")
+	}
+
+	assert.NoError(t, r.Shutdown(context.Background()))
+	assert.True(t, r.Closed())
+	assert.Equal(t, ErrReporterIsClosed, r.reportSpan(&metrics.HTTPSpanMessage{}))
+	assert.Equal(t, ErrShutdownClosedReporter, r.Shutdown(context.Background()))
+
+	_, err = dialUDP([]string{addrs[0], "invalid"})
+	assert.Error(t, err)
+}
+
 func assertUDPMode(t *testing.T) {
 	// for UDP mode run test like this:
 	// APPOPTICS_REPORTER=udp go test -v
@@ -398,16 +456,38 @@ func TestInvalidKey(t *testing.T) {
 }
 
 func TestDefaultBackoff(t *testing.T) {
+	opts := config.ReporterOpts()
+	maxRetries := opts.GetMaxRetries()
+	jitter := opts.RetryJitter
+	defer func() { opts.RetryJitter = jitter }()
+
+	opts.RetryJitter = 0
 	var backoff []int64
 	expected := []int64{
 		500, 750, 1125, 1687, 2531, 3796, 5695, 8542, 12814, 19221, 28832,
 		43248, 60000, 60000, 60000, 60000, 60000, 60000, 60000, 60000}
 	bf := func(d time.Duration) { backoff = append(backoff, d.Nanoseconds()/1e6) }
-	for i := 1; i <= grpcMaxRetries+1; i++ {
+	for i := 1; i <= maxRetries+1; i++ {
 		DefaultBackoff(i, bf)
 	}
 	assert.Equal(t, expected, backoff)
-	assert.NotNil(t, DefaultBackoff(grpcMaxRetries+1, func(d time.Duration) {}))
+	assert.NotNil(t, DefaultBackoff(maxRetries+1, func(d time.Duration) {}))
+
+	// the jitter only shortens the delays
+	opts.RetryJitter = 0.5
+	for i := 0; i < 100; i++ {
+		DefaultBackoff(20, func(d time.Duration) {
+			assert.True(t, d > 30*time.Second && d <= time.Minute, d)
+		})
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	assert.True(t, isTransient(status.Error(codes.Unavailable, "restarting")))
+	assert.True(t, isTransient(status.Error(codes.ResourceExhausted, "busy")))
+	assert.True(t, isTransient(errConnStale))
+	assert.False(t, isTransient(status.Error(codes.Unauthenticated, "denied")))
+	assert.False(t, isTransient(status.Error(codes.InvalidArgument, "malformed")))
 }
 
 type NoopDialer struct{}
@@ -437,7 +517,7 @@ func TestInvokeRPC(t *testing.T) {
 		certificate: []byte(grpcCertDefault),
 		queueStats:  &metrics.EventQueueStats{},
 		backoff: func(retries int, wait func(d time.Duration)) error {
-			if retries > grpcMaxRetries {
+			if retries > config.ReporterOpts().GetMaxRetries() {
 				return errGiveUpAfterRetries
 			}
 			return nil
@@ -501,7 +581,7 @@ func TestInvokeRPC(t *testing.T) {
 	assert.Equal(t, errNoRetryOnErr, c.InvokeRPC(exit, mockMethod))
 
 	// Test invocation error / recovery logs
-	failsNum := grpcRetryLogThreshold + (grpcMaxRetries-grpcRetryLogThreshold)/2
+	failsNum := grpcRetryLogThreshold + (config.ReporterOpts().GetMaxRetries()-grpcRetryLogThreshold)/2
 
 	mockMethod = &mocks.Method{}
 	mockMethod.On("String").Return("mock")
@@ -528,6 +608,19 @@ func TestInvokeRPC(t *testing.T) {
 	assert.True(t, strings.Contains(buf.String(), "invocation error"))
 	assert.True(t, strings.Contains(buf.String(), "error recovered"))
 
+	// Test the non-transient error is not retried
+	mockMethod = &mocks.Method{}
+	mockMethod.On("String").Return("mock")
+	mockMethod.On("ServiceKey").Return("serviceKey")
+	mockMethod.On("Message").Return(nil)
+	mockMethod.On("MessageLen").Return(int64(3))
+	mockMethod.On("RequestSize").Return(int64(1))
+	mockMethod.On("RetryOnErr", mock.Anything).Return(true)
+	mockMethod.On("Call", mock.Anything, mock.Anything).
+		Return(status.Error(codes.Unauthenticated, "denied"))
+	assert.Equal(t, errNoRetryOnErr, errors.Cause(c.InvokeRPC(exit, mockMethod)))
+	mockMethod.AssertNumberOfCalls(t, "Call", 1)
+
 	// Test redirect
 	redirectNum := 1
 	mockMethod = &mocks.Method{}
@@ -556,6 +649,26 @@ func TestInvokeRPC(t *testing.T) {
 	assert.True(t, c.isActive())
 	assert.Equal(t, "new-addr:9999", c.address)
 
+	// Test the redirect out of the data residency region is refused
+	c.regionCollectors = []string{"new-addr:9999"}
+	mockMethod = &mocks.Method{}
+	mockMethod.On("String").Return("mock")
+	mockMethod.On("ServiceKey").Return("serviceKey")
+	mockMethod.On("Message").Return(nil)
+	mockMethod.On("MessageLen").Return(int64(0))
+	mockMethod.On("RequestSize").Return(int64(1))
+	mockMethod.On("CallSummary").Return("summary")
+	mockMethod.On("RetryOnErr", mock.Anything).Return(true)
+	mockMethod.On("Arg", mock.Anything, mock.Anything).
+		Return("other-region:9999")
+	mockMethod.On("ResultCode", mock.Anything, mock.Anything).
+		Return(pb.ResultCode_REDIRECT, nil)
+	mockMethod.On("Call", mock.Anything, mock.Anything).
+		Return(nil)
+	assert.Equal(t, errRedirectOutOfRegion, c.InvokeRPC(exit, mockMethod))
+	assert.Equal(t, "new-addr:9999", c.address)
+	c.regionCollectors = nil
+
 	// Test request too big
 	mockMethod = &mocks.Method{}
 	mockMethod.On("Call", mock.Anything, mock.Anything).
@@ -589,6 +702,20 @@ func TestInitReporter(t *testing.T) {
 	require.IsType(t, &grpcReporter{}, globalReporter)
 }
 
+func TestInitReporterDataResidency(t *testing.T) {
+	os.Setenv("APPOPTICS_REPORTER", "ssl")
+	os.Setenv("APPOPTICS_DATA_RESIDENCY", "eu")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_DATA_RESIDENCY")
+		config.Load()
+	}()
+
+	// no fallback to the default collector
+	initReporter()
+	require.IsType(t, &nullReporter{}, globalReporter)
+}
+
 func TestCollectMetricsNextInterval(t *testing.T) {
 	r := &grpcReporter{collectMetricInterval: 10}
 	next := r.collectMetricsNextInterval()
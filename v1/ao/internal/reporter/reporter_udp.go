@@ -5,6 +5,8 @@ package reporter
 import (
 	"context"
 	"net"
+	"strings"
+	"sync/atomic"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/bson"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
@@ -18,33 +20,81 @@ const (
 )
 
 type udpReporter struct {
-	conn *net.UDPConn
+	conns   []net.Conn // one per destination
+	encoder Encoder
+	closed  int32 // set atomically by Shutdown
 }
 
 func udpNewReporter() reporter {
-	var conn *net.UDPConn
-
 	// collector address override
-	udpAddress := config.GetCollectorUDP()
-	if udpAddress == "" {
-		udpAddress = udpAddrDefault
+	addrs := config.GetCollectorUDPAddrs()
+	if len(addrs) == 0 {
+		addrs = []string{udpAddrDefault}
 	}
 
-	serverAddr, err := net.ResolveUDPAddr("udp4", udpAddress)
-	if err == nil {
-		conn, err = net.DialUDP("udp4", nil, serverAddr)
-	}
+	conns, err := dialUDP(addrs)
 	if err != nil {
 		log.Errorf("AppOptics failed to initialize UDP reporter: %v", err)
 		return &nullReporter{}
 	}
 
-	// add default setting
+	addLocalDefaultSetting()
+	return &udpReporter{conns: conns, encoder: getEncoder()}
+}
+
+// addLocalDefaultSetting adds the default setting of the reporters which don't
+// get the settings from the collector, which samples every request.
+func addLocalDefaultSetting() {
 	updateSetting(int32(TYPE_DEFAULT), "",
 		[]byte("SAMPLE_START,SAMPLE_THROUGH_ALWAYS"),
 		1000000, 120, argsToMap(16, 8, 16, 8, 16, 8, -1, -1, []byte("")))
+}
 
-	return &udpReporter{conn: conn}
+// dialUDP connects to each of the UDP collectors, or the Unix datagram socket
+// of a relay if the address is prefixed by "unix:".
+func dialUDP(addrs []string) ([]net.Conn, error) {
+	var conns []net.Conn
+	for _, addr := range addrs {
+		var conn net.Conn
+		var err error
+		if path := strings.TrimPrefix(addr, unixAddrPrefix); path != addr {
+			conn, err = net.Dial("unixgram", path)
+		} else {
+			var serverAddr *net.UDPAddr
+			serverAddr, err = net.ResolveUDPAddr("udp4", addr)
+			if err == nil {
+				conn, err = net.DialUDP("udp4", nil, serverAddr)
+			}
+		}
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			return nil, errors.Wrap(err, addr)
+		}
+		conns = append(conns, conn)
+	}
+	return conns, nil
+}
+
+// write sends the buffer to all the UDP collectors, returning the first error.
+func (r *udpReporter) write(buf []byte) error {
+	if r.Closed() {
+		return ErrReporterIsClosed
+	}
+	if r.encoder != nil {
+		var err error
+		if buf, err = r.encoder.Encode(buf); err != nil {
+			return err
+		}
+	}
+	var firstErr error
+	for _, conn := range r.conns {
+		if _, err := conn.Write(buf); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
 
 func (r *udpReporter) report(ctx *oboeContext, e *event) error {
@@ -53,22 +103,30 @@ func (r *udpReporter) report(ctx *oboeContext, e *event) error {
 		return err
 	}
 
-	_, err := r.conn.Write((*e).bbuf.GetBuf())
-	return err
+	return r.write((*e).bbuf.GetBuf())
 }
 
-// Shutdown closes the UDP reporter TODO: not supported
+// Shutdown closes the connections to the UDP collectors. The events are sent
+// synchronously so there is nothing to flush.
 func (r *udpReporter) Shutdown(ctx context.Context) error {
-	// return r.conn.Close()
-	return errors.New("not implemented")
+	return r.ShutdownNow()
 }
 
 // ShutdownNow closes the reporter immediately.
-func (r *udpReporter) ShutdownNow() error { return nil }
+func (r *udpReporter) ShutdownNow() error {
+	if !atomic.CompareAndSwapInt32(&r.closed, 0, 1) {
+		return ErrShutdownClosedReporter
+	}
+	for _, conn := range r.conns {
+		conn.Close()
+	}
+	log.Warning("AppOptics APM agent is stopped.")
+	return nil
+}
 
-// Closed returns if the reporter is closed or not TODO: not supported
+// Closed returns if the reporter is closed or not
 func (r *udpReporter) Closed() bool {
-	return false
+	return atomic.LoadInt32(&r.closed) == 1
 }
 
 // WaitForReady waits until the reporter becomes ready or the context is canceled.
@@ -92,8 +150,7 @@ func (r *udpReporter) reportSpan(span metrics.SpanMessage) error {
 	bbuf.AppendBool("hasError", s.HasError)
 	bbuf.AppendInt64("duration", s.Duration.Nanoseconds())
 	bbuf.Finish()
-	_, err := r.conn.Write(bbuf.GetBuf())
-	return err
+	return r.write(bbuf.GetBuf())
 }
 
 func (r *udpReporter) CustomSummaryMetric(name string, value float64, opts metrics.MetricOptions) error {
@@ -0,0 +1,139 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"sync"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+)
+
+// SendFailureStatus describes the consecutive failures of sending data to the
+// collector.
+type SendFailureStatus struct {
+	// Alerting is true once the consecutive failures have reached the
+	// threshold, until the next successful send.
+	Alerting            bool      `json:"alerting"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	LastError           string    `json:"lastError,omitempty"`
+	Since               time.Time `json:"since,omitempty"`
+}
+
+// sendFailures tracks the consecutive send failures of all the connections to
+// the collector, as they usually fail together, e.g. when the collector is not
+// reachable.
+type sendFailures struct {
+	sync.Mutex
+	status   SendFailureStatus
+	callback func(SendFailureStatus)
+	// the alerts raised and cleared but not delivered to the callback yet, and
+	// whether a goroutine is delivering them. They are delivered one by one in
+	// order, so a slow callback never sees a cleared alert before it's raised.
+	pending    []sendFailureAlert
+	delivering bool
+}
+
+type sendFailureAlert struct {
+	cb     func(SendFailureStatus)
+	status SendFailureStatus
+}
+
+var globalSendFailures = &sendFailures{}
+
+// fail records a failed attempt to send data to the collector.
+func (f *sendFailures) fail(name string, err error) {
+	f.Lock()
+	if f.status.ConsecutiveFailures == 0 {
+		f.status.Since = time.Now()
+	}
+	f.status.ConsecutiveFailures++
+	if err != nil {
+		f.status.LastError = err.Error()
+	}
+	if f.status.Alerting || f.status.ConsecutiveFailures < config.GetSendFailureThreshold() {
+		f.Unlock()
+		return
+	}
+	f.status.Alerting = true
+	status := f.status
+	f.notify(status)
+	f.Unlock()
+
+	log.Warningf("[%s] !!! AppOptics is unable to send data to the collector: %d consecutive failures since %s, last error: %s. "+
+		"The traces and metrics are being dropped.",
+		name, status.ConsecutiveFailures, status.Since.Format(time.RFC3339), status.LastError)
+}
+
+// succeed records a successful send and clears the alert, if any.
+func (f *sendFailures) succeed(name string) {
+	f.Lock()
+	if f.status.ConsecutiveFailures == 0 {
+		f.Unlock()
+		return
+	}
+	alerting := f.status.Alerting
+	f.status = SendFailureStatus{}
+	if alerting {
+		f.notify(f.status)
+	}
+	f.Unlock()
+
+	if alerting {
+		log.Warningf("[%s] AppOptics has resumed sending data to the collector.", name)
+	}
+}
+
+// notify queues the status for the callback, if any. It must be called with
+// the lock held so the statuses are queued in the order of the changes.
+func (f *sendFailures) notify(status SendFailureStatus) {
+	if f.callback == nil {
+		return
+	}
+	f.pending = append(f.pending, sendFailureAlert{cb: f.callback, status: status})
+	if !f.delivering {
+		f.delivering = true
+		go f.deliver()
+	}
+}
+
+// deliver calls the callback with the queued statuses until none is left.
+func (f *sendFailures) deliver() {
+	for {
+		f.Lock()
+		if len(f.pending) == 0 {
+			f.delivering = false
+			f.Unlock()
+			return
+		}
+		a := f.pending[0]
+		f.pending = f.pending[1:]
+		f.Unlock()
+
+		a.cb(a.status)
+	}
+}
+
+func (f *sendFailures) get() SendFailureStatus {
+	f.Lock()
+	defer f.Unlock()
+	return f.status
+}
+
+func (f *sendFailures) setCallback(cb func(SendFailureStatus)) {
+	f.Lock()
+	defer f.Unlock()
+	f.callback = cb
+}
+
+// GetSendFailureStatus returns the status of the consecutive send failures.
+func GetSendFailureStatus() SendFailureStatus {
+	return globalSendFailures.get()
+}
+
+// SetSendFailureCallback sets the function called when the send failure alert
+// is raised and when it's cleared.
+func SetSendFailureCallback(cb func(SendFailureStatus)) {
+	globalSendFailures.setCallback(cb)
+}
@@ -0,0 +1,80 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendFailures(t *testing.T) {
+	f := &sendFailures{}
+	alerts := make(chan SendFailureStatus, 2)
+	f.setCallback(func(s SendFailureStatus) { alerts <- s })
+
+	f.succeed("events")
+	assert.Equal(t, SendFailureStatus{}, f.get())
+
+	for i := 1; i < config.GetSendFailureThreshold(); i++ {
+		f.fail("events", errors.New("unavailable"))
+	}
+	s := f.get()
+	assert.False(t, s.Alerting)
+	assert.Equal(t, config.GetSendFailureThreshold()-1, s.ConsecutiveFailures)
+	assert.Equal(t, "unavailable", s.LastError)
+	assert.False(t, s.Since.IsZero())
+
+	// the alert is raised only once
+	f.fail("metrics", errors.New("deadline exceeded"))
+	f.fail("events", errors.New("deadline exceeded"))
+	select {
+	case s = <-alerts:
+		assert.True(t, s.Alerting)
+		assert.Equal(t, config.GetSendFailureThreshold(), s.ConsecutiveFailures)
+		assert.Equal(t, "deadline exceeded", s.LastError)
+	case <-time.After(time.Second):
+		t.Fatal("no alert")
+	}
+	assert.True(t, f.get().Alerting)
+
+	// cleared by a successful send
+	f.succeed("events")
+	select {
+	case s = <-alerts:
+		assert.False(t, s.Alerting)
+	case <-time.After(time.Second):
+		t.Fatal("the alert is not cleared")
+	}
+	assert.Equal(t, SendFailureStatus{}, f.get())
+	assert.Empty(t, alerts)
+}
+
+func TestSendFailuresInOrder(t *testing.T) {
+	f := &sendFailures{}
+	var alerting []bool
+	done := make(chan struct{})
+	f.setCallback(func(s SendFailureStatus) {
+		time.Sleep(time.Millisecond) // a slow callback
+		alerting = append(alerting, s.Alerting)
+		if len(alerting) == 10 {
+			close(done)
+		}
+	})
+
+	for n := 0; n < 5; n++ {
+		for i := 0; i < config.GetSendFailureThreshold(); i++ {
+			f.fail("events", errors.New("unavailable"))
+		}
+		f.succeed("events")
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the alerts are not delivered")
+	}
+	assert.Equal(t, []bool{true, false, true, false, true, false, true, false, true, false}, alerting)
+}
@@ -0,0 +1,96 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/metrics"
+)
+
+// Settings is a snapshot of the sampling settings in effect, which are the
+// settings retrieved from the collector merged with the local configuration.
+type Settings struct {
+	// SampleRate is the sample rate, in the range of 0 to 1000000.
+	SampleRate int
+	// SampleSource is where the sample rate is from: "file" for the local
+	// configuration, "default" or "layer" for the collector.
+	SampleSource string
+	// TracingMode is either "enabled", "disabled", "metrics-only" or "dry-run".
+	TracingMode string
+	// TriggerTrace indicates if trigger trace is enabled.
+	TriggerTrace bool
+	// The capacity and rate (tokens per second) of the token bucket of the
+	// regular requests.
+	BucketCapacity float64
+	BucketRate     float64
+	// The capacity and rate of the token bucket of the trigger trace requests
+	// from authenticated clients.
+	TriggerTraceRelaxedBucketCapacity float64
+	TriggerTraceRelaxedBucketRate     float64
+	// The capacity and rate of the token bucket of the trigger trace requests
+	// from unauthenticated clients.
+	TriggerTraceStrictBucketCapacity float64
+	TriggerTraceStrictBucketRate     float64
+	// Timestamp is the time when the settings were received.
+	Timestamp time.Time
+	// TTL is how long the settings are valid after the Timestamp.
+	TTL time.Duration
+	// MetricsOnly indicates that no requests are sampled, as the tracing mode
+	// is "metrics-only" or "dry-run", or the agent overhead exceeds the budget
+	// (APPOPTICS_OVERHEAD_BUDGET).
+	MetricsOnly bool
+	// MaxTransactions is the max number of the transaction names of a metrics
+	// cycle set by the collector. The names beyond it are reported as "other".
+	MaxTransactions int
+}
+
+// CurrentSettings returns the sampling settings in effect. The second return
+// value is false if no settings have been received from the collector yet.
+func CurrentSettings() (Settings, bool) {
+	s, ok := getSetting("")
+	if !ok {
+		return Settings{}, false
+	}
+
+	mode := config.DisabledTracingMode
+	local := config.GetTracingMode()
+	metricsOnly := local == config.MetricsOnlyTracingMode || local == config.DryRunTracingMode
+	if s.flags.Enabled() {
+		mode = config.EnabledTracingMode
+		if metricsOnly {
+			mode = local
+		}
+	}
+	cs := Settings{
+		SampleRate:      s.value,
+		SampleSource:    s.source.String(),
+		TracingMode:     string(mode),
+		TriggerTrace:    s.flags.TriggerTraceEnabled(),
+		Timestamp:       s.timestamp,
+		TTL:             time.Duration(s.ttl) * time.Second,
+		MetricsOnly:     metricsOnly || overheadBreakerOpen(),
+		MaxTransactions: int(metrics.TransactionLimit()),
+	}
+	cs.BucketRate, cs.BucketCapacity = s.bucket.rateCap()
+	cs.TriggerTraceRelaxedBucketRate, cs.TriggerTraceRelaxedBucketCapacity = s.triggerTraceRelaxedBucket.rateCap()
+	cs.TriggerTraceStrictBucketRate, cs.TriggerTraceStrictBucketCapacity = s.triggerTraceStrictBucket.rateCap()
+	return cs, true
+}
+
+// String returns the name of the sample source.
+func (s sampleSource) String() string {
+	switch s {
+	case SAMPLE_SOURCE_NONE:
+		return "none"
+	case SAMPLE_SOURCE_FILE:
+		return "file"
+	case SAMPLE_SOURCE_DEFAULT:
+		return "default"
+	case SAMPLE_SOURCE_LAYER:
+		return "layer"
+	default:
+		return "unset"
+	}
+}
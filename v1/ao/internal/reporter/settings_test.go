@@ -0,0 +1,48 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCurrentSettings(t *testing.T) {
+	// the local config overrides the remote settings
+	os.Unsetenv("APPOPTICS_SAMPLE_RATE")
+	os.Unsetenv("APPOPTICS_TRACING_MODE")
+	config.Load()
+	r := SetTestReporter(TestReporterSettingType(NoSettingST))
+	defer r.Close(0)
+
+	_, ok := CurrentSettings()
+	assert.False(t, ok)
+
+	updateSetting(int32(TYPE_DEFAULT), "",
+		[]byte("SAMPLE_START,SAMPLE_THROUGH_ALWAYS"),
+		500000, 120, argsToMap(16, 8, 4, 2, 6, 0.1, -1, -1, []byte("")))
+	s, ok := CurrentSettings()
+	assert.True(t, ok)
+	assert.Equal(t, 500000, s.SampleRate)
+	assert.Equal(t, "default", s.SampleSource)
+	assert.Equal(t, "enabled", s.TracingMode)
+	assert.False(t, s.TriggerTrace)
+	assert.Equal(t, 16.0, s.BucketCapacity)
+	assert.Equal(t, 8.0, s.BucketRate)
+	assert.Equal(t, 4.0, s.TriggerTraceRelaxedBucketCapacity)
+	assert.Equal(t, 2.0, s.TriggerTraceRelaxedBucketRate)
+	assert.Equal(t, 6.0, s.TriggerTraceStrictBucketCapacity)
+	assert.Equal(t, 0.1, s.TriggerTraceStrictBucketRate)
+	assert.Equal(t, 120*time.Second, s.TTL)
+	assert.Equal(t, 200, s.MaxTransactions)
+
+	updateSetting(int32(TYPE_DEFAULT), "", []byte("TRIGGER_TRACE"),
+		0, 120, argsToMap(0, 0, 0, 0, 0, 0, -1, -1, []byte("")))
+	s, _ = CurrentSettings()
+	assert.Equal(t, "disabled", s.TracingMode)
+	assert.True(t, s.TriggerTrace)
+}
@@ -0,0 +1,234 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+	"github.com/pkg/errors"
+)
+
+const (
+	spoolFileExt = ".batch"
+	// the extension of the corrupted batches, which are kept for inspection
+	// but never replayed
+	spoolCorruptExt = ".corrupt"
+)
+
+// spool keeps the event batches which failed to be sent in files of a local
+// directory, to be sent again once the collector is reachable. It's bounded by
+// the total size of the files, beyond which the oldest batches are dropped, and
+// the batches older than the TTL are dropped as well.
+type spool struct {
+	dir      string
+	maxBytes int64
+	ttl      time.Duration
+
+	mu  sync.Mutex
+	seq uint64
+	// set while the batches are being replayed
+	replaying int32
+}
+
+// newSpool returns the spool of the directory in the configuration, or nil if
+// spooling is disabled or the directory can't be created.
+func newSpool() *spool {
+	dir := config.GetSpoolDir()
+	if dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Warningf("Event spooling is disabled: %v", err)
+		return nil
+	}
+	return &spool{dir: dir, maxBytes: config.GetSpoolMaxBytes(), ttl: config.GetSpoolTTL()}
+}
+
+// spoolFile is a spooled batch.
+type spoolFile struct {
+	path string
+	size int64
+}
+
+// files returns the spooled batches, the oldest first. The expired ones are
+// removed.
+func (s *spool) files() []spoolFile {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		log.Warningf("Failed to read the spool directory: %v", err)
+		return nil
+	}
+	var files []spoolFile
+	for _, info := range infos {
+		// skip the batches being written
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") || !strings.HasSuffix(info.Name(), spoolFileExt) {
+			continue
+		}
+		path := filepath.Join(s.dir, info.Name())
+		if time.Since(info.ModTime()) > s.ttl {
+			os.Remove(path)
+			continue
+		}
+		files = append(files, spoolFile{path: path, size: info.Size()})
+	}
+	// the names start with the time they're written
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+	return files
+}
+
+// write spools the batch, dropping the oldest batches to keep the total size
+// within the limit.
+func (s *spool) write(msgs [][]byte) error {
+	var size int64
+	for _, m := range msgs {
+		size += int64(4 + len(m))
+	}
+	if size > s.maxBytes {
+		return errors.Errorf("the batch of %d bytes exceeds the spool size", size)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files := s.files()
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+	for len(files) > 0 && total+size > s.maxBytes {
+		os.Remove(files[0].path)
+		total -= files[0].size
+		files = files[1:]
+		log.Info("Dropped the oldest spooled event batch.")
+	}
+
+	s.seq++
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), s.seq%1000000, spoolFileExt)
+	tmp := filepath.Join(s.dir, "."+name)
+	if err := writeBatch(tmp, msgs); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// the batch is visible only once it's completely written
+	return os.Rename(tmp, filepath.Join(s.dir, name))
+}
+
+// writeBatch writes the messages to the file, each prefixed by its length.
+func writeBatch(path string, msgs [][]byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	var l [4]byte
+	for _, m := range msgs {
+		binary.BigEndian.PutUint32(l[:], uint32(len(m)))
+		w.Write(l[:])
+		w.Write(m)
+	}
+	if err = w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readBatch reads the messages written by writeBatch. A length prefix beyond
+// the rest of the file or maxBytes is taken as corruption, so a damaged file
+// can't make it allocate an arbitrary amount of memory.
+func readBatch(path string, maxBytes int64) ([][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	remaining := info.Size()
+	r := bufio.NewReader(f)
+	var msgs [][]byte
+	var l [4]byte
+	for {
+		if _, err = io.ReadFull(r, l[:]); err == io.EOF {
+			return msgs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		remaining -= int64(len(l))
+		size := int64(binary.BigEndian.Uint32(l[:]))
+		if size > remaining || size > maxBytes {
+			return nil, errors.Errorf("invalid message length %d", size)
+		}
+		m := make([]byte, size)
+		if _, err = io.ReadFull(r, m); err != nil {
+			return nil, err
+		}
+		remaining -= size
+		msgs = append(msgs, m)
+	}
+}
+
+// quarantine renames the corrupted batch so it's neither replayed nor counted
+// in the spool size.
+func quarantine(path string) {
+	if err := os.Rename(path, path+spoolCorruptExt); err != nil {
+		os.Remove(path)
+	}
+}
+
+// tryReplay starts replaying the spooled batches in the background, unless a
+// replay is already running.
+func (s *spool) tryReplay(send func([][]byte) error) {
+	if !atomic.CompareAndSwapInt32(&s.replaying, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&s.replaying, 0)
+		s.replay(send)
+	}()
+}
+
+// replay sends the spooled batches by send, the oldest first, and removes the
+// batches sent. It stops at the first batch failed to be sent, which is kept.
+func (s *spool) replay(send func([][]byte) error) {
+	s.mu.Lock()
+	files := s.files()
+	s.mu.Unlock()
+
+	var sent int
+	for _, f := range files {
+		msgs, err := readBatch(f.path, s.maxBytes)
+		if err != nil {
+			// the batch may have been dropped in the meantime
+			if !os.IsNotExist(err) {
+				log.Warningf("Quarantined the corrupted spooled event batch %s: %v", f.path, err)
+				quarantine(f.path)
+			}
+			continue
+		}
+		if err = send(msgs); err != nil {
+			log.Infof("Stopped replaying the spooled event batches: %v", err)
+			break
+		}
+		os.Remove(f.path)
+		sent++
+	}
+	if sent > 0 {
+		log.Infof("Replayed %d spooled event batches.", sent)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSpool(t *testing.T, maxBytes int64) *spool {
+	dir, err := ioutil.TempDir("", "spool")
+	require.NoError(t, err)
+	return &spool{dir: dir, maxBytes: maxBytes, ttl: time.Hour}
+}
+
+func TestSpoolReplay(t *testing.T) {
+	s := newTestSpool(t, 1<<20)
+	defer os.RemoveAll(s.dir)
+
+	assert.NoError(t, s.write([][]byte{[]byte("a1"), []byte("a2")}))
+	assert.NoError(t, s.write([][]byte{[]byte("b1")}))
+	assert.NoError(t, s.write([][]byte{[]byte(""), []byte("c2")}))
+	assert.Len(t, s.files(), 3)
+
+	// the failed batch and the ones after it are kept
+	var sent [][][]byte
+	s.replay(func(msgs [][]byte) error {
+		if len(sent) == 1 {
+			return errors.New("unavailable")
+		}
+		sent = append(sent, msgs)
+		return nil
+	})
+	assert.Equal(t, [][][]byte{{[]byte("a1"), []byte("a2")}}, sent)
+	assert.Len(t, s.files(), 2)
+
+	s.replay(func(msgs [][]byte) error {
+		sent = append(sent, msgs)
+		return nil
+	})
+	assert.Equal(t, [][][]byte{
+		{[]byte("a1"), []byte("a2")},
+		{[]byte("b1")},
+		{[]byte(""), []byte("c2")},
+	}, sent)
+	assert.Empty(t, s.files())
+}
+
+func TestSpoolBounds(t *testing.T) {
+	s := newTestSpool(t, 20)
+	defer os.RemoveAll(s.dir)
+
+	// 4 bytes of length and 4 bytes of data each
+	assert.NoError(t, s.write([][]byte{[]byte("1111")}))
+	assert.NoError(t, s.write([][]byte{[]byte("2222")}))
+	assert.NoError(t, s.write([][]byte{[]byte("3333")}))
+	assert.Error(t, s.write([][]byte{[]byte("too big for the spool")}))
+
+	// the oldest one is dropped
+	var sent []string
+	s.replay(func(msgs [][]byte) error {
+		sent = append(sent, string(msgs[0]))
+		return nil
+	})
+	assert.Equal(t, []string{"2222", "3333"}, sent)
+
+	// the expired ones are dropped
+	assert.NoError(t, s.write([][]byte{[]byte("4444")}))
+	files := s.files()
+	require.Len(t, files, 1)
+	old := time.Now().Add(-2 * time.Hour)
+	assert.NoError(t, os.Chtimes(files[0].path, old, old))
+	assert.Empty(t, s.files())
+
+	// the corrupted ones are quarantined
+	corrupted := filepath.Join(s.dir, "0-0"+spoolFileExt)
+	assert.NoError(t, ioutil.WriteFile(corrupted, []byte{0, 0, 0, 9, 1}, 0600))
+	s.replay(func(msgs [][]byte) error {
+		t.Error("the corrupted batch is sent")
+		return nil
+	})
+	assert.Empty(t, s.files())
+	assert.FileExists(t, corrupted+spoolCorruptExt)
+}
+
+func TestReadBatchInvalidLength(t *testing.T) {
+	s := newTestSpool(t, 20)
+	defer os.RemoveAll(s.dir)
+	path := filepath.Join(s.dir, "batch")
+
+	// beyond the rest of the file
+	assert.NoError(t, ioutil.WriteFile(path, []byte{0xff, 0xff, 0xff, 0xff, 1}, 0600))
+	_, err := readBatch(path, 1<<40)
+	assert.Error(t, err)
+
+	// beyond the spool size
+	assert.NoError(t, writeBatch(path, [][]byte{make([]byte, 32)}))
+	_, err = readBatch(path, s.maxBytes)
+	assert.Error(t, err)
+	msgs, err := readBatch(path, 1<<20)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+}
+
+func TestSpoolTryReplay(t *testing.T) {
+	s := newTestSpool(t, 1<<20)
+	defer os.RemoveAll(s.dir)
+	assert.NoError(t, s.write([][]byte{[]byte("a1")}))
+
+	release := make(chan struct{})
+	var calls int32
+	send := func(msgs [][]byte) error {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return nil
+	}
+	s.tryReplay(send)
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&calls) == 1 }, time.Second, time.Millisecond)
+	// no other replay while one is running
+	s.tryReplay(send)
+	close(release)
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&s.replaying) == 0 }, time.Second, time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.Empty(t, s.files())
+}
+
+func TestNewSpool(t *testing.T) {
+	assert.Nil(t, newSpool())
+
+	dir, err := ioutil.TempDir("", "spool")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	os.Setenv("APPOPTICS_SPOOL_DIR", filepath.Join(dir, "events"))
+	defer os.Unsetenv("APPOPTICS_SPOOL_DIR")
+	defer config.Load()
+	config.Load()
+
+	s := newSpool()
+	require.NotNil(t, s)
+	assert.DirExists(t, s.dir)
+	assert.Equal(t, time.Hour, s.ttl)
+}
+
+func TestSpoolEvents(t *testing.T) {
+	s := newTestSpool(t, 1<<20)
+	defer os.RemoveAll(s.dir)
+	r := &grpcReporter{spool: s}
+
+	// never accepted or not allowed to be sent elsewhere
+	r.spoolEvents([][]byte{[]byte("a1")}, errNoRetryOnErr)
+	r.spoolEvents([][]byte{[]byte("a1")}, errRedirectOutOfRegion)
+	assert.Empty(t, s.files())
+
+	r.spoolEvents([][]byte{[]byte("a1")}, errors.New("unavailable"))
+	assert.Len(t, s.files(), 1)
+}
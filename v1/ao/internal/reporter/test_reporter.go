@@ -22,7 +22,9 @@ type TestReporter struct {
 	UseSettings    bool
 	SettingType    int
 	CaptureMetrics bool
-	ErrorEvents    map[int]bool // whether to drop an event
+	ErrorEvents    map[int]bool          // whether to drop an event
+	CustomMetrics  []metrics.Measurement // the valid custom measurements submitted
+	customLock     sync.Mutex
 	eventCount     int64
 	done           chan int
 	wg             sync.WaitGroup
@@ -288,9 +290,23 @@ func (r *TestReporter) updateSetting() {
 }
 
 func (r *TestReporter) CustomSummaryMetric(name string, value float64, opts metrics.MetricOptions) error {
+	if err := metrics.NewMeasurements(true, 0, metrics.MaxTagsCount).Summary(name, value, opts); err != nil {
+		return err
+	}
+	r.addCustomMetric(metrics.Measurement{Name: name, Tags: opts.Tags, Count: opts.Count, Sum: value, ReportSum: true})
 	return nil
 }
 
 func (r *TestReporter) CustomIncrementMetric(name string, opts metrics.MetricOptions) error {
+	if err := metrics.NewMeasurements(true, 0, metrics.MaxTagsCount).Increment(name, opts); err != nil {
+		return err
+	}
+	r.addCustomMetric(metrics.Measurement{Name: name, Tags: opts.Tags, Count: opts.Count})
 	return nil
 }
+
+func (r *TestReporter) addCustomMetric(m metrics.Measurement) {
+	r.customLock.Lock()
+	defer r.customLock.Unlock()
+	r.CustomMetrics = append(r.CustomMetrics, m)
+}
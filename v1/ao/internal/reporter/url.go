@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
@@ -22,10 +23,8 @@ func init() {
 
 // ReloadURLsConfig reloads the configuration and build the transaction filtering
 // filters and cache.
-// This function is used for testing purpose only. It's not thread-safe.
 func ReloadURLsConfig(filters []config.TransactionFilter) {
 	urls.LoadConfig(filters)
-	urls.cache.Clear()
 }
 
 // urlCache is a cache to store the disabled url patterns
@@ -53,13 +52,39 @@ func (c *urlCache) getURLTrace(url string) (tracingMode, error) {
 // urlFilter defines a URL filter
 type urlFilter interface {
 	match(url string) bool
+	matchMethod(method string) bool
 	tracingMode() tracingMode
 }
 
+// methodSet is the HTTP methods a filter applies to. An empty set matches
+// all the methods.
+type methodSet map[string]struct{}
+
+func newMethodSet(methods []string) methodSet {
+	var s methodSet
+	for _, m := range methods {
+		if s == nil {
+			s = make(methodSet)
+		}
+		s[strings.ToUpper(m)] = struct{}{}
+	}
+	return s
+}
+
+// matchMethod checks if the HTTP method matches the filter
+func (s methodSet) matchMethod(method string) bool {
+	if len(s) == 0 {
+		return true
+	}
+	_, ok := s[strings.ToUpper(method)]
+	return ok
+}
+
 // regexFilter is a regular expression based URL filter
 type regexFilter struct {
 	regex *regexp.Regexp
 	trace tracingMode
+	methodSet
 }
 
 // newRegexFilter creates a new regexFilter instance
@@ -85,20 +110,22 @@ func (f *regexFilter) tracingMode() tracingMode {
 type extensionFilter struct {
 	Exts  map[string]struct{}
 	trace tracingMode
+	methodSet
 }
 
-// newExtensionFilter create a new instance of extensionFilter
+// newExtensionFilter create a new instance of extensionFilter. The extensions
+// are case-insensitive and can be with or without the leading dot.
 func newExtensionFilter(extensions []string, mode tracingMode) *extensionFilter {
 	exts := make(map[string]struct{})
 	for _, ext := range extensions {
-		exts[ext] = struct{}{}
+		exts[strings.ToLower(strings.TrimLeft(ext, "."))] = struct{}{}
 	}
 	return &extensionFilter{Exts: exts, trace: mode}
 }
 
 // match checks if the url matches the filter
 func (f *extensionFilter) match(url string) bool {
-	ext := strings.TrimLeft(filepath.Ext(url), ".")
+	ext := strings.ToLower(strings.TrimLeft(filepath.Ext(url), "."))
 	_, ok := f.Exts[ext]
 	return ok
 }
@@ -109,6 +136,7 @@ func (f *extensionFilter) tracingMode() tracingMode {
 }
 
 type urlFilters struct {
+	sync.RWMutex
 	cache   *urlCache
 	filters []urlFilter
 }
@@ -124,44 +152,60 @@ func (f *urlFilters) LoadConfig(filters []config.TransactionFilter) {
 	f.loadConfig(filters)
 }
 
+// loadConfig replaces the filters and clears the cache of the decisions made by
+// the old ones. It's safe to be called while the requests are being filtered.
 func (f *urlFilters) loadConfig(filters []config.TransactionFilter) {
-	f.filters = nil
-
+	var loaded []urlFilter
 	for _, filter := range filters {
 		if filter.RegEx != "" {
 			re, err := newRegexFilter(filter.RegEx, newTracingMode(filter.Tracing))
 			if err != nil {
-				log.Warningf("Ignore bad regex: %s, error=", filter.RegEx, err.Error())
+				log.Warningf("Ignore bad regex: %s, error=%s", filter.RegEx, err.Error())
+				continue
 			}
-			f.filters = append(f.filters, re)
+			re.methodSet = newMethodSet(filter.Methods)
+			loaded = append(loaded, re)
 		} else {
-			f.filters = append(f.filters,
-				newExtensionFilter(filter.Extensions, newTracingMode(filter.Tracing)))
+			ext := newExtensionFilter(filter.Extensions, newTracingMode(filter.Tracing))
+			ext.methodSet = newMethodSet(filter.Methods)
+			loaded = append(loaded, ext)
 		}
 	}
+
+	f.Lock()
+	defer f.Unlock()
+	f.filters = loaded
+	f.cache.Clear()
 }
 
-// getTracingMode checks if the URL should be traced or not. It returns TRACE_UNKNOWN
-// if the url is not found.
-func (f *urlFilters) getTracingMode(url string) tracingMode {
+// getTracingMode checks if the request of the HTTP method and URL should be
+// traced or not. The method can be empty if unknown, which matches only the
+// filters of all the methods. It returns TRACE_UNKNOWN if the url is not found.
+func (f *urlFilters) getTracingMode(method, url string) tracingMode {
+	f.RLock()
+	defer f.RUnlock()
 	if len(f.filters) == 0 || url == "" {
 		return TRACE_UNKNOWN
 	}
 
-	trace, err := f.cache.getURLTrace(url)
+	key := url
+	if method != "" {
+		key = method + " " + url
+	}
+	trace, err := f.cache.getURLTrace(key)
 	if err == nil {
 		return trace
 	}
 
-	trace = f.lookupTracingMode(url)
-	f.cache.setURLTrace(url, trace)
+	trace = f.lookupTracingMode(method, url)
+	f.cache.setURLTrace(key, trace)
 
 	return trace
 }
 
-func (f *urlFilters) lookupTracingMode(url string) tracingMode {
+func (f *urlFilters) lookupTracingMode(method, url string) tracingMode {
 	for _, filter := range f.filters {
-		if filter.match(url) {
+		if filter.matchMethod(method) && filter.match(url) {
 			return filter.tracingMode()
 		}
 	}
@@ -39,22 +39,39 @@ func TestUrlFilter(t *testing.T) {
 		{Type: "url", Extensions: []string{"png", "jpg"}, Tracing: config.DisabledTracingMode},
 	})
 
-	assert.Equal(t, TRACE_DISABLED, filter.getTracingMode("user123"))
+	assert.Equal(t, TRACE_DISABLED, filter.getTracingMode("", "user123"))
 	assert.Equal(t, int64(1), filter.cache.EntryCount())
 	assert.Equal(t, int64(0), filter.cache.HitCount())
 
-	assert.Equal(t, TRACE_UNKNOWN, filter.getTracingMode("test123"))
+	assert.Equal(t, TRACE_UNKNOWN, filter.getTracingMode("", "test123"))
 	assert.Equal(t, int64(2), filter.cache.EntryCount())
 	assert.Equal(t, int64(2), filter.cache.MissCount())
 
-	assert.Equal(t, TRACE_DISABLED, filter.getTracingMode("user200"))
+	assert.Equal(t, TRACE_DISABLED, filter.getTracingMode("", "user200"))
 	assert.Equal(t, int64(3), filter.cache.EntryCount())
 	assert.Equal(t, int64(0), filter.cache.HitCount())
 
-	assert.Equal(t, TRACE_DISABLED, filter.getTracingMode("user123"))
+	assert.Equal(t, TRACE_DISABLED, filter.getTracingMode("", "user123"))
 	assert.Equal(t, int64(3), filter.cache.EntryCount())
 	assert.Equal(t, int64(1), filter.cache.HitCount())
 
-	assert.Equal(t, TRACE_DISABLED, filter.getTracingMode("http://user.com/eric/avatar.png"))
+	assert.Equal(t, TRACE_DISABLED, filter.getTracingMode("", "http://user.com/eric/avatar.png"))
 	assert.Equal(t, int64(4), filter.cache.EntryCount())
 }
+
+func TestUrlFilterMethods(t *testing.T) {
+	filter := newURLFilters()
+	filter.loadConfig([]config.TransactionFilter{
+		{Type: "url", RegEx: `^/health$`, Tracing: config.DisabledTracingMode, Methods: []string{"get", "HEAD"}},
+		{Type: "url", RegEx: `[`, Tracing: config.DisabledTracingMode},
+		{Type: "url", Extensions: []string{".JS", "css"}, Tracing: config.DisabledTracingMode},
+	})
+	assert.Len(t, filter.filters, 2)
+
+	assert.Equal(t, TRACE_DISABLED, filter.getTracingMode("GET", "/health"))
+	assert.Equal(t, TRACE_DISABLED, filter.getTracingMode("HEAD", "/health"))
+	assert.Equal(t, TRACE_UNKNOWN, filter.getTracingMode("POST", "/health"))
+	assert.Equal(t, TRACE_UNKNOWN, filter.getTracingMode("", "/health"))
+	assert.Equal(t, TRACE_DISABLED, filter.getTracingMode("POST", "/static/app.js"))
+	assert.Equal(t, TRACE_DISABLED, filter.getTracingMode("", "/static/site.CSS"))
+}
@@ -10,6 +10,7 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"unicode/utf8"
 
 	"gopkg.in/mgo.v2/bson"
 )
@@ -79,6 +80,18 @@ func Max(x, y int) int {
 	return y
 }
 
+// Truncate cuts s to at most max bytes without splitting a multi-byte UTF-8
+// character. It returns false if s is not cut.
+func Truncate(s string, max int) (string, bool) {
+	if len(s) <= max {
+		return s, false
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max], true
+}
+
 // Byte2String converts a byte array into a string
 func Byte2String(bs []int8) string {
 	b := make([]byte, len(bs))
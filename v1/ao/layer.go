@@ -1,3 +1,5 @@
+// +build !ao_noop
+
 // Copyright (C) 2016 Librato, Inc. All rights reserved.
 
 package ao
@@ -6,9 +8,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"runtime"
 	"runtime/debug"
 	"sync"
+	"time"
 
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
 )
 
@@ -31,6 +36,8 @@ const (
 	keyErrorClass      = "ErrorClass"
 	keyErrorType       = "ErrorType"
 	keyErrorMsg        = "ErrorMsg"
+	keyGRPCStatus      = "GRPCStatus"
+	keyRetryable       = "Retryable"
 	keyAsync           = "Async"
 	keyLanguage        = "Language"
 	keyFunctionName    = "FunctionName"
@@ -55,10 +62,16 @@ const (
 	keyForwardedProto  = "Forwarded-Proto"
 	keyForwardedPort   = "Forwarded-Port"
 	keyRequestOrigURI  = "Request-Orig-URI"
+	keyProfileName     = "ProfileName"
 )
 
 // Span is used to measure a span of time associated with an activity
 // such as an RPC call, DB query, or method invocation.
+//
+// The spans returned by this package are never nil. A span which is not
+// reporting, e.g., it has ended, it's not sampled or the agent is disabled,
+// is safe to use: its methods do nothing and the child spans it begins are
+// not reporting either, so the code can be instrumented unconditionally.
 type Span interface {
 	// BeginSpan starts a new Span, returning a child of this Span.
 	BeginSpan(spanName string, args ...interface{}) Span
@@ -72,6 +85,15 @@ type Span interface {
 	// Deprecated: BeginProfile exists for historical compatibility and should not be
 	// used, use BeginSpan instead.
 	BeginProfile(profileName string, args ...interface{}) Profile
+
+	// Profile times the execution of fn as a named profile within this Span.
+	// The fn is always called, whether this Span is tracing or not.
+	Profile(profileName string, fn func(), args ...interface{})
+
+	// StartProfile starts a named profile within this Span. The returned Profile
+	// should be closed with End() to stop the timing.
+	StartProfile(profileName string, args ...interface{}) Profile
+
 	// End ends a Span, optionally reporting KV pairs provided by args.
 	End(args ...interface{})
 	// AddEndArgs adds additional KV pairs that will be serialized (and
@@ -84,6 +106,10 @@ type Span interface {
 	// InfoWithOptions reports a new info event with the KVs and options provided
 	InfoWithOptions(opts SpanOptions, args ...interface{})
 
+	// AddEvent reports a named event of this Span. Unlike Info, the attributes
+	// are set by the options, so they can't be dropped by an odd number of args.
+	AddEvent(name string, opts ...EventOption)
+
 	// ErrorWithOpts reports an error with customized options
 	ErrorWithOpts(opts... ErrOpt)
 	// Error reports details about an error (along with a stack trace) for this Span.
@@ -116,14 +142,15 @@ type Span interface {
 	IsReporting() bool
 	addChildEdge(reporter.Context)
 	addProfile(Profile)
+	removeProfile(Profile)
+	addChildTime(time.Duration)
+	breakdown() *breakdown
 	aoContext() reporter.Context
 	ok() bool
 }
 
 // Profile is used to provide micro-benchmarks of named timings inside a Span.
-//
-// Deprecated: Profile exists for historical compatibility and should not be
-// used, use Span instead.
+// It is reported as a pair of profile_entry and profile_exit events.
 type Profile interface {
 	// End ends a Profile, optionally reporting KV pairs provided by args.
 	End(args ...interface{})
@@ -143,6 +170,11 @@ type SpanOptions struct {
 
 	ContextOptions
 	TransactionName string
+
+	// Timestamp overrides the timestamp of the reported event, which is the
+	// time the event is reported by default. It's useful to report events
+	// which happened earlier, e.g., those translated from batched records.
+	Timestamp time.Time
 }
 
 // SpanOpt defines the function type that changes the SpanOptions
@@ -155,8 +187,16 @@ func WithBackTrace() SpanOpt {
 	}
 }
 
+// WithTimestamp returns a function that sets the timestamp of the event
+func WithTimestamp(ts time.Time) SpanOpt {
+	return func(o *SpanOptions) {
+		o.Timestamp = ts
+	}
+}
+
 // BeginSpan starts a new Span, provided a parent context and name. It returns a Span
-// and context bound to the new child Span.
+// and context bound to the new child Span. If the span name is listed in the
+// APPOPTICS_DISABLED_LAYERS config, a no-op Span and the unchanged context are returned.
 func BeginSpan(ctx context.Context, spanName string, args ...interface{}) (Span, context.Context) {
 	return BeginSpanWithOptions(ctx, spanName, SpanOptions{}, args...)
 }
@@ -165,7 +205,10 @@ func BeginSpan(ctx context.Context, spanName string, args ...interface{}) (Span,
 func addKVsFromOpts(opts SpanOptions, args ...interface{}) []interface{} {
 	kvs := args
 	if opts.WithBackTrace {
-		kvs = mergeKVs(args, []interface{}{KeyBackTrace, string(debug.Stack())})
+		kvs = mergeKVs(kvs, []interface{}{KeyBackTrace, string(debug.Stack())})
+	}
+	if !opts.Timestamp.IsZero() {
+		kvs = mergeKVs(kvs, []interface{}{reporter.KeyTimestamp, opts.Timestamp})
 	}
 	return kvs
 }
@@ -197,9 +240,26 @@ func fromKVs(kvs ...interface{}) KVMap {
 
 // BeginSpanWithOptions starts a span with provided options
 func BeginSpanWithOptions(ctx context.Context, spanName string, opts SpanOptions, args ...interface{}) (Span, context.Context) {
+	// the context is returned unchanged so the spans started from it are still
+	// children of the parent span.
+	if config.IsLayerDisabled(spanName) {
+		return nullSpan{}, ctx
+	}
 	kvs := addKVsFromOpts(opts, args...)
-	if parent, ok := fromContext(ctx); ok && parent.ok() { // report span entry from parent context
-		l := newSpan(parent.aoContext().Copy(), spanName, parent, kvs...)
+	parent, ok := fromContext(ctx)
+	var gid uint64
+	var scope *Scope
+	if !ok {
+		parent, gid, scope, ok = scopeParent()
+	}
+	if ok && parent.ok() { // report span entry from parent context
+		if dkvs := deadlineKVs(ctx); dkvs != nil {
+			kvs = mergeKVs(kvs, dkvs)
+		}
+		l := newSpan(ctx, parent.aoContext().Copy(), spanName, parent, kvs...)
+		if scope != nil {
+			registerScopeSpan(l, gid, scope)
+		}
 		return l, newSpanContext(ctx, l)
 	}
 	return nullSpan{}, ctx
@@ -212,9 +272,9 @@ func (s *layerSpan) BeginSpan(spanName string, args ...interface{}) Span {
 
 // BeginSpanWithOptions starts a new child span with provided options
 func (s *layerSpan) BeginSpanWithOptions(spanName string, opts SpanOptions, args ...interface{}) Span {
-	if s.ok() { // copy parent context and report entry from child
+	if s.ok() && !config.IsLayerDisabled(spanName) { // copy parent context and report entry from child
 		kvs := addKVsFromOpts(opts, args...)
-		return newSpan(s.aoCtx.Copy(), spanName, s, kvs...)
+		return newSpan(s.ctx, s.aoCtx.Copy(), spanName, s, kvs...)
 	}
 	return nullSpan{}
 }
@@ -242,15 +302,53 @@ func (s *layerSpan) BeginProfile(profileName string, args ...interface{}) Profil
 	return s.BeginSpan(profileName, args)
 }
 
+// StartProfile starts a named profile to time a code block within this Span. The
+// returned Profile should be closed with End(). A profile which is still open
+// will be ended along with this Span.
+func (s *layerSpan) StartProfile(profileName string, args ...interface{}) Profile {
+	if s.ok() {
+		return newProfile(s.aoCtx.Copy(), profileName, s, args...)
+	}
+	return nullSpan{}
+}
+
+// Profile times the execution of fn as a named profile within this Span, e.g.,
+//   span.Profile("renderTemplate", func() {
+//       // ... do something ...
+//   })
+func (s *layerSpan) Profile(profileName string, fn func(), args ...interface{}) {
+	if fn == nil {
+		return
+	}
+	var p Profile = nullSpan{}
+	if s.ok() {
+		p = newProfile(s.aoCtx.Copy(), profileName, s, args...)
+	}
+	defer p.End()
+	fn()
+}
+
+// endProfiles ends the profiles which are still open. It must not be called
+// with the lock held as the profiles report their edges back to this span.
+func (s *span) endProfiles() {
+	s.lock.RLock()
+	profiles := s.childProfiles
+	s.lock.RUnlock()
+	for _, prof := range profiles {
+		prof.End()
+	}
+}
+
 // End a profiled block or method.
 func (s *span) End(args ...interface{}) {
 	if s.ok() {
+		s.endProfiles()
 		s.lock.Lock()
 		defer s.lock.Unlock()
-		for _, prof := range s.childProfiles {
-			prof.End()
-		}
 		args = append(args, s.endArgs...)
+		if done := contextDone(s.ctx); done != "" {
+			args = append(args, keyContextDone, done)
+		}
 		for _, edge := range s.childEdges { // add Edge KV for each joined child
 			args = append(args, keyEdge, edge)
 		}
@@ -258,9 +356,16 @@ func (s *span) End(args ...interface{}) {
 		s.childEdges = nil // clear child edge list
 		s.endArgs = nil
 		s.ended = true
+		unregisterActiveSpan(s)
+		var d time.Duration
+		if s.bd != nil && s.layerName() != "" { // profiles are timed by their span
+			d = time.Since(s.start)
+			s.bd.add(s.layerName(), selfTime(d, s.childTime))
+		}
 		// add this span's context to list to be used as Edge by parent exit
 		if s.parent != nil && s.parent.ok() {
 			s.parent.addChildEdge(s.aoCtx)
+			s.parent.addChildTime(d)
 		}
 	}
 }
@@ -354,6 +459,9 @@ const (
 const (
 	ErrClassHTTPError = "http error"
 	ErrClassError = "error"
+	// ErrClassClientAborted is the class of the transactions cancelled by
+	// the client, which are not counted as server errors.
+	ErrClassClientAborted = "client aborted"
 )
 
 type ErrOpts struct {
@@ -361,6 +469,17 @@ type ErrOpts struct {
 	Class string
 	Msg string
 	WithBackTrace bool
+	// GRPCStatus is the gRPC status code name, e.g. "Unavailable". It's not
+	// reported if empty.
+	GRPCStatus string
+	// Retryable indicates whether the failed call is safe to be retried. It's
+	// only reported if set by WithErrRetryable.
+	Retryable bool
+	// Timestamp overrides the timestamp of the error event if it's not zero.
+	Timestamp time.Time
+
+	retryableSet bool
+	kvs          []interface{} // the extra KVs of the error event
 }
 
 type ErrOpt func(*ErrOpts)
@@ -389,7 +508,41 @@ func WithErrBackTrace(withBackTrace bool) ErrOpt {
 	}
 }
 
+// WithErrGRPCStatus sets the gRPC status code name of the error.
+func WithErrGRPCStatus(code string) ErrOpt {
+	return func(opts *ErrOpts) {
+		opts.GRPCStatus = code
+	}
+}
+
+// WithErrTimestamp sets the time when the error happened.
+func WithErrTimestamp(ts time.Time) ErrOpt {
+	return func(opts *ErrOpts) {
+		opts.Timestamp = ts
+	}
+}
+
+// WithErrRetryable marks the error as retryable or not.
+func WithErrRetryable(retryable bool) ErrOpt {
+	return func(opts *ErrOpts) {
+		opts.Retryable = retryable
+		opts.retryableSet = true
+	}
+}
+
+// withErrKVs adds the KVs to the error event.
+func withErrKVs(kvs ...interface{}) ErrOpt {
+	return func(opts *ErrOpts) {
+		opts.kvs = append(opts.kvs, kvs...)
+	}
+}
+
 func (s *span) ErrorWithOpts(opts... ErrOpt) {
+	s.reportError(opts...)
+}
+
+// reportError reports the error event and returns the resolved options.
+func (s *span) reportError(opts ...ErrOpt) *ErrOpts {
 	errOpts := &ErrOpts{
 		Type: "exception",
 		Class: "error",
@@ -409,13 +562,26 @@ func (s *span) ErrorWithOpts(opts... ErrOpt) {
 	}
 	
 	if s.ok() {
-		s.aoCtx.ReportEvent(reporter.LabelError, s.layerName(),
+		args := []interface{}{
 			keySpec, "error",
 			keyErrorType, errOpts.Type,
 			keyErrorClass, errOpts.Class,
 			keyErrorMsg, errOpts.Msg,
-			KeyBackTrace, backTrace)
+			KeyBackTrace, backTrace,
+		}
+		if errOpts.GRPCStatus != "" {
+			args = append(args, keyGRPCStatus, errOpts.GRPCStatus)
+		}
+		if errOpts.retryableSet {
+			args = append(args, keyRetryable, errOpts.Retryable)
+		}
+		if !errOpts.Timestamp.IsZero() {
+			args = append(args, reporter.KeyTimestamp, errOpts.Timestamp)
+		}
+		args = append(args, errOpts.kvs...)
+		s.aoCtx.ReportEvent(reporter.LabelError, s.layerName(), args...)
 	}
+	return errOpts
 }
 
 // Error reports an error, distinguished by its class and message
@@ -437,21 +603,35 @@ type span struct {
 	labeler
 	aoCtx         reporter.Context
 	parent        Span
-	childEdges    []string // for reporting in exit event
+	ctx           context.Context // the context the span is started with, if any
+	childEdges    []string        // for reporting in exit event
 	childProfiles []Profile
 	endArgs       []interface{}
-	ended         bool // has exit event been reported?
+	ended         bool   // has exit event been reported?
+	gid           uint64 // the goroutine registered as running the span, if any
+	start         time.Time
+	childTime     time.Duration // the total duration of the ended child spans
+	bd            *breakdown    // shared by the spans of a trace
 	lock          sync.RWMutex
 }
 type layerSpan struct{ span }   // satisfies Span
 type profileSpan struct{ span } // satisfies Profile
 type nullSpan struct{}          // a span that is not tracing; satisfies Span & Profile
 
+// End ends the profile and removes it from the open profiles of its parent.
+func (p *profileSpan) End(args ...interface{}) {
+	p.span.End(args...)
+	if p.parent != nil {
+		p.parent.removeProfile(p)
+	}
+}
+
 func (s nullSpan) BeginSpan(spanName string, args ...interface{}) Span { return nullSpan{} }
 func (s nullSpan) BeginSpanWithOptions(spanName string, opts SpanOptions, args ...interface{}) Span {
 	return nullSpan{}
 }
 func (s nullSpan) BeginProfile(name string, args ...interface{}) Profile { return nullSpan{} }
+func (s nullSpan) StartProfile(name string, args ...interface{}) Profile { return nullSpan{} }
 func (s nullSpan) End(args ...interface{})                               {}
 func (s nullSpan) AddEndArgs(args ...interface{})                        {}
 func (s nullSpan) Error(class, msg string)                               {}
@@ -459,9 +639,13 @@ func (s nullSpan) ErrorWithOpts(opts... ErrOpt) {}
 func (s nullSpan) Err(err error)                                         {}
 func (s nullSpan) Info(args ...interface{})                              {}
 func (s nullSpan) InfoWithOptions(opts SpanOptions, args ...interface{}) {}
+func (s nullSpan) AddEvent(name string, opts ...EventOption)             {}
 func (s nullSpan) IsReporting() bool                                     { return false }
 func (s nullSpan) addChildEdge(reporter.Context)                         {}
 func (s nullSpan) addProfile(Profile)                                    {}
+func (s nullSpan) removeProfile(Profile)                                 {}
+func (s nullSpan) addChildTime(time.Duration)                            {}
+func (s nullSpan) breakdown() *breakdown                                 { return nil }
 func (s nullSpan) ok() bool                                              { return false }
 func (s nullSpan) aoContext() reporter.Context                           { return reporter.NewNullContext() }
 func (s nullSpan) MetadataString() string                                { return "" }
@@ -470,6 +654,11 @@ func (s nullSpan) SetAsync(bool)                                         {}
 func (s nullSpan) SetOperationName(string)                               {}
 func (s nullSpan) SetTransactionName(string) error                       { return nil }
 func (s nullSpan) GetTransactionName() string                            { return "" }
+func (s nullSpan) Profile(name string, fn func(), args ...interface{}) {
+	if fn != nil {
+		fn()
+	}
+}
 
 // is this span still valid (has it timed out, expired, not sampled)
 func (s *span) ok() bool {
@@ -489,12 +678,30 @@ func (s *span) addChildEdge(ctx reporter.Context) {
 	defer s.lock.Unlock()
 	s.childEdges = append(s.childEdges, ctx.MetadataString())
 }
+func (s *span) addChildTime(d time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.childTime += d
+}
+func (s *span) breakdown() *breakdown { return s.bd }
 func (s *span) addProfile(p Profile) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	s.childProfiles = append([]Profile{p}, s.childProfiles...)
 }
 
+// removeProfile forgets an ended profile, so only the open ones are kept.
+func (s *span) removeProfile(p Profile) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, prof := range s.childProfiles {
+		if prof == p {
+			s.childProfiles = append(s.childProfiles[:i], s.childProfiles[i+1:]...)
+			return
+		}
+	}
+}
+
 // labelers help spans choose label and layer names.
 type labeler interface {
 	entryLabel() reporter.Label
@@ -503,8 +710,6 @@ type labeler interface {
 	setName(string)
 }
 type spanLabeler struct{ name string }
-
-// Deprecated: profileLabeler is deprecated, use spanLabeler instead.
 type profileLabeler struct{ name string }
 
 // AO's Span and Profile spans report their layer and label names slightly differently
@@ -513,7 +718,14 @@ func (l spanLabeler) exitLabel() reporter.Label  { return reporter.LabelExit }
 func (l spanLabeler) layerName() string          { return l.name }
 func (l spanLabeler) setName(name string)        { l.name = name }
 
-func newSpan(aoCtx reporter.Context, spanName string, parent Span, args ...interface{}) Span {
+// Profile events are not associated with a layer, the profile name is reported
+// as a KV instead.
+func (l profileLabeler) entryLabel() reporter.Label { return reporter.LabelProfileEntry }
+func (l profileLabeler) exitLabel() reporter.Label  { return reporter.LabelProfileExit }
+func (l profileLabeler) layerName() string          { return "" }
+func (l profileLabeler) setName(name string)        { l.name = name }
+
+func newSpan(ctx context.Context, aoCtx reporter.Context, spanName string, parent Span, args ...interface{}) Span {
 	if spanName == "" {
 		return nullSpan{}
 	}
@@ -522,6 +734,35 @@ func newSpan(aoCtx reporter.Context, spanName string, parent Span, args ...inter
 	if err := aoCtx.ReportEvent(ll.entryLabel(), ll.layerName(), args...); err != nil {
 		return nullSpan{}
 	}
-	return &layerSpan{span: span{aoCtx: aoCtx.Copy(), labeler: ll, parent: parent}}
+	l := &layerSpan{span: span{aoCtx: aoCtx.Copy(), labeler: ll, parent: parent, ctx: ctx,
+		start: time.Now(), bd: parent.breakdown()}}
+	registerActiveSpan(&l.span, l)
+	return l
+
+}
+
+// newProfile reports the profile_entry event and returns a Profile which will be
+// ended (if not yet) by its parent. It must be called directly by the public
+// API so that the caller's function name and source location can be reported.
+func newProfile(aoCtx reporter.Context, profileName string, parent Span, args ...interface{}) Profile {
+	if profileName == "" {
+		return nullSpan{}
+	}
+
+	ll := profileLabeler{profileName}
+	kvs := []interface{}{keyLanguage, "go", keyProfileName, profileName}
+	if pc, file, line, ok := runtime.Caller(2); ok {
+		if f := runtime.FuncForPC(pc); f != nil {
+			kvs = append(kvs, keyFunctionName, f.Name())
+		}
+		kvs = append(kvs, keyFile, file, keyLineNumber, line)
+	}
+	if err := aoCtx.ReportEvent(ll.entryLabel(), ll.layerName(), mergeKVs(kvs, args)...); err != nil {
+		return nullSpan{}
+	}
 
+	p := &profileSpan{span: span{aoCtx: aoCtx.Copy(), labeler: ll, parent: parent,
+		endArgs: []interface{}{keyProfileName, profileName}}}
+	parent.addProfile(p)
+	return p
 }
@@ -1,14 +1,20 @@
+// +build !ao_noop
+
 package ao
 
 import (
 	"context"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/metrics"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -86,6 +92,193 @@ func TestBeginSpan(t *testing.T) {
 	}
 }
 
+func TestEventTimestamp(t *testing.T) {
+	r := reporter.SetTestReporter()
+
+	ts := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	ctx := NewContext(context.Background(), NewTrace("baseSpan"))
+	s, _ := BeginSpan(ctx, "testSpan")
+
+	s.InfoWithOptions(SpanOptions{Timestamp: ts}, "K", "V")
+	s.ErrorWithOpts(WithErrClass("lag"), WithErrMsg("consumer lag"), WithErrTimestamp(ts.Add(time.Second)))
+	s.Info("K", "now")
+
+	s.End()
+	EndTrace(ctx)
+
+	r.Close(7)
+
+	var found int
+	for _, evt := range r.EventBufs {
+		m := make(map[string]interface{})
+		bson.Unmarshal(evt, m)
+		switch {
+		case m["Label"] == "info" && m["K"] == "V":
+			assert.EqualValues(t, ts.UnixNano()/1000, m["Timestamp_u"])
+			found++
+		case m["Label"] == "error":
+			assert.EqualValues(t, ts.Add(time.Second).UnixNano()/1000, m["Timestamp_u"])
+			found++
+		default:
+			assert.True(t, m["Timestamp_u"].(int64) > ts.UnixNano()/1000)
+		}
+	}
+	assert.Equal(t, 2, found)
+}
+
+func TestAddEvent(t *testing.T) {
+	r := reporter.SetTestReporter()
+
+	ts := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	ctx := NewContext(context.Background(), NewTrace("baseSpan"))
+	s, _ := BeginSpan(ctx, "testSpan")
+
+	s.AddEvent("cache miss", WithEventAttribute("key", "user:1"), WithEventAttributes(map[string]interface{}{
+		"shard": 3,
+		"hot":   true,
+	}))
+	s.AddEvent("retry", WithEventError(), WithEventTimestamp(ts))
+
+	s.End()
+	EndTrace(ctx)
+	// no-op on the ended span
+	s.AddEvent("late")
+
+	r.Close(6)
+
+	var found int
+	for _, evt := range r.EventBufs {
+		m := make(map[string]interface{})
+		bson.Unmarshal(evt, m)
+		switch m[keyEventName] {
+		case "cache miss":
+			assert.Equal(t, "info", m["Label"])
+			assert.Equal(t, "testSpan", m["Layer"])
+			assert.Equal(t, "user:1", m["key"])
+			assert.EqualValues(t, 3, m["shard"])
+			assert.Equal(t, true, m["hot"])
+			assert.NotContains(t, m, keyEventError)
+			found++
+		case "retry":
+			assert.Equal(t, true, m[keyEventError])
+			assert.EqualValues(t, ts.UnixNano()/1000, m["Timestamp_u"])
+			found++
+		}
+	}
+	assert.Equal(t, 2, found)
+}
+
+func TestDisabledLayers(t *testing.T) {
+	os.Setenv("APPOPTICS_DISABLED_LAYERS", "redis, memcache")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_DISABLED_LAYERS")
+		config.Load()
+	}()
+
+	r := reporter.SetTestReporter()
+	ctx := NewContext(context.Background(), NewTrace("baseSpan"))
+
+	l, lctx := BeginSpan(ctx, "redis")
+	assert.Equal(t, nullSpan{}, l)
+	assert.Equal(t, ctx, lctx)
+	// the spans started under the disabled layer still belong to the trace
+	child, _ := BeginSpan(lctx, "child")
+	child.End()
+	l.End()
+
+	assert.Equal(t, nullSpan{}, FromContext(ctx).BeginSpan("memcache"))
+	s := FromContext(ctx).BeginSpan("mysql")
+	s.End()
+
+	EndTrace(ctx)
+	r.Close(6)
+
+	layers := make(map[string]int)
+	for _, evt := range r.EventBufs {
+		m := make(map[string]interface{})
+		bson.Unmarshal(evt, m)
+		layers[m["Layer"].(string)]++
+	}
+	assert.Equal(t, map[string]int{"baseSpan": 2, "child": 2, "mysql": 2}, layers)
+}
+
+func TestSpanMessageTraceID(t *testing.T) {
+	// no exemplars by default
+	r := reporter.SetTestReporter()
+	NewTrace("exemplar").End()
+	r.Close(2)
+	assert.Equal(t, 1, len(r.SpanMessages))
+	assert.Empty(t, r.SpanMessages[0].(*metrics.HTTPSpanMessage).TraceID)
+
+	os.Setenv("APPOPTICS_REPORT_EXEMPLARS", "true")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_REPORT_EXEMPLARS")
+		config.Load()
+	}()
+
+	r = reporter.SetTestReporter()
+	tr := NewTrace("exemplar")
+	traceID := tr.LoggableTraceID()
+	tr.End()
+	r.Close(2)
+
+	assert.Equal(t, 1, len(r.SpanMessages))
+	msg := r.SpanMessages[0].(*metrics.HTTPSpanMessage)
+	assert.Equal(t, strings.TrimSuffix(traceID, "-1"), msg.TraceID)
+	assert.Len(t, msg.TraceID, 40)
+	assert.True(t, msg.Sampled)
+}
+
+func TestSpanMessageTransactionType(t *testing.T) {
+	r := reporter.SetTestReporter()
+	tr := NewTrace("consumer")
+	tr.SetTransactionType(TransactionTypeConsumer)
+	tr.End()
+	r.Close(2)
+
+	assert.Equal(t, 1, len(r.SpanMessages))
+	msg := r.SpanMessages[0].(*metrics.HTTPSpanMessage)
+	assert.Equal(t, TransactionTypeConsumer, msg.TransactionType)
+}
+
+func TestSiblingTrace(t *testing.T) {
+	r := reporter.SetTestReporter()
+	tr := NewTrace("request")
+	sibling := NewSiblingTrace(tr, "audit")
+	assert.True(t, sibling.IsReporting())
+	sibling.SetTransactionName("audit")
+	assert.Equal(t, "", tr.GetTransactionName())
+	sibling.End()
+	tr.End()
+	r.Close(6)
+
+	var entries []map[string]interface{}
+	for _, evt := range r.EventBufs {
+		m := make(map[string]interface{})
+		bson.Unmarshal(evt, m)
+		if m["Label"] == "entry" {
+			entries = append(entries, m)
+		}
+	}
+	require.Len(t, entries, 2)
+	root, audit := entries[0], entries[1]
+	assert.Equal(t, "audit", audit["Layer"])
+	assert.Nil(t, audit["Edge"])
+	assert.Equal(t, root["X-Trace"], audit[keySiblingOf])
+	// the same trace ID
+	assert.Equal(t, root["X-Trace"].(string)[:42], audit["X-Trace"].(string)[:42])
+
+	require.Len(t, r.SpanMessages, 2)
+	msg := r.SpanMessages[0].(*metrics.HTTPSpanMessage)
+	assert.True(t, msg.Sibling)
+	assert.Equal(t, "audit", msg.Transaction)
+	assert.False(t, r.SpanMessages[1].(*metrics.HTTPSpanMessage).Sibling)
+
+	assert.False(t, NewSiblingTrace(NewNullTrace(), "audit").IsReporting())
+}
+
 func TestSpanInfo(t *testing.T) {
 	r := reporter.SetTestReporter()
 
@@ -113,6 +306,49 @@ func TestSpanInfo(t *testing.T) {
 	}
 }
 
+func TestSpanProfile(t *testing.T) {
+	r := reporter.SetTestReporter()
+
+	tr := NewTrace("baseSpan")
+	called := false
+	tr.Profile("block", func() { called = true }, "hello", "world")
+	assert.True(t, called)
+
+	// only the open profiles are kept by the span
+	for i := 0; i < 3; i++ {
+		tr.StartProfile("closed").End()
+	}
+	tr.StartProfile("unclosed")
+	assert.Len(t, tr.(*aoTrace).childProfiles, 1)
+	tr.End()
+
+	// fn is called even if the span is not tracing
+	called = false
+	nullSpan{}.Profile("block", func() { called = true })
+	assert.True(t, called)
+
+	r.Close(13)
+
+	profiles := make(map[string]int)
+	for _, evt := range r.EventBufs {
+		m := make(map[string]interface{})
+		bson.Unmarshal(evt, m)
+		switch m["Label"] {
+		case reporter.LabelProfileEntry:
+			assert.Equal(t, "go", m[keyLanguage])
+			assert.Contains(t, m, keyFunctionName)
+			assert.Contains(t, m, keyLineNumber)
+			if m[keyProfileName] == "block" {
+				assert.Equal(t, "world", m["hello"])
+			}
+			profiles[m[keyProfileName].(string)]++
+		case reporter.LabelProfileExit:
+			profiles[m[keyProfileName].(string)]++
+		}
+	}
+	assert.Equal(t, map[string]int{"block": 2, "closed": 6, "unclosed": 2}, profiles)
+}
+
 func TestFromKVs(t *testing.T) {
 	assert.Equal(t, 0, len(fromKVs()))
 	assert.Equal(t, 0, len(fromKVs("hello")))
@@ -197,8 +433,8 @@ func TestWithTransactionFiltering(t *testing.T) {
 
 	// Reload config with transaction filtering settings
 	reporter.ReloadURLsConfig([]config.TransactionFilter{
-		{"url", `test\d{1}`, nil, "disabled"},
-		{"url", "", []string{"jpg"}, "disabled"},
+		{"url", `test\d{1}`, nil, "disabled", nil},
+		{"url", "", []string{"jpg"}, "disabled", nil},
 	})
 
 	// 2. “disabled” transaction settings not matched
@@ -272,8 +508,8 @@ func TestWithTransactionFiltering(t *testing.T) {
 
 	// service level trace mode is disabled
 	reporter.ReloadURLsConfig([]config.TransactionFilter{
-		{"url", `test\d{1}`, nil, "enabled"},
-		{"url", "", []string{"jpg"}, "enabled"},
+		{"url", `test\d{1}`, nil, "enabled", nil},
+		{"url", "", []string{"jpg"}, "enabled", nil},
 	})
 
 	// 9.“enabled” transaction settings not matched
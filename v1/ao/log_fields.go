@@ -0,0 +1,55 @@
+// +build !ao_noop
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"strings"
+)
+
+// The keys of the fields returned by LogFields.
+const (
+	// LogFieldTraceID is the ID of the trace, shared by all its spans.
+	LogFieldTraceID = "ao.trace_id"
+	// LogFieldSpanID is the ID of the last event of the span.
+	LogFieldSpanID = "ao.span_id"
+)
+
+// LogFields returns the fields which correlate a log line with the span bound
+// to ctx: the trace ID (LogFieldTraceID) and the span ID (LogFieldSpanID), in
+// lowercase hex. It returns nil if the span is not sampled, as there is no
+// trace to look up. The contrib/aolog packages add them to the log lines of
+// zap, logrus and zerolog, or they can be added by hand, e.g.,
+//   for k, v := range ao.LogFields(ctx) {
+//       fields[k] = v
+//   }
+func LogFields(ctx context.Context) map[string]string {
+	l, ok := fromContext(ctx)
+	if !ok || !l.IsSampled() {
+		return nil
+	}
+	// 1 byte of header, 20 bytes of task ID, 8 bytes of op ID and 1 byte of flags
+	md := l.MetadataString()
+	if len(md) != 60 {
+		return nil
+	}
+	return map[string]string{
+		LogFieldTraceID: strings.ToLower(md[2:42]),
+		LogFieldSpanID:  strings.ToLower(md[42:58]),
+	}
+}
+
+// IfSampled calls f only if the span bound to ctx is sampled, e.g., to log
+// the details of a request only when there is a trace to look them up with:
+//   ao.IfSampled(ctx, func() {
+//       log.Printf("cart: %+v", cart)
+//   })
+// The contrib/aolog packages lower the log level of the sampled requests in a
+// similar way.
+func IfSampled(ctx context.Context, f func()) {
+	if IsSampled(ctx) {
+		f()
+	}
+}
@@ -0,0 +1,59 @@
+// +build !ao_noop
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogFields(t *testing.T) {
+	r := reporter.SetTestReporter()
+	assert.Nil(t, LogFields(context.Background()))
+
+	ctx := NewContext(context.Background(), NewTrace("logs"))
+	s, sctx := BeginSpan(ctx, "span")
+	fields := LogFields(sctx)
+	md := strings.ToLower(s.MetadataString())
+	assert.Equal(t, map[string]string{
+		LogFieldTraceID: md[2:42],
+		LogFieldSpanID:  md[42:58],
+	}, fields)
+	// the spans of a trace share the trace ID
+	assert.Equal(t, fields[LogFieldTraceID], LogFields(ctx)[LogFieldTraceID])
+	assert.NotEqual(t, fields[LogFieldSpanID], LogFields(ctx)[LogFieldSpanID])
+	s.End()
+	EndTrace(ctx)
+	r.Close(4)
+
+	r = reporter.SetTestReporter(reporter.TestReporterDisableTracing())
+	ctx = NewContext(context.Background(), NewTrace("unsampled"))
+	assert.Nil(t, LogFields(ctx))
+	r.Close(0)
+}
+
+func TestIfSampled(t *testing.T) {
+	var called int
+	f := func() { called++ }
+
+	r := reporter.SetTestReporter()
+	IfSampled(context.Background(), f)
+	assert.Zero(t, called)
+	ctx := NewContext(context.Background(), NewTrace("sampled"))
+	IfSampled(ctx, f)
+	assert.Equal(t, 1, called)
+	EndTrace(ctx)
+	r.Close(2)
+
+	r = reporter.SetTestReporter(reporter.TestReporterDisableTracing())
+	ctx = NewContext(context.Background(), NewTrace("unsampled"))
+	IfSampled(ctx, f)
+	assert.Equal(t, 1, called)
+	r.Close(0)
+}
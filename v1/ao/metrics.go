@@ -1,3 +1,5 @@
+// +build !ao_noop
+
 // Copyright (C) 2019 Librato, Inc. All rights reserved.
 
 package ao
@@ -10,6 +12,27 @@ import (
 // MetricOptions is a struct for the optional parameters of a measurement.
 type MetricOptions = metrics.MetricOptions
 
+// Measurement is a single aggregated measurement.
+type Measurement = metrics.Measurement
+
+// Exemplar links a measurement to a sampled trace which contributed to it. The
+// exemplars are reported only if enabled by APPOPTICS_REPORT_EXEMPLARS.
+type Exemplar = metrics.Exemplar
+
+// FlushedMetrics is the snapshot of the measurements to be sent in a flush cycle.
+type FlushedMetrics = metrics.FlushedMetrics
+
+// LatencyPercentiles is the response time percentiles of a transaction.
+type LatencyPercentiles = metrics.LatencyPercentiles
+
+// TransactionLimitEvent describes a change of the max number of the transaction
+// names of a metrics cycle, or a cycle in which the limit is exceeded.
+type TransactionLimitEvent = metrics.TransactionLimitEvent
+
+// HostMetricsCollector collects the host metrics, i.e., the load and the memory
+// of the host and the memory of the process, reported with the built-in metrics.
+type HostMetricsCollector = metrics.HostMetricsCollector
+
 const (
 	// MaxTagsCount is the maximum number of tags allowed.
 	MaxTagsCount = metrics.MaxTagsCount
@@ -36,3 +59,50 @@ func SummaryMetric(name string, value float64, opts MetricOptions) error {
 func IncrementMetric(name string, opts MetricOptions) error {
 	return reporter.IncrementMetric(name, opts)
 }
+
+// AddMetricsFlushCallback registers a callback which is invoked at each metrics
+// flush with the measurements about to be sent, either the built-in HTTP metrics
+// or the custom ones. It can be used to mirror the metrics aggregated by the agent
+// into other systems. The callback is called from the reporter's background
+// goroutine and should return quickly.
+func AddMetricsFlushCallback(cb func(FlushedMetrics)) {
+	metrics.AddFlushCallback(cb)
+}
+
+// AddTransactionLimitCallback registers a callback which is invoked when the
+// collector changes the max number of the transaction names of a metrics cycle,
+// and at each metrics flush in which the transaction names exceeded the limit,
+// i.e., some of the transactions were reported as "other". It can be used to
+// alert on a naming scheme producing too many distinct transaction names:
+//   ao.AddTransactionLimitCallback(func(e ao.TransactionLimitEvent) {
+//       if e.Overflow {
+//           alert("too many transaction names, the limit is %d", e.Limit)
+//       }
+//   })
+// The current limit is also reported by CurrentSettings.
+func AddTransactionLimitCallback(cb func(TransactionLimitEvent)) {
+	metrics.AddTransactionLimitCallback(cb)
+}
+
+// LatencySnapshot returns the p50/p95/p99 response time of the transaction,
+// or of all the transactions if it's empty, computed from the histograms the
+// agent has collected since the last metrics flush. It can be used to shed
+// load when the latency degrades:
+//   if p, ok := ao.LatencySnapshot("GET /search"); ok && p.P95 > 2*time.Second {
+//       http.Error(w, "overloaded", http.StatusServiceUnavailable)
+//       return
+//   }
+// It returns false if no response time has been recorded in the current cycle,
+// e.g., right after a flush.
+func LatencySnapshot(transaction string) (LatencyPercentiles, bool) {
+	return metrics.GetLatencyPercentiles(transaction)
+}
+
+// SetHostMetricsCollector replaces the collector of the host metrics, which
+// reads /proc on Linux and collects nothing on the other platforms, e.g., on
+// FreeBSD or in the containers with a restricted /proc. Each method of the
+// collector returns false for the metrics it can't tell, which are then not
+// reported. A nil collector restores the default one.
+func SetHostMetricsCollector(c HostMetricsCollector) {
+	metrics.SetHostMetricsCollector(c)
+}
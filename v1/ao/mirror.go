@@ -0,0 +1,81 @@
+// +build !ao_noop
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+)
+
+// TraceMirrorStatus describes the state of the trace mirroring.
+type TraceMirrorStatus = reporter.TraceMirrorStatus
+
+// StartTraceMirror writes one out of every `every` sampled traces to the local
+// directory dir as decoded JSON events (one file per trace), in addition to the
+// normal reporting. It stops automatically after the window, which is capped to
+// one hour. This is for debugging instrumentation issues only.
+func StartTraceMirror(dir string, every int, window time.Duration) error {
+	return reporter.StartTraceMirror(dir, every, window)
+}
+
+// StopTraceMirror stops the trace mirroring started by StartTraceMirror.
+func StopTraceMirror() {
+	reporter.StopTraceMirror()
+}
+
+// GetTraceMirrorStatus returns the current state of the trace mirroring.
+func GetTraceMirrorStatus() TraceMirrorStatus {
+	return reporter.GetTraceMirrorStatus()
+}
+
+// TraceMirrorHandler returns an http.Handler to toggle the trace mirroring at
+// runtime. It's meant to be mounted on an internal status endpoint:
+//   GET    returns the current state
+//   POST   starts the mirroring, with the query parameters every (default
+//          100) and window (e.g. 5m, default 10m)
+//   DELETE stops the mirroring
+// All the methods respond with the state of the mirroring in JSON. The traces
+// are written to the directory set by APPOPTICS_TRACE_MIRROR_DIR, and the
+// mirroring can't be started by the handler if it's not set.
+func TraceMirrorHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			q := r.URL.Query()
+			every, window := 100, 10*time.Minute
+			var err error
+			if s := q.Get("every"); s != "" {
+				if every, err = strconv.Atoi(s); err != nil {
+					http.Error(w, "invalid every: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			if s := q.Get("window"); s != "" {
+				if window, err = time.ParseDuration(s); err != nil {
+					http.Error(w, "invalid window: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			if err = StartTraceMirror(config.GetTraceMirrorDir(), every, window); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		case http.MethodDelete:
+			StopTraceMirror()
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(GetTraceMirrorStatus())
+	})
+}
@@ -0,0 +1,52 @@
+// +build !ao_noop
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTraceMirrorHandler(t *testing.T) {
+	h := TraceMirrorHandler()
+	defer StopTraceMirror()
+
+	// no directory configured
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/mirror?every=1", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	dir, err := ioutil.TempDir("", "ao-mirror")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	os.Setenv("APPOPTICS_TRACE_MIRROR_DIR", dir)
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_TRACE_MIRROR_DIR")
+		config.Load()
+	}()
+
+	// the directory can't be set by the request
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/mirror?every=1&dir=/tmp/other", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var st TraceMirrorStatus
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &st))
+	assert.True(t, st.Enabled)
+	assert.Equal(t, dir, st.Dir)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/mirror", nil))
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &st))
+	assert.False(t, st.Enabled)
+}
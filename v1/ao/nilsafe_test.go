@@ -0,0 +1,90 @@
+// +build !ao_noop
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+// callAll calls each exported method of the interface typ on v with the zero
+// values, e.g., nil functions and maps, as arguments, and fails if any of them
+// panics.
+func callAll(t *testing.T, name string, typ reflect.Type, v interface{}) {
+	for i := 0; i < typ.NumMethod(); i++ {
+		m := typ.Method(i)
+		if m.PkgPath != "" { // unexported
+			continue
+		}
+		var args []reflect.Value
+		for j := 0; j < m.Type.NumIn(); j++ {
+			if m.Type.IsVariadic() && j == m.Type.NumIn()-1 {
+				break
+			}
+			args = append(args, reflect.Zero(m.Type.In(j)))
+		}
+		fn := reflect.ValueOf(v).MethodByName(m.Name)
+		assert.NotPanics(t, func() { fn.Call(args) }, "%s.%s", name, m.Name)
+	}
+}
+
+func TestNilSafeSpans(t *testing.T) {
+	r := reporter.SetTestReporter()
+
+	spanType := reflect.TypeOf((*Span)(nil)).Elem()
+	traceType := reflect.TypeOf((*Trace)(nil)).Elem()
+
+	ended := NewTrace("ended")
+	endedSpan := ended.BeginSpan("span")
+	endedSpan.End()
+	ended.End()
+
+	r.Close(4)
+	r = reporter.SetTestReporter(reporter.TestReporterDisableTracing())
+	defer r.Close(0)
+	unsampled := NewTrace("unsampled")
+	assert.False(t, unsampled.IsSampled())
+
+	for name, s := range map[string]Span{
+		"nullSpan":    nullSpan{},
+		"ended span":  endedSpan,
+		"ended trace": ended,
+		"unsampled":   unsampled.BeginSpan("span"),
+	} {
+		callAll(t, name, spanType, s)
+	}
+	for name, tr := range map[string]Trace{
+		"nullTrace":   &nullTrace{},
+		"null trace":  NewNullTrace(),
+		"ended trace": ended,
+		"unsampled":   unsampled,
+	} {
+		callAll(t, name, traceType, tr)
+	}
+	assert.NotPanics(t, func() { HTTPClientSpan{}.AddHTTPResponse(nil, nil) })
+
+	// the functions taking a nil context
+	assert.NotPanics(t, func() {
+		var ctx context.Context
+		s, sctx := BeginSpan(ctx, "span")
+		s.End()
+		assert.Nil(t, sctx)
+		EndTrace(ctx)
+		Info(ctx, "K", "V")
+		Error(ctx, "class", "msg")
+		Err(ctx, nil)
+		End(ctx)
+		SetTransactionName(ctx, "name")
+		assert.Empty(t, MetadataString(ctx))
+		assert.False(t, IsSampled(ctx))
+		assert.Empty(t, GetTransactionName(ctx))
+		assert.NotNil(t, FromContext(ctx))
+		assert.NotNil(t, TraceFromContext(ctx))
+	})
+}
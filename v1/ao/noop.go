@@ -0,0 +1,634 @@
+// +build ao_noop
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// This file replaces the whole package when built with the ao_noop tag. All the
+// APIs keep their signatures but do nothing, and none of the agent's internal
+// packages are linked into the binary:
+//   go build -tags ao_noop ./...
+
+package ao
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// HTTPHeaderName is a constant for the HTTP header used by AppOptics ("X-Trace") to propagate
+	// the distributed tracing context across HTTP requests.
+	HTTPHeaderName = "X-Trace"
+	// HTTPHeaderXTraceOptions is the header for the trigger trace options.
+	HTTPHeaderXTraceOptions = "X-Trace-Options"
+	// HTTPHeaderXTraceOptionsSignature is the header for the signature of the trigger trace options.
+	HTTPHeaderXTraceOptionsSignature = "X-Trace-Options-Signature"
+	// HTTPHeaderXTraceOptionsResponse is the header of the response to the trigger trace options.
+	HTTPHeaderXTraceOptionsResponse = "X-Trace-Options-Response"
+)
+
+const (
+	MaxCustomTransactionNameLength = 255
+
+	// KeyBackTrace is the key to report current stack trace.
+	KeyBackTrace = "Backtrace"
+
+	// LoggableTraceID is used as the key for log injection.
+	LoggableTraceID = "ao.traceId"
+
+	// MaxTagsCount is the maximum number of tags allowed.
+	MaxTagsCount = 50
+)
+
+type ErrType string
+
+const (
+	ErrTypeException = "exception"
+	ErrTypeStatus    = "status"
+)
+
+// The entry types of non-HTTP traces, see Trace.SetTransactionType.
+const (
+	TransactionTypeGRPC     = "grpc"
+	TransactionTypeConsumer = "consumer"
+	TransactionTypeCron     = "cron"
+)
+
+// error classes
+const (
+	ErrClassHTTPError     = "http error"
+	ErrClassError         = "error"
+	ErrClassClientAborted = "client aborted"
+)
+
+// The measurements submission errors
+var (
+	ErrExceedsTagsCountLimit       = errors.New("exceeds tags count limit")
+	ErrExceedsMetricsCountLimit    = errors.New("exceeds metrics count limit per flush interval")
+	ErrMetricsWithNonPositiveCount = errors.New("metrics with non-positive count")
+)
+
+// KVMap is a map of additional key-value pairs to report along with the event data.
+type KVMap map[string]interface{}
+
+// ContextOptions are the options to continue a trace.
+type ContextOptions struct {
+	MdStr                  string
+	URL                    string
+	Method                 string
+	SampledHint            string
+	XTraceOptions          string
+	XTraceOptionsSignature string
+	CB                     func() KVMap
+}
+
+// SpanOptions defines the options of creating a span
+type SpanOptions struct {
+	WithBackTrace bool
+	ContextOptions
+	TransactionName string
+	Timestamp       time.Time
+}
+
+// SpanOpt defines the function type that changes the SpanOptions
+type SpanOpt func(*SpanOptions)
+
+// WithBackTrace returns a function that sets the WithBackTrace flag
+func WithBackTrace() SpanOpt { return func(o *SpanOptions) { o.WithBackTrace = true } }
+
+// WithTimestamp returns a function that sets the timestamp of the event
+func WithTimestamp(ts time.Time) SpanOpt { return func(o *SpanOptions) { o.Timestamp = ts } }
+
+type ErrOpts struct {
+	Type          ErrType
+	Class         string
+	Msg           string
+	WithBackTrace bool
+	GRPCStatus    string
+	Retryable     bool
+	Timestamp     time.Time
+}
+
+type ErrOpt func(*ErrOpts)
+
+func WithErrType(tp ErrType) ErrOpt { return func(o *ErrOpts) { o.Type = tp } }
+func WithErrClass(c string) ErrOpt  { return func(o *ErrOpts) { o.Class = c } }
+func WithErrMsg(msg string) ErrOpt  { return func(o *ErrOpts) { o.Msg = msg } }
+func WithErrBackTrace(withBackTrace bool) ErrOpt {
+	return func(o *ErrOpts) { o.WithBackTrace = withBackTrace }
+}
+func WithErrGRPCStatus(code string) ErrOpt   { return func(o *ErrOpts) { o.GRPCStatus = code } }
+func WithErrRetryable(retryable bool) ErrOpt { return func(o *ErrOpts) { o.Retryable = retryable } }
+func WithErrTimestamp(ts time.Time) ErrOpt   { return func(o *ErrOpts) { o.Timestamp = ts } }
+
+// EventOptions defines the options of a span event
+type EventOptions struct {
+	Timestamp  time.Time
+	Attributes map[string]interface{}
+	Error      bool
+}
+
+// EventOption defines the function type that changes the EventOptions
+type EventOption func(*EventOptions)
+
+func WithEventTimestamp(ts time.Time) EventOption { return func(o *EventOptions) { o.Timestamp = ts } }
+func WithEventAttribute(key string, value interface{}) EventOption {
+	return func(o *EventOptions) {}
+}
+func WithEventAttributes(attrs map[string]interface{}) EventOption {
+	return func(o *EventOptions) {}
+}
+func WithEventError() EventOption { return func(o *EventOptions) { o.Error = true } }
+
+// Span is used to measure a span of time associated with an activity.
+type Span interface {
+	BeginSpan(spanName string, args ...interface{}) Span
+	BeginSpanWithOptions(spanName string, opts SpanOptions, args ...interface{}) Span
+	BeginProfile(profileName string, args ...interface{}) Profile
+	Profile(profileName string, fn func(), args ...interface{})
+	StartProfile(profileName string, args ...interface{}) Profile
+	End(args ...interface{})
+	AddEndArgs(args ...interface{})
+	Info(args ...interface{})
+	InfoWithOptions(opts SpanOptions, args ...interface{})
+	AddEvent(name string, opts ...EventOption)
+	ErrorWithOpts(opts ...ErrOpt)
+	Error(class, msg string)
+	Err(error)
+	MetadataString() string
+	IsSampled() bool
+	SetAsync(bool)
+	SetOperationName(string)
+	SetTransactionName(string) error
+	GetTransactionName() string
+	IsReporting() bool
+}
+
+// Profile is used to provide micro-benchmarks of named timings inside a Span.
+type Profile interface {
+	End(args ...interface{})
+	Error(class, msg string)
+	Err(error)
+}
+
+// Trace represents the root span of a distributed trace for this request.
+type Trace interface {
+	Span
+	EndCallback(f func() KVMap)
+	ExitMetadata() string
+	SetMethod(method string)
+	SetPath(url string)
+	SetHost(host string)
+	SetStatus(status int)
+	SetTransactionType(typ string)
+	SetStartTime(start time.Time)
+	LoggableTraceID() string
+	HTTPRspHeaders() map[string]string
+	SetHTTPRspHeaders(map[string]string)
+}
+
+// nullTrace implements Trace, Span and Profile doing nothing.
+type nullTrace struct{}
+
+func (nullTrace) BeginSpan(string, ...interface{}) Span                         { return nullTrace{} }
+func (nullTrace) BeginSpanWithOptions(string, SpanOptions, ...interface{}) Span { return nullTrace{} }
+func (nullTrace) BeginProfile(string, ...interface{}) Profile                   { return nullTrace{} }
+func (nullTrace) StartProfile(string, ...interface{}) Profile                   { return nullTrace{} }
+func (nullTrace) End(...interface{})                                            {}
+func (nullTrace) AddEndArgs(...interface{})                                     {}
+func (nullTrace) Info(...interface{})                                           {}
+func (nullTrace) InfoWithOptions(SpanOptions, ...interface{})                   {}
+func (nullTrace) AddEvent(string, ...EventOption)                               {}
+func (nullTrace) ErrorWithOpts(...ErrOpt)                                       {}
+func (nullTrace) Error(string, string)                                          {}
+func (nullTrace) Err(error)                                                     {}
+func (nullTrace) MetadataString() string                                        { return "" }
+func (nullTrace) IsSampled() bool                                               { return false }
+func (nullTrace) SetAsync(bool)                                                 {}
+func (nullTrace) SetOperationName(string)                                       {}
+func (nullTrace) SetTransactionName(string) error                               { return nil }
+func (nullTrace) GetTransactionName() string                                    { return "" }
+func (nullTrace) IsReporting() bool                                             { return false }
+func (nullTrace) EndCallback(func() KVMap)                                      {}
+func (nullTrace) ExitMetadata() string                                          { return "" }
+func (nullTrace) SetMethod(string)                                              {}
+func (nullTrace) SetPath(string)                                                {}
+func (nullTrace) SetHost(string)                                                {}
+func (nullTrace) SetStatus(int)                                                 {}
+func (nullTrace) SetTransactionType(string)                                     {}
+func (nullTrace) SetStartTime(time.Time)                                        {}
+func (nullTrace) LoggableTraceID() string                                       { return "" }
+func (nullTrace) HTTPRspHeaders() map[string]string                             { return nil }
+func (nullTrace) SetHTTPRspHeaders(map[string]string)                           {}
+
+func (nullTrace) Profile(name string, fn func(), args ...interface{}) {
+	if fn != nil {
+		fn()
+	}
+}
+
+func NewTrace(spanName string) Trace                               { return nullTrace{} }
+func NewTraceWithOptions(spanName string, opts SpanOptions) Trace  { return nullTrace{} }
+func NewTraceFromID(spanName, mdStr string, cb func() KVMap) Trace { return nullTrace{} }
+func NewTraceFromIDForURL(spanName, mdStr, url string, cb func() KVMap) Trace {
+	return nullTrace{}
+}
+func NewNullTrace() Trace                            { return nullTrace{} }
+func NewSiblingTrace(t Trace, spanName string) Trace { return nullTrace{} }
+
+func BeginSpan(ctx context.Context, spanName string, args ...interface{}) (Span, context.Context) {
+	return nullTrace{}, ctx
+}
+func BeginSpanWithOptions(ctx context.Context, spanName string, opts SpanOptions,
+	args ...interface{}) (Span, context.Context) {
+	return nullTrace{}, ctx
+}
+func BeginProfile(ctx context.Context, profileName string, args ...interface{}) Profile {
+	return nullTrace{}
+}
+func BeginQuerySpan(ctx context.Context, spanName, query, flavor, remoteHost string,
+	args ...interface{}) Span {
+	return nullTrace{}
+}
+func BeginCacheSpan(ctx context.Context, spanName, op, key, remoteHost string, hit bool,
+	args ...interface{}) Span {
+	return nullTrace{}
+}
+func BeginRemoteURLSpan(ctx context.Context, spanName, remoteURL string, args ...interface{}) Span {
+	return nullTrace{}
+}
+func BeginRPCSpan(ctx context.Context, spanName, protocol, controller, remoteHost string,
+	args ...interface{}) Span {
+	return nullTrace{}
+}
+
+func NewContext(ctx context.Context, t Trace) context.Context   { return ctx }
+func FromContext(ctx context.Context) Span                      { return nullTrace{} }
+func TraceFromContext(ctx context.Context) Trace                { return nullTrace{} }
+func ActiveSpan() Span                                          { return nullTrace{} }
+func End(ctx context.Context, args ...interface{})              {}
+func EndTrace(ctx context.Context)                              {}
+func Info(ctx context.Context, args ...interface{})             {}
+func Error(ctx context.Context, class, msg string)              {}
+func Err(ctx context.Context, err error)                        {}
+func ErrorWithOpts(ctx context.Context, opts ...ErrOpt)         {}
+func MetadataString(ctx context.Context) string                 { return "" }
+func IsSampled(ctx context.Context) bool                        { return false }
+func SetTransactionName(ctx context.Context, name string) error { return nil }
+func GetTransactionName(ctx context.Context) string             { return "" }
+
+// Scope is an empty scope, see StartScope.
+type Scope struct{}
+
+func StartScope(ctx context.Context) *Scope { return &Scope{} }
+func (sc *Scope) End()                      {}
+
+type SpanBuilder struct{}
+
+func NewSpanBuilder(spanName string) *SpanBuilder                        { return &SpanBuilder{} }
+func (b *SpanBuilder) WithKVs(args ...interface{}) *SpanBuilder          { return b }
+func (b *SpanBuilder) WithKVFunc(f func() []interface{}) *SpanBuilder    { return b }
+func (b *SpanBuilder) WithLink(xTraceID string) *SpanBuilder             { return b }
+func (b *SpanBuilder) WithStartTime(start time.Time) *SpanBuilder        { return b }
+func (b *SpanBuilder) Build(ctx context.Context) (Span, context.Context) { return nullTrace{}, ctx }
+
+// HTTPClientSpan is a Span that aids in reporting HTTP client requests.
+type HTTPClientSpan struct{ Span }
+
+func BeginHTTPClientSpan(ctx context.Context, req *http.Request) HTTPClientSpan {
+	return HTTPClientSpan{Span: nullTrace{}}
+}
+func (l HTTPClientSpan) AddHTTPResponse(resp *http.Response, err error) {}
+func NewRoundTripper(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		return http.DefaultTransport
+	}
+	return rt
+}
+
+// HTTPResponseWriter observes an http.ResponseWriter when WriteHeader() or
+// Write() is called to check the status code and response headers.
+type HTTPResponseWriter struct {
+	Writer      http.ResponseWriter
+	StatusCode  int
+	WroteHeader bool
+}
+
+func (w *HTTPResponseWriter) Write(p []byte) (n int, err error) {
+	w.WroteHeader = true
+	return w.Writer.Write(p)
+}
+func (w *HTTPResponseWriter) WriteHeader(status int) {
+	w.StatusCode, w.WroteHeader = status, true
+	w.Writer.WriteHeader(status)
+}
+func (w *HTTPResponseWriter) Header() http.Header { return w.Writer.Header() }
+
+func HTTPHandler(handler func(http.ResponseWriter, *http.Request),
+	opts ...SpanOpt) func(http.ResponseWriter, *http.Request) {
+	return handler
+}
+func SetTransactionNameFunc(f func(r *http.Request) string) {}
+
+func TraceFromHTTPRequestResponse(spanName string, w http.ResponseWriter, r *http.Request,
+	opts ...SpanOpt) (Trace, http.ResponseWriter, *http.Request) {
+	return nullTrace{}, w, r
+}
+
+// MetricOptions is a struct for the optional parameters of a measurement.
+type MetricOptions struct {
+	Count   int
+	HostTag bool
+	Tags    map[string]string
+}
+
+// Measurement is a single aggregated measurement.
+type Measurement struct {
+	Name      string
+	Tags      map[string]string
+	Count     int
+	Sum       float64
+	ReportSum bool
+	Exemplars []Exemplar
+}
+
+// Exemplar links a measurement to a sampled trace which contributed to it.
+type Exemplar struct {
+	TraceID string
+	Value   float64
+}
+
+// FlushedMetrics is the snapshot of the measurements to be sent in a flush cycle.
+type FlushedMetrics struct {
+	IsCustom      bool
+	FlushInterval int32
+	Measurements  []Measurement
+}
+
+// TransactionLimitEvent describes a change or an overflow of the transaction limit.
+type TransactionLimitEvent struct {
+	Limit    int32
+	Previous int32
+	Overflow bool
+}
+
+// HostMetricsCollector collects the host metrics reported with the built-in metrics.
+type HostMetricsCollector interface {
+	Load1() (float64, bool)
+	TotalRAM() (int64, bool)
+	FreeRAM() (int64, bool)
+	ProcessRAM() (int64, bool)
+}
+
+// LatencyPercentiles is the response time percentiles of a transaction.
+type LatencyPercentiles struct {
+	Count int64
+	P50   time.Duration
+	P95   time.Duration
+	P99   time.Duration
+}
+
+// RetriesMetricName is the name of the measurement of the retried calls.
+const RetriesMetricName = "retried_call.duration"
+
+// RetryFunc is an attempt of a retried call, numbered from 1.
+type RetryFunc func(ctx context.Context, attempt int) (backoff time.Duration, err error)
+
+// WithRetries calls fn until it succeeds or gives up, without tracing.
+func WithRetries(ctx context.Context, name string, fn RetryFunc) error {
+	for attempt := 1; ; attempt++ {
+		backoff, err := fn(ctx, attempt)
+		if err == nil || backoff < 0 {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+const (
+	// HTTPHeaderTraceparent is the header of the W3C Trace Context.
+	HTTPHeaderTraceparent = "traceparent"
+	// HTTPHeaderTracestate is the header of the vendor specific data of the W3C Trace Context.
+	HTTPHeaderTracestate = "tracestate"
+)
+
+func W3CTraceContext(md string) (traceparent, tracestate string) { return "", "" }
+func MergeTraceState(md, tracestate string) string               { return tracestate }
+func MetadataFromW3C(traceparent, tracestate string) string      { return "" }
+
+func SummaryMetric(name string, value float64, opts MetricOptions) error { return nil }
+func IncrementMetric(name string, opts MetricOptions) error              { return nil }
+func AddMetricsFlushCallback(cb func(FlushedMetrics))                    {}
+func AddTransactionLimitCallback(cb func(TransactionLimitEvent))         {}
+func SetHostMetricsCollector(c HostMetricsCollector)                     {}
+func LatencySnapshot(transaction string) (LatencyPercentiles, bool) {
+	return LatencyPercentiles{}, false
+}
+
+// IDGenerator generates the task IDs and op IDs of the trace context.
+type IDGenerator interface {
+	TaskID(b []byte) error
+	OpID(b []byte) error
+}
+
+func SetIDGenerator(g IDGenerator) {}
+func TraceIDCollisions() uint64    { return 0 }
+
+// Pressure indicates how saturated the event queue of the agent is.
+type Pressure int
+
+// The pressure levels returned by PressureLevel.
+const (
+	PressureNormal Pressure = iota
+	PressureElevated
+	PressureHigh
+)
+
+func (p Pressure) String() string {
+	switch p {
+	case PressureNormal:
+		return "normal"
+	case PressureElevated:
+		return "elevated"
+	case PressureHigh:
+		return "high"
+	default:
+		return "unknown"
+	}
+}
+
+func PressureLevel() Pressure { return PressureNormal }
+
+// Settings is a snapshot of the sampling settings in effect.
+type Settings struct {
+	SampleRate                        int
+	SampleSource                      string
+	TracingMode                       string
+	TriggerTrace                      bool
+	BucketCapacity                    float64
+	BucketRate                        float64
+	TriggerTraceRelaxedBucketCapacity float64
+	TriggerTraceRelaxedBucketRate     float64
+	TriggerTraceStrictBucketCapacity  float64
+	TriggerTraceStrictBucketRate      float64
+	Timestamp                         time.Time
+	TTL                               time.Duration
+	MetricsOnly                       bool
+	MaxTransactions                   int
+}
+
+func CurrentSettings() (Settings, bool) { return Settings{}, false }
+
+// TraceMirrorStatus describes the state of the trace mirroring.
+type TraceMirrorStatus struct {
+	Enabled bool      `json:"enabled"`
+	Dir     string    `json:"dir,omitempty"`
+	Every   int       `json:"every,omitempty"`
+	Until   time.Time `json:"until,omitempty"`
+	Written int64     `json:"written"`
+}
+
+func StartTraceMirror(dir string, every int, window time.Duration) error {
+	return errors.New("trace mirroring is not available in the ao_noop build")
+}
+func StopTraceMirror()                        {}
+func GetTraceMirrorStatus() TraceMirrorStatus { return TraceMirrorStatus{} }
+func TraceMirrorHandler() http.Handler        { return http.NotFoundHandler() }
+
+// SendFailureStatus describes the consecutive failures of sending data to the
+// collector.
+type SendFailureStatus struct {
+	Alerting            bool      `json:"alerting"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	LastError           string    `json:"lastError,omitempty"`
+	Since               time.Time `json:"since,omitempty"`
+}
+
+func GetSendFailureStatus() SendFailureStatus           { return SendFailureStatus{} }
+func SetSendFailureCallback(cb func(SendFailureStatus)) {}
+func SendFailureHandler() http.Handler                  { return http.NotFoundHandler() }
+
+// TraceTokenField is the suggested name of the form field or the query
+// parameter carrying a trace token.
+const TraceTokenField = "ao_trace_token"
+
+// TraceTokenTTL is how long a trace token is valid for after it's issued.
+const TraceTokenTTL = 24 * time.Hour
+
+func TraceToken(ctx context.Context) string                 { return "" }
+func LinkTraceToken(ctx context.Context, token string) bool { return false }
+
+// Connectivity describes the state of the connection to the collector.
+type Connectivity string
+
+// The connectivity states of ReporterHealth.
+const (
+	ConnectivityConnected    Connectivity = "connected"
+	ConnectivityDisconnected Connectivity = "disconnected"
+	ConnectivityClosed       Connectivity = "closed"
+	ConnectivityUnknown      Connectivity = "unknown"
+)
+
+// ReporterHealth is a snapshot of the reporter's health.
+type ReporterHealth struct {
+	Reporter      string       `json:"reporter"`
+	Connectivity  Connectivity `json:"connectivity"`
+	QueueDepth    int          `json:"queueDepth"`
+	QueueCapacity int          `json:"queueCapacity"`
+	NumSent       int64        `json:"numSent"`
+	NumFailed     int64        `json:"numFailed"`
+	NumOverflowed int64        `json:"numOverflowed"`
+	LastSent      time.Time    `json:"lastSent,omitempty"`
+}
+
+// RateCountTotals are the numbers of the requests for which a token bucket
+// made the sampling decisions.
+type RateCountTotals struct {
+	Requested int64 `json:"requested"`
+	Sampled   int64 `json:"sampled"`
+	Limited   int64 `json:"limited"`
+	Traced    int64 `json:"traced"`
+	Through   int64 `json:"through"`
+}
+
+// The token buckets, i.e., the keys of AgentStats.RateCounts.
+const (
+	RateCountsRegular        = "regular"
+	RateCountsRelaxedTrigger = "triggerRelaxed"
+	RateCountsStrictTrigger  = "triggerStrict"
+)
+
+// AgentStats are the internal counters of the agent.
+type AgentStats struct {
+	ReporterHealth
+	RateCounts               map[string]RateCountTotals `json:"rateCounts"`
+	TransactionNameOverflows int64                      `json:"transactionNameOverflows"`
+}
+
+func GetAgentStats() AgentStats { return AgentStats{ReporterHealth: ReporterStatus()} }
+
+// ExpvarName is the name of the variable published by PublishExpvar.
+const ExpvarName = "appoptics"
+
+func ReporterStatus() ReporterHealth {
+	return ReporterHealth{Reporter: "none", Connectivity: ConnectivityClosed}
+}
+func PublishExpvar() {}
+
+func WaitForReady(ctx context.Context) bool { return false }
+func Shutdown(ctx context.Context) error    { return nil }
+func Closed() bool                          { return true }
+func SetLogLevel(level string) error        { return nil }
+func GetLogLevel() string                   { return "" }
+func SetLogOutput(w io.Writer)              {}
+func SetServiceKey(key string)              {}
+
+// Reporter is the transport of the events of a custom reporter.
+type Reporter interface {
+	WriteEvent(buf []byte) error
+	WriteStatus(buf []byte) error
+	Close(ctx context.Context) error
+}
+
+// ReporterFactory creates the transport of a custom reporter.
+type ReporterFactory func() (Reporter, error)
+
+func RegisterReporter(name string, factory ReporterFactory) error { return nil }
+
+// Encoder converts the events into the format of a custom reporter encoding.
+type Encoder interface {
+	Encode(doc []byte) ([]byte, error)
+}
+
+// EncoderFunc is an Encoder by a function.
+type EncoderFunc func(doc []byte) ([]byte, error)
+
+// Encode implements the Encoder interface.
+func (f EncoderFunc) Encode(doc []byte) ([]byte, error) { return f(doc) }
+
+func RegisterEncoder(name string, e Encoder) {}
+
+func ReportAnnotation(title string, kvs KVMap) error { return nil }
+
+// HTTPHeaderXTraceSampled carries the sampling decision of the trace.
+const HTTPHeaderXTraceSampled = "X-Trace-Sampled"
+
+func SampledHeaderValue(md string) string { return "" }
+
+func ReloadConfig() {}
+
+// The keys of the fields returned by LogFields.
+const (
+	LogFieldTraceID = "ao.trace_id"
+	LogFieldSpanID  = "ao.span_id"
+)
+
+func LogFields(ctx context.Context) map[string]string { return nil }
+
+func IfSampled(ctx context.Context, f func()) {}
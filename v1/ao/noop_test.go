@@ -0,0 +1,41 @@
+// +build ao_noop
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNoop(t *testing.T) {
+	tr := ao.NewTrace("test")
+	ctx := ao.NewContext(context.Background(), tr)
+	span, ctx := ao.BeginSpan(ctx, "span", "K", "V")
+	assert.False(t, span.IsSampled())
+	assert.Empty(t, ao.MetadataString(ctx))
+
+	called := false
+	span.Profile("p", func() { called = true })
+	assert.True(t, called)
+	span.Profile("p", nil)
+	span.End()
+	tr.End()
+
+	h := ao.HTTPHandler(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusTeapot, rec.Code)
+	assert.Empty(t, rec.Header().Get(ao.HTTPHeaderName))
+
+	assert.NoError(t, ao.IncrementMetric("m", ao.MetricOptions{}))
+	assert.True(t, ao.Closed())
+}
@@ -0,0 +1,88 @@
+// +build !ao_noop
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"expvar"
+	"sync"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+)
+
+// ReporterHealth is a snapshot of the reporter's health: the depth of the event
+// queue, the numbers of the messages sent, failed to send and overflowed since
+// the process started, the time of the last successful send and the state of
+// the connection to the collector.
+type ReporterHealth = reporter.ReporterHealth
+
+// Connectivity describes the state of the connection to the collector.
+type Connectivity = reporter.Connectivity
+
+// The connectivity states of ReporterHealth.
+const (
+	// ConnectivityConnected means the data is being sent to the collector.
+	ConnectivityConnected = reporter.ConnectivityConnected
+	// ConnectivityDisconnected means the connection is lost or the latest
+	// attempts to send data have failed.
+	ConnectivityDisconnected = reporter.ConnectivityDisconnected
+	// ConnectivityClosed means the agent is shut down or disabled.
+	ConnectivityClosed = reporter.ConnectivityClosed
+	// ConnectivityUnknown is for the reporters which don't talk to the
+	// collector directly, e.g., the UDP and the serverless reporters.
+	ConnectivityUnknown = reporter.ConnectivityUnknown
+)
+
+// ReporterStatus returns the health of the reporter, e.g., to be checked by a
+// readiness probe or shown on an admin page:
+//   if s := ao.ReporterStatus(); s.Connectivity == ao.ConnectivityDisconnected {
+//       log.Printf("AppOptics is disconnected, last sent at %v", s.LastSent)
+//   }
+func ReporterStatus() ReporterHealth {
+	return reporter.GetReporterHealth()
+}
+
+// AgentStats are the internal counters of the agent accumulated since the
+// process started: the reporter health, the sampling decisions made by each
+// token bucket and the transaction name overflows. They are meant to be
+// exported to the monitoring systems other than AppOptics, e.g., Prometheus.
+type AgentStats = reporter.AgentStats
+
+// RateCountTotals are the numbers of the requests for which a token bucket
+// made the sampling decisions.
+type RateCountTotals = reporter.RateCountTotals
+
+// The token buckets, i.e., the keys of AgentStats.RateCounts.
+const (
+	RateCountsRegular        = reporter.RateCountsRegular
+	RateCountsRelaxedTrigger = reporter.RateCountsRelaxedTrigger
+	RateCountsStrictTrigger  = reporter.RateCountsStrictTrigger
+)
+
+// GetAgentStats returns the internal counters of the agent. The rate counts
+// and the transaction name overflows are updated each time the metrics are
+// flushed, i.e., once a minute by default.
+func GetAgentStats() AgentStats {
+	return reporter.GetAgentStats()
+}
+
+// ExpvarName is the name of the variable published by PublishExpvar.
+const ExpvarName = "appoptics"
+
+// PublishExpvar publishes the reporter status as the expvar variable "appoptics",
+// so it's served at /debug/vars along with the other variables of the process.
+// It's safe to call it more than once, concurrently as well.
+func PublishExpvar() {
+	publishExpvarOnce.Do(func() {
+		// the name may be taken by the application already
+		if expvar.Get(ExpvarName) != nil {
+			return
+		}
+		expvar.Publish(ExpvarName, expvar.Func(func() interface{} {
+			return ReporterStatus()
+		}))
+	})
+}
+
+var publishExpvarOnce sync.Once
@@ -0,0 +1,97 @@
+// +build !ao_noop
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+)
+
+// RetriesMetricName is the name of the measurement of the retried calls, which
+// is tagged by the Name, the Status (ok or failed) and the number of Attempts.
+const RetriesMetricName = "retried_call.duration"
+
+const (
+	keyAttempt  = "Attempt"
+	keyAttempts = "Attempts"
+	keyBackoff  = "Backoff"
+)
+
+// RetryFunc is an attempt of a retried call, numbered from 1. It returns nil if
+// the attempt succeeds. Otherwise it returns the time to wait before the next
+// attempt, or a negative duration to give up.
+type RetryFunc func(ctx context.Context, attempt int) (backoff time.Duration, err error)
+
+// WithRetries calls fn until it succeeds or gives up, and returns the error of
+// the last attempt. The retried call is traced as a span named name, with each
+// attempt as a child span reporting the attempt number and the backoff waited
+// before it (in microseconds). The call is measured once no matter how many
+// attempts it takes, so the retries don't look like independent calls.
+//   err := ao.WithRetries(ctx, "payment", func(ctx context.Context, attempt int) (time.Duration, error) {
+//       if err := charge(ctx); err != nil {
+//           return time.Duration(attempt) * 100 * time.Millisecond, err
+//       }
+//       return 0, nil
+//   })
+// The retries stop if ctx is done while waiting for the next attempt.
+func WithRetries(ctx context.Context, name string, fn RetryFunc) error {
+	start := time.Now()
+	span, ctx := BeginSpan(ctx, name)
+
+	var err error
+	var backoff time.Duration
+	attempt := 0
+	for {
+		attempt++
+		attemptSpan, attemptCtx := BeginSpan(ctx, name+"-attempt",
+			keyAttempt, attempt, keyBackoff, int64(backoff/time.Microsecond))
+		backoff, err = fn(attemptCtx, attempt)
+		if err != nil {
+			attemptSpan.Err(err)
+		}
+		attemptSpan.End()
+
+		if err == nil || backoff < 0 || !waitBackoff(ctx, backoff) {
+			break
+		}
+	}
+
+	status := "ok"
+	if err != nil {
+		status = "failed"
+		span.Err(err)
+	}
+	span.End(keyAttempts, attempt)
+
+	if err := SummaryMetric(RetriesMetricName, float64(time.Since(start)/time.Microsecond), MetricOptions{
+		Count: 1,
+		Tags: map[string]string{
+			"Name":     name,
+			"Status":   status,
+			"Attempts": strconv.Itoa(attempt),
+		},
+	}); err != nil {
+		log.Debugf("Failed to record the retried call %s: %v", name, err)
+	}
+	return err
+}
+
+// waitBackoff waits for the backoff and returns false if ctx is done before it.
+func waitBackoff(ctx context.Context, backoff time.Duration) bool {
+	if backoff <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
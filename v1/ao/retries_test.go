@@ -0,0 +1,90 @@
+// +build !ao_noop
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestWithRetries(t *testing.T) {
+	r := reporter.SetTestReporter()
+	ctx := NewContext(context.Background(), NewTrace("retries"))
+
+	errUnavailable := errors.New("unavailable")
+	err := WithRetries(ctx, "payment", func(ctx context.Context, attempt int) (time.Duration, error) {
+		assert.True(t, IsSampled(ctx))
+		if attempt < 3 {
+			return time.Duration(attempt) * time.Millisecond, errUnavailable
+		}
+		return 0, nil
+	})
+	assert.NoError(t, err)
+	EndTrace(ctx)
+	r.Close(12)
+
+	backoffs := map[int]int64{}
+	events := map[string]int{}
+	for _, buf := range r.EventBufs {
+		m := make(map[string]interface{})
+		assert.NoError(t, bson.Unmarshal(buf, m))
+		event := m["Layer"].(string) + " " + m["Label"].(string)
+		events[event]++
+		switch event {
+		case "payment-attempt entry":
+			backoffs[m[keyAttempt].(int)] = m[keyBackoff].(int64)
+		case "payment-attempt error":
+			assert.Equal(t, "unavailable", m["ErrorMsg"])
+		case "payment exit":
+			assert.Equal(t, 3, m[keyAttempts])
+		}
+	}
+	assert.Equal(t, map[string]int{
+		"retries entry": 1, "retries exit": 1,
+		"payment entry": 1, "payment exit": 1,
+		"payment-attempt entry": 3, "payment-attempt error": 2, "payment-attempt exit": 3,
+	}, events)
+	assert.Equal(t, map[int]int64{1: 0, 2: 1000, 3: 2000}, backoffs)
+
+	// the call is measured once
+	require.Len(t, r.CustomMetrics, 1)
+	m := r.CustomMetrics[0]
+	assert.Equal(t, RetriesMetricName, m.Name)
+	assert.Equal(t, 1, m.Count)
+	assert.Equal(t, map[string]string{"Name": "payment", "Status": "ok", "Attempts": "3"}, m.Tags)
+	assert.True(t, m.Sum >= 3000)
+}
+
+func TestWithRetriesGiveUp(t *testing.T) {
+	errFatal := errors.New("fatal")
+	attempts := 0
+	err := WithRetries(context.Background(), "giveUp", func(ctx context.Context, attempt int) (time.Duration, error) {
+		attempts = attempt
+		if attempt == 2 {
+			return -1, errFatal
+		}
+		return 0, errors.New("retry")
+	})
+	assert.Equal(t, errFatal, err)
+	assert.Equal(t, 2, attempts)
+
+	// the retries stop once the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts = 0
+	err = WithRetries(ctx, "cancelled", func(ctx context.Context, attempt int) (time.Duration, error) {
+		attempts = attempt
+		cancel()
+		return time.Hour, errFatal
+	})
+	assert.Equal(t, errFatal, err)
+	assert.Equal(t, 1, attempts)
+}
@@ -0,0 +1,30 @@
+// +build !ao_noop
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"strings"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+)
+
+// HTTPHeaderXTraceSampled carries the sampling decision of the trace, 1 or 0,
+// for the downstream services which don't parse X-Trace. It's only sent and
+// honored if APPOPTICS_SAMPLED_HEADER is enabled.
+const HTTPHeaderXTraceSampled = "X-Trace-Sampled"
+
+// SampledHeaderValue returns the value of the X-Trace-Sampled header of the
+// X-Trace ID, "1" if the trace is sampled or "0" if not. An empty string is
+// returned if the header is not enabled or md is not a valid X-Trace ID.
+func SampledHeaderValue(md string) string {
+	if !config.GetSampledHeader() || len(md) != xTraceLen ||
+		!strings.HasPrefix(md, xTraceVersion) || !isHex(md[2:]) {
+		return ""
+	}
+	if md[xTraceLen-1] == '1' {
+		return "1"
+	}
+	return "0"
+}
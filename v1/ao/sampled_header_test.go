@@ -0,0 +1,59 @@
+// +build !ao_noop
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestSampledHeader(t *testing.T) {
+	assert.Empty(t, SampledHeaderValue(testXTrace))
+
+	os.Setenv("APPOPTICS_SAMPLED_HEADER", "true")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_SAMPLED_HEADER")
+		config.Load()
+	}()
+	assert.Equal(t, "1", SampledHeaderValue(testXTrace))
+	assert.Equal(t, "0", SampledHeaderValue(testXTrace[:58]+"00"))
+	assert.Empty(t, SampledHeaderValue("2B00"))
+
+	var outgoing http.Header
+	handler := HTTPHandler(func(w http.ResponseWriter, req *http.Request) {
+		out, _ := http.NewRequest(http.MethodGet, "http://downstream.example.com", nil)
+		l := BeginHTTPClientSpan(req.Context(), out)
+		l.End()
+		outgoing = out.Header
+	})
+
+	// the upstream decision is followed by a new trace without an edge
+	r := reporter.SetTestReporter()
+	req := httptest.NewRequest(http.MethodGet, "/sampled", nil)
+	req.Header.Set(HTTPHeaderXTraceSampled, "1")
+	handler(httptest.NewRecorder(), req)
+	r.Close(5)
+	assert.Equal(t, "1", outgoing.Get(HTTPHeaderXTraceSampled))
+	var entry map[string]interface{}
+	assert.NoError(t, bson.Unmarshal(r.EventBufs[0], &entry))
+	assert.Equal(t, "entry", entry["Label"])
+	assert.NotContains(t, entry, "Edge")
+
+	r = reporter.SetTestReporter()
+	req = httptest.NewRequest(http.MethodGet, "/sampled", nil)
+	req.Header.Set(HTTPHeaderXTraceSampled, "0")
+	handler(httptest.NewRecorder(), req)
+	r.Close(0)
+	t.Log(outgoing)
+	assert.Equal(t, "0", outgoing.Get(HTTPHeaderXTraceSampled))
+}
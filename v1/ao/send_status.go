@@ -0,0 +1,45 @@
+// +build !ao_noop
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+)
+
+// SendFailureStatus describes the consecutive failures of sending data to the
+// collector. It's alerting once the failures reach the threshold
+// (APPOPTICS_SEND_FAILURE_THRESHOLD), until data is sent successfully again.
+type SendFailureStatus = reporter.SendFailureStatus
+
+// GetSendFailureStatus returns the status of the consecutive send failures.
+func GetSendFailureStatus() SendFailureStatus {
+	return reporter.GetSendFailureStatus()
+}
+
+// SetSendFailureCallback sets the function called, in a separate goroutine,
+// when the send failure alert is raised and when it's cleared, e.g., to page
+// the on-call engineer as the traces and metrics are being dropped:
+//   ao.SetSendFailureCallback(func(s ao.SendFailureStatus) {
+//       if s.Alerting {
+//           alert("AppOptics data is being dropped: " + s.LastError)
+//       }
+//   })
+// Passing nil removes the callback.
+func SetSendFailureCallback(cb func(SendFailureStatus)) {
+	reporter.SetSendFailureCallback(cb)
+}
+
+// SendFailureHandler returns an http.Handler which responds with the send
+// failure status in JSON. It's meant to be mounted on an internal status
+// endpoint.
+func SendFailureHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(GetSendFailureStatus())
+	})
+}
@@ -0,0 +1,124 @@
+// +build !ao_noop
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"bytes"
+	"runtime/pprof"
+	"sync/atomic"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+)
+
+// the KVs of the exit event carrying the profile of a slow request
+const (
+	keyProfile     = "Profile"
+	keyProfileType = "ProfileType"
+)
+
+const (
+	// maxProfileBytes is the max size of the profile attached to the exit
+	// event. The profiles are gzipped by pprof already; a larger one is not
+	// attached as a truncated profile is unreadable.
+	maxProfileBytes = 64 << 10
+	// maxCPUProfileDuration caps the CPU profile of a request which is still
+	// running long after it crossed the threshold.
+	maxCPUProfileDuration = time.Second
+)
+
+// profiling is set while a slow request is being profiled. Only one profile is
+// taken at a time, which is required by the CPU profiler anyway and keeps the
+// overhead bounded when many requests become slow at once.
+var profiling int32
+
+// lastProfile is the time in Unix nanoseconds the last profile was started.
+// The profiles are at least the configured interval apart, so the overhead is
+// also bounded when the requests are slow all the time.
+var lastProfile int64
+
+// slowProfile profiles a request once it has been running for longer than the
+// threshold, while it's still in progress.
+type slowProfile struct {
+	typ   string
+	timer *time.Timer
+	stop  chan struct{} // closed when the request ends
+	done  chan struct{} // closed when the profiling is over, if it started
+	buf   bytes.Buffer
+}
+
+// profileIfSlow arranges the profile of the sampled trace t to be taken once
+// it runs longer than the configured threshold, and attached to its exit event.
+func profileIfSlow(t Trace) {
+	d := config.GetSlowProfileThreshold()
+	if d <= 0 || !t.IsSampled() {
+		return
+	}
+	at, ok := t.(*aoTrace)
+	if !ok {
+		return
+	}
+	p := &slowProfile{
+		typ:  config.GetSlowProfileType(),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	p.timer = time.AfterFunc(d, p.run)
+	at.onEnd(func() { p.attach(t) })
+}
+
+// run takes the profile, unless another one is being taken or the last one was
+// taken too recently.
+func (p *slowProfile) run() {
+	defer close(p.done)
+	if !atomic.CompareAndSwapInt32(&profiling, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&profiling, 0)
+	now := time.Now()
+	if last := atomic.LoadInt64(&lastProfile); last != 0 &&
+		now.Sub(time.Unix(0, last)) < config.GetSlowProfileInterval() {
+		return
+	}
+	atomic.StoreInt64(&lastProfile, now.UnixNano())
+
+	switch p.typ {
+	case config.SlowProfileCPU:
+		if err := pprof.StartCPUProfile(&p.buf); err != nil {
+			// the application is being profiled already
+			log.Debugf("slow request CPU profile skipped: %v", err)
+			return
+		}
+		select {
+		case <-p.stop:
+		case <-time.After(maxCPUProfileDuration):
+		}
+		pprof.StopCPUProfile()
+	default:
+		if err := pprof.Lookup("goroutine").WriteTo(&p.buf, 0); err != nil {
+			log.Debugf("slow request goroutine profile failed: %v", err)
+			p.buf.Reset()
+		}
+	}
+}
+
+// attach is called when the request ends. It stops the profiling and adds the
+// profile taken, if any, to the exit event.
+func (p *slowProfile) attach(t Trace) {
+	if p.timer.Stop() {
+		return // the request is not slow
+	}
+	close(p.stop)
+	<-p.done
+	if p.buf.Len() == 0 {
+		return
+	}
+	if p.buf.Len() > maxProfileBytes {
+		log.Debugf("slow request %s profile of %d bytes is too large to be attached", p.typ, p.buf.Len())
+		return
+	}
+	t.AddEndArgs(keyProfile, p.buf.Bytes(), keyProfileType, p.typ)
+}
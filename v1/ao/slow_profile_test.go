@@ -0,0 +1,71 @@
+// +build !ao_noop
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSlowProfile(typ string) *slowProfile {
+	return &slowProfile{
+		typ:   typ,
+		timer: time.NewTimer(0),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+func TestSlowProfileCPU(t *testing.T) {
+	atomic.StoreInt64(&lastProfile, 0)
+	p := newTestSlowProfile(config.SlowProfileCPU)
+	go p.run()
+	time.Sleep(50 * time.Millisecond)
+	<-p.timer.C
+
+	start := time.Now()
+	close(p.stop)
+	<-p.done
+	assert.True(t, time.Since(start) < maxCPUProfileDuration)
+	assert.NotZero(t, p.buf.Len())
+	assert.Zero(t, atomic.LoadInt32(&profiling))
+}
+
+func TestSlowProfileBusy(t *testing.T) {
+	atomic.StoreInt32(&profiling, 1)
+	defer atomic.StoreInt32(&profiling, 0)
+
+	p := newTestSlowProfile(config.SlowProfileGoroutine)
+	p.run()
+	assert.Zero(t, p.buf.Len())
+	select {
+	case <-p.done:
+	default:
+		t.Fatal("done is not closed")
+	}
+}
+
+func TestSlowProfileInterval(t *testing.T) {
+	atomic.StoreInt64(&lastProfile, 0)
+	defer atomic.StoreInt64(&lastProfile, 0)
+
+	p := newTestSlowProfile(config.SlowProfileGoroutine)
+	p.run()
+	assert.NotZero(t, p.buf.Len())
+
+	// too soon after the last one
+	p = newTestSlowProfile(config.SlowProfileGoroutine)
+	p.run()
+	assert.Zero(t, p.buf.Len())
+
+	atomic.StoreInt64(&lastProfile, time.Now().Add(-time.Hour).UnixNano())
+	p = newTestSlowProfile(config.SlowProfileGoroutine)
+	p.run()
+	assert.NotZero(t, p.buf.Len())
+}
@@ -0,0 +1,90 @@
+// +build !ao_noop
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"time"
+)
+
+const keyLink = "Link"
+
+// SpanBuilder accumulates the name, KVs, links and start time of a span, and
+// only starts the span when Build is called. The KVs which are expensive to
+// produce can be provided by WithKVFunc, which is not called at all if the
+// request is not sampled.
+//   span, ctx := ao.NewSpanBuilder("render").
+//       WithKVs("Template", name).
+//       WithKVFunc(func() []interface{} { return []interface{}{"Params", dump(params)} }).
+//       WithStartTime(queuedAt).
+//       Build(ctx)
+//   defer span.End()
+//
+// A SpanBuilder is not safe for concurrent use.
+type SpanBuilder struct {
+	name    string
+	args    []interface{}
+	argFns  []func() []interface{}
+	links   []string
+	startAt time.Time
+}
+
+// NewSpanBuilder returns a SpanBuilder of the span named spanName.
+func NewSpanBuilder(spanName string) *SpanBuilder {
+	return &SpanBuilder{name: spanName}
+}
+
+// WithKVs adds the KV pairs to the entry event of the span.
+func (b *SpanBuilder) WithKVs(args ...interface{}) *SpanBuilder {
+	b.args = append(b.args, args...)
+	return b
+}
+
+// WithKVFunc adds the KV pairs returned by f to the entry event of the span. f
+// is only called by Build if the span is reported.
+func (b *SpanBuilder) WithKVFunc(f func() []interface{}) *SpanBuilder {
+	if f != nil {
+		b.argFns = append(b.argFns, f)
+	}
+	return b
+}
+
+// WithLink links the span to another span identified by its X-Trace ID, e.g.,
+// the span which enqueued the work this span processes. It's reported as a
+// Link KV of the entry event.
+func (b *SpanBuilder) WithLink(xTraceID string) *SpanBuilder {
+	if xTraceID != "" {
+		b.links = append(b.links, xTraceID)
+	}
+	return b
+}
+
+// WithStartTime sets the time the span starts, which is the time Build is
+// called by default.
+func (b *SpanBuilder) WithStartTime(start time.Time) *SpanBuilder {
+	b.startAt = start
+	return b
+}
+
+// Build starts the span as a child of the span bound to ctx, and returns the
+// span and a context bound to it. Nothing accumulated is evaluated or reported
+// if the request is not sampled, in which case a null span and ctx are returned.
+func (b *SpanBuilder) Build(ctx context.Context) (Span, context.Context) {
+	if !IsSampled(ctx) {
+		return nullSpan{}, ctx
+	}
+
+	args := b.args
+	if len(b.argFns) != 0 || len(b.links) != 0 {
+		args = append([]interface{}(nil), b.args...)
+		for _, f := range b.argFns {
+			args = append(args, f()...)
+		}
+		for _, link := range b.links {
+			args = append(args, keyLink, link)
+		}
+	}
+	return BeginSpanWithOptions(ctx, b.name, SpanOptions{Timestamp: b.startAt}, args...)
+}
@@ -0,0 +1,75 @@
+// +build !ao_noop
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestSpanBuilder(t *testing.T) {
+	r := reporter.SetTestReporter()
+
+	ts := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	tr := NewTrace("baseSpan")
+	ctx := NewContext(context.Background(), tr)
+	link := tr.MetadataString()
+
+	b := NewSpanBuilder("deferred").
+		WithKVs("K1", "V1").
+		WithKVFunc(func() []interface{} { return []interface{}{"K2", "V2"} }).
+		WithLink(link).
+		WithStartTime(ts)
+	s, sCtx := b.Build(ctx)
+	assert.True(t, s.IsReporting())
+	assert.Equal(t, s, FromContext(sCtx))
+	s.End()
+	EndTrace(ctx)
+
+	r.Close(4)
+
+	var found bool
+	for _, evt := range r.EventBufs {
+		m := make(map[string]interface{})
+		bson.Unmarshal(evt, m)
+		if m["Layer"] == "deferred" && m["Label"] == "entry" {
+			assert.Equal(t, "V1", m["K1"])
+			assert.Equal(t, "V2", m["K2"])
+			assert.Equal(t, link, m[keyLink])
+			assert.EqualValues(t, ts.UnixNano()/1000, m["Timestamp_u"])
+			found = true
+		}
+	}
+	assert.True(t, found)
+}
+
+func TestSpanBuilderNotSampled(t *testing.T) {
+	r := reporter.SetTestReporter(reporter.TestReporterDisableTracing())
+
+	ctx := NewContext(context.Background(), NewTrace("baseSpan"))
+	var called bool
+	s, sCtx := NewSpanBuilder("deferred").
+		WithKVFunc(func() []interface{} { called = true; return nil }).
+		Build(ctx)
+	assert.False(t, s.IsReporting())
+	assert.Equal(t, ctx, sCtx)
+	s.End()
+	EndTrace(ctx)
+
+	// nothing is built without a trace in the context either
+	s, _ = NewSpanBuilder("deferred").
+		WithKVFunc(func() []interface{} { called = true; return nil }).
+		Build(context.Background())
+	assert.False(t, s.IsReporting())
+
+	r.Close(0)
+	assert.False(t, called)
+	assert.Empty(t, r.EventBufs)
+}
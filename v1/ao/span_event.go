@@ -0,0 +1,90 @@
+// +build !ao_noop
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+)
+
+// The keys of the span events reported by AddEvent
+const (
+	keyEventName  = "EventName"
+	keyEventError = "EventError"
+)
+
+// EventOptions defines the options of a span event
+type EventOptions struct {
+	// Timestamp overrides the time of the event, which is the time it's added
+	// by default.
+	Timestamp time.Time
+	// Attributes are the KVs reported with the event.
+	Attributes map[string]interface{}
+	// Error flags the event as an error that has been handled, e.g., a failed
+	// attempt which is retried.
+	Error bool
+}
+
+// EventOption defines the function type that changes the EventOptions
+type EventOption func(*EventOptions)
+
+// WithEventTimestamp sets the time when the event happened.
+func WithEventTimestamp(ts time.Time) EventOption {
+	return func(o *EventOptions) {
+		o.Timestamp = ts
+	}
+}
+
+// WithEventAttribute adds an attribute to the event.
+func WithEventAttribute(key string, value interface{}) EventOption {
+	return func(o *EventOptions) {
+		if o.Attributes == nil {
+			o.Attributes = make(map[string]interface{})
+		}
+		o.Attributes[key] = value
+	}
+}
+
+// WithEventAttributes adds the attributes to the event.
+func WithEventAttributes(attrs map[string]interface{}) EventOption {
+	return func(o *EventOptions) {
+		for k, v := range attrs {
+			WithEventAttribute(k, v)(o)
+		}
+	}
+}
+
+// WithEventError flags the event as an error.
+func WithEventError() EventOption {
+	return func(o *EventOptions) {
+		o.Error = true
+	}
+}
+
+// AddEvent reports a named info event of this span with the options, e.g.,
+//   span.AddEvent("cache miss", ao.WithEventAttribute("key", key))
+func (s *layerSpan) AddEvent(name string, opts ...EventOption) {
+	if !s.ok() {
+		return
+	}
+	o := &EventOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	kvs := make([]interface{}, 0, 2*len(o.Attributes)+6)
+	for k, v := range o.Attributes {
+		kvs = append(kvs, k, v)
+	}
+	kvs = append(kvs, keyEventName, name)
+	if o.Error {
+		kvs = append(kvs, keyEventError, true)
+	}
+	if !o.Timestamp.IsZero() {
+		kvs = append(kvs, reporter.KeyTimestamp, o.Timestamp)
+	}
+	s.aoCtx.ReportEvent(reporter.LabelInfo, s.layerName(), kvs...)
+}
@@ -1,9 +1,12 @@
+// +build !ao_noop
+
 // Copyright (C) 2016 Librato, Inc. All rights reserved.
 
 package ao
 
 import (
 	"fmt"
+	"path"
 	"strings"
 	"time"
 
@@ -22,7 +25,8 @@ const (
 // Trace represents the root span of a distributed trace for this request that reports
 // events to AppOptics. The Trace interface extends the Span interface with additional
 // methods that can be used to help categorize a service's inbound requests on the
-// AppOptics service dashboard.
+// AppOptics service dashboard. As with Span, the methods of a Trace which is not
+// reporting do nothing.
 type Trace interface {
 	// Span inherited from the Span interface
 	// BeginSpan(spanName string, args ...interface{}) Span
@@ -61,6 +65,11 @@ type Trace interface {
 	// It is used for categorizing service metrics and traces in AppOptics.
 	SetStatus(status int)
 
+	// SetTransactionType sets the entry type of a non-HTTP trace, e.g.
+	// TransactionTypeConsumer. The inbound metrics of the trace are tagged by
+	// the type instead of the HTTP method and status.
+	SetTransactionType(typ string)
+
 	// SetStartTime sets the start time of a span.
 	SetStartTime(start time.Time)
 
@@ -81,6 +90,13 @@ type Trace interface {
 // details on the key names that AppOptics looks for.
 type KVMap = reporter.KVMap
 
+// The entry types of non-HTTP traces, see Trace.SetTransactionType.
+const (
+	TransactionTypeGRPC     = metrics.TransactionTypeGRPC
+	TransactionTypeConsumer = metrics.TransactionTypeConsumer
+	TransactionTypeCron     = metrics.TransactionTypeCron
+)
+
 // ContextOptions is an alias of the reporter's ContextOptions
 type ContextOptions = reporter.ContextOptions
 
@@ -89,6 +105,9 @@ type traceHTTPSpan struct {
 	start      time.Time
 	controller string
 	action     string
+	// pathTxnName is the transaction name returned by the function set by
+	// SetTransactionNameFunc for the request.
+	pathTxnName string
 }
 
 type aoTrace struct {
@@ -96,6 +115,7 @@ type aoTrace struct {
 	exitEvent      reporter.Event
 	httpSpan       traceHTTPSpan
 	httpRspHeaders map[string]string
+	endHooks       []func() // called before the exit event is reported
 }
 
 func (t *aoTrace) aoContext() reporter.Context { return t.aoCtx }
@@ -131,7 +151,8 @@ func NewTraceWithOptions(spanName string, opts SpanOptions) Trace {
 		return NewNullTrace()
 	}
 	t := &aoTrace{
-		layerSpan:      layerSpan{span: span{aoCtx: ctx, labeler: spanLabeler{spanName}}},
+		layerSpan: layerSpan{span: span{aoCtx: ctx, labeler: spanLabeler{spanName},
+			start: time.Now(), bd: newBreakdown()}},
 		httpRspHeaders: make(map[string]string),
 	}
 
@@ -140,9 +161,47 @@ func NewTraceWithOptions(spanName string, opts SpanOptions) Trace {
 	}
 	t.SetStartTime(time.Now())
 	t.SetHTTPRspHeaders(headers)
+	registerActiveSpan(&t.span, t)
 	return t
 }
 
+// keySiblingOf is the KV of the entry event of a sibling trace, which is the
+// X-Trace ID of the trace it's started from.
+const keySiblingOf = "SiblingOf"
+
+// NewSiblingTrace starts another root span named spanName in the trace of t,
+// for a separate transaction of the same request, e.g., an audit pipeline run
+// alongside the request handling. The sibling trace shares the trace ID and the
+// sampling decision of t, but not its transaction name, HTTP properties or
+// metrics: it's reported as its own transaction, which is left out of the
+// overall request metrics so the request is not counted twice. Name it by
+// SetTransactionName or SetTransactionType:
+//   audit := ao.NewSiblingTrace(ao.TraceFromContext(r.Context()), "audit")
+//   audit.SetTransactionName("audit")
+//   defer audit.End()
+// It returns a no-op trace if t is not an active trace.
+func NewSiblingTrace(t Trace, spanName string) Trace {
+	at, ok := t.(*aoTrace)
+	if !ok || !at.ok() || spanName == "" {
+		return NewNullTrace()
+	}
+	ctx, ok := reporter.NewSiblingContext(at.aoCtx, spanName, func() KVMap {
+		return KVMap{keySiblingOf: at.MetadataString()}
+	})
+	if !ok {
+		return NewNullTrace()
+	}
+	sibling := &aoTrace{
+		layerSpan: layerSpan{span: span{aoCtx: ctx, labeler: spanLabeler{spanName},
+			start: time.Now(), bd: newBreakdown()}},
+		httpRspHeaders: make(map[string]string),
+	}
+	sibling.httpSpan.span.Sibling = true
+	sibling.SetStartTime(time.Now())
+	registerActiveSpan(&sibling.span, sibling)
+	return sibling
+}
+
 // NewTraceFromID creates a new Trace for reporting to AppOptics, provided an
 // incoming trace ID (e.g. from a incoming RPC or service call's "X-Trace" header).
 // If callback is provided & trace is sampled, cb will be called for entry event KVs
@@ -179,6 +238,7 @@ func GetTransactionName(ctx context.Context) string {
 func (t *aoTrace) End(args ...interface{}) {
 	if t.ok() {
 		t.AddEndArgs(args...)
+		t.runEndHooks()
 		t.reportExit()
 		flushAgent()
 	}
@@ -194,11 +254,30 @@ func (t *aoTrace) EndCallback(cb func() KVMap) {
 			}
 			t.AddEndArgs(args...)
 		}
+		t.runEndHooks()
 		t.reportExit()
 		flushAgent()
 	}
 }
 
+// onEnd registers f to be called when the trace ends, before the exit event is
+// reported.
+func (t *aoTrace) onEnd(f func()) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.endHooks = append(t.endHooks, f)
+}
+
+func (t *aoTrace) runEndHooks() {
+	t.lock.Lock()
+	hooks := t.endHooks
+	t.endHooks = nil
+	t.lock.Unlock()
+	for _, f := range hooks {
+		f()
+	}
+}
+
 // SetStartTime sets the start time of a trace
 func (t *aoTrace) SetStartTime(start time.Time) {
 	t.httpSpan.start = start
@@ -224,8 +303,38 @@ func (t *aoTrace) SetStatus(status int) {
 	t.httpSpan.span.Status = status
 }
 
+// SetTransactionType sets the entry type of a non-HTTP trace
+func (t *aoTrace) SetTransactionType(typ string) {
+	t.httpSpan.span.TransactionType = typ
+}
+
+// ErrorWithOpts reports an error with customized options. The class of the first
+// error is kept for the ErrorCount measurement of the transaction.
+func (t *aoTrace) ErrorWithOpts(opts ...ErrOpt) {
+	errOpts := t.reportError(opts...)
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.httpSpan.span.ErrorClass == "" {
+		t.httpSpan.span.ErrorClass = errOpts.Class
+	}
+}
+
+// Error reports details about an error (along with a stack trace) for this Trace.
+func (t *aoTrace) Error(class, msg string) {
+	t.ErrorWithOpts(WithErrType(ErrTypeException), WithErrClass(class), WithErrMsg(msg), WithErrBackTrace(true))
+}
+
+// Err reports details about error err (along with a stack trace) for this Trace.
+func (t *aoTrace) Err(err error) {
+	if err == nil {
+		return
+	}
+	t.Error(ErrClassError, err.Error())
+}
+
 func (t *aoTrace) reportExit() {
 	if t.ok() {
+		t.endProfiles()
 		t.lock.Lock()
 		defer t.lock.Unlock()
 
@@ -243,6 +352,13 @@ func (t *aoTrace) reportExit() {
 			t.recordHTTPSpan()
 		}
 
+		if done := contextDone(t.ctx); done != "" {
+			t.endArgs = append(t.endArgs, keyContextDone, done)
+		}
+		if t.aoCtx.IsSampled() {
+			self := selfTime(time.Since(t.start), t.childTime)
+			t.endArgs = append(t.endArgs, t.bd.kvs(self)...)
+		}
 		for _, edge := range t.childEdges { // add Edge KV for each joined child
 			t.endArgs = append(t.endArgs, keyEdge, edge)
 		}
@@ -255,6 +371,7 @@ func (t *aoTrace) reportExit() {
 		t.childEdges = nil // clear child edge list
 		t.endArgs = nil
 		t.ended = true
+		unregisterActiveSpan(&t.span)
 	}
 }
 
@@ -304,19 +421,52 @@ func (t *aoTrace) recordHTTPSpan() {
 
 	if t.httpSpan.span.Status >= 500 && t.httpSpan.span.Status < 600 {
 		t.httpSpan.span.HasError = true
+		if t.httpSpan.span.ErrorClass == "" {
+			t.httpSpan.span.ErrorClass = ErrClassHTTPError
+		}
+	}
+
+	// the errors of a request abandoned by the client are not the server's
+	if contextDone(t.ctx) == ctxDoneCanceled {
+		t.httpSpan.span.HasError = false
+		t.httpSpan.span.ErrorClass = ErrClassClientAborted
 	}
 
-	reporter.ReportSpan(&t.httpSpan.span)
+	// link the metrics to this trace if it's sampled and the exemplars are
+	// enabled. The metadata is read from the context directly as the lock is held.
+	if config.GetReportExemplars() && t.aoCtx.IsSampled() {
+		t.httpSpan.span.Sampled = true
+		if md := t.aoCtx.MetadataString(); len(md) >= 42 {
+			t.httpSpan.span.TraceID = md[2:42]
+		}
+	}
+	t.httpSpan.span.DryRunSampled = t.aoCtx.GetDryRunSampled()
+
+	if !isMetricsExcluded(t.httpSpan.span.Transaction) {
+		reporter.ReportSpan(&t.httpSpan.span)
+	}
 
 	// This will add the TransactionName KV into the exit event.
 	t.endArgs = append(t.endArgs, keyTransactionName, t.httpSpan.span.Transaction)
 }
 
+// isMetricsExcluded checks if the transaction matches any of the configured
+// patterns and should be left out of the metrics.
+func isMetricsExcluded(txn string) bool {
+	for _, p := range config.GetMetricsExclusion() {
+		if matched, _ := path.Match(p, txn); matched {
+			return true
+		}
+	}
+	return false
+}
+
 // finalizeTxnName finalizes the transaction name based on the following factors:
 // custom transaction name, action/controller, Path and the value of APPOPTICS_PREPEND_DOMAIN
 func (t *aoTrace) finalizeTxnName(controller string, action string) {
 	// The precedence:
-	// custom transaction name > framework specific transaction naming > controller.action > 1st and 2nd segment of Path
+	// custom transaction name > SetTransactionNameFunc > framework specific transaction naming > controller.action >
+	// 1st and 2nd segment of Path
 	customTxnName := t.aoCtx.GetTransactionName()
 	if config.GetTransactionName() != "" {
 		customTxnName = config.GetTransactionName()
@@ -324,6 +474,8 @@ func (t *aoTrace) finalizeTxnName(controller string, action string) {
 
 	if customTxnName != "" {
 		t.httpSpan.span.Transaction = customTxnName
+	} else if t.httpSpan.pathTxnName != "" {
+		t.httpSpan.span.Transaction = t.httpSpan.pathTxnName
 	} else if t.httpSpan.controller != "" && t.httpSpan.action != "" {
 		t.httpSpan.span.Transaction = t.httpSpan.controller + "." + t.httpSpan.action
 	} else if controller != "" && action != "" {
@@ -390,6 +542,7 @@ func (t *nullTrace) SetMethod(method string)                     {}
 func (t *nullTrace) SetPath(path string)                         {}
 func (t *nullTrace) SetHost(host string)                         {}
 func (t *nullTrace) SetStatus(status int)                        {}
+func (t *nullTrace) SetTransactionType(typ string)               {}
 func (t *nullTrace) LoggableTraceID() string                     { return "" }
 func (t *nullTrace) recordMetrics()                              {}
 func (t *nullTrace) HTTPRspHeaders() map[string]string           { return nil }
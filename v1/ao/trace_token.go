@@ -0,0 +1,93 @@
+// +build !ao_noop
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+)
+
+// TraceTokenField is the suggested name of the form field or the query
+// parameter carrying a trace token.
+const TraceTokenField = "ao_trace_token"
+
+// TraceTokenTTL is how long a trace token is valid for after it's issued.
+const TraceTokenTTL = 24 * time.Hour
+
+// TraceToken returns a token carrying the trace context of ctx, signed by the
+// secret set by APPOPTICS_TRACE_TOKEN_SECRET. It's meant to be embedded in an
+// HTML response, e.g., as a hidden form field, so the trace of the follow-up
+// request can be linked to this one by LinkTraceToken, for the multi-step flows
+// to be tracked as one logical operation:
+//   <input type="hidden" name="ao_trace_token" value="{{ .TraceToken }}">
+// It returns an empty string if the request is not sampled or no secret is set.
+// The token contains only URL-safe characters.
+func TraceToken(ctx context.Context) string {
+	if !IsSampled(ctx) {
+		return ""
+	}
+	secret := config.GetTraceTokenSecret()
+	if secret == "" {
+		return ""
+	}
+	payload := MetadataString(ctx) + "." + strconv.FormatInt(time.Now().Add(TraceTokenTTL).Unix(), 10)
+	return payload + "." + signTraceToken(secret, payload)
+}
+
+// LinkTraceToken links the trace of ctx, e.g., the trace of the follow-up
+// request of a form, to the trace which issued the token, by a Link KV reported
+// when the trace ends. It returns false if the token is not valid, expired or
+// signed by a different secret, in which case nothing is linked.
+//   ao.LinkTraceToken(r.Context(), r.FormValue(ao.TraceTokenField))
+func LinkTraceToken(ctx context.Context, token string) bool {
+	xTraceID, ok := verifyTraceToken(config.GetTraceTokenSecret(), token, time.Now())
+	if !ok {
+		return false
+	}
+	TraceFromContext(ctx).AddEndArgs(keyLink, xTraceID)
+	return true
+}
+
+func signTraceToken(secret, payload string) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// verifyTraceToken returns the X-Trace ID carried by the token if it's signed
+// by the secret and not expired at now.
+func verifyTraceToken(secret, token string, now time.Time) (string, bool) {
+	if secret == "" {
+		return "", false
+	}
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 {
+		return "", false
+	}
+	payload, sig := token[:i], token[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(signTraceToken(secret, payload))) {
+		return "", false
+	}
+	parts := strings.Split(payload, ".")
+	if len(parts) != 2 {
+		return "", false
+	}
+	expiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || now.Unix() > expiry {
+		return "", false
+	}
+	if !reporter.ValidMetadata(parts[0]) {
+		return "", false
+	}
+	return parts[0], true
+}
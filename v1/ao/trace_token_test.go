@@ -0,0 +1,96 @@
+// +build !ao_noop
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestVerifyTraceToken(t *testing.T) {
+	md := "2B7435A9FE510AE86C4A4F8F3C32C5B4E3A1B2C3D4E5F6A7B8C9D0E1F201"
+	now := time.Unix(1600000000, 0)
+	payload := md + ".1600000060"
+	token := payload + "." + signTraceToken("secret", payload)
+
+	id, ok := verifyTraceToken("secret", token, now)
+	assert.True(t, ok)
+	assert.Equal(t, md, id)
+
+	for _, c := range []struct {
+		name, secret, token string
+		now                 time.Time
+	}{
+		{"wrong secret", "other", token, now},
+		{"no secret", "", token, now},
+		{"expired", "secret", token, now.Add(time.Hour)},
+		{"tampered", "secret", strings.Replace(token, "2B74", "2B75", 1), now},
+		{"no signature", "secret", payload, now},
+		{"garbage", "secret", "not-a-token", now},
+	} {
+		_, ok := verifyTraceToken(c.secret, c.token, c.now)
+		assert.False(t, ok, c.name)
+	}
+
+	// a validly signed token carrying an invalid X-Trace ID
+	payload = "abc.1600000060"
+	_, ok = verifyTraceToken("secret", payload+"."+signTraceToken("secret", payload), now)
+	assert.False(t, ok)
+}
+
+func TestTraceTokenLink(t *testing.T) {
+	os.Setenv("APPOPTICS_TRACE_TOKEN_SECRET", "s3cr3t")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_TRACE_TOKEN_SECRET")
+		config.Load()
+	}()
+
+	r := reporter.SetTestReporter()
+	first := NewTrace("form")
+	ctx := NewContext(context.Background(), first)
+	token := TraceToken(ctx)
+	assert.NotEmpty(t, token)
+	first.End()
+
+	second := NewTrace("submit")
+	ctx = NewContext(context.Background(), second)
+	assert.False(t, LinkTraceToken(ctx, token+"x"))
+	assert.True(t, LinkTraceToken(ctx, token))
+	second.End()
+	r.Close(4)
+
+	var linked bool
+	for _, evt := range r.EventBufs {
+		m := make(map[string]interface{})
+		bson.Unmarshal(evt, m)
+		if m["Layer"] == "submit" && m["Label"] == "exit" {
+			assert.Equal(t, strings.SplitN(token, ".", 2)[0], m[keyLink])
+			linked = true
+		}
+	}
+	assert.True(t, linked)
+
+	// no token for the requests not sampled
+	r = reporter.SetTestReporter(reporter.TestReporterDisableTracing())
+	assert.Empty(t, TraceToken(NewContext(context.Background(), NewTrace("form"))))
+	r.Close(0)
+
+	// no token without a secret
+	os.Unsetenv("APPOPTICS_TRACE_TOKEN_SECRET")
+	config.Load()
+	r = reporter.SetTestReporter()
+	assert.Empty(t, TraceToken(NewContext(context.Background(), NewTrace("form"))))
+	assert.False(t, LinkTraceToken(ctx, token))
+	r.Close(0)
+}
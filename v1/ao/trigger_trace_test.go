@@ -1,3 +1,5 @@
+// +build !ao_noop
+
 package ao_test
 
 import (
@@ -230,7 +232,7 @@ func TestTriggerTraceEnabledTracingModeDisabled(t *testing.T) {
 // trigger trace with service/URL based trace filtering
 func TestTriggerTraceWithURLFiltering(t *testing.T) {
 	reporter.ReloadURLsConfig([]config.TransactionFilter{
-		{"url", `hello`, nil, "disabled"}, // trace is disabled for this URL pattern
+		{"url", `hello`, nil, "disabled", nil}, // trace is disabled for this URL pattern
 	})
 
 	r := reporter.SetTestReporter(reporter.TestReporterSettingType(reporter.DefaultST))
@@ -0,0 +1,149 @@
+// +build !ao_noop
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+const (
+	// HTTPHeaderTraceparent is the header of the W3C Trace Context, which is
+	// propagated along with X-Trace to interoperate with the services
+	// instrumented by OpenTelemetry or other W3C compliant tracers.
+	HTTPHeaderTraceparent = "traceparent"
+	// HTTPHeaderTracestate is the header of the vendor specific data of the
+	// W3C Trace Context.
+	HTTPHeaderTracestate = "tracestate"
+)
+
+const (
+	// the key of the tracestate entry carrying the X-Trace ID. The W3C trace ID
+	// holds only the first 16 bytes of the 20-byte task ID, so the full task ID
+	// is kept here to continue the same trace after a W3C-only service.
+	traceStateKey = "ao"
+	// the X-Trace ID of version 2: "2B" + task ID (20 bytes) + op ID (8 bytes) + flags
+	xTraceLen       = 60
+	xTraceVersion   = "2B"
+	traceparentLen  = 55
+	w3cTraceIDLen   = 32
+	w3cParentIDLen  = 16
+	w3cFlagsSampled = 0x01
+	// the maximum number of the list members of tracestate
+	traceStateMaxMembers = 32
+)
+
+// W3CTraceContext converts an X-Trace ID, e.g. the result of MetadataString,
+// to the values of the traceparent and tracestate headers. Empty strings are
+// returned if md is not a valid X-Trace ID.
+func W3CTraceContext(md string) (traceparent, tracestate string) {
+	if len(md) != xTraceLen || !strings.HasPrefix(md, xTraceVersion) || !isHex(md[2:]) {
+		return "", ""
+	}
+	md = strings.ToLower(md)
+	traceparent = "00-" + md[2:2+w3cTraceIDLen] + "-" + md[42:58] + "-" + md[58:60]
+	return traceparent, traceStateKey + "=" + strings.ToUpper(md)
+}
+
+// MergeTraceState returns the value of the tracestate header for the X-Trace ID
+// md, which has the ao entry first, as required for the updated vendor, followed
+// by the entries of the other vendors in tracestate. The old ao entry, if any,
+// is removed. tracestate is returned as is if md is not a valid X-Trace ID.
+func MergeTraceState(md, tracestate string) string {
+	_, entry := W3CTraceContext(md)
+	if entry == "" {
+		return tracestate
+	}
+	members := []string{entry}
+	for _, member := range strings.Split(tracestate, ",") {
+		member = strings.TrimSpace(member)
+		if member == "" || strings.HasPrefix(member, traceStateKey+"=") {
+			continue
+		}
+		if len(members) == traceStateMaxMembers {
+			break
+		}
+		members = append(members, member)
+	}
+	return strings.Join(members, ",")
+}
+
+// MetadataFromW3C converts the values of the traceparent and tracestate
+// headers to an X-Trace ID, or returns an empty string if traceparent is not
+// valid. The task ID is restored from tracestate if it's propagated by this
+// agent, otherwise it's the W3C trace ID padded with zeros.
+func MetadataFromW3C(traceparent, tracestate string) string {
+	traceparent = strings.TrimSpace(traceparent)
+	parts := strings.Split(traceparent, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || !isHex(parts[0]) ||
+		(parts[0] == "00" && (len(parts) != 4 || len(traceparent) != traceparentLen)) {
+		return ""
+	}
+	traceID, parentID, flags := parts[1], parts[2], parts[3]
+	if len(traceID) != w3cTraceIDLen || len(parentID) != w3cParentIDLen || len(flags) != 2 ||
+		!isHex(traceID+parentID+flags) || isZeros(traceID) || isZeros(parentID) {
+		return ""
+	}
+	traceID = strings.ToUpper(traceID)
+
+	taskID := traceID + "00000000"
+	if md := traceStateEntry(tracestate, traceStateKey); len(md) == xTraceLen &&
+		strings.HasPrefix(md, xTraceVersion) && isHex(md[2:]) && strings.ToUpper(md[2:34]) == traceID {
+		taskID = strings.ToUpper(md[2:42])
+	}
+
+	b, _ := hex.DecodeString(flags)
+	xtFlags := "00"
+	if b[0]&w3cFlagsSampled != 0 {
+		xtFlags = "01"
+	}
+	return xTraceVersion + taskID + strings.ToUpper(parentID) + xtFlags
+}
+
+// incomingMetadata returns the X-Trace ID of the request, or the one
+// converted from the W3C Trace Context if there is no X-Trace header.
+func incomingMetadata(h http.Header) string {
+	if md := h.Get(HTTPHeaderName); md != "" {
+		return md
+	}
+	return MetadataFromW3C(h.Get(HTTPHeaderTraceparent), h.Get(HTTPHeaderTracestate))
+}
+
+// setOutgoingHeaders sets both the X-Trace and the W3C Trace Context headers,
+// and X-Trace-Sampled if enabled. The tracestate entries of the other vendors
+// already in h are kept.
+func setOutgoingHeaders(h http.Header, md string) {
+	h.Set(HTTPHeaderName, md)
+	if traceparent, _ := W3CTraceContext(md); traceparent != "" {
+		h.Set(HTTPHeaderTraceparent, traceparent)
+		tracestate := strings.Join(h[textproto.CanonicalMIMEHeaderKey(HTTPHeaderTracestate)], ",")
+		h.Set(HTTPHeaderTracestate, MergeTraceState(md, tracestate))
+	}
+	if sampled := SampledHeaderValue(md); sampled != "" {
+		h.Set(HTTPHeaderXTraceSampled, sampled)
+	}
+}
+
+// traceStateEntry returns the value of the key in the tracestate list.
+func traceStateEntry(tracestate, key string) string {
+	for _, member := range strings.Split(tracestate, ",") {
+		kv := strings.SplitN(strings.TrimSpace(member), "=", 2)
+		if len(kv) == 2 && kv[0] == key {
+			return kv[1]
+		}
+	}
+	return ""
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil && len(s) > 0
+}
+
+func isZeros(s string) bool {
+	return strings.Trim(s, "0") == ""
+}
@@ -0,0 +1,112 @@
+// +build !ao_noop
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testXTrace      = "2B0AF7651916CD43DD8448EB211C80319CAABBCCDDB7AD6B7169203331" + "01"
+	testTraceparent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+)
+
+func TestW3CTraceContext(t *testing.T) {
+	traceparent, tracestate := W3CTraceContext(testXTrace)
+	assert.Equal(t, testTraceparent, traceparent)
+	assert.Equal(t, "ao="+testXTrace, tracestate)
+
+	for _, md := range []string{"", "2B00", "1B" + testXTrace[2:], testXTrace[:58] + "ZZ"} {
+		traceparent, tracestate = W3CTraceContext(md)
+		assert.Empty(t, traceparent, md)
+		assert.Empty(t, tracestate, md)
+	}
+}
+
+func TestMergeTraceState(t *testing.T) {
+	_, entry := W3CTraceContext(testXTrace)
+	assert.Equal(t, entry, MergeTraceState(testXTrace, ""))
+	assert.Equal(t, entry+",rojo=00f067aa0ba902b7,congo=t61rcWkgMzE",
+		MergeTraceState(testXTrace, "rojo=00f067aa0ba902b7, ao=2B00 ,congo=t61rcWkgMzE"))
+	// invalid X-Trace ID
+	assert.Equal(t, "rojo=00f067aa0ba902b7", MergeTraceState("2B00", "rojo=00f067aa0ba902b7"))
+
+	var members []string
+	for i := 0; i < 40; i++ {
+		members = append(members, fmt.Sprintf("v%d=%d", i, i))
+	}
+	assert.Len(t, strings.Split(MergeTraceState(testXTrace, strings.Join(members, ",")), ","), traceStateMaxMembers)
+}
+
+func TestMetadataFromW3C(t *testing.T) {
+	// the task ID is padded without the tracestate of this agent
+	assert.Equal(t, "2B0AF7651916CD43DD8448EB211C80319C00000000B7AD6B716920333101",
+		MetadataFromW3C(testTraceparent, ""))
+	assert.Equal(t, "2B0AF7651916CD43DD8448EB211C80319C00000000B7AD6B716920333100",
+		MetadataFromW3C("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00", "other=1"))
+	// the full task ID is restored from the tracestate
+	assert.Equal(t, "2B0AF7651916CD43DD8448EB211C80319CAABBCCDDB7AD6B716920333101",
+		MetadataFromW3C(testTraceparent, "other=1, ao="+testXTrace))
+	// the tracestate of another trace is ignored
+	assert.Equal(t, "2B0AF7651916CD43DD8448EB211C80319C00000000B7AD6B716920333101",
+		MetadataFromW3C(testTraceparent, "ao=2B1AF7651916CD43DD8448EB211C80319CAABBCCDDB7AD6B716920333101"))
+	// future versions may have more fields
+	assert.NotEmpty(t, MetadataFromW3C("01-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-extra", ""))
+
+	for _, tp := range []string{
+		"",
+		"ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-extra",
+		"00-00000000000000000000000000000000-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c80319c-0000000000000000-01",
+		"00-0af7651916cd43dd8448eb211c8031-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c80319z-b7ad6b7169203331-01",
+	} {
+		assert.Empty(t, MetadataFromW3C(tp, ""), tp)
+	}
+}
+
+func TestW3CPropagation(t *testing.T) {
+	r := reporter.SetTestReporter()
+	var outgoing http.Header
+	handler := HTTPHandler(func(w http.ResponseWriter, req *http.Request) {
+		out, _ := http.NewRequest(http.MethodGet, "http://downstream.example.com", nil)
+		l := BeginHTTPClientSpan(req.Context(), out)
+		l.End()
+		outgoing = out.Header
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/w3c", nil)
+	req.Header.Set(HTTPHeaderTraceparent, testTraceparent)
+	handler(httptest.NewRecorder(), req)
+	r.Close(5)
+
+	// the trace continues the W3C trace
+	md := outgoing.Get(HTTPHeaderName)
+	assert.Equal(t, "2B0AF7651916CD43DD8448EB211C80319C00000000", md[:42])
+	traceparent, tracestate := W3CTraceContext(md)
+	assert.Equal(t, traceparent, outgoing.Get(HTTPHeaderTraceparent))
+	assert.Equal(t, tracestate, outgoing.Get(HTTPHeaderTracestate))
+	assert.Equal(t, "00-0af7651916cd43dd8448eb211c80319c-", traceparent[:36])
+
+	// the tracestate entries of the other vendors are kept
+	out, _ := http.NewRequest(http.MethodGet, "http://downstream.example.com", nil)
+	out.Header.Set(HTTPHeaderTracestate, "rojo=00f067aa0ba902b7")
+	setOutgoingHeaders(out.Header, md)
+	assert.Equal(t, tracestate+",rojo=00f067aa0ba902b7", out.Header.Get(HTTPHeaderTracestate))
+
+	// X-Trace takes precedence
+	h := http.Header{}
+	h.Set(HTTPHeaderName, testXTrace)
+	h.Set(HTTPHeaderTraceparent, "00-1af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	assert.Equal(t, testXTrace, incomingMetadata(h))
+}
@@ -0,0 +1,252 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aoaws provides AppOptics tracing for the AWS SDK for Go
+// (github.com/aws/aws-sdk-go). Each call of the clients created from a wrapped
+// session is reported as a span with the service, the operation, the region
+// and the request ID, e.g., S3 GetObject or DynamoDB Query. The calls must be
+// made with a context, i.e., by the *WithContext methods, for the spans to be
+// part of the trace:
+//   sess := aoaws.WrapSession(session.Must(session.NewSession()))
+//   db := dynamodb.New(sess)
+//   out, err := db.GetItemWithContext(ctx, &dynamodb.GetItemInput{...})
+//
+// The trace context is added to the attributes of the messages sent to SQS
+// and SNS, so the consumers can continue the trace with HandleMessage. The
+// messages received by SQS from SNS carry the attributes only if the raw
+// message delivery of the subscription is enabled. The attribute is skipped
+// if a message has 10 attributes already, the maximum allowed by SQS.
+package aoaws
+
+import (
+	"context"
+	"reflect"
+	"strings"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+const (
+	handlerName          = "appoptics.aoaws"
+	consumerSpanName     = "sqs-consumer"
+	maxMessageAttributes = 10
+)
+
+// MessageAttributeKey is the key of the message attribute carrying the trace
+// context.
+const MessageAttributeKey = "x-trace"
+
+// the request parameters reported as the span KVs, if any
+var resourceFields = []struct{ field, key string }{
+	{"Bucket", "Bucket"},
+	{"TableName", "TableName"},
+	{"QueueUrl", "QueueURL"},
+	{"TopicArn", "TopicARN"},
+}
+
+type spanKey struct{}
+
+// WrapSession returns a copy of the session with the AppOptics handlers, so
+// the calls of the clients created from it are traced.
+func WrapSession(s *session.Session) *session.Session {
+	s = s.Copy()
+	AddHandlers(&s.Handlers)
+	return s
+}
+
+// AddHandlers adds the AppOptics handlers to the handlers, e.g., those of a
+// client created without a wrapped session.
+func AddHandlers(h *request.Handlers) {
+	h.Build.PushFrontNamed(request.NamedHandler{Name: handlerName + ".start", Fn: startSpan})
+	h.Complete.PushBackNamed(request.NamedHandler{Name: handlerName + ".end", Fn: endSpan})
+}
+
+// startSpan begins the span before the request is built, so the trace
+// context can be added to the messages.
+func startSpan(req *request.Request) {
+	ctx := req.Context()
+	service := req.ClientInfo.ServiceID
+	if service == "" {
+		service = req.ClientInfo.ServiceName
+	}
+	kvs := []interface{}{
+		"Spec", "rsc",
+		"IsService", true,
+		"RemoteController", service,
+		"Service", service,
+		"Operation", req.Operation.Name,
+		"Region", aws.StringValue(req.Config.Region),
+	}
+	if req.HTTPRequest != nil && req.HTTPRequest.URL != nil {
+		kvs = append(kvs, "RemoteHost", req.HTTPRequest.URL.Host)
+	}
+	kvs = append(kvs, resourceKVs(req.Params)...)
+
+	span, _ := ao.BeginSpan(ctx, "aws-"+strings.ToLower(service), kvs...)
+	if !span.IsReporting() {
+		return
+	}
+	req.SetContext(context.WithValue(ctx, spanKey{}, span))
+	req.Params = injectTraceContext(req.Params, span.MetadataString())
+}
+
+// endSpan ends the span once the request is completed, including the retries.
+func endSpan(req *request.Request) {
+	span, ok := req.Context().Value(spanKey{}).(ao.Span)
+	if !ok {
+		return
+	}
+	if req.RequestID != "" {
+		span.AddEndArgs("RequestID", req.RequestID)
+	}
+	if req.HTTPResponse != nil {
+		span.AddEndArgs("RemoteStatus", req.HTTPResponse.StatusCode)
+	}
+	if req.RetryCount > 0 {
+		span.AddEndArgs("RetryCount", req.RetryCount)
+	}
+	if req.Error != nil {
+		span.Err(req.Error)
+	}
+	span.End()
+}
+
+// resourceKVs returns the KVs of the resource the request is made to, e.g.,
+// the S3 bucket or the DynamoDB table.
+func resourceKVs(params interface{}) []interface{} {
+	v := reflect.ValueOf(params)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	v = v.Elem()
+	var kvs []interface{}
+	for _, f := range resourceFields {
+		fv := v.FieldByName(f.field)
+		if fv.Kind() == reflect.Ptr && !fv.IsNil() && fv.Elem().Kind() == reflect.String {
+			kvs = append(kvs, f.key, fv.Elem().String())
+		}
+	}
+	return kvs
+}
+
+// injectTraceContext returns a copy of the parameters of the messages sent to
+// SQS or SNS with the X-Trace ID added to their attributes. The parameters of
+// the caller are left unchanged.
+func injectTraceContext(params interface{}, xTraceID string) interface{} {
+	if xTraceID == "" {
+		return params
+	}
+	switch in := params.(type) {
+	case *sqs.SendMessageInput:
+		cp := *in
+		cp.MessageAttributes = setSQSAttribute(in.MessageAttributes, xTraceID)
+		return &cp
+	case *sqs.SendMessageBatchInput:
+		cp := *in
+		cp.Entries = make([]*sqs.SendMessageBatchRequestEntry, len(in.Entries))
+		for i, e := range in.Entries {
+			if e != nil {
+				ce := *e
+				ce.MessageAttributes = setSQSAttribute(e.MessageAttributes, xTraceID)
+				e = &ce
+			}
+			cp.Entries[i] = e
+		}
+		return &cp
+	case *sns.PublishInput:
+		cp := *in
+		cp.MessageAttributes = setSNSAttribute(in.MessageAttributes, xTraceID)
+		return &cp
+	case *sns.PublishBatchInput:
+		cp := *in
+		cp.PublishBatchRequestEntries = make([]*sns.PublishBatchRequestEntry, len(in.PublishBatchRequestEntries))
+		for i, e := range in.PublishBatchRequestEntries {
+			if e != nil {
+				ce := *e
+				ce.MessageAttributes = setSNSAttribute(e.MessageAttributes, xTraceID)
+				e = &ce
+			}
+			cp.PublishBatchRequestEntries[i] = e
+		}
+		return &cp
+	}
+	return params
+}
+
+func setSQSAttribute(attrs map[string]*sqs.MessageAttributeValue, xTraceID string) map[string]*sqs.MessageAttributeValue {
+	if _, ok := attrs[MessageAttributeKey]; !ok && len(attrs) >= maxMessageAttributes {
+		return attrs
+	}
+	cp := make(map[string]*sqs.MessageAttributeValue, len(attrs)+1)
+	for k, v := range attrs {
+		cp[k] = v
+	}
+	cp[MessageAttributeKey] = &sqs.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(xTraceID),
+	}
+	return cp
+}
+
+func setSNSAttribute(attrs map[string]*sns.MessageAttributeValue, xTraceID string) map[string]*sns.MessageAttributeValue {
+	if _, ok := attrs[MessageAttributeKey]; !ok && len(attrs) >= maxMessageAttributes {
+		return attrs
+	}
+	cp := make(map[string]*sns.MessageAttributeValue, len(attrs)+1)
+	for k, v := range attrs {
+		cp[k] = v
+	}
+	cp[MessageAttributeKey] = &sns.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(xTraceID),
+	}
+	return cp
+}
+
+// MessageAttribute returns the X-Trace ID in the attributes of the SQS
+// message, or an empty string if the message has no trace context. The
+// attribute is received only if it's requested by the MessageAttributeNames
+// of the sqs.ReceiveMessageInput, e.g., "All" or MessageAttributeKey.
+func MessageAttribute(msg *sqs.Message) string {
+	if msg == nil {
+		return ""
+	}
+	if v, ok := msg.MessageAttributes[MessageAttributeKey]; ok && v != nil {
+		return aws.StringValue(v.StringValue)
+	}
+	return ""
+}
+
+// HandleMessage starts a trace for the message received from the queue,
+// continuing the sender's trace if the context is found in its attributes.
+// The handler is called with the context bound to the trace and any error it
+// returns is reported and returned.
+func HandleMessage(queue string, msg *sqs.Message,
+	handler func(ctx context.Context, msg *sqs.Message) error) error {
+	t := ao.NewTraceFromID(consumerSpanName, MessageAttribute(msg), func() ao.KVMap {
+		kvs := ao.KVMap{
+			"Spec":   "pushq",
+			"Flavor": "sqs",
+			"Op":     "consume",
+			"Queue":  queue,
+		}
+		if msg != nil && msg.MessageId != nil {
+			kvs["MessageID"] = *msg.MessageId
+		}
+		return kvs
+	})
+	t.SetTransactionName(queue)
+	t.SetTransactionType(ao.TransactionTypeConsumer)
+	ctx := ao.NewContext(context.Background(), t)
+
+	err := handler(ctx, msg)
+	if err != nil {
+		t.Err(err)
+	}
+	t.End()
+	return err
+}
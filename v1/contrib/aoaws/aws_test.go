@@ -0,0 +1,105 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aoaws
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/awstesting/unit"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/assert"
+)
+
+const xt = "2BF4CAA9BB8AF02BD4B1EC4F8CDB0C9A36C3B0BF34F8E67D9B6846A2C101"
+
+func TestResourceKVs(t *testing.T) {
+	assert.Equal(t, []interface{}{"Bucket", "photos"},
+		resourceKVs(&s3.GetObjectInput{Bucket: aws.String("photos"), Key: aws.String("a.jpg")}))
+	assert.Equal(t, []interface{}{"TableName", "users"},
+		resourceKVs(&dynamodb.GetItemInput{TableName: aws.String("users")}))
+	assert.Equal(t, []interface{}{"QueueURL", "https://sqs/q"},
+		resourceKVs(&sqs.SendMessageInput{QueueUrl: aws.String("https://sqs/q")}))
+	assert.Nil(t, resourceKVs(&s3.ListBucketsInput{}))
+	assert.Nil(t, resourceKVs((*s3.GetObjectInput)(nil)))
+	assert.Nil(t, resourceKVs(nil))
+}
+
+func TestInjectTraceContext(t *testing.T) {
+	in := &sqs.SendMessageInput{}
+	out := injectTraceContext(in, xt).(*sqs.SendMessageInput)
+	assert.Equal(t, xt, aws.StringValue(out.MessageAttributes[MessageAttributeKey].StringValue))
+	assert.Equal(t, xt, MessageAttribute(&sqs.Message{MessageAttributes: out.MessageAttributes}))
+	// the input of the caller is left unchanged
+	assert.Nil(t, in.MessageAttributes)
+
+	batch := &sqs.SendMessageBatchInput{Entries: []*sqs.SendMessageBatchRequestEntry{{}, nil}}
+	outBatch := injectTraceContext(batch, xt).(*sqs.SendMessageBatchInput)
+	assert.Contains(t, outBatch.Entries[0].MessageAttributes, MessageAttributeKey)
+	assert.Nil(t, outBatch.Entries[1])
+	assert.Nil(t, batch.Entries[0].MessageAttributes)
+
+	pub := &sns.PublishInput{MessageAttributes: map[string]*sns.MessageAttributeValue{
+		"k": {DataType: aws.String("String"), StringValue: aws.String("v")}}}
+	outPub := injectTraceContext(pub, xt).(*sns.PublishInput)
+	assert.Len(t, outPub.MessageAttributes, 2)
+	assert.Equal(t, xt, aws.StringValue(outPub.MessageAttributes[MessageAttributeKey].StringValue))
+	assert.Len(t, pub.MessageAttributes, 1)
+
+	// no room for the trace context
+	full := &sqs.SendMessageInput{MessageAttributes: map[string]*sqs.MessageAttributeValue{}}
+	for i := 0; i < maxMessageAttributes; i++ {
+		full.MessageAttributes[strconv.Itoa(i)] = &sqs.MessageAttributeValue{}
+	}
+	out = injectTraceContext(full, xt).(*sqs.SendMessageInput)
+	assert.NotContains(t, out.MessageAttributes, MessageAttributeKey)
+
+	untraced := &sqs.SendMessageInput{}
+	assert.Equal(t, untraced, injectTraceContext(untraced, ""))
+	assert.Nil(t, untraced.MessageAttributes)
+}
+
+func TestWrapSession(t *testing.T) {
+	sess := WrapSession(unit.Session)
+	assert.Equal(t, unit.Session.Handlers.Build.Len()+1, sess.Handlers.Build.Len())
+	assert.Equal(t, unit.Session.Handlers.Complete.Len()+1, sess.Handlers.Complete.Len())
+
+	svc := sqs.New(sess, aws.NewConfig().WithMaxRetries(0))
+	svc.Handlers.Send.Clear()
+	svc.Handlers.Unmarshal.Clear()
+	svc.Handlers.UnmarshalMeta.Clear()
+	svc.Handlers.ValidateResponse.Clear()
+	svc.Handlers.Send.PushBack(func(r *request.Request) {
+		r.Error = errors.New("unavailable")
+	})
+
+	_, err := svc.SendMessageWithContext(context.Background(), &sqs.SendMessageInput{
+		QueueUrl:    aws.String("https://sqs.us-east-1.amazonaws.com/1/q"),
+		MessageBody: aws.String("hi"),
+	})
+	assert.Error(t, err)
+}
+
+func TestHandleMessage(t *testing.T) {
+	msg := &sqs.Message{MessageId: aws.String("1"), Body: aws.String("hi")}
+	assert.Empty(t, MessageAttribute(msg))
+	assert.Empty(t, MessageAttribute(nil))
+
+	var got *sqs.Message
+	assert.NoError(t, HandleMessage("orders", msg, func(ctx context.Context, m *sqs.Message) error {
+		got = m
+		return nil
+	}))
+	assert.Equal(t, msg, got)
+
+	errFailed := errors.New("failed")
+	assert.Equal(t, errFailed, HandleMessage("orders", msg,
+		func(ctx context.Context, m *sqs.Message) error { return errFailed }))
+}
@@ -0,0 +1,11 @@
+module github.com/appoptics/appoptics-apm-go/v1/contrib/aoaws
+
+go 1.14
+
+require (
+	github.com/appoptics/appoptics-apm-go v1.14.0
+	github.com/aws/aws-sdk-go v1.44.100
+	github.com/stretchr/testify v1.6.1
+)
+
+replace github.com/appoptics/appoptics-apm-go => ../../..
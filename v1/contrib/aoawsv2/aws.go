@@ -0,0 +1,260 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aoawsv2 provides AppOptics tracing for the AWS SDK for Go v2
+// (github.com/aws/aws-sdk-go-v2). Each call of the clients created from a
+// config with the AppOptics middleware is reported as a span with the service,
+// the operation, the region and the request ID, e.g., S3 GetObject or DynamoDB
+// Query:
+//   cfg, err := config.LoadDefaultConfig(ctx)
+//   aoawsv2.AppendMiddleware(&cfg.APIOptions)
+//   db := dynamodb.NewFromConfig(cfg)
+//   out, err := db.GetItem(ctx, &dynamodb.GetItemInput{...})
+//
+// The trace context is added to the attributes of the messages sent to SQS
+// and SNS, so the consumers can continue the trace with HandleMessage. The
+// messages received by SQS from SNS carry the attributes only if the raw
+// message delivery of the subscription is enabled. The attribute is skipped
+// if a message has 10 attributes already, the maximum allowed by SQS.
+package aoawsv2
+
+import (
+	"context"
+	"reflect"
+	"strings"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+const (
+	middlewareID         = "AppOpticsTracing"
+	consumerSpanName     = "sqs-consumer"
+	maxMessageAttributes = 10
+)
+
+// MessageAttributeKey is the key of the message attribute carrying the trace
+// context.
+const MessageAttributeKey = "x-trace"
+
+// the request parameters reported as the span KVs, if any
+var resourceFields = []struct{ field, key string }{
+	{"Bucket", "Bucket"},
+	{"TableName", "TableName"},
+	{"QueueUrl", "QueueURL"},
+	{"TopicArn", "TopicARN"},
+}
+
+type callKey struct{}
+
+// call is the state of a traced call shared by the middleware.
+type call struct {
+	span       ao.Span
+	remoteHost string
+	status     int
+	attempts   int
+}
+
+// AppendMiddleware adds the AppOptics middleware to the API options of a
+// config or the options of a client.
+func AppendMiddleware(apiOptions *[]func(*middleware.Stack) error) {
+	*apiOptions = append(*apiOptions, addMiddleware)
+}
+
+func addMiddleware(stack *middleware.Stack) error {
+	// after the service metadata is added to the context
+	err := stack.Initialize.Add(middleware.InitializeMiddlewareFunc(middlewareID, startSpan), middleware.After)
+	if err != nil {
+		return err
+	}
+	return stack.Deserialize.Add(middleware.DeserializeMiddlewareFunc(middlewareID, recordAttempt), middleware.Before)
+}
+
+// startSpan reports the span of the call, including the retries.
+func startSpan(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (
+	out middleware.InitializeOutput, metadata middleware.Metadata, err error) {
+	service := awsmiddleware.GetServiceID(ctx)
+	kvs := []interface{}{
+		"Spec", "rsc",
+		"IsService", true,
+		"RemoteController", service,
+		"Service", service,
+		"Operation", awsmiddleware.GetOperationName(ctx),
+		"Region", awsmiddleware.GetRegion(ctx),
+	}
+	kvs = append(kvs, resourceKVs(in.Parameters)...)
+
+	span, _ := ao.BeginSpan(ctx, "aws-"+strings.ToLower(service), kvs...)
+	if !span.IsReporting() {
+		return next.HandleInitialize(ctx, in)
+	}
+	in.Parameters = injectTraceContext(in.Parameters, span.MetadataString())
+
+	c := &call{span: span}
+	out, metadata, err = next.HandleInitialize(context.WithValue(ctx, callKey{}, c), in)
+	if id, ok := awsmiddleware.GetRequestIDMetadata(metadata); ok {
+		span.AddEndArgs("RequestID", id)
+	}
+	if c.remoteHost != "" {
+		span.AddEndArgs("RemoteHost", c.remoteHost)
+	}
+	if c.status != 0 {
+		span.AddEndArgs("RemoteStatus", c.status)
+	}
+	if c.attempts > 1 {
+		span.AddEndArgs("RetryCount", c.attempts-1)
+	}
+	if err != nil {
+		span.Err(err)
+	}
+	span.End()
+	return out, metadata, err
+}
+
+// recordAttempt records the remote host and the response status of each
+// attempt of the call.
+func recordAttempt(ctx context.Context, in middleware.DeserializeInput, next middleware.DeserializeHandler) (
+	out middleware.DeserializeOutput, metadata middleware.Metadata, err error) {
+	out, metadata, err = next.HandleDeserialize(ctx, in)
+	if c, ok := ctx.Value(callKey{}).(*call); ok {
+		c.attempts++
+		if req, ok := in.Request.(*smithyhttp.Request); ok && req.URL != nil {
+			c.remoteHost = req.URL.Host
+		}
+		if resp, ok := out.RawResponse.(*smithyhttp.Response); ok && resp != nil && resp.Response != nil {
+			c.status = resp.StatusCode
+		}
+	}
+	return out, metadata, err
+}
+
+// resourceKVs returns the KVs of the resource the request is made to, e.g.,
+// the S3 bucket or the DynamoDB table.
+func resourceKVs(params interface{}) []interface{} {
+	v := reflect.ValueOf(params)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	v = v.Elem()
+	var kvs []interface{}
+	for _, f := range resourceFields {
+		fv := v.FieldByName(f.field)
+		if fv.Kind() == reflect.Ptr && !fv.IsNil() && fv.Elem().Kind() == reflect.String {
+			kvs = append(kvs, f.key, fv.Elem().String())
+		}
+	}
+	return kvs
+}
+
+// injectTraceContext returns a copy of the parameters of the messages sent to
+// SQS or SNS with the X-Trace ID added to their attributes. The parameters of
+// the caller are left unchanged.
+func injectTraceContext(params interface{}, xTraceID string) interface{} {
+	if xTraceID == "" {
+		return params
+	}
+	switch in := params.(type) {
+	case *sqs.SendMessageInput:
+		cp := *in
+		cp.MessageAttributes = setSQSAttribute(in.MessageAttributes, xTraceID)
+		return &cp
+	case *sqs.SendMessageBatchInput:
+		cp := *in
+		cp.Entries = make([]sqstypes.SendMessageBatchRequestEntry, len(in.Entries))
+		for i, e := range in.Entries {
+			e.MessageAttributes = setSQSAttribute(e.MessageAttributes, xTraceID)
+			cp.Entries[i] = e
+		}
+		return &cp
+	case *sns.PublishInput:
+		cp := *in
+		cp.MessageAttributes = setSNSAttribute(in.MessageAttributes, xTraceID)
+		return &cp
+	case *sns.PublishBatchInput:
+		cp := *in
+		cp.PublishBatchRequestEntries = make([]snstypes.PublishBatchRequestEntry, len(in.PublishBatchRequestEntries))
+		for i, e := range in.PublishBatchRequestEntries {
+			e.MessageAttributes = setSNSAttribute(e.MessageAttributes, xTraceID)
+			cp.PublishBatchRequestEntries[i] = e
+		}
+		return &cp
+	}
+	return params
+}
+
+func setSQSAttribute(attrs map[string]sqstypes.MessageAttributeValue, xTraceID string) map[string]sqstypes.MessageAttributeValue {
+	if _, ok := attrs[MessageAttributeKey]; !ok && len(attrs) >= maxMessageAttributes {
+		return attrs
+	}
+	cp := make(map[string]sqstypes.MessageAttributeValue, len(attrs)+1)
+	for k, v := range attrs {
+		cp[k] = v
+	}
+	cp[MessageAttributeKey] = sqstypes.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(xTraceID),
+	}
+	return cp
+}
+
+func setSNSAttribute(attrs map[string]snstypes.MessageAttributeValue, xTraceID string) map[string]snstypes.MessageAttributeValue {
+	if _, ok := attrs[MessageAttributeKey]; !ok && len(attrs) >= maxMessageAttributes {
+		return attrs
+	}
+	cp := make(map[string]snstypes.MessageAttributeValue, len(attrs)+1)
+	for k, v := range attrs {
+		cp[k] = v
+	}
+	cp[MessageAttributeKey] = snstypes.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(xTraceID),
+	}
+	return cp
+}
+
+// MessageAttribute returns the X-Trace ID in the attributes of the SQS
+// message, or an empty string if the message has no trace context. The
+// attribute is received only if it's requested by the MessageAttributeNames
+// of the sqs.ReceiveMessageInput, e.g., "All" or MessageAttributeKey.
+func MessageAttribute(msg sqstypes.Message) string {
+	if v, ok := msg.MessageAttributes[MessageAttributeKey]; ok {
+		return aws.ToString(v.StringValue)
+	}
+	return ""
+}
+
+// HandleMessage starts a trace for the message received from the queue,
+// continuing the sender's trace if the context is found in its attributes.
+// The handler is called with the context bound to the trace and any error it
+// returns is reported and returned.
+func HandleMessage(queue string, msg sqstypes.Message,
+	handler func(ctx context.Context, msg sqstypes.Message) error) error {
+	t := ao.NewTraceFromID(consumerSpanName, MessageAttribute(msg), func() ao.KVMap {
+		kvs := ao.KVMap{
+			"Spec":   "pushq",
+			"Flavor": "sqs",
+			"Op":     "consume",
+			"Queue":  queue,
+		}
+		if msg.MessageId != nil {
+			kvs["MessageID"] = *msg.MessageId
+		}
+		return kvs
+	})
+	t.SetTransactionName(queue)
+	t.SetTransactionType(ao.TransactionTypeConsumer)
+	ctx := ao.NewContext(context.Background(), t)
+
+	err := handler(ctx, msg)
+	if err != nil {
+		t.Err(err)
+	}
+	t.End()
+	return err
+}
@@ -0,0 +1,93 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aoawsv2
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/stretchr/testify/assert"
+)
+
+const xt = "2BF4CAA9BB8AF02BD4B1EC4F8CDB0C9A36C3B0BF34F8E67D9B6846A2C101"
+
+func TestAppendMiddleware(t *testing.T) {
+	var opts []func(*middleware.Stack) error
+	AppendMiddleware(&opts)
+	assert.Len(t, opts, 1)
+
+	stack := middleware.NewStack("SendMessage", smithyhttp.NewStackRequest)
+	assert.NoError(t, opts[0](stack))
+	_, ok := stack.Initialize.Get(middlewareID)
+	assert.True(t, ok)
+	_, ok = stack.Deserialize.Get(middlewareID)
+	assert.True(t, ok)
+}
+
+func TestResourceKVs(t *testing.T) {
+	assert.Equal(t, []interface{}{"QueueURL", "https://sqs/q"},
+		resourceKVs(&sqs.SendMessageInput{QueueUrl: aws.String("https://sqs/q")}))
+	assert.Equal(t, []interface{}{"TopicARN", "arn:aws:sns:us-east-1:1:t"},
+		resourceKVs(&sns.PublishInput{TopicArn: aws.String("arn:aws:sns:us-east-1:1:t")}))
+	assert.Nil(t, resourceKVs(&sqs.ListQueuesInput{}))
+	assert.Nil(t, resourceKVs((*sqs.SendMessageInput)(nil)))
+	assert.Nil(t, resourceKVs(nil))
+}
+
+func TestInjectTraceContext(t *testing.T) {
+	in := &sqs.SendMessageInput{}
+	out := injectTraceContext(in, xt).(*sqs.SendMessageInput)
+	assert.Equal(t, xt, aws.ToString(out.MessageAttributes[MessageAttributeKey].StringValue))
+	assert.Equal(t, xt, MessageAttribute(sqstypes.Message{MessageAttributes: out.MessageAttributes}))
+	// the input of the caller is left unchanged
+	assert.Nil(t, in.MessageAttributes)
+
+	batch := &sqs.SendMessageBatchInput{Entries: []sqstypes.SendMessageBatchRequestEntry{{}, {}}}
+	outBatch := injectTraceContext(batch, xt).(*sqs.SendMessageBatchInput)
+	assert.Contains(t, outBatch.Entries[1].MessageAttributes, MessageAttributeKey)
+	assert.Nil(t, batch.Entries[1].MessageAttributes)
+
+	pub := &sns.PublishBatchInput{PublishBatchRequestEntries: []snstypes.PublishBatchRequestEntry{{
+		MessageAttributes: map[string]snstypes.MessageAttributeValue{
+			"k": {DataType: aws.String("String"), StringValue: aws.String("v")}}}}}
+	outPub := injectTraceContext(pub, xt).(*sns.PublishBatchInput)
+	assert.Len(t, outPub.PublishBatchRequestEntries[0].MessageAttributes, 2)
+	assert.Len(t, pub.PublishBatchRequestEntries[0].MessageAttributes, 1)
+
+	// no room for the trace context
+	full := &sqs.SendMessageInput{MessageAttributes: map[string]sqstypes.MessageAttributeValue{}}
+	for i := 0; i < maxMessageAttributes; i++ {
+		full.MessageAttributes[strconv.Itoa(i)] = sqstypes.MessageAttributeValue{}
+	}
+	out = injectTraceContext(full, xt).(*sqs.SendMessageInput)
+	assert.NotContains(t, out.MessageAttributes, MessageAttributeKey)
+
+	untraced := &sqs.SendMessageInput{}
+	assert.Equal(t, untraced, injectTraceContext(untraced, ""))
+	assert.Nil(t, untraced.MessageAttributes)
+}
+
+func TestHandleMessage(t *testing.T) {
+	msg := sqstypes.Message{MessageId: aws.String("1"), Body: aws.String("hi")}
+	assert.Empty(t, MessageAttribute(msg))
+
+	var got sqstypes.Message
+	assert.NoError(t, HandleMessage("orders", msg, func(ctx context.Context, m sqstypes.Message) error {
+		got = m
+		return nil
+	}))
+	assert.Equal(t, msg, got)
+
+	errFailed := errors.New("failed")
+	assert.Equal(t, errFailed, HandleMessage("orders", msg,
+		func(ctx context.Context, m sqstypes.Message) error { return errFailed }))
+}
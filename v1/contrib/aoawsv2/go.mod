@@ -0,0 +1,14 @@
+module github.com/appoptics/appoptics-apm-go/v1/contrib/aoawsv2
+
+go 1.15
+
+require (
+	github.com/appoptics/appoptics-apm-go v1.14.0
+	github.com/aws/aws-sdk-go-v2 v1.16.16
+	github.com/aws/aws-sdk-go-v2/service/sns v1.18.1
+	github.com/aws/aws-sdk-go-v2/service/sqs v1.19.10
+	github.com/aws/smithy-go v1.13.3
+	github.com/stretchr/testify v1.6.1
+)
+
+replace github.com/appoptics/appoptics-apm-go => ../../..
@@ -0,0 +1,125 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aocache traces the cache-aside pattern: the value is looked up in a
+// cache first, and on a miss it's loaded, e.g., from the database, and stored
+// back to the cache. The cache lookup and the loader are reported as separate
+// spans, and the hit ratio of each cache is reported as a custom metric.
+//
+//	users := aocache.New("users", redisStore, "redis:6379")
+//	v, err := users.Do(ctx, "user:42", func(ctx context.Context) (interface{}, error) {
+//	    return loadUser(ctx, 42)
+//	})
+package aocache
+
+import (
+	"context"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+)
+
+const (
+	loaderSpanName = "cache-loader"
+
+	// HitRatioMetricName is the name of the summary metric of the cache
+	// lookups. Each lookup is measured as 1 on a hit and 0 on a miss, so the
+	// average of the metric is the hit ratio. It's tagged by the cache name.
+	HitRatioMetricName = "cache.hit_ratio"
+)
+
+// Store is the cache backing the cache-aside pattern.
+type Store interface {
+	// Get returns the value of the key and whether it's found.
+	Get(ctx context.Context, key string) (value interface{}, found bool, err error)
+	// Set stores the value of the key.
+	Set(ctx context.Context, key string, value interface{}) error
+}
+
+// Loader loads the value on a cache miss.
+type Loader func(ctx context.Context) (interface{}, error)
+
+// Cache is a traced cache-aside helper for a Store. It's safe for concurrent
+// use if the Store is.
+type Cache struct {
+	name       string
+	store      Store
+	remoteHost string
+}
+
+// New returns a Cache of the store, the name is used as the span name of the
+// cache operations and tags the hit ratio metric. remoteHost is the address of
+// the cache server and can be empty for the in-process caches.
+func New(name string, store Store, remoteHost string) *Cache {
+	return &Cache{name: name, store: store, remoteHost: remoteHost}
+}
+
+// Do returns the value of the key from the cache. On a miss, the loader is
+// called and the value loaded is stored to the cache. A failed lookup is
+// treated as a miss, and a failed store doesn't fail Do, both are reported to
+// the span of the operation. The error of the loader is returned as is and its
+// value is not cached.
+func (c *Cache) Do(ctx context.Context, key string, loader Loader) (interface{}, error) {
+	if v, ok := c.get(ctx, key); ok {
+		return v, nil
+	}
+
+	v, err := c.load(ctx, loader)
+	if err != nil {
+		return nil, err
+	}
+	c.set(ctx, key, v)
+	return v, nil
+}
+
+func (c *Cache) beginSpan(ctx context.Context, op, key string) ao.Span {
+	span, _ := ao.BeginSpan(ctx, c.name,
+		"Spec", "cache",
+		"KVOp", op,
+		"KVKey", key,
+		"RemoteHost", c.remoteHost)
+	return span
+}
+
+func (c *Cache) get(ctx context.Context, key string) (interface{}, bool) {
+	span := c.beginSpan(ctx, "get", key)
+	defer span.End()
+
+	v, found, err := c.store.Get(ctx, key)
+	if err != nil {
+		span.Err(err)
+		found = false
+	}
+	span.AddEndArgs("KVHit", found)
+	c.recordLookup(found)
+	return v, found
+}
+
+func (c *Cache) load(ctx context.Context, loader Loader) (interface{}, error) {
+	span, ctx := ao.BeginSpan(ctx, loaderSpanName, "Cache", c.name)
+	defer span.End()
+
+	v, err := loader(ctx)
+	if err != nil {
+		span.Err(err)
+	}
+	return v, err
+}
+
+func (c *Cache) set(ctx context.Context, key string, v interface{}) {
+	span := c.beginSpan(ctx, "set", key)
+	defer span.End()
+
+	if err := c.store.Set(ctx, key, v); err != nil {
+		span.Err(err)
+	}
+}
+
+func (c *Cache) recordLookup(hit bool) {
+	var value float64
+	if hit {
+		value = 1
+	}
+	ao.SummaryMetric(HitRatioMetricName, value, ao.MetricOptions{
+		Count: 1,
+		Tags:  map[string]string{"Cache": c.name},
+	})
+}
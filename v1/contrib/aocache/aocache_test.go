@@ -0,0 +1,93 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aocache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mapStore struct {
+	sync.Mutex
+	m      map[string]interface{}
+	getErr error
+	setErr error
+}
+
+func (s *mapStore) Get(ctx context.Context, key string) (interface{}, bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.getErr != nil {
+		return nil, false, s.getErr
+	}
+	v, ok := s.m[key]
+	return v, ok, nil
+}
+
+func (s *mapStore) Set(ctx context.Context, key string, value interface{}) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.setErr != nil {
+		return s.setErr
+	}
+	s.m[key] = value
+	return nil
+}
+
+func TestDo(t *testing.T) {
+	store := &mapStore{m: make(map[string]interface{})}
+	c := New("test", store, "")
+	ctx := context.Background()
+
+	loads := 0
+	loader := func(ctx context.Context) (interface{}, error) {
+		loads++
+		return "v", nil
+	}
+
+	v, err := c.Do(ctx, "k", loader)
+	assert.NoError(t, err)
+	assert.Equal(t, "v", v)
+	assert.Equal(t, 1, loads)
+
+	// a hit doesn't call the loader
+	v, err = c.Do(ctx, "k", loader)
+	assert.NoError(t, err)
+	assert.Equal(t, "v", v)
+	assert.Equal(t, 1, loads)
+}
+
+func TestDoErrors(t *testing.T) {
+	store := &mapStore{m: make(map[string]interface{}), getErr: errors.New("get")}
+	c := New("test", store, "")
+	ctx := context.Background()
+
+	// a failed lookup is a miss
+	v, err := c.Do(ctx, "k", func(ctx context.Context) (interface{}, error) {
+		return "v", nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "v", v)
+
+	// the loader error is returned and the value is not cached
+	store.getErr = nil
+	loadErr := errors.New("load")
+	v, err = c.Do(ctx, "x", func(ctx context.Context) (interface{}, error) {
+		return "v", loadErr
+	})
+	assert.Equal(t, loadErr, err)
+	assert.Nil(t, v)
+	assert.NotContains(t, store.m, "x")
+
+	// a failed store doesn't fail Do
+	store.setErr = errors.New("set")
+	v, err = c.Do(ctx, "y", func(ctx context.Context) (interface{}, error) {
+		return "w", nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "w", v)
+}
@@ -0,0 +1,162 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aocontrib is the toolkit to write AppOptics integrations for web and
+// RPC frameworks, e.g., routers, RPC servers and job runners, against stable
+// APIs rather than the internal packages of the agent. An integration follows
+// the same steps for each request the framework serves:
+//   func (m *middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+//       e, w, r := aocontrib.StartHTTP("myframework", w, r)
+//       defer e.End()
+//       e.SetRoute(m.router.RouteOf(r)) // e.g., "/users/:id"
+//       m.next.ServeHTTP(w, r)
+//   }
+//
+// The contract the integrations can rely on:
+//   - Start and StartHTTP continue the trace of the incoming request, if any,
+//     and bind it to the returned context or request, so the spans started
+//     from them by the application become part of the trace.
+//   - SetRoute names the transaction after the route template, so it must be
+//     called before the application handler, which can still rename it by
+//     ao.SetTransactionName.
+//   - The HTTP response status is recorded by the returned writer. Other
+//     frameworks record their status, e.g., a gRPC code, by SetStatus.
+//   - End must be deferred. It reports a panic of the handler, if any, and
+//     re-raises it.
+//   - All of them are safe to call while the agent is disabled or closed.
+//
+// The framework specific metrics can be submitted by Metrics, which tags them
+// with the framework name.
+package aocontrib
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+)
+
+// FrameworkTag is the tag of the metrics submitted by Metrics.
+const FrameworkTag = "Framework"
+
+// Entry is the entry span of a request served by a framework.
+type Entry struct {
+	t      ao.Trace
+	ctx    context.Context
+	w      *ao.HTTPResponseWriter
+	status int
+}
+
+// StartHTTP starts the entry span of the HTTP request, continuing the trace of
+// the X-Trace header, if any. The returned writer and request must be passed
+// on to the handler in place of the original ones.
+func StartHTTP(framework string, w http.ResponseWriter, r *http.Request) (*Entry, http.ResponseWriter, *http.Request) {
+	if ao.Closed() {
+		return &Entry{t: ao.NewNullTrace(), ctx: r.Context()}, w, r
+	}
+	t, w, r := ao.TraceFromHTTPRequestResponse(framework, w, r)
+	e := &Entry{t: t, ctx: r.Context()}
+	e.w, _ = w.(*ao.HTTPResponseWriter)
+	return e, w, r
+}
+
+// Start starts the entry span of a request which isn't served over HTTP,
+// continuing the trace of xTraceID, if any, e.g., the one received in the
+// metadata of an RPC or the headers of a message. The kvs are reported by the
+// entry event. The returned Entry's Context must be passed on to the handler.
+func Start(ctx context.Context, framework, xTraceID string, kvs ao.KVMap) *Entry {
+	if ao.Closed() {
+		return &Entry{t: ao.NewNullTrace(), ctx: ctx}
+	}
+	t := ao.NewTraceFromID(framework, xTraceID, func() ao.KVMap { return kvs })
+	return &Entry{t: t, ctx: ao.NewContext(ctx, t)}
+}
+
+// Context returns the context bound to the trace.
+func (e *Entry) Context() context.Context {
+	return e.ctx
+}
+
+// Trace returns the trace of the entry span, to report the framework specific
+// KVs or to start child spans.
+func (e *Entry) Trace() ao.Trace {
+	return e.t
+}
+
+// SetRoute names the transaction after the route template the request
+// matches, e.g., "/users/:id" rather than "/users/42". An empty route keeps
+// the default name.
+func (e *Entry) SetRoute(route string) {
+	if route != "" {
+		e.t.SetTransactionName(route)
+	}
+}
+
+// SetStatus records the status of the response, e.g., the HTTP status of the
+// frameworks which don't write the response through the writer returned by
+// StartHTTP.
+func (e *Entry) SetStatus(status int) {
+	e.status = status
+	if e.w != nil {
+		e.w.StatusCode = status
+		return
+	}
+	e.t.SetStatus(status)
+}
+
+// Status returns the status of the response recorded so far, or zero if none
+// is recorded.
+func (e *Entry) Status() int {
+	if e.w != nil {
+		return e.w.StatusCode
+	}
+	return e.status
+}
+
+// Error reports the error of the request, e.g., the one returned by the
+// handler, without ending the span.
+func (e *Entry) Error(err error) {
+	if err != nil {
+		e.t.Err(err)
+	}
+}
+
+// End ends the entry span. It must be deferred, as it reports the panic of the
+// handler, if any, before re-raising it.
+func (e *Entry) End() {
+	if err := recover(); err != nil {
+		e.t.Error("panic", fmt.Sprintf("%v", err))
+		e.t.End()
+		panic(err)
+	}
+	e.t.End()
+}
+
+// Metrics submits the custom metrics of a framework, tagged with its name.
+type Metrics struct {
+	framework string
+}
+
+// NewMetrics returns the Metrics of the framework.
+func NewMetrics(framework string) *Metrics {
+	return &Metrics{framework: framework}
+}
+
+// Increment increments the count of the metric.
+func (m *Metrics) Increment(name string, tags map[string]string) error {
+	return ao.IncrementMetric(name, m.options(tags))
+}
+
+// Summary submits a value of the metric, e.g., the time spent in rendering.
+func (m *Metrics) Summary(name string, value float64, tags map[string]string) error {
+	return ao.SummaryMetric(name, value, m.options(tags))
+}
+
+func (m *Metrics) options(tags map[string]string) ao.MetricOptions {
+	t := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		t[k] = v
+	}
+	t[FrameworkTag] = m.framework
+	return ao.MetricOptions{Count: 1, Tags: t}
+}
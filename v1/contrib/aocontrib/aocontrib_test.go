@@ -0,0 +1,62 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aocontrib
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStartHTTP(t *testing.T) {
+	var name string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		e, w, r := StartHTTP("testframework", w, r)
+		defer e.End()
+		e.SetRoute("/users/:id")
+		name = ao.GetTransactionName(r.Context())
+		w.WriteHeader(http.StatusTeapot)
+		assert.Equal(t, http.StatusTeapot, e.Status())
+	}
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/users/42", nil))
+	assert.Equal(t, http.StatusTeapot, w.Code)
+	assert.Equal(t, "/users/:id", name)
+}
+
+func TestStart(t *testing.T) {
+	e := Start(context.Background(), "testrpc", "", ao.KVMap{"Method": "Get"})
+	assert.NotNil(t, e.Context())
+	assert.NotNil(t, e.Trace())
+	assert.Zero(t, e.Status())
+	e.SetStatus(5)
+	assert.Equal(t, 5, e.Status())
+	e.Error(errors.New("unavailable"))
+	e.Error(nil)
+	e.End()
+}
+
+func TestEndPanic(t *testing.T) {
+	assert.PanicsWithValue(t, "boom", func() {
+		e := Start(context.Background(), "testjob", "", nil)
+		defer e.End()
+		panic("boom")
+	})
+}
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics("testframework")
+	tags := map[string]string{"Route": "/users/:id"}
+	assert.Equal(t, ao.MetricOptions{Count: 1, Tags: map[string]string{
+		"Route":      "/users/:id",
+		FrameworkTag: "testframework",
+	}}, m.options(tags))
+	assert.Len(t, tags, 1)
+	assert.Equal(t, map[string]string{FrameworkTag: "testframework"}, m.options(nil).Tags)
+}
@@ -0,0 +1,137 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aodns provides AppOptics tracing for the DNS lookups made by a
+// net.Resolver. Each lookup is reported as an exit span with the query type,
+// the name looked up and the number of the records returned:
+//   r := aodns.Wrap(&net.Resolver{PreferGo: true})
+//   addrs, err := r.LookupSRV(ctx, "grpc", "tcp", "users.service.consul")
+package aodns
+
+import (
+	"context"
+	"net"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+)
+
+const (
+	spanName = "dns"
+	protocol = "dns"
+
+	keyQueryType   = "QueryType"
+	keyRecordCount = "RecordCount"
+	keyNotFound    = "NotFound"
+
+	// the message of the errors of the names not found, by both the Go and the
+	// cgo resolvers
+	errNoSuchHost = "no such host"
+)
+
+// Resolver wraps a net.Resolver and traces its Lookup* methods.
+type Resolver struct {
+	*net.Resolver
+}
+
+// Wrap returns a Resolver tracing the lookups made by r. The default resolver
+// is used if r is nil.
+func Wrap(r *net.Resolver) *Resolver {
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	return &Resolver{Resolver: r}
+}
+
+// LookupHost traces net.Resolver.LookupHost.
+func (r *Resolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	span := begin(ctx, "A", host)
+	addrs, err := r.Resolver.LookupHost(ctx, host)
+	end(span, len(addrs), err)
+	return addrs, err
+}
+
+// LookupIPAddr traces net.Resolver.LookupIPAddr.
+func (r *Resolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	span := begin(ctx, "A", host)
+	addrs, err := r.Resolver.LookupIPAddr(ctx, host)
+	end(span, len(addrs), err)
+	return addrs, err
+}
+
+// LookupAddr traces net.Resolver.LookupAddr.
+func (r *Resolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	span := begin(ctx, "PTR", addr)
+	names, err := r.Resolver.LookupAddr(ctx, addr)
+	end(span, len(names), err)
+	return names, err
+}
+
+// LookupCNAME traces net.Resolver.LookupCNAME.
+func (r *Resolver) LookupCNAME(ctx context.Context, host string) (string, error) {
+	span := begin(ctx, "CNAME", host)
+	cname, err := r.Resolver.LookupCNAME(ctx, host)
+	end(span, 1, err)
+	return cname, err
+}
+
+// LookupSRV traces net.Resolver.LookupSRV.
+func (r *Resolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	target := name
+	if service != "" || proto != "" {
+		target = "_" + service + "._" + proto + "." + name
+	}
+	span := begin(ctx, "SRV", target)
+	cname, addrs, err := r.Resolver.LookupSRV(ctx, service, proto, name)
+	end(span, len(addrs), err)
+	return cname, addrs, err
+}
+
+// LookupMX traces net.Resolver.LookupMX.
+func (r *Resolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	span := begin(ctx, "MX", name)
+	mxs, err := r.Resolver.LookupMX(ctx, name)
+	end(span, len(mxs), err)
+	return mxs, err
+}
+
+// LookupNS traces net.Resolver.LookupNS.
+func (r *Resolver) LookupNS(ctx context.Context, name string) ([]*net.NS, error) {
+	span := begin(ctx, "NS", name)
+	nss, err := r.Resolver.LookupNS(ctx, name)
+	end(span, len(nss), err)
+	return nss, err
+}
+
+// LookupTXT traces net.Resolver.LookupTXT.
+func (r *Resolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	span := begin(ctx, "TXT", name)
+	txts, err := r.Resolver.LookupTXT(ctx, name)
+	end(span, len(txts), err)
+	return txts, err
+}
+
+func begin(ctx context.Context, queryType, name string) ao.Span {
+	return ao.BeginRPCSpan(ctx, spanName, protocol, queryType, name, keyQueryType, queryType)
+}
+
+// end reports the number of the records found, or whether the name is not
+// found, and ends the span. A name which doesn't exist is not reported as an
+// error as it's an expected answer in service discovery.
+func end(span ao.Span, records int, err error) {
+	if err != nil {
+		if isNotFound(err) {
+			span.AddEndArgs(keyNotFound, true)
+		} else {
+			span.Err(err)
+		}
+	} else {
+		span.AddEndArgs(keyRecordCount, records)
+	}
+	span.End()
+}
+
+// isNotFound tells if the name is not found. The message is checked as
+// net.DNSError.IsNotFound is only available since Go 1.13.
+func isNotFound(err error) bool {
+	dnsErr, ok := err.(*net.DNSError)
+	return ok && dnsErr.Err == errNoSuchHost
+}
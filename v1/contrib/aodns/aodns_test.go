@@ -0,0 +1,37 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aodns
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolver(t *testing.T) {
+	ctx := context.Background()
+	r := Wrap(nil)
+	assert.Equal(t, net.DefaultResolver, r.Resolver)
+
+	addrs, err := r.LookupHost(ctx, "localhost")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, addrs)
+
+	ips, err := r.LookupIPAddr(ctx, "127.0.0.1")
+	assert.NoError(t, err)
+	assert.Len(t, ips, 1)
+
+	// the errors are returned as is
+	_, err = r.LookupHost(ctx, "invalid.")
+	assert.Error(t, err)
+	_, _, err = r.LookupSRV(ctx, "grpc", "tcp", "invalid.")
+	assert.Error(t, err)
+}
+
+func TestIsNotFound(t *testing.T) {
+	assert.True(t, isNotFound(&net.DNSError{Err: "no such host", Name: "invalid."}))
+	assert.False(t, isNotFound(&net.DNSError{Err: "server misbehaving", Name: "example.com"}))
+	assert.False(t, isNotFound(context.DeadlineExceeded))
+}
@@ -0,0 +1,161 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aoexec provides AppOptics tracing for the external commands run by
+// os/exec. Each command is reported as an exit span with the name of the
+// binary and the exit code, along with the end of the standard error output if
+// it fails:
+//   out, err := aoexec.CommandContext(ctx, "convert", "in.png", "out.jpg").Output()
+//
+// Only the name of the binary is reported, as the arguments may contain
+// credentials.
+package aoexec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+)
+
+const (
+	spanName = "exec"
+
+	keyCommand  = "Command"
+	keyExitCode = "ExitCode"
+	keyStderr   = "Stderr"
+
+	// the max length of the standard error output reported
+	maxStderrLen = 1024
+)
+
+// Cmd is an exec.Cmd which traces the command it runs.
+type Cmd struct {
+	*exec.Cmd
+	ctx    context.Context
+	span   ao.Span
+	stderr *tailBuffer
+}
+
+// CommandContext returns a Cmd running the command like exec.CommandContext,
+// as a child span of the span bound to ctx.
+func CommandContext(ctx context.Context, name string, arg ...string) *Cmd {
+	return Wrap(ctx, exec.CommandContext(ctx, name, arg...))
+}
+
+// Wrap returns a Cmd tracing cmd as a child span of the span bound to ctx.
+func Wrap(ctx context.Context, cmd *exec.Cmd) *Cmd {
+	return &Cmd{Cmd: cmd, ctx: ctx}
+}
+
+// Start starts the command and begins its span. The span ends when Wait is
+// called, or now if the command fails to start.
+func (c *Cmd) Start() error {
+	c.span, _ = ao.BeginSpan(c.ctx, spanName, keyCommand, filepath.Base(c.Path))
+	// keep the end of the output, which is otherwise discarded, to be reported
+	// if the command fails
+	if c.Stderr == nil && c.span.IsReporting() {
+		c.stderr = &tailBuffer{}
+		c.Stderr = c.stderr
+	}
+	err := c.Cmd.Start()
+	if err != nil {
+		c.end(err)
+	}
+	return err
+}
+
+// Wait waits for the command to exit and ends its span.
+func (c *Cmd) Wait() error {
+	err := c.Cmd.Wait()
+	c.end(err)
+	return err
+}
+
+// Run starts the command and waits for it to exit.
+func (c *Cmd) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+// Output runs the command and returns its standard output. Like
+// exec.Cmd.Output, the standard error output is returned in the Stderr of the
+// *exec.ExitError, if it's not redirected, but only the last 1 KB of it.
+func (c *Cmd) Output() ([]byte, error) {
+	if c.Stdout != nil {
+		return nil, errors.New("exec: Stdout already set")
+	}
+	var stdout bytes.Buffer
+	c.Stdout = &stdout
+	captured := c.Stderr == nil
+	if captured {
+		c.stderr = &tailBuffer{}
+		c.Stderr = c.stderr
+	}
+	err := c.Run()
+	if ee, ok := err.(*exec.ExitError); ok && captured {
+		ee.Stderr = c.stderr.Bytes()
+	}
+	return stdout.Bytes(), err
+}
+
+// CombinedOutput runs the command and returns its combined standard output
+// and standard error.
+func (c *Cmd) CombinedOutput() ([]byte, error) {
+	if c.Stdout != nil {
+		return nil, errors.New("exec: Stdout already set")
+	}
+	if c.Stderr != nil {
+		return nil, errors.New("exec: Stderr already set")
+	}
+	var b bytes.Buffer
+	c.Stdout = &b
+	c.Stderr = &b
+	err := c.Run()
+	return b.Bytes(), err
+}
+
+// end reports the exit code, which is unknown if the command failed to start,
+// and the end of the standard error output if the command failed, then ends
+// the span.
+func (c *Cmd) end(err error) {
+	if c.span == nil {
+		return
+	}
+	if c.ProcessState != nil {
+		c.span.AddEndArgs(keyExitCode, c.ProcessState.ExitCode())
+	}
+	if err != nil {
+		if c.stderr != nil && c.stderr.Len() > 0 {
+			c.span.AddEndArgs(keyStderr, string(c.stderr.Bytes()))
+		}
+		c.span.Err(err)
+	}
+	c.span.End()
+	c.span = nil
+}
+
+// tailBuffer keeps the last maxStderrLen bytes written to it.
+type tailBuffer struct {
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if n >= maxStderrLen {
+		b.buf = append(b.buf[:0], p[n-maxStderrLen:]...)
+		return n, nil
+	}
+	if over := len(b.buf) + n - maxStderrLen; over > 0 {
+		b.buf = append(b.buf[:0], b.buf[over:]...)
+	}
+	b.buf = append(b.buf, p...)
+	return n, nil
+}
+
+func (b *tailBuffer) Bytes() []byte { return b.buf }
+func (b *tailBuffer) Len() int      { return len(b.buf) }
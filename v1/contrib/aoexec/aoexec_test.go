@@ -0,0 +1,64 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aoexec
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommand(t *testing.T) {
+	ctx := context.Background()
+
+	out, err := CommandContext(ctx, "sh", "-c", "echo out; echo err >&2").Output()
+	assert.NoError(t, err)
+	assert.Equal(t, "out\n", string(out))
+
+	out, err = CommandContext(ctx, "sh", "-c", "echo out; echo err >&2").CombinedOutput()
+	assert.NoError(t, err)
+	assert.Equal(t, "out\nerr\n", string(out))
+
+	// the standard error output is returned like exec.Cmd.Output
+	_, err = CommandContext(ctx, "sh", "-c", "echo failed >&2; exit 3").Output()
+	ee, ok := err.(*exec.ExitError)
+	assert.True(t, ok)
+	assert.Equal(t, 3, ee.ExitCode())
+	assert.Equal(t, "failed\n", string(ee.Stderr))
+
+	var stderr bytes.Buffer
+	cmd := Wrap(ctx, exec.Command("sh", "-c", "echo failed >&2; exit 1"))
+	cmd.Stderr = &stderr
+	assert.Error(t, cmd.Run())
+	assert.Equal(t, "failed\n", stderr.String())
+
+	assert.Error(t, CommandContext(ctx, "no-such-command").Run())
+
+	cmd = CommandContext(ctx, "true")
+	cmd.Stdout = &bytes.Buffer{}
+	_, err = cmd.Output()
+	assert.Error(t, err)
+}
+
+func TestTailBuffer(t *testing.T) {
+	b := &tailBuffer{}
+	n, err := b.Write([]byte("abc"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, "abc", string(b.Bytes()))
+
+	b.Write(bytes.Repeat([]byte("x"), maxStderrLen-1))
+	assert.Equal(t, maxStderrLen, b.Len())
+	assert.Equal(t, "c", string(b.Bytes()[:1]))
+
+	b.Write([]byte("yz"))
+	assert.Equal(t, maxStderrLen, b.Len())
+	assert.Equal(t, "xyz", string(b.Bytes()[maxStderrLen-3:]))
+
+	n, _ = b.Write(bytes.Repeat([]byte("w"), 2*maxStderrLen))
+	assert.Equal(t, 2*maxStderrLen, n)
+	assert.Equal(t, bytes.Repeat([]byte("w"), maxStderrLen), b.Bytes())
+}
@@ -0,0 +1,74 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aoflags provides hooks for feature-flag evaluation libraries, e.g.,
+// LaunchDarkly or OpenFeature, which report each flag evaluation as an Info
+// event on the span bound to the context, or the active span of the goroutine
+// if the context has none. The event has the flag key, the provider and the
+// evaluation latency; the value of the flag is never reported.
+//
+// Call Before when the evaluation starts and After when it finishes:
+//   eval := aoflags.Before(ctx, "launchdarkly", "new-checkout")
+//   enabled, err := client.BoolVariation("new-checkout", user, false)
+//   eval.After(err)
+//
+// It can be adapted to the before/after hooks of the SDKs as well, e.g., by
+// keeping the *Evaluation in the hook hints of OpenFeature.
+package aoflags
+
+import (
+	"context"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+)
+
+const (
+	keyFlagKey      = "FlagKey"
+	keyFlagProvider = "FlagProvider"
+	keyFlagLatency  = "FlagLatency"
+	keyFlagError    = "FlagError"
+)
+
+// Evaluation is an in-flight flag evaluation started by Before.
+type Evaluation struct {
+	span     ao.Span
+	provider string
+	key      string
+	start    time.Time
+}
+
+// Before starts the evaluation of the flag by the provider.
+func Before(ctx context.Context, provider, flagKey string) *Evaluation {
+	return &Evaluation{
+		span:     spanOf(ctx),
+		provider: provider,
+		key:      flagKey,
+		start:    time.Now(),
+	}
+}
+
+// After finishes the evaluation and reports it, with the error returned by the
+// evaluation if any. The latency is in microseconds.
+func (e *Evaluation) After(err error) {
+	if e == nil || !e.span.IsReporting() {
+		return
+	}
+	args := []interface{}{
+		keyFlagKey, e.key,
+		keyFlagProvider, e.provider,
+		keyFlagLatency, time.Since(e.start).Nanoseconds() / 1000,
+	}
+	if err != nil {
+		args = append(args, keyFlagError, err.Error())
+	}
+	e.span.Info(args...)
+}
+
+// spanOf returns the span bound to ctx, or the active span of the goroutine if
+// there is none.
+func spanOf(ctx context.Context) ao.Span {
+	if span := ao.FromContext(ctx); span.IsReporting() {
+		return span
+	}
+	return ao.ActiveSpan()
+}
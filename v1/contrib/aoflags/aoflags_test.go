@@ -0,0 +1,32 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aoflags
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluation(t *testing.T) {
+	tr := ao.NewTrace("flags")
+	ctx := ao.NewContext(context.Background(), tr)
+
+	eval := Before(ctx, "launchdarkly", "new-checkout")
+	assert.Equal(t, tr, eval.span)
+	assert.Equal(t, "new-checkout", eval.key)
+	eval.After(nil)
+	Before(ctx, "launchdarkly", "new-checkout").After(errors.New("flag not found"))
+	tr.End()
+
+	// nothing is reported without a span
+	eval = Before(context.Background(), "launchdarkly", "new-checkout")
+	assert.False(t, eval.span.IsReporting())
+	eval.After(nil)
+
+	var nilEval *Evaluation
+	nilEval.After(nil)
+}
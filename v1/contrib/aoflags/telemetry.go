@@ -0,0 +1,80 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aoflags
+
+import (
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+)
+
+// The names of the flag evaluation metrics, tagged with the FlagKey, the
+// Provider and the Reason of the evaluation. The latency is in microseconds.
+const (
+	EvaluationCountMetricName   = "feature_flag.evaluations"
+	EvaluationErrorMetricName   = "feature_flag.evaluation_errors"
+	EvaluationLatencyMetricName = "feature_flag.evaluation_latency"
+)
+
+// EvaluationEvent is the result of a flag evaluation. The fields follow the
+// evaluation event attributes of the OpenFeature telemetry conventions, except
+// the variant and the value of the flag which are not collected.
+type EvaluationEvent struct {
+	FlagKey      string
+	ProviderName string
+	// Reason is the OpenFeature resolution reason, e.g., TARGETING_MATCH.
+	Reason string
+	// ErrorCode is the OpenFeature error code, e.g., FLAG_NOT_FOUND, or empty
+	// if the evaluation succeeded.
+	ErrorCode string
+	Latency   time.Duration
+}
+
+// Telemetry aggregates the flag evaluations into the agent's metrics per flag
+// key. It's meant to be called from the Finally stage of an OpenFeature hook,
+// or wherever the SDK exposes the evaluation details:
+//   func (h hook) Finally(ctx context.Context, hc openfeature.HookContext,
+//       details openfeature.InterfaceEvaluationDetails, hints openfeature.HookHints) {
+//       h.telemetry.Record(aoflags.EvaluationEvent{
+//           FlagKey:      hc.FlagKey(),
+//           ProviderName: hc.ProviderMetadata().Name,
+//           Reason:       string(details.Reason),
+//           ErrorCode:    string(details.ErrorCode),
+//           Latency:      time.Since(hints.Value("start").(time.Time)),
+//       })
+//   }
+type Telemetry struct {
+	// Tags are added to all the metrics, e.g., the service environment.
+	Tags map[string]string
+}
+
+// NewTelemetry returns a Telemetry adding tags to all the metrics.
+func NewTelemetry(tags map[string]string) *Telemetry {
+	return &Telemetry{Tags: tags}
+}
+
+// Record aggregates the evaluation event. The events of all the requests are
+// aggregated whether they are sampled or not.
+func (t *Telemetry) Record(evt EvaluationEvent) {
+	tags := make(map[string]string, len(t.Tags)+3)
+	for k, v := range t.Tags {
+		tags[k] = v
+	}
+	tags["FlagKey"] = evt.FlagKey
+	tags["Provider"] = evt.ProviderName
+	if evt.Reason != "" {
+		tags["Reason"] = evt.Reason
+	}
+
+	opts := ao.MetricOptions{Count: 1, Tags: tags}
+	ao.IncrementMetric(EvaluationCountMetricName, opts)
+	ao.SummaryMetric(EvaluationLatencyMetricName, float64(evt.Latency.Nanoseconds()/1000), opts)
+	if evt.ErrorCode != "" {
+		errTags := make(map[string]string, len(tags)+1)
+		for k, v := range tags {
+			errTags[k] = v
+		}
+		errTags["ErrorCode"] = evt.ErrorCode
+		ao.IncrementMetric(EvaluationErrorMetricName, ao.MetricOptions{Count: 1, Tags: errTags})
+	}
+}
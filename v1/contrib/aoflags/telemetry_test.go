@@ -0,0 +1,30 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aoflags
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTelemetryRecord(t *testing.T) {
+	tags := map[string]string{"Env": "test"}
+	tm := NewTelemetry(tags)
+	tm.Record(EvaluationEvent{
+		FlagKey:      "new-checkout",
+		ProviderName: "flagd",
+		Reason:       "TARGETING_MATCH",
+		Latency:      time.Millisecond,
+	})
+	tm.Record(EvaluationEvent{
+		FlagKey:      "missing",
+		ProviderName: "flagd",
+		ErrorCode:    "FLAG_NOT_FOUND",
+	})
+	// the tags of the Telemetry are not modified
+	assert.Equal(t, map[string]string{"Env": "test"}, tags)
+
+	NewTelemetry(nil).Record(EvaluationEvent{FlagKey: "k"})
+}
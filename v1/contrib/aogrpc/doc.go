@@ -0,0 +1,35 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aogrpc provides the gRPC interceptors which trace the RPCs of a
+// server and a client without hand-written spans around every handler.
+//
+// On the server side, each unary and streaming RPC is traced as a transaction
+// named after the service and method. The trace context of the client is read
+// from the incoming gRPC metadata, as well as the trigger trace request
+// (x-trace-options), which is answered by the x-trace-options-response header:
+//   s := grpc.NewServer(
+//       grpc.UnaryInterceptor(aogrpc.UnaryServerInterceptor("myService")),
+//       grpc.StreamInterceptor(aogrpc.StreamServerInterceptor("myService")),
+//   )
+//
+// On the client side, each RPC is reported as a remote call span of the trace
+// bound to the call's context, which is propagated to the server as the
+// X-Trace gRPC metadata:
+//   conn, err := grpc.Dial(target,
+//       grpc.WithUnaryInterceptor(aogrpc.UnaryClientInterceptor(target, "myService")),
+//       grpc.WithStreamInterceptor(aogrpc.StreamClientInterceptor(target, "myService")),
+//   )
+//
+// The address of the server picked by the client-side load balancer and the
+// time it takes to pick it are reported on the client spans by the stats
+// handler, which is installed along with the interceptors:
+//   grpc.WithStatsHandler(aogrpc.ClientStatsHandler())
+//
+// The health checks and long-lived watch streams can dominate the traces. The
+// methods traced by an interceptor can be limited with the options:
+//   aogrpc.UnaryServerInterceptor("myService",
+//       aogrpc.WithMethodDenylist("/grpc.health.v1.Health/*"))
+//
+// A failed RPC is reported as an error event with its gRPC status code and
+// whether it can be retried.
+package aogrpc
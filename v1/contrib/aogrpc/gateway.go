@@ -0,0 +1,36 @@
+package aogrpc
+
+import (
+	"net/http"
+
+	"golang.org/x/net/context"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"google.golang.org/grpc/metadata"
+)
+
+// GatewayMetadata propagates the trace context from the HTTP layer of
+// grpc-gateway to the gRPC server, so a request entering through the gateway
+// produces one trace with the HTTP and gRPC layers linked. It's meant to be
+// registered with grpc-gateway's runtime.WithMetadata option:
+//   mux := runtime.NewServeMux(runtime.WithMetadata(aogrpc.GatewayMetadata))
+//   http.ListenAndServe(":8080", ao.HTTPHandler(mux.ServeHTTP))
+// The gRPC server should use UnaryServerInterceptor or StreamServerInterceptor
+// to continue the trace.
+//
+// The context of the span bound to the request, e.g., the one started by
+// ao.HTTPHandler, is propagated. If there is none, the X-Trace header of the
+// request is forwarded as is.
+func GatewayMetadata(ctx context.Context, req *http.Request) metadata.MD {
+	xtID := ao.MetadataString(ctx)
+	if xtID == "" && req != nil {
+		xtID = ao.MetadataString(req.Context())
+		if xtID == "" {
+			xtID = req.Header.Get(ao.HTTPHeaderName)
+		}
+	}
+	if xtID == "" {
+		return nil
+	}
+	return metadata.Pairs(ao.HTTPHeaderName, xtID)
+}
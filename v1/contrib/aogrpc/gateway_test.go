@@ -0,0 +1,25 @@
+package aogrpc
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGatewayMetadata(t *testing.T) {
+	xtID := "2BF4CAA9BB8AF02BD4B1EC4F8CDB0C9A36C3B0BF34F8E67D9B6846A2C101"
+
+	// no trace context at all
+	req := httptest.NewRequest("GET", "/v1/echo", nil)
+	assert.Nil(t, GatewayMetadata(context.Background(), req))
+	assert.Nil(t, GatewayMetadata(context.Background(), nil))
+
+	// the request header is forwarded if the HTTP layer is not traced
+	req.Header.Set(ao.HTTPHeaderName, xtID)
+	md := GatewayMetadata(context.Background(), req)
+	assert.Equal(t, xtID, getFirstValFromMd(md, ao.HTTPHeaderName))
+}
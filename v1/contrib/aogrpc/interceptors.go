@@ -14,7 +14,9 @@ import (
 
 	"github.com/pkg/errors"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 func actionFromMethod(method string) string {
@@ -41,6 +43,29 @@ func getErrClass(err error) string {
 	return "error"
 }
 
+// errOpts returns the options of an error event for a failed RPC, which include
+// the gRPC status code and whether the call is retryable.
+func errOpts(err error) []ao.ErrOpt {
+	code := status.Code(err)
+	return []ao.ErrOpt{
+		ao.WithErrType(ao.ErrTypeException),
+		ao.WithErrClass(getErrClass(err)),
+		ao.WithErrMsg(err.Error()),
+		ao.WithErrBackTrace(true),
+		ao.WithErrGRPCStatus(code.String()),
+		ao.WithErrRetryable(isRetryable(code)),
+	}
+}
+
+// isRetryable tells if an RPC failed with this status code may succeed on retry.
+func isRetryable(code codes.Code) bool {
+	switch code {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
 var (
 	errNilStackTracer  = errors.New("nil stackTracer pointer")
 	errEmptyStackTrace = errors.New("empty stack trace")
@@ -75,18 +100,24 @@ func getFirstValFromMd(md metadata.MD, key string) string {
 	return v
 }
 
-func tracingContext(ctx context.Context, serverName string, methodName string, statusCode *int) (context.Context, ao.Trace) {
+func tracingContext(ctx context.Context, serverName string, methodName string, statusCode *int, o *options) (context.Context, ao.Trace) {
 
 	action := actionFromMethod(methodName)
 
 	xtID := ""
 	opt := ""
 	signature := ""
+	sampled := ""
 	md, ok := metadata.FromIncomingContext(ctx)
 	if ok {
 		xtID = getFirstValFromMd(md, ao.HTTPHeaderName)
+		if xtID == "" {
+			xtID = ao.MetadataFromW3C(getFirstValFromMd(md, ao.HTTPHeaderTraceparent),
+				getFirstValFromMd(md, ao.HTTPHeaderTracestate))
+		}
 		opt = getFirstValFromMd(md, ao.HTTPHeaderXTraceOptions)
 		signature = getFirstValFromMd(md, ao.HTTPHeaderXTraceOptionsSignature)
+		sampled = getFirstValFromMd(md, ao.HTTPHeaderXTraceSampled)
 	}
 
 	t := ao.NewTraceWithOptions(serverName, ao.SpanOptions{
@@ -95,6 +126,7 @@ func tracingContext(ctx context.Context, serverName string, methodName string, s
 			URL:                    methodName,
 			XTraceOptions:          opt,
 			XTraceOptionsSignature: signature,
+			SampledHint:            sampled,
 			CB: func() ao.KVMap {
 				kvs := ao.KVMap{
 					"Method":     "POST",
@@ -109,26 +141,71 @@ func tracingContext(ctx context.Context, serverName string, methodName string, s
 		}})
 
 	t.SetMethod("POST")
+	if o.transactionType {
+		t.SetTransactionType(ao.TransactionTypeGRPC)
+	}
 	t.SetTransactionName(serverName + "." + action)
 	t.SetStartTime(time.Now())
 
 	return ao.NewContext(ctx, t), t
 }
 
+// responseHeaders returns the response headers of the trace as the metadata,
+// e.g., X-Trace-Options-Response of a trigger trace request, or nil if none.
+func responseHeaders(t ao.Trace) metadata.MD {
+	headers := t.HTTPRspHeaders()
+	if len(headers) == 0 {
+		return nil
+	}
+	md := metadata.MD{}
+	for k, v := range headers {
+		md.Set(k, v)
+	}
+	return md
+}
+
+// outgoingContext sets the trace context in the outgoing metadata, as both
+// X-Trace and the W3C Trace Context, and X-Trace-Sampled if enabled. The
+// tracestate entries of the other vendors already in the metadata are kept.
+func outgoingContext(ctx context.Context, xtID string) context.Context {
+	if len(xtID) == 0 {
+		return ctx
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	md.Set(ao.HTTPHeaderName, xtID)
+	if traceparent, _ := ao.W3CTraceContext(xtID); traceparent != "" {
+		md.Set(ao.HTTPHeaderTraceparent, traceparent)
+		tracestate := strings.Join(md.Get(ao.HTTPHeaderTracestate), ",")
+		md.Set(ao.HTTPHeaderTracestate, ao.MergeTraceState(xtID, tracestate))
+	}
+	if sampled := ao.SampledHeaderValue(xtID); sampled != "" {
+		md.Set(ao.HTTPHeaderXTraceSampled, sampled)
+	}
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
 // UnaryServerInterceptor returns an interceptor that traces gRPC unary server RPCs using AppOptics.
 // If the client is using UnaryClientInterceptor, the distributed trace's context will be read from the client.
-func UnaryServerInterceptor(serverName string) grpc.UnaryServerInterceptor {
+func UnaryServerInterceptor(serverName string, opts ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(opts)
 	return func(
 		ctx context.Context,
 		req interface{},
 		info *grpc.UnaryServerInfo,
 		handler grpc.UnaryHandler,
 	) (interface{}, error) {
+		if !o.traced(info.FullMethod) {
+			return handler(ctx, req)
+		}
 		var err error
 		var resp interface{}
 		var statusCode = 200
 		var t ao.Trace
-		ctx, t = tracingContext(ctx, serverName, info.FullMethod, &statusCode)
+		ctx, t = tracingContext(ctx, serverName, info.FullMethod, &statusCode, o)
+		if md := responseHeaders(t); md != nil {
+			grpc.SetHeader(ctx, md)
+		}
 		defer func() {
 			t.SetStatus(statusCode)
 			ao.EndTrace(ctx)
@@ -136,7 +213,7 @@ func UnaryServerInterceptor(serverName string) grpc.UnaryServerInterceptor {
 		resp, err = handler(ctx, req)
 		if err != nil {
 			statusCode = 500
-			ao.Error(ctx, getErrClass(err), err.Error())
+			ao.ErrorWithOpts(ctx, errOpts(err)...)
 		}
 		return resp, err
 	}
@@ -146,12 +223,29 @@ func UnaryServerInterceptor(serverName string) grpc.UnaryServerInterceptor {
 type wrappedServerStream struct {
 	grpc.ServerStream
 	WrappedContext context.Context
+	stats          *streamStats
 }
 
 func (w *wrappedServerStream) Context() context.Context {
 	return w.WrappedContext
 }
 
+func (w *wrappedServerStream) SendMsg(m interface{}) error {
+	err := w.ServerStream.SendMsg(m)
+	if err == nil && w.stats != nil {
+		w.stats.msgSent()
+	}
+	return err
+}
+
+func (w *wrappedServerStream) RecvMsg(m interface{}) error {
+	err := w.ServerStream.RecvMsg(m)
+	if err == nil && w.stats != nil {
+		w.stats.msgReceived()
+	}
+	return err
+}
+
 func wrapServerStream(stream grpc.ServerStream) *wrappedServerStream {
 	if existing, ok := stream.(*wrappedServerStream); ok {
 		return existing
@@ -161,12 +255,23 @@ func wrapServerStream(stream grpc.ServerStream) *wrappedServerStream {
 
 // StreamServerInterceptor returns an interceptor that traces gRPC streaming server RPCs using AppOptics.
 // Each server span starts with the first message and ends when all request and response messages have finished streaming.
-func StreamServerInterceptor(serverName string) grpc.StreamServerInterceptor {
+// The number of messages in each direction, the stream duration and whether the stream is cancelled are reported
+// with the span and aggregated into the per-method stream metrics.
+func StreamServerInterceptor(serverName string, opts ...Option) grpc.StreamServerInterceptor {
+	o := newOptions(opts)
 	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !o.traced(info.FullMethod) {
+			return handler(srv, stream)
+		}
 		var err error
 		var statusCode = 200
-		newCtx, t := tracingContext(stream.Context(), serverName, info.FullMethod, &statusCode)
+		newCtx, t := tracingContext(stream.Context(), serverName, info.FullMethod, &statusCode, o)
+		if md := responseHeaders(t); md != nil {
+			stream.SetHeader(md)
+		}
+		stats := newStreamStats(info.FullMethod, roleServer)
 		defer func() {
+			stats.finish(t, isCancelled(newCtx, err))
 			t.SetStatus(statusCode)
 			ao.EndTrace(newCtx)
 		}()
@@ -176,12 +281,14 @@ func StreamServerInterceptor(serverName string) grpc.StreamServerInterceptor {
 		// }
 		wrappedStream := wrapServerStream(stream)
 		wrappedStream.WrappedContext = newCtx
+		wrappedStream.stats = stats
 		err = handler(srv, wrappedStream)
 		if err == io.EOF {
+			err = nil
 			return nil
 		} else if err != nil {
 			statusCode = 500
-			ao.Error(newCtx, getErrClass(err), err.Error())
+			ao.ErrorWithOpts(newCtx, errOpts(err)...)
 		}
 		return err
 	}
@@ -189,7 +296,8 @@ func StreamServerInterceptor(serverName string) grpc.StreamServerInterceptor {
 
 // UnaryClientInterceptor returns an interceptor that traces a unary RPC from a gRPC client to a server using
 // AppOptics, by propagating the distributed trace's context from client to server using gRPC metadata.
-func UnaryClientInterceptor(target string, serviceName string) grpc.UnaryClientInterceptor {
+func UnaryClientInterceptor(target string, serviceName string, options ...Option) grpc.UnaryClientInterceptor {
+	o := newOptions(options)
 	return func(
 		ctx context.Context,
 		method string,
@@ -198,16 +306,16 @@ func UnaryClientInterceptor(target string, serviceName string) grpc.UnaryClientI
 		invoker grpc.UnaryInvoker,
 		opts ...grpc.CallOption,
 	) error {
+		if !o.traced(method) {
+			return invoker(ctx, method, req, resp, cc, opts...)
+		}
 		action := actionFromMethod(method)
 		span := ao.BeginRPCSpan(ctx, action, "grpc", serviceName, target)
 		defer span.End()
-		xtID := span.MetadataString()
-		if len(xtID) > 0 {
-			ctx = metadata.AppendToOutgoingContext(ctx, ao.HTTPHeaderName, xtID)
-		}
+		ctx = outgoingContext(withClientSpan(ctx, span), span.MetadataString())
 		err := invoker(ctx, method, req, resp, cc, opts...)
 		if err != nil {
-			span.Error(getErrClass(err), err.Error())
+			span.ErrorWithOpts(errOpts(err)...)
 			return err
 		}
 		return nil
@@ -217,21 +325,25 @@ func UnaryClientInterceptor(target string, serviceName string) grpc.UnaryClientI
 // StreamClientInterceptor returns an interceptor that traces a streaming RPC from a gRPC client to a server using
 // AppOptics, by propagating the distributed trace's context from client to server using gRPC metadata.
 // The client span starts with the first message and ends when all request and response messages have finished streaming.
-func StreamClientInterceptor(target string, serviceName string) grpc.StreamClientInterceptor {
+func StreamClientInterceptor(target string, serviceName string, options ...Option) grpc.StreamClientInterceptor {
+	o := newOptions(options)
 	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if !o.traced(method) {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
 		action := actionFromMethod(method)
 		span := ao.BeginRPCSpan(ctx, action, "grpc", serviceName, target)
-		xtID := span.MetadataString()
-		// lg.Debug("stream client interceptor", "x-trace", xtID)
-		if len(xtID) > 0 {
-			ctx = metadata.AppendToOutgoingContext(ctx, ao.HTTPHeaderName, xtID)
-		}
+		ctx = outgoingContext(withClientSpan(ctx, span), span.MetadataString())
 		clientStream, err := streamer(ctx, desc, cc, method, opts...)
 		if err != nil {
 			closeSpan(span, err)
 			return nil, err
 		}
-		return &tracedClientStream{ClientStream: clientStream, span: span}, nil
+		return &tracedClientStream{
+			ClientStream: clientStream,
+			span:         span,
+			stats:        newStreamStats(method, roleClient),
+		}, nil
 	}
 }
 
@@ -240,6 +352,7 @@ type tracedClientStream struct {
 	mu     sync.Mutex
 	closed bool
 	span   ao.Span
+	stats  *streamStats
 }
 
 func (s *tracedClientStream) Header() (metadata.MD, error) {
@@ -254,6 +367,8 @@ func (s *tracedClientStream) SendMsg(m interface{}) error {
 	err := s.ClientStream.SendMsg(m)
 	if err != nil {
 		s.closeSpan(err)
+	} else {
+		s.stats.msgSent()
 	}
 	return err
 }
@@ -270,6 +385,8 @@ func (s *tracedClientStream) RecvMsg(m interface{}) error {
 	err := s.ClientStream.RecvMsg(m)
 	if err != nil {
 		s.closeSpan(err)
+	} else {
+		s.stats.msgReceived()
 	}
 	return err
 }
@@ -278,6 +395,7 @@ func (s *tracedClientStream) closeSpan(err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if !s.closed {
+		s.stats.finish(s.span, isCancelled(s.ClientStream.Context(), err))
 		closeSpan(s.span, err)
 		s.closed = true
 	}
@@ -286,7 +404,7 @@ func (s *tracedClientStream) closeSpan(err error) {
 func closeSpan(span ao.Span, err error) {
 	// lg.Debug("closing span", "err", err.Error())
 	if err != nil && err != io.EOF {
-		span.Error(getErrClass(err), err.Error())
+		span.ErrorWithOpts(errOpts(err)...)
 	}
 	span.End()
 }
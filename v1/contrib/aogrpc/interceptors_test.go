@@ -1,12 +1,14 @@
 package aogrpc
 
 import (
+	"context"
 	"testing"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao"
 	"github.com/appoptics/appoptics-apm-go/v1/contrib/aogrpc/mocks"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
 )
 
 func TestGetTopFramePkg(t *testing.T) {
@@ -63,3 +65,51 @@ func TestActionFromMethod(t *testing.T) {
 	assert.EqualValues(t, "", actionFromMethod("abc/"))
 	assert.EqualValues(t, "", actionFromMethod("/abc/"))
 }
+
+type rspHeadersTrace struct {
+	ao.Trace
+	headers map[string]string
+}
+
+func (t *rspHeadersTrace) HTTPRspHeaders() map[string]string { return t.headers }
+
+func TestResponseHeaders(t *testing.T) {
+	assert.Nil(t, responseHeaders(ao.NewNullTrace()))
+
+	md := responseHeaders(&rspHeadersTrace{headers: map[string]string{
+		ao.HTTPHeaderXTraceOptionsResponse: "trigger-trace=ok",
+	}})
+	assert.Equal(t, []string{"trigger-trace=ok"}, md.Get("x-trace-options-response"))
+}
+
+func TestOutgoingContext(t *testing.T) {
+	xtID := "2B0AF7651916CD43DD8448EB211C80319C5A7D0E3BB7AD6B716920333101"
+	traceparent, entry := ao.W3CTraceContext(xtID)
+	ctx := metadata.AppendToOutgoingContext(context.Background(),
+		ao.HTTPHeaderTracestate, "rojo=00f067aa0ba902b7,ao=2B00",
+		ao.HTTPHeaderTracestate, "congo=t61rcWkgMzE")
+	md, _ := metadata.FromOutgoingContext(outgoingContext(ctx, xtID))
+	assert.Equal(t, []string{xtID}, md.Get(ao.HTTPHeaderName))
+	assert.Equal(t, []string{traceparent}, md.Get(ao.HTTPHeaderTraceparent))
+	// the ao entry goes first and replaces the old one
+	assert.Equal(t, []string{entry + ",rojo=00f067aa0ba902b7,congo=t61rcWkgMzE"},
+		md.Get(ao.HTTPHeaderTracestate))
+}
+
+func TestMethodFilters(t *testing.T) {
+	o := newOptions(nil)
+	assert.True(t, o.traced("/grpc.health.v1.Health/Check"))
+
+	o = newOptions([]Option{WithMethodDenylist("/grpc.health.v1.Health/*")})
+	assert.False(t, o.traced("/grpc.health.v1.Health/Check"))
+	assert.True(t, o.traced("/shop.Orders/Get"))
+
+	o = newOptions([]Option{
+		WithMethodAllowlist("/shop.Orders/*", "/shop.Carts/Get"),
+		WithMethodDenylist("/shop.Orders/Watch"),
+	})
+	assert.True(t, o.traced("/shop.Orders/Get"))
+	assert.True(t, o.traced("/shop.Carts/Get"))
+	assert.False(t, o.traced("/shop.Carts/Update"))
+	assert.False(t, o.traced("/shop.Orders/Watch"))
+}
@@ -0,0 +1,70 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aogrpc
+
+import (
+	"path"
+)
+
+// Option configures the interceptors.
+type Option func(*options)
+
+type options struct {
+	allow           []string
+	deny            []string
+	transactionType bool
+}
+
+// WithMethodAllowlist traces only the methods matching any of the patterns. The
+// patterns are in the syntax of path.Match and are matched against the full
+// method names, e.g., "/shop.Orders/*".
+func WithMethodAllowlist(patterns ...string) Option {
+	return func(o *options) {
+		o.allow = append(o.allow, patterns...)
+	}
+}
+
+// WithMethodDenylist doesn't trace the methods matching any of the patterns,
+// e.g., "/grpc.health.v1.Health/*". It takes precedence over the allowlist.
+func WithMethodDenylist(patterns ...string) Option {
+	return func(o *options) {
+		o.deny = append(o.deny, patterns...)
+	}
+}
+
+// WithTransactionType tags the inbound metrics of the RPCs by the transaction
+// type grpc instead of the HTTP method and status of the RPCs, which are always
+// POST and the gRPC status code, respectively. It's off by default as it
+// changes the tags of the metrics already reported.
+func WithTransactionType() Option {
+	return func(o *options) {
+		o.transactionType = true
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// traced returns if the RPCs of the full method name are traced. The RPCs of
+// the other methods are passed through untouched, without any trace context
+// read or propagated.
+func (o *options) traced(method string) bool {
+	if matchAny(o.deny, method) {
+		return false
+	}
+	return len(o.allow) == 0 || matchAny(o.allow, method)
+}
+
+func matchAny(patterns []string, method string) bool {
+	for _, p := range patterns {
+		if matched, _ := path.Match(p, method); matched {
+			return true
+		}
+	}
+	return false
+}
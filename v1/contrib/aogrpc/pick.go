@@ -0,0 +1,91 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aogrpc
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+
+	"google.golang.org/grpc/stats"
+)
+
+const (
+	keyRemoteAddr  = "RemoteAddr"
+	keyPickLatency = "PickLatency"
+)
+
+type clientSpanKey struct{}
+
+// withClientSpan binds the client span of an RPC to its context, for the stats
+// handler to find it.
+func withClientSpan(ctx context.Context, span ao.Span) context.Context {
+	return context.WithValue(ctx, clientSpanKey{}, span)
+}
+
+// ClientStatsHandler returns a gRPC stats handler which reports, on the client
+// span of each RPC, the address of the server the load balancer picked
+// (RemoteAddr) and the time from the start of the RPC until it was sent on the
+// picked connection (PickLatency, in microseconds). The latter includes the
+// name resolution, the pick and the connection setup, which would otherwise
+// be mistaken for the time the server takes. It's installed along with the
+// client interceptors, which start the spans:
+//   conn, err := grpc.Dial(target,
+//       grpc.WithUnaryInterceptor(aogrpc.UnaryClientInterceptor(target, "myService")),
+//       grpc.WithStreamInterceptor(aogrpc.StreamClientInterceptor(target, "myService")),
+//       grpc.WithStatsHandler(aogrpc.ClientStatsHandler()),
+//   )
+func ClientStatsHandler() stats.Handler {
+	return clientStatsHandler{}
+}
+
+type clientStatsHandler struct{}
+
+// pickStats is the state of an RPC between the Begin and OutHeader stats.
+type pickStats struct {
+	once  sync.Once
+	span  ao.Span
+	begin time.Time
+}
+
+type pickStatsKey struct{}
+
+func (clientStatsHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	span, ok := ctx.Value(clientSpanKey{}).(ao.Span)
+	if !ok || !span.IsReporting() {
+		return ctx
+	}
+	return context.WithValue(ctx, pickStatsKey{}, &pickStats{span: span})
+}
+
+func (clientStatsHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	ps, ok := ctx.Value(pickStatsKey{}).(*pickStats)
+	if !ok || !s.IsClient() {
+		return
+	}
+	switch s := s.(type) {
+	case *stats.Begin:
+		ps.begin = s.BeginTime
+	case *stats.OutHeader:
+		// only the first pick is reported if the RPC is retried transparently
+		ps.once.Do(func() {
+			var args []interface{}
+			if s.RemoteAddr != nil {
+				args = append(args, keyRemoteAddr, s.RemoteAddr.String())
+			}
+			if !ps.begin.IsZero() {
+				args = append(args, keyPickLatency, time.Since(ps.begin).Nanoseconds()/1000)
+			}
+			ps.span.AddEndArgs(args...)
+		})
+	}
+}
+
+func (clientStatsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (clientStatsHandler) HandleConn(context.Context, stats.ConnStats) {}
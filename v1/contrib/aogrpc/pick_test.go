@@ -0,0 +1,48 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aogrpc
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/stats"
+)
+
+type reportingSpan struct {
+	endArgsSpan
+}
+
+func (s *reportingSpan) IsReporting() bool { return true }
+
+func TestClientStatsHandler(t *testing.T) {
+	h := ClientStatsHandler()
+	span := &reportingSpan{}
+	ctx := h.TagRPC(withClientSpan(context.Background(), span), &stats.RPCTagInfo{FullMethodName: "/svc/Get"})
+
+	addr := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 7), Port: 443}
+	h.HandleRPC(ctx, &stats.Begin{Client: true, BeginTime: time.Now().Add(-time.Millisecond)})
+	h.HandleRPC(ctx, &stats.OutHeader{Client: true, RemoteAddr: addr})
+	h.HandleRPC(ctx, &stats.OutHeader{Client: true, RemoteAddr: addr}) // a transparent retry
+	h.HandleRPC(ctx, &stats.End{Client: true})
+
+	assert.Len(t, span.args, 4)
+	assert.Equal(t, []interface{}{keyRemoteAddr, "10.0.0.7:443", keyPickLatency}, span.args[:3])
+	assert.True(t, span.args[3].(int64) >= 1000)
+}
+
+func TestClientStatsHandlerNoSpan(t *testing.T) {
+	h := ClientStatsHandler()
+	ctx := h.TagRPC(context.Background(), &stats.RPCTagInfo{})
+	assert.Nil(t, ctx.Value(pickStatsKey{}))
+	h.HandleRPC(ctx, &stats.OutHeader{Client: true})
+
+	// the RPC is not sampled
+	ctx = h.TagRPC(withClientSpan(context.Background(), ao.FromContext(context.Background())), &stats.RPCTagInfo{})
+	assert.Nil(t, ctx.Value(pickStatsKey{}))
+}
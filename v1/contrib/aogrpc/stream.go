@@ -0,0 +1,93 @@
+package aogrpc
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The names of the per-method stream metrics. All of them are tagged with the
+// full method name (Method) and the side of the stream (Role, "server" or
+// "client"); the message counts are tagged with the Direction ("sent" or
+// "received") as well.
+const (
+	StreamDurationMetricName  = "grpc.stream.duration"
+	StreamMessagesMetricName  = "grpc.stream.messages"
+	StreamCancelledMetricName = "grpc.stream.cancelled"
+)
+
+const (
+	keyMessagesSent     = "MessagesSent"
+	keyMessagesReceived = "MessagesReceived"
+	keyStreamDuration   = "StreamDuration"
+	keyStreamCancelled  = "StreamCancelled"
+)
+
+const (
+	roleServer = "server"
+	roleClient = "client"
+)
+
+// streamStats counts the messages of a single stream in each direction.
+type streamStats struct {
+	method   string
+	role     string
+	start    time.Time
+	sent     int64
+	received int64
+}
+
+func newStreamStats(method, role string) *streamStats {
+	return &streamStats{method: method, role: role, start: time.Now()}
+}
+
+func (s *streamStats) msgSent()     { atomic.AddInt64(&s.sent, 1) }
+func (s *streamStats) msgReceived() { atomic.AddInt64(&s.received, 1) }
+
+// finish reports the per-stream KVs to the span and aggregates the per-method
+// stream metrics. The stream duration is reported in microseconds.
+func (s *streamStats) finish(span ao.Span, cancelled bool) {
+	duration := time.Since(s.start)
+	sent := atomic.LoadInt64(&s.sent)
+	received := atomic.LoadInt64(&s.received)
+
+	span.AddEndArgs(
+		keyMessagesSent, sent,
+		keyMessagesReceived, received,
+		keyStreamDuration, duration.Nanoseconds()/1000,
+		keyStreamCancelled, cancelled,
+	)
+
+	tags := map[string]string{"Method": s.method, "Role": s.role}
+	ao.SummaryMetric(StreamDurationMetricName, float64(duration.Nanoseconds()/1000),
+		ao.MetricOptions{Count: 1, Tags: tags})
+	for dir, n := range map[string]int64{"sent": sent, "received": received} {
+		ao.SummaryMetric(StreamMessagesMetricName, float64(n), ao.MetricOptions{
+			Count: 1,
+			Tags:  map[string]string{"Method": s.method, "Role": s.role, "Direction": dir},
+		})
+	}
+	if cancelled {
+		ao.IncrementMetric(StreamCancelledMetricName, ao.MetricOptions{Count: 1, Tags: tags})
+	}
+}
+
+// isCancelled tells if a stream ended with err was cancelled by either side. A
+// stream which ends cleanly is never cancelled, though grpc-go cancels the
+// context of a client stream once it's finished.
+func isCancelled(ctx context.Context, err error) bool {
+	if err == nil || err == io.EOF {
+		return false
+	}
+	if err == context.Canceled || status.Code(err) == codes.Canceled {
+		return true
+	}
+	return ctx != nil && ctx.Err() == context.Canceled
+}
@@ -0,0 +1,131 @@
+package aogrpc
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/grpc/test/grpc_testing"
+)
+
+type endArgsSpan struct {
+	ao.Span
+	args []interface{}
+}
+
+func (s *endArgsSpan) AddEndArgs(args ...interface{}) { s.args = append(s.args, args...) }
+
+type fakeServerStream struct {
+	grpc.ServerStream
+	recv int
+}
+
+func (s *fakeServerStream) Context() context.Context    { return context.Background() }
+func (s *fakeServerStream) SendMsg(m interface{}) error { return nil }
+func (s *fakeServerStream) RecvMsg(m interface{}) error {
+	if s.recv == 0 {
+		return io.EOF
+	}
+	s.recv--
+	return nil
+}
+
+func TestStreamStats(t *testing.T) {
+	stats := newStreamStats("/svc/Chat", roleServer)
+	w := wrapServerStream(&fakeServerStream{recv: 2})
+	w.stats = stats
+
+	assert.NoError(t, w.SendMsg("out"))
+	for w.RecvMsg(nil) == nil {
+	}
+	assert.EqualValues(t, 1, stats.sent)
+	assert.EqualValues(t, 2, stats.received)
+
+	span := &endArgsSpan{}
+	stats.finish(span, true)
+	kvs := make(map[string]interface{})
+	for i := 0; i+1 < len(span.args); i += 2 {
+		kvs[span.args[i].(string)] = span.args[i+1]
+	}
+	assert.EqualValues(t, 1, kvs[keyMessagesSent])
+	assert.EqualValues(t, 2, kvs[keyMessagesReceived])
+	assert.Equal(t, true, kvs[keyStreamCancelled])
+	assert.Contains(t, kvs, keyStreamDuration)
+}
+
+func TestIsCancelled(t *testing.T) {
+	assert.False(t, isCancelled(context.Background(), nil))
+	assert.False(t, isCancelled(context.Background(), errors.New("failed")))
+	assert.True(t, isCancelled(context.Background(), context.Canceled))
+	assert.True(t, isCancelled(context.Background(), status.Error(codes.Canceled, "cancelled")))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.True(t, isCancelled(ctx, errors.New("transport is closing")))
+	// the clean end of a stream
+	assert.False(t, isCancelled(ctx, nil))
+	assert.False(t, isCancelled(ctx, io.EOF))
+}
+
+type streamingServer struct {
+	*grpc_testing.UnimplementedTestServiceServer
+}
+
+func (streamingServer) StreamingOutputCall(req *grpc_testing.StreamingOutputCallRequest,
+	stream grpc_testing.TestService_StreamingOutputCallServer) error {
+	for i := 0; i < 2; i++ {
+		if err := stream.Send(&grpc_testing.StreamingOutputCallResponse{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestClientStreamCompleted(t *testing.T) {
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	grpc_testing.RegisterTestServiceServer(srv, streamingServer{})
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	cc, err := grpc.Dial("bufnet", grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }))
+	assert.NoError(t, err)
+	defer cc.Close()
+
+	desc := &grpc.StreamDesc{StreamName: "StreamingOutputCall", ServerStreams: true}
+	cs, err := StreamClientInterceptor("bufnet", "test")(context.Background(), desc, cc,
+		"/grpc.testing.TestService/StreamingOutputCall",
+		func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+			opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return cc.NewStream(ctx, desc, method, opts...)
+		})
+	assert.NoError(t, err)
+	span := &endArgsSpan{Span: cs.(*tracedClientStream).span}
+	cs.(*tracedClientStream).span = span
+
+	assert.NoError(t, cs.SendMsg(&grpc_testing.StreamingOutputCallRequest{}))
+	assert.NoError(t, cs.CloseSend())
+	for {
+		if err = cs.RecvMsg(&grpc_testing.StreamingOutputCallResponse{}); err != nil {
+			break
+		}
+	}
+	assert.Equal(t, io.EOF, err)
+
+	kvs := make(map[string]interface{})
+	for i := 0; i+1 < len(span.args); i += 2 {
+		kvs[span.args[i].(string)] = span.args[i+1]
+	}
+	assert.EqualValues(t, 2, kvs[keyMessagesReceived])
+	assert.Equal(t, false, kvs[keyStreamCancelled])
+}
@@ -0,0 +1,56 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aohttp provides a drop-in replacement of http.ServeMux which traces
+// every request it serves and names the transaction after the pattern the
+// request matches, so the handlers don't need to be wrapped one by one:
+//   mux := aohttp.NewServeMux()
+//   mux.HandleFunc("/users/", usersHandler)
+//   mux.HandleFunc("GET /orders/{id}", orderHandler) // Go 1.22+ patterns
+//   http.ListenAndServe(":8080", mux)
+//
+// A transaction name set by the handler takes precedence.
+package aohttp
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+)
+
+const spanName = "http.HandlerFunc"
+
+// ServeMux is an http.ServeMux tracing the requests it serves.
+type ServeMux struct {
+	*http.ServeMux
+}
+
+// NewServeMux allocates and returns a new ServeMux.
+func NewServeMux() *ServeMux {
+	return &ServeMux{ServeMux: http.NewServeMux()}
+}
+
+// ServeHTTP traces the request and dispatches it to the handler whose pattern
+// most closely matches the request.
+func (m *ServeMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if ao.Closed() {
+		m.ServeMux.ServeHTTP(w, r)
+		return
+	}
+
+	_, pattern := m.ServeMux.Handler(r)
+	t, w, r := ao.TraceFromHTTPRequestResponse(spanName, w, r)
+	defer t.End()
+
+	if pattern != "" {
+		t.SetTransactionName(pattern)
+	}
+
+	defer func() { // catch and report panic, if one occurs
+		if err := recover(); err != nil {
+			t.Error("panic", fmt.Sprintf("%v", err))
+			panic(err) // re-raise the panic
+		}
+	}()
+	m.ServeMux.ServeHTTP(w, r)
+}
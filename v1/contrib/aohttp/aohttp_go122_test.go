@@ -0,0 +1,26 @@
+// +build go1.22
+
+//go:debug httpmuxgo121=0
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aohttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeMuxMethodPattern(t *testing.T) {
+	mux := NewServeMux()
+	var name string
+	mux.HandleFunc("GET /orders/{id}", func(w http.ResponseWriter, r *http.Request) {
+		name = ao.GetTransactionName(r.Context())
+	})
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/orders/7", nil))
+	assert.Equal(t, "GET /orders/{id}", name)
+}
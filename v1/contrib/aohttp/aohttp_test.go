@@ -0,0 +1,39 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aohttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeMux(t *testing.T) {
+	mux := NewServeMux()
+	var name string
+	mux.HandleFunc("/users/", func(w http.ResponseWriter, r *http.Request) {
+		name = ao.GetTransactionName(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/custom", func(w http.ResponseWriter, r *http.Request) {
+		ao.SetTransactionName(r.Context(), "custom-name")
+		name = ao.GetTransactionName(r.Context())
+	})
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/users/42", nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "/users/", name)
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/custom", nil))
+	assert.Equal(t, "custom-name", name)
+
+	// no pattern matches
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/missing", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
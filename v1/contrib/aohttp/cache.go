@@ -0,0 +1,96 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aohttp
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+)
+
+// The cache status of a response.
+const (
+	CacheHit   = "hit"
+	CacheMiss  = "miss"
+	CacheStale = "stale"
+)
+
+// CacheHitRatioMetricName is the name of the measurement of the responses
+// served from the cache, tagged with the TransactionName. Each response is
+// recorded as 1 if it's a hit or 0 otherwise, so the average is the hit ratio.
+const CacheHitRatioMetricName = "http.cache.hit_ratio"
+
+const keyCacheStatus = "CacheStatus"
+
+// SetCacheStatus reports the cache status of the response to the request
+// traced by ctx, for the caching layers which don't expose it in the response
+// headers.
+func SetCacheStatus(ctx context.Context, status string) {
+	t := ao.TraceFromContext(ctx)
+	t.AddEndArgs(keyCacheStatus, status)
+
+	// the request is not traced by the agent at all
+	name := t.GetTransactionName()
+	if name == "" {
+		return
+	}
+
+	var value float64
+	if status == CacheHit {
+		value = 1
+	}
+	ao.SummaryMetric(CacheHitRatioMetricName, value, ao.MetricOptions{
+		Count: 1,
+		Tags:  map[string]string{"TransactionName": name},
+	})
+}
+
+// CacheStatusHandler reports the cache status of the responses written by
+// handler, which is usually a caching middleware. The status is read from the
+// X-Cache, X-Cache-Status (nginx) or Cache-Status (RFC 9211) response header.
+// It must be wrapped in a traced handler, e.g., registered on a ServeMux:
+//   mux.Handle("/", aohttp.CacheStatusHandler(cache.Middleware(handler)))
+func CacheStatusHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r)
+		if status := cacheStatusOf(w.Header()); status != "" {
+			SetCacheStatus(r.Context(), status)
+		}
+	})
+}
+
+// cacheStatusOf returns the cache status in the response headers, or an empty
+// string if it's not found or not recognized.
+func cacheStatusOf(h http.Header) string {
+	if v := h.Get("Cache-Status"); v != "" {
+		// e.g., "ExampleCache; hit" or "ExampleCache; fwd=stale; stored"
+		params := strings.Split(strings.ToLower(v), ";")
+		for _, p := range params[1:] {
+			switch strings.TrimSpace(p) {
+			case "hit":
+				return CacheHit
+			case "fwd=stale":
+				return CacheStale
+			}
+		}
+		return CacheMiss
+	}
+
+	v := h.Get("X-Cache-Status")
+	if v == "" {
+		v = h.Get("X-Cache")
+	}
+	v = strings.ToLower(v)
+	switch {
+	case strings.HasPrefix(v, "hit"):
+		return CacheHit
+	case strings.HasPrefix(v, "stale"), strings.HasPrefix(v, "updating"):
+		return CacheStale
+	case strings.HasPrefix(v, "miss"), strings.HasPrefix(v, "expired"),
+		strings.HasPrefix(v, "bypass"), strings.HasPrefix(v, "revalidated"):
+		return CacheMiss
+	}
+	return ""
+}
@@ -0,0 +1,49 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aohttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheStatusOf(t *testing.T) {
+	for hdr, expected := range map[[2]string]string{
+		{"X-Cache", "HIT from proxy"}:                          CacheHit,
+		{"X-Cache", "MISS"}:                                    CacheMiss,
+		{"X-Cache-Status", "STALE"}:                            CacheStale,
+		{"X-Cache-Status", "UPDATING"}:                         CacheStale,
+		{"X-Cache-Status", "EXPIRED"}:                          CacheMiss,
+		{"Cache-Status", "ExampleCache; hit"}:                  CacheHit,
+		{"Cache-Status", "ExampleCache; fwd=stale"}:            CacheStale,
+		{"Cache-Status", "ExampleCache; fwd=uri-miss; stored"}: CacheMiss,
+		{"X-Cache", "unknown"}:                                 "",
+		{"Content-Type", "text/plain"}:                         "",
+	} {
+		h := make(http.Header)
+		h.Set(hdr[0], hdr[1])
+		assert.Equal(t, expected, cacheStatusOf(h), hdr)
+	}
+}
+
+func TestCacheStatusHandler(t *testing.T) {
+	mux := NewServeMux()
+	var status string
+	mux.Handle("/cached", CacheStatusHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Cache", "HIT")
+		status = cacheStatusOf(w.Header())
+	})))
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/cached", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, CacheHit, status)
+
+	// not traced
+	w = httptest.NewRecorder()
+	CacheStatusHandler(http.NotFoundHandler()).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,75 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aohttp
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+)
+
+// Stage is a named middleware of a chain built by Chain.
+type Stage struct {
+	Name       string
+	Middleware func(http.Handler) http.Handler
+}
+
+// Chain wraps handler in the middlewares of the stages, the first stage being
+// the outermost one, and reports the time each middleware spends before
+// passing the request on to the next one as a child span named after the
+// stage:
+//   mux.Handle("/api/", aohttp.Chain(apiHandler,
+//       aohttp.Stage{Name: "auth", Middleware: auth},
+//       aohttp.Stage{Name: "ratelimit", Middleware: limiter.Wrap},
+//       aohttp.Stage{Name: "decompress", Middleware: gunzip},
+//   ))
+// The time is measured between the boundaries of the stages, so the work a
+// middleware does after the next one returns is not included. If a middleware
+// ends the request, e.g., auth rejects it, the span of that middleware lasts
+// until the chain returns. The chain must be wrapped in a traced handler,
+// e.g., registered on a ServeMux.
+func Chain(handler http.Handler, stages ...Stage) http.Handler {
+	h := handler
+	for i := len(stages) - 1; i >= 0; i-- {
+		h = stages[i].Middleware(boundary(i, h))
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ao.IsSampled(r.Context()) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		c := &stageClock{stages: stages, last: time.Now()}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), stageClockKey{}, c)))
+		if c.next < len(stages) {
+			c.report(r.Context(), c.next)
+		}
+	})
+}
+
+type stageClockKey struct{}
+
+// stageClock tracks the stages of a chain a request has passed.
+type stageClock struct {
+	stages []Stage
+	next   int // the stage the request is in
+	last   time.Time
+}
+
+// report reports the span of the stage i, from the last boundary to now.
+func (c *stageClock) report(ctx context.Context, i int) {
+	span, _ := ao.NewSpanBuilder(c.stages[i].Name).WithStartTime(c.last).Build(ctx)
+	span.End()
+	c.next, c.last = i+1, time.Now()
+}
+
+// boundary returns the handler marking the end of the stage i.
+func boundary(i int, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c, ok := r.Context().Value(stageClockKey{}).(*stageClock); ok && c.next == i {
+			c.report(r.Context(), i)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
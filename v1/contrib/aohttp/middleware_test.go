@@ -0,0 +1,53 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aohttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChain(t *testing.T) {
+	var calls []string
+	mw := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	h := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "app")
+		w.WriteHeader(http.StatusAccepted)
+	}), Stage{"auth", mw("auth")}, Stage{"ratelimit", mw("ratelimit")})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, []string{"auth", "ratelimit", "app"}, calls)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+}
+
+func TestBoundary(t *testing.T) {
+	start := time.Now()
+	c := &stageClock{stages: []Stage{{Name: "auth"}, {Name: "ratelimit"}}, last: start}
+	r := httptest.NewRequest("GET", "/", nil)
+	r = r.WithContext(context.WithValue(r.Context(), stageClockKey{}, c))
+	app := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	// a boundary out of order is ignored
+	boundary(1, app).ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal(t, 0, c.next)
+
+	boundary(0, boundary(1, app)).ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal(t, 2, c.next)
+	assert.False(t, c.last.Before(start))
+
+	// no clock in the context
+	boundary(0, app).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
@@ -0,0 +1,170 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aoio reports the large file and blob reads and writes as spans with
+// the number of bytes transferred and the throughput, for the services whose
+// latency is dominated by I/O. It's opt-in per call, so the small reads and
+// writes are not traced:
+//   n, err := aoio.Copy(ctx, "upload", "photos/42.jpg", bucketWriter, req.Body)
+//
+//   r := aoio.NewReader(ctx, "download", path, f)
+//   defer r.Close()
+//   _, err := io.Copy(w, r)
+package aoio
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+)
+
+const (
+	spanName = "io"
+
+	keyOp         = "Op"
+	keyResource   = "Resource"
+	keyBytes      = "Bytes"
+	keyThroughput = "BytesPerSecond"
+)
+
+// ioSpan is the span of an I/O operation counting the bytes transferred.
+type ioSpan struct {
+	span  ao.Span
+	start time.Time
+	once  sync.Once
+	mu    sync.Mutex
+	n     int64
+}
+
+func begin(ctx context.Context, op, resource string) *ioSpan {
+	span, _ := ao.BeginSpan(ctx, spanName, keyOp, op, keyResource, resource)
+	return &ioSpan{span: span, start: time.Now()}
+}
+
+func (s *ioSpan) add(n int64) {
+	s.mu.Lock()
+	s.n += n
+	s.mu.Unlock()
+}
+
+// end reports the bytes transferred and the throughput, and the error if it's
+// not nil, and ends the span. Only the first call has effect.
+func (s *ioSpan) end(err error) {
+	s.once.Do(func() {
+		s.mu.Lock()
+		n := s.n
+		s.mu.Unlock()
+		s.span.AddEndArgs(keyBytes, n)
+		if d := time.Since(s.start); d > 0 {
+			s.span.AddEndArgs(keyThroughput, int64(float64(n)/d.Seconds()))
+		}
+		if err != nil {
+			s.span.Err(err)
+		}
+		s.span.End()
+	})
+}
+
+// Copy copies from src to dst like io.Copy in a span of the op, e.g., upload,
+// on the resource, e.g., the file path or the object key.
+func Copy(ctx context.Context, op, resource string, dst io.Writer, src io.Reader) (int64, error) {
+	s := begin(ctx, op, resource)
+	n, err := io.Copy(dst, src)
+	s.add(n)
+	s.end(err)
+	return n, err
+}
+
+// ReadFile reads the file like ioutil.ReadFile in a span.
+func ReadFile(ctx context.Context, path string) ([]byte, error) {
+	s := begin(ctx, "read", path)
+	data, err := ioutil.ReadFile(path)
+	s.add(int64(len(data)))
+	s.end(err)
+	return data, err
+}
+
+// WriteFile writes the data to the file like ioutil.WriteFile in a span.
+func WriteFile(ctx context.Context, path string, data []byte, perm os.FileMode) error {
+	s := begin(ctx, "write", path)
+	err := ioutil.WriteFile(path, data, perm)
+	if err == nil {
+		s.add(int64(len(data)))
+	}
+	s.end(err)
+	return err
+}
+
+// Reader counts the bytes read from the underlying reader in a span, which
+// ends at the end of the input, on a read error or when the Reader is closed,
+// whichever is the first.
+type Reader struct {
+	r io.Reader
+	s *ioSpan
+}
+
+// NewReader returns a Reader of r, which begins the span of the op on the
+// resource.
+func NewReader(ctx context.Context, op, resource string, r io.Reader) *Reader {
+	return &Reader{r: r, s: begin(ctx, op, resource)}
+}
+
+// Read reads from the underlying reader.
+func (r *Reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.s.add(int64(n))
+	if err == io.EOF {
+		r.s.end(nil)
+	} else if err != nil {
+		r.s.end(err)
+	}
+	return n, err
+}
+
+// Close ends the span and closes the underlying reader if it's an io.Closer.
+func (r *Reader) Close() error {
+	var err error
+	if c, ok := r.r.(io.Closer); ok {
+		err = c.Close()
+	}
+	r.s.end(err)
+	return err
+}
+
+// Writer counts the bytes written to the underlying writer in a span, which
+// ends when the Writer is closed, or on a write error.
+type Writer struct {
+	w io.Writer
+	s *ioSpan
+}
+
+// NewWriter returns a Writer of w, which begins the span of the op on the
+// resource. The Writer must be closed to end the span.
+func NewWriter(ctx context.Context, op, resource string, w io.Writer) *Writer {
+	return &Writer{w: w, s: begin(ctx, op, resource)}
+}
+
+// Write writes to the underlying writer.
+func (w *Writer) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.s.add(int64(n))
+	if err != nil {
+		w.s.end(err)
+	}
+	return n, err
+}
+
+// Close ends the span and closes the underlying writer if it's an io.Closer,
+// e.g., to flush the upload of an object.
+func (w *Writer) Close() error {
+	var err error
+	if c, ok := w.w.(io.Closer); ok {
+		err = c.Close()
+	}
+	w.s.end(err)
+	return err
+}
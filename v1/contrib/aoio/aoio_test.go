@@ -0,0 +1,89 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aoio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type closeRecorder struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestCopy(t *testing.T) {
+	ctx := context.Background()
+	var dst bytes.Buffer
+	n, err := Copy(ctx, "upload", "photos/42.jpg", &dst, strings.NewReader("data"))
+	assert.NoError(t, err)
+	assert.EqualValues(t, 4, n)
+	assert.Equal(t, "data", dst.String())
+
+	_, err = Copy(ctx, "upload", "photos/42.jpg", failingWriter{}, strings.NewReader("data"))
+	assert.EqualError(t, err, "disk full")
+}
+
+func TestFile(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "aoio")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "blob")
+
+	assert.NoError(t, WriteFile(ctx, path, []byte("blob"), 0600))
+	data, err := ReadFile(ctx, path)
+	assert.NoError(t, err)
+	assert.Equal(t, "blob", string(data))
+
+	_, err = ReadFile(ctx, filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
+
+func TestReaderAndWriter(t *testing.T) {
+	ctx := context.Background()
+	src := &closeRecorder{}
+	src.WriteString("hello")
+	r := NewReader(ctx, "download", "blob", src)
+	dst := &closeRecorder{}
+	w := NewWriter(ctx, "upload", "blob", dst)
+
+	n, err := io.Copy(w, r)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 5, n)
+	assert.EqualValues(t, 5, r.s.n)
+	assert.EqualValues(t, 5, w.s.n)
+
+	// the span ends only once
+	assert.NoError(t, r.Close())
+	assert.NoError(t, r.Close())
+	assert.NoError(t, w.Close())
+	assert.True(t, src.closed)
+	assert.True(t, dst.closed)
+	assert.Equal(t, "hello", dst.String())
+
+	// the readers and writers which are not closers
+	assert.NoError(t, NewReader(ctx, "read", "r", strings.NewReader("")).Close())
+	fw := NewWriter(ctx, "write", "w", failingWriter{})
+	_, err = fw.Write([]byte("x"))
+	assert.Error(t, err)
+	assert.NoError(t, fw.Close())
+}
@@ -0,0 +1,115 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aologrus adds the trace and span IDs (ao.trace_id and ao.span_id) of
+// the span bound to the context of a logrus entry to its fields, so the log
+// lines can be correlated with the traces:
+//
+//	logrus.AddHook(aologrus.NewHook())
+//	logrus.WithContext(ctx).Info("payment charged")
+//
+// Boost lowers the level of the logger of the sampled requests, so their debug
+// logs are written along with the traces:
+//
+//	aologrus.Boost(ctx, logger, logrus.DebugLevel).Debugf("cart: %+v", cart)
+package aologrus
+
+import (
+	"context"
+	"sync"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/sirupsen/logrus"
+)
+
+// Hook is a logrus.Hook which adds the fields of ao.LogFields to the entries
+// with a context.
+type Hook struct{}
+
+var _ logrus.Hook = (*Hook)(nil)
+
+// NewHook returns a Hook for all the levels.
+func NewHook() *Hook {
+	return &Hook{}
+}
+
+// Levels implements logrus.Hook.
+func (h *Hook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook.
+func (h *Hook) Fire(e *logrus.Entry) error {
+	if e.Context == nil {
+		return nil
+	}
+	for k, v := range ao.LogFields(e.Context) {
+		e.Data[k] = v
+	}
+	return nil
+}
+
+// Boost returns an entry of l with ctx, whose levels up to level are enabled if
+// the span bound to ctx is sampled. The boosted entry is written by a logger
+// kept for l and level, which fires the hooks of l and writes to its output
+// with its formatter, as they are when the entry is written. logrus doesn't
+// share the lock of l, so the boosted entries are serialized with each other
+// but not with those of l, and the output must be safe for concurrent writes,
+// e.g., os.Stderr or a file.
+func Boost(ctx context.Context, l *logrus.Logger, level logrus.Level) *logrus.Entry {
+	if !ao.IsSampled(ctx) || level <= l.GetLevel() {
+		return l.WithContext(ctx)
+	}
+	return boostedLogger(l, level).WithContext(ctx)
+}
+
+type boostKey struct {
+	l     *logrus.Logger
+	level logrus.Level
+}
+
+// the boosted loggers keyed by boostKey
+var boostedLoggers sync.Map
+
+// boostedLogger returns the logger of l with level, which is created once.
+func boostedLogger(l *logrus.Logger, level logrus.Level) *logrus.Logger {
+	key := boostKey{l: l, level: level}
+	if b, ok := boostedLoggers.Load(key); ok {
+		return b.(*logrus.Logger)
+	}
+	b := &logrus.Logger{
+		Out:          parentOutput{l},
+		Hooks:        logrus.LevelHooks{},
+		Formatter:    parentFormatter{l},
+		ReportCaller: l.ReportCaller,
+		Level:        level,
+		ExitFunc:     l.Exit,
+	}
+	b.AddHook(parentHooks{l})
+	actual, _ := boostedLoggers.LoadOrStore(key, b)
+	return actual.(*logrus.Logger)
+}
+
+// parentOutput writes to the output of the parent logger.
+type parentOutput struct{ l *logrus.Logger }
+
+func (o parentOutput) Write(p []byte) (int, error) {
+	return o.l.Out.Write(p)
+}
+
+// parentFormatter formats the entries with the formatter of the parent logger.
+type parentFormatter struct{ l *logrus.Logger }
+
+func (f parentFormatter) Format(e *logrus.Entry) ([]byte, error) {
+	return f.l.Formatter.Format(e)
+}
+
+// parentHooks fires the hooks of the parent logger.
+type parentHooks struct{ l *logrus.Logger }
+
+func (h parentHooks) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h parentHooks) Fire(e *logrus.Entry) error {
+	return h.l.Hooks.Fire(e.Level, e)
+}
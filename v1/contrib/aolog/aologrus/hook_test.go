@@ -0,0 +1,60 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aologrus
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHookWithoutTrace(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&buf)
+	logger.AddHook(NewHook())
+
+	logger.Info("no context")
+	logger.WithContext(context.Background()).Info("no trace")
+	assert.Contains(t, buf.String(), "no trace")
+	assert.NotContains(t, buf.String(), "ao.trace_id")
+}
+
+func TestBoostWithoutTrace(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&buf)
+
+	e := Boost(context.Background(), logger, logrus.DebugLevel)
+	e.Debug("not sampled")
+	assert.Equal(t, logger, e.Logger)
+	assert.Empty(t, buf.String())
+}
+
+type countingHook struct{ fired int }
+
+func (h *countingHook) Levels() []logrus.Level { return logrus.AllLevels }
+func (h *countingHook) Fire(e *logrus.Entry) error {
+	h.fired++
+	return nil
+}
+
+func TestBoostedLogger(t *testing.T) {
+	logger := logrus.New()
+	b := boostedLogger(logger, logrus.DebugLevel)
+	assert.Same(t, b, boostedLogger(logger, logrus.DebugLevel))
+
+	// the hooks, output and formatter of the parent are used as they are
+	var buf bytes.Buffer
+	h := &countingHook{}
+	logger.SetOutput(&buf)
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.AddHook(h)
+	b.WithContext(context.Background()).Debug("boosted")
+	assert.Equal(t, 1, h.fired)
+	assert.Contains(t, buf.String(), `"msg":"boosted"`)
+	assert.Contains(t, buf.String(), `"level":"debug"`)
+}
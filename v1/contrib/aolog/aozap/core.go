@@ -0,0 +1,157 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aozap adds the trace and span IDs (ao.trace_id and ao.span_id) of the
+// span bound to a context to the zap log lines, so they can be correlated with
+// the traces. The fields are added by a child logger:
+//
+//	aozap.Logger(ctx, logger).Info("payment charged")
+//
+// or by a core which replaces the Context field of a log line with them:
+//
+//	logger := zap.New(aozap.NewCore(core))
+//	logger.Info("payment charged", aozap.Context(ctx))
+//
+// Boost lowers the level of the logger of the sampled requests, so their debug
+// logs are written along with the traces:
+//
+//	aozap.Boost(ctx, logger, zapcore.DebugLevel).Debug("cart loaded", zap.Any("cart", cart))
+package aozap
+
+import (
+	"context"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// contextKey is the key of the field returned by Context.
+const contextKey = "ao.context"
+
+// Fields returns the fields of ao.LogFields, or nil if the span bound to ctx is
+// not sampled.
+func Fields(ctx context.Context) []zap.Field {
+	f := ao.LogFields(ctx)
+	if f == nil {
+		return nil
+	}
+	return []zap.Field{
+		zap.String(ao.LogFieldTraceID, f[ao.LogFieldTraceID]),
+		zap.String(ao.LogFieldSpanID, f[ao.LogFieldSpanID]),
+	}
+}
+
+// Logger returns a child logger of l with the fields of ctx.
+func Logger(ctx context.Context, l *zap.Logger) *zap.Logger {
+	if fields := Fields(ctx); fields != nil {
+		return l.With(fields...)
+	}
+	return l
+}
+
+// Boost returns Logger(ctx, l) with the levels from level on enabled if the
+// span bound to ctx is sampled, or l if it's not. The entries below the level
+// of l are written only to the cores enabled at the lowest level of l, e.g.,
+// to the console but not to the error file of a zapcore.NewTee of them.
+func Boost(ctx context.Context, l *zap.Logger, level zapcore.Level) *zap.Logger {
+	if !ao.IsSampled(ctx) {
+		return l
+	}
+	return Logger(ctx, l).WithOptions(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		return &boostCore{Core: c, level: level}
+	}))
+}
+
+// Context returns a field carrying ctx, which is replaced by the fields of ctx
+// in the cores returned by NewCore, and skipped by any other core.
+func Context(ctx context.Context) zap.Field {
+	return zap.Field{Key: contextKey, Type: zapcore.SkipType, Interface: ctx}
+}
+
+type core struct {
+	zapcore.Core
+}
+
+// NewCore wraps c to replace the Context field of the log lines with the
+// fields of the context.
+func NewCore(c zapcore.Core) zapcore.Core {
+	return &core{Core: c}
+}
+
+func (c *core) With(fields []zapcore.Field) zapcore.Core {
+	return &core{Core: c.Core.With(withContextFields(fields))}
+}
+
+func (c *core) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *core) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	return c.Core.Write(ent, withContextFields(fields))
+}
+
+// withContextFields replaces the Context field, if any, with the fields of the
+// context. The fields passed in are not modified.
+func withContextFields(fields []zapcore.Field) []zapcore.Field {
+	for i, f := range fields {
+		if f.Type != zapcore.SkipType || f.Key != contextKey {
+			continue
+		}
+		ctx, ok := f.Interface.(context.Context)
+		if !ok {
+			continue
+		}
+		out := make([]zapcore.Field, 0, len(fields)+1)
+		out = append(out, fields[:i]...)
+		out = append(out, fields[i+1:]...)
+		return append(out, Fields(ctx)...)
+	}
+	return fields
+}
+
+// boostCore enables the levels from level on in addition to those of Core. The
+// entries enabled only by the boost are checked by Core at its lowest level, so
+// a Tee writes them only to the cores enabled at that level, which must write
+// them regardless of their own level, as the cores of zap do.
+type boostCore struct {
+	zapcore.Core
+	level zapcore.Level
+}
+
+func (c *boostCore) Enabled(lvl zapcore.Level) bool {
+	return lvl >= c.level || c.Core.Enabled(lvl)
+}
+
+func (c *boostCore) With(fields []zapcore.Field) zapcore.Core {
+	return &boostCore{Core: c.Core.With(fields), level: c.level}
+}
+
+func (c *boostCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Core.Enabled(ent.Level) {
+		return c.Core.Check(ent, ce)
+	}
+	if ent.Level >= c.level {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *boostCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	lowest := ent
+	for lowest.Level = zapcore.DebugLevel; lowest.Level < zapcore.FatalLevel; lowest.Level++ {
+		if c.Core.Enabled(lowest.Level) {
+			break
+		}
+	}
+	ce := c.Core.Check(lowest, nil)
+	if ce == nil {
+		return nil
+	}
+	// written with its own level
+	ce.Entry = ent
+	ce.Write(fields...)
+	return nil
+}
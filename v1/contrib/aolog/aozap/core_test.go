@@ -0,0 +1,67 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aozap
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestCoreWithoutTrace(t *testing.T) {
+	obs, logs := observer.New(zapcore.InfoLevel)
+	logger := zap.New(NewCore(obs))
+	ctx := context.Background()
+
+	logger.Info("no trace", Context(ctx), zap.Int("n", 1))
+	logger.Debug("disabled", Context(ctx))
+	Logger(ctx, logger).With(Context(ctx)).Info("child")
+
+	entries := logs.AllUntimed()
+	assert.Len(t, entries, 2)
+	// the context field is removed
+	assert.Equal(t, map[string]interface{}{"n": int64(1)}, entries[0].ContextMap())
+	assert.Empty(t, entries[1].ContextMap())
+	assert.Nil(t, Fields(ctx))
+}
+
+func TestBoost(t *testing.T) {
+	obs, logs := observer.New(zapcore.InfoLevel)
+	logger := zap.New(obs)
+
+	// not sampled
+	assert.Equal(t, logger, Boost(context.Background(), logger, zapcore.DebugLevel))
+
+	boosted := zap.New(&boostCore{Core: obs, level: zapcore.DebugLevel})
+	boosted.Debug("boosted")
+	boosted.With(zap.Int("n", 1)).Debug("child")
+	logger.Debug("disabled")
+
+	entries := logs.AllUntimed()
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "boosted", entries[0].Message)
+	assert.Equal(t, map[string]interface{}{"n": int64(1)}, entries[1].ContextMap())
+}
+
+func TestBoostTee(t *testing.T) {
+	console, consoleLogs := observer.New(zapcore.InfoLevel)
+	errs, errLogs := observer.New(zapcore.ErrorLevel)
+	boosted := zap.New(&boostCore{Core: zapcore.NewTee(console, errs), level: zapcore.DebugLevel})
+
+	boosted.Debug("boosted")
+	boosted.Info("info")
+	boosted.Error("error")
+
+	// the error core doesn't get the boosted entries
+	entries := consoleLogs.AllUntimed()
+	assert.Len(t, entries, 3)
+	assert.Equal(t, zapcore.DebugLevel, entries[0].Level)
+	assert.Equal(t, "boosted", entries[0].Message)
+	entries = errLogs.AllUntimed()
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "error", entries[0].Message)
+}
@@ -0,0 +1,11 @@
+module github.com/appoptics/appoptics-apm-go/v1/contrib/aolog/aozap
+
+go 1.14
+
+require (
+	github.com/appoptics/appoptics-apm-go v1.14.0
+	github.com/stretchr/testify v1.7.0
+	go.uber.org/zap v1.19.1
+)
+
+replace github.com/appoptics/appoptics-apm-go => ../../../..
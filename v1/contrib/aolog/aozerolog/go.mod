@@ -0,0 +1,11 @@
+module github.com/appoptics/appoptics-apm-go/v1/contrib/aolog/aozerolog
+
+go 1.15
+
+require (
+	github.com/appoptics/appoptics-apm-go v1.14.0
+	github.com/rs/zerolog v1.32.0
+	github.com/stretchr/testify v1.6.1
+)
+
+replace github.com/appoptics/appoptics-apm-go => ../../../..
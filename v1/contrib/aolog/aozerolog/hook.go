@@ -0,0 +1,47 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aozerolog adds the trace and span IDs (ao.trace_id and ao.span_id) of
+// the span bound to the context of a zerolog event to it, so the log lines can
+// be correlated with the traces:
+//
+//	logger := zerolog.New(os.Stderr).Hook(aozerolog.Hook{})
+//	logger.Info().Ctx(ctx).Msg("payment charged")
+//
+// Boost lowers the level of the logger of the sampled requests, so their debug
+// logs are written along with the traces:
+//
+//	log := aozerolog.Boost(ctx, logger, zerolog.DebugLevel)
+//	log.Debug().Ctx(ctx).Interface("cart", cart).Msg("cart loaded")
+package aozerolog
+
+import (
+	"context"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/rs/zerolog"
+)
+
+// Hook is a zerolog.Hook which adds the fields of ao.LogFields to the events
+// with a context.
+type Hook struct{}
+
+var _ zerolog.Hook = Hook{}
+
+// Run implements zerolog.Hook.
+func (Hook) Run(e *zerolog.Event, level zerolog.Level, msg string) {
+	f := ao.LogFields(e.GetCtx())
+	if f == nil {
+		return
+	}
+	e.Str(ao.LogFieldTraceID, f[ao.LogFieldTraceID]).Str(ao.LogFieldSpanID, f[ao.LogFieldSpanID])
+}
+
+// Boost returns l with the levels from level on enabled if the span bound to
+// ctx is sampled, or l itself if it's not. The global level set by
+// zerolog.SetGlobalLevel still applies.
+func Boost(ctx context.Context, l zerolog.Logger, level zerolog.Level) zerolog.Logger {
+	if !ao.IsSampled(ctx) || level >= l.GetLevel() {
+		return l
+	}
+	return l.Level(level)
+}
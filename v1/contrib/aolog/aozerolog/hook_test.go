@@ -0,0 +1,32 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aozerolog
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHookWithoutTrace(t *testing.T) {
+	var buf bytes.Buffer
+	logger := zerolog.New(&buf).Hook(Hook{})
+
+	logger.Info().Msg("no context")
+	logger.Info().Ctx(context.Background()).Msg("no trace")
+	assert.Contains(t, buf.String(), "no trace")
+	assert.NotContains(t, buf.String(), "ao.trace_id")
+}
+
+func TestBoostWithoutTrace(t *testing.T) {
+	var buf bytes.Buffer
+	logger := zerolog.New(&buf).Level(zerolog.InfoLevel)
+
+	boosted := Boost(context.Background(), logger, zerolog.DebugLevel)
+	boosted.Debug().Msg("not sampled")
+	assert.Equal(t, zerolog.InfoLevel, boosted.GetLevel())
+	assert.Empty(t, buf.String())
+}
@@ -0,0 +1,137 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aomqtt provides AppOptics tracing for MQTT v5 clients, e.g., Eclipse
+// Paho (github.com/eclipse/paho.golang). The trace context is carried in the
+// user properties of the PUBLISH packets.
+//
+// To trace the publisher:
+//   err := aomqtt.Publish(ctx, "broker:1883", "sensors/temp", 1,
+//       func(props map[string]string) error {
+//           pb := &paho.Publish{Topic: "sensors/temp", QoS: 1, Payload: payload,
+//               Properties: &paho.PublishProperties{}}
+//           for k, v := range props {
+//               pb.Properties.User.Add(k, v)
+//           }
+//           _, err := client.Publish(ctx, pb)
+//           return err
+//       })
+//
+// To trace the subscriber:
+//   OnPublishReceived: []func(paho.PublishReceived) (bool, error){
+//       func(pr paho.PublishReceived) (bool, error) {
+//           p := pr.Packet
+//           var get func(string) string
+//           if p.Properties != nil {
+//               get = p.Properties.User.Get
+//           }
+//           err := aomqtt.HandleMessage(p.Topic, p.QoS, get, func(ctx context.Context) error {
+//               // process p.Payload, the trace is bound to ctx
+//               return nil
+//           }, aomqtt.WithSubscription("sensors/+/temp"))
+//           return true, err
+//       }},
+package aomqtt
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+)
+
+const (
+	publisherSpanName  = "mqtt-publisher"
+	subscriberSpanName = "mqtt-subscriber"
+	flavor             = "mqtt"
+)
+
+// UserPropertyKey is the key of the MQTT v5 user property carrying the trace
+// context.
+const UserPropertyKey = "x-trace"
+
+// Option configures the subscribers.
+type Option func(*options)
+
+type options struct {
+	subscription string
+}
+
+// WithSubscription names the transactions of the messages handled after the
+// topic filter of the subscription which they are received by, e.g.,
+// "sensors/+/temp". The topics often embed the IDs of the devices or the users,
+// so they are not used as the transaction names, which would exceed the limit.
+func WithSubscription(filter string) Option {
+	return func(o *options) {
+		o.subscription = filter
+	}
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Publish traces the publishing of a message to the topic. The publish function
+// should send the PUBLISH packet with props added as its user properties. The
+// props are empty if the request is not traced. Any error returned by publish
+// is reported and returned.
+func Publish(ctx context.Context, broker, topic string, qos byte,
+	publish func(props map[string]string) error) error {
+	span, _ := ao.BeginSpan(ctx, publisherSpanName,
+		"Spec", "pushq",
+		"Flavor", flavor,
+		"Op", "publish",
+		"Topic", topic,
+		"QoS", strconv.Itoa(int(qos)),
+		"RemoteHost", broker)
+	defer span.End()
+
+	props := make(map[string]string)
+	if xt := span.MetadataString(); xt != "" {
+		props[UserPropertyKey] = xt
+	}
+	err := publish(props)
+	if err != nil {
+		span.Err(err)
+	}
+	return err
+}
+
+// HandleMessage starts a trace for the message received from the topic,
+// continuing the publisher's trace if the context is found in the user
+// properties. getProp returns the value of a user property by key, e.g., the
+// Get method of paho.UserProperties, and can be nil if the packet has no
+// properties. The handler is called with the context bound to the trace and
+// any error it returns is reported and returned. The transaction is named after
+// the subscription of WithSubscription, if any.
+func HandleMessage(topic string, qos byte, getProp func(key string) string,
+	handler func(ctx context.Context) error, opts ...Option) error {
+	var xt string
+	if getProp != nil {
+		xt = getProp(UserPropertyKey)
+	}
+	t := ao.NewTraceFromID(subscriberSpanName, xt, func() ao.KVMap {
+		return ao.KVMap{
+			"Spec":   "pushq",
+			"Flavor": flavor,
+			"Op":     "consume",
+			"Topic":  topic,
+			"QoS":    strconv.Itoa(int(qos)),
+		}
+	})
+	if o := newOptions(opts); o.subscription != "" {
+		t.SetTransactionName(o.subscription)
+	}
+	t.SetTransactionType(ao.TransactionTypeConsumer)
+	ctx := ao.NewContext(context.Background(), t)
+
+	err := handler(ctx)
+	if err != nil {
+		t.Err(err)
+	}
+	t.End()
+	return err
+}
@@ -0,0 +1,50 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aomqtt
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishAndHandle(t *testing.T) {
+	var sent map[string]string
+	err := Publish(context.Background(), "localhost:1883", "sensors/temp", 1,
+		func(props map[string]string) error {
+			sent = props
+			return nil
+		})
+	assert.NoError(t, err)
+	assert.NotNil(t, sent)
+
+	called := false
+	err = HandleMessage("sensors/temp", 1, func(key string) string { return sent[key] },
+		func(ctx context.Context) error {
+			called = true
+			return nil
+		})
+	assert.NoError(t, err)
+	assert.True(t, called)
+
+	// the packet may have no properties
+	errFailed := errors.New("failed")
+	assert.Equal(t, errFailed, HandleMessage("sensors/temp", 0, nil,
+		func(ctx context.Context) error { return errFailed }))
+	assert.Equal(t, errFailed, Publish(context.Background(), "localhost:1883", "sensors/temp", 0,
+		func(props map[string]string) error { return errFailed }))
+}
+
+func TestSubscriptionTransactionName(t *testing.T) {
+	var names []string
+	handler := func(ctx context.Context) error {
+		names = append(names, ao.GetTransactionName(ctx))
+		return nil
+	}
+	assert.NoError(t, HandleMessage("sensors/42/temp", 1, nil, handler))
+	assert.NoError(t, HandleMessage("sensors/42/temp", 1, nil, handler, WithSubscription("sensors/+/temp")))
+	assert.Equal(t, []string{"", "sensors/+/temp"}, names)
+}
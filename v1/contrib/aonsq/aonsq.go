@@ -0,0 +1,168 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aonsq provides AppOptics tracing for the producers and consumers of
+// NSQ (github.com/nsqio/go-nsq).
+//
+// NSQ messages don't carry headers, so the trace context can only be passed to
+// the consumer in the message body. With WithEnvelope, the producer embeds it in
+// an envelope in front of the body, which the consumer strips. As the envelope
+// changes the bodies seen by any other consumer of the topic, it's off by
+// default, and it must be enabled on the consumers before the producers. The
+// messages without an envelope are passed to the consumer unchanged.
+//
+// To trace the producer:
+//   producer, _ := nsq.NewProducer(addr, nsq.NewConfig())
+//   p := aonsq.NewProducer(producer, addr, aonsq.WithEnvelope())
+//   err := p.Publish(ctx, "orders", body)
+//
+// To trace the consumer:
+//   consumer.AddHandler(nsq.HandlerFunc(func(m *nsq.Message) error {
+//       return aonsq.HandleMessage("orders", "billing", m.Body,
+//           func(ctx context.Context, body []byte) error {
+//               // process the body, the trace is bound to ctx
+//               return nil
+//           }, aonsq.WithEnvelope())
+//   }))
+package aonsq
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+)
+
+const (
+	producerSpanName = "nsq-producer"
+	consumerSpanName = "nsq-consumer"
+	flavor           = "nsq"
+)
+
+// envelopeMagic starts a message body with the trace context embedded. It is
+// followed by one byte of the length of the X-Trace ID, the X-Trace ID and
+// then the original body.
+var envelopeMagic = []byte("\x00AOXT")
+
+// Option configures the producers and the consumers.
+type Option func(*options)
+
+type options struct {
+	envelope bool
+}
+
+// WithEnvelope embeds the trace context in the message bodies published by a
+// producer, or strips it from those handled by a consumer, which continues the
+// trace of the producer.
+func WithEnvelope() Option {
+	return func(o *options) {
+		o.envelope = true
+	}
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Publisher publishes a message to a topic. It's implemented by *nsq.Producer.
+type Publisher interface {
+	Publish(topic string, body []byte) error
+}
+
+// Producer traces the messages published by a Publisher.
+type Producer struct {
+	pub  Publisher
+	addr string
+	opts options
+}
+
+// NewProducer wraps the publisher, addr is the nsqd address reported as the
+// remote host.
+func NewProducer(pub Publisher, addr string, opts ...Option) *Producer {
+	return &Producer{pub: pub, addr: addr, opts: newOptions(opts)}
+}
+
+// Publish publishes the body to the topic synchronously in a span, with the
+// trace context embedded in the message if WithEnvelope is set.
+func (p *Producer) Publish(ctx context.Context, topic string, body []byte) error {
+	span, _ := ao.BeginSpan(ctx, producerSpanName,
+		"Spec", "pushq",
+		"Flavor", flavor,
+		"Op", "publish",
+		"Topic", topic,
+		"RemoteHost", p.addr)
+	defer span.End()
+
+	if p.opts.envelope {
+		body = Wrap(span.MetadataString(), body)
+	}
+	err := p.pub.Publish(topic, body)
+	if err != nil {
+		span.Err(err)
+	}
+	return err
+}
+
+// Wrap embeds the X-Trace ID into the message body. The body is returned as is
+// if xTraceID is empty or too long.
+func Wrap(xTraceID string, body []byte) []byte {
+	if xTraceID == "" || len(xTraceID) > 0xFF {
+		return body
+	}
+	buf := make([]byte, 0, len(envelopeMagic)+1+len(xTraceID)+len(body))
+	buf = append(buf, envelopeMagic...)
+	buf = append(buf, byte(len(xTraceID)))
+	buf = append(buf, xTraceID...)
+	return append(buf, body...)
+}
+
+// Unwrap extracts the X-Trace ID embedded by Wrap and returns the original
+// body. The X-Trace ID is empty and the body is returned as is if the message
+// has no trace context embedded.
+func Unwrap(body []byte) (xTraceID string, payload []byte) {
+	if !bytes.HasPrefix(body, envelopeMagic) || len(body) <= len(envelopeMagic) {
+		return "", body
+	}
+	n := int(body[len(envelopeMagic)])
+	start := len(envelopeMagic) + 1
+	if len(body) < start+n {
+		return "", body
+	}
+	return string(body[start : start+n]), body[start+n:]
+}
+
+// HandleMessage starts a trace for the message received from the topic and
+// channel. With WithEnvelope, it continues the producer's trace if its context
+// is embedded, and the handler is called with the original body. Otherwise the
+// body is passed as is. Any error returned by the handler is reported and
+// returned.
+func HandleMessage(topic, channel string, body []byte,
+	handler func(ctx context.Context, body []byte) error, opts ...Option) error {
+	var xTraceID string
+	payload := body
+	if newOptions(opts).envelope {
+		xTraceID, payload = Unwrap(body)
+	}
+	t := ao.NewTraceFromID(consumerSpanName, xTraceID, func() ao.KVMap {
+		return ao.KVMap{
+			"Spec":    "pushq",
+			"Flavor":  flavor,
+			"Op":      "consume",
+			"Topic":   topic,
+			"Channel": channel,
+		}
+	})
+	t.SetTransactionName(topic + "." + channel)
+	t.SetTransactionType(ao.TransactionTypeConsumer)
+	ctx := ao.NewContext(context.Background(), t)
+
+	err := handler(ctx, payload)
+	if err != nil {
+		t.Err(err)
+	}
+	t.End()
+	return err
+}
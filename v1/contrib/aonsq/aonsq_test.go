@@ -0,0 +1,118 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aonsq
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockPublisher struct {
+	topic string
+	body  []byte
+	err   error
+}
+
+func (m *mockPublisher) Publish(topic string, body []byte) error {
+	m.topic, m.body = topic, body
+	return m.err
+}
+
+const sampledXTraceID = "2BF4CAA9BB8AF02BD4B1EC4F8CDB0C9A36C3B0BF34F8E67D9B6846A2C101"
+
+func TestWrapUnwrap(t *testing.T) {
+	xt := sampledXTraceID
+	body := []byte("hello")
+
+	wrapped := Wrap(xt, body)
+	id, payload := Unwrap(wrapped)
+	assert.Equal(t, xt, id)
+	assert.Equal(t, body, payload)
+
+	// not wrapped
+	assert.Equal(t, body, Wrap("", body))
+	assert.Equal(t, body, Wrap(strings.Repeat("a", 256), body))
+	id, payload = Unwrap(body)
+	assert.Empty(t, id)
+	assert.Equal(t, body, payload)
+
+	// truncated envelope
+	id, payload = Unwrap(wrapped[:10])
+	assert.Empty(t, id)
+	assert.Equal(t, wrapped[:10], payload)
+}
+
+// taskID returns the task ID of the X-Trace ID, which is shared by the spans
+// of a trace.
+func taskID(xTraceID string) string {
+	if len(xTraceID) < 42 {
+		return ""
+	}
+	return xTraceID[2:42]
+}
+
+func TestProducerAndConsumer(t *testing.T) {
+	pub := &mockPublisher{}
+	p := NewProducer(pub, "127.0.0.1:4150", WithEnvelope())
+	ctx := ao.NewContext(context.Background(), ao.NewTrace("checkout"))
+	assert.NoError(t, p.Publish(ctx, "orders", []byte("hello")))
+	assert.Equal(t, "orders", pub.topic)
+
+	// the envelope carries the producer's trace
+	producerID, _ := Unwrap(pub.body)
+	assert.NotEmpty(t, taskID(producerID))
+	assert.Equal(t, taskID(ao.MetadataString(ctx)), taskID(producerID))
+	ao.EndTrace(ctx)
+
+	var got []byte
+	var consumerID string
+	err := HandleMessage("orders", "billing", pub.body, func(ctx context.Context, body []byte) error {
+		got = body
+		return nil
+	}, WithEnvelope())
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), got)
+
+	// the consumer continues the trace of a sampled producer, which can't be
+	// sampled here without the settings from the collector
+	err = HandleMessage("orders", "billing", Wrap(sampledXTraceID, []byte("hello")),
+		func(ctx context.Context, body []byte) error {
+			consumerID = ao.MetadataString(ctx)
+			return nil
+		}, WithEnvelope())
+	assert.NoError(t, err)
+	assert.Equal(t, taskID(sampledXTraceID), taskID(consumerID))
+
+	errFailed := errors.New("failed")
+	pub.err = errFailed
+	assert.Equal(t, errFailed, p.Publish(context.Background(), "orders", []byte("hello")))
+	assert.Equal(t, errFailed, HandleMessage("orders", "billing", pub.body,
+		func(ctx context.Context, body []byte) error { return errFailed }, WithEnvelope()))
+}
+
+func TestWithoutEnvelope(t *testing.T) {
+	pub := &mockPublisher{}
+	p := NewProducer(pub, "127.0.0.1:4150")
+	ctx := ao.NewContext(context.Background(), ao.NewTrace("checkout"))
+	assert.NoError(t, p.Publish(ctx, "orders", []byte("hello")))
+	// the body is published as is
+	assert.Equal(t, []byte("hello"), pub.body)
+
+	var got []byte
+	var consumerID string
+	wrapped := Wrap(sampledXTraceID, []byte("hello"))
+	assert.NoError(t, HandleMessage("orders", "billing", wrapped, func(ctx context.Context, body []byte) error {
+		got = body
+		consumerID = ao.MetadataString(ctx)
+		return nil
+	}))
+	// nor stripped by the consumer, which starts a new trace
+	assert.Equal(t, wrapped, got)
+	assert.NotEqual(t, taskID(sampledXTraceID), taskID(consumerID))
+	ao.EndTrace(ctx)
+}
@@ -0,0 +1,110 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aoprometheus exports the internal counters of the AppOptics agent as
+// Prometheus metrics, so the degradation of the agent, e.g., the events
+// dropped as the queue overflows or the lost connection to the collector, can
+// be alerted on from an existing Prometheus stack:
+//   prometheus.MustRegister(aoprometheus.NewCollector())
+//   http.Handle("/metrics", promhttp.Handler())
+//
+// All the metrics are prefixed with appoptics_agent_. The sampling counts and
+// the transaction name overflows are updated when the agent flushes its
+// metrics, i.e., once a minute by default.
+package aoprometheus
+
+import (
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "appoptics_agent"
+
+var (
+	descMessagesSent = prometheus.NewDesc(namespace+"_messages_sent_total",
+		"The number of the messages sent to the collector.", nil, nil)
+	descMessagesFailed = prometheus.NewDesc(namespace+"_messages_failed_total",
+		"The number of the messages the collector failed to accept.", nil, nil)
+	descEventsOverflowed = prometheus.NewDesc(namespace+"_events_overflowed_total",
+		"The number of the events dropped as the event queue is full.", nil, nil)
+	descQueueDepth = prometheus.NewDesc(namespace+"_event_queue_depth",
+		"The number of the events waiting in the queue.", nil, nil)
+	descQueueCapacity = prometheus.NewDesc(namespace+"_event_queue_capacity",
+		"The capacity of the event queue.", nil, nil)
+	descLastSent = prometheus.NewDesc(namespace+"_last_sent_timestamp_seconds",
+		"The time of the last successful send to the collector.", nil, nil)
+	descConnected = prometheus.NewDesc(namespace+"_connected",
+		"Whether the agent is sending data to the collector (1) or not (0).",
+		[]string{"reporter", "connectivity"}, nil)
+	descOverflows = prometheus.NewDesc(namespace+"_transaction_name_overflows_total",
+		"The number of the metrics cycles in which the transaction names exceeded the limit.", nil, nil)
+
+	// the sampling decisions, labeled by the token bucket which made them
+	descRequested = prometheus.NewDesc(namespace+"_requests_total",
+		"The number of the requests for which a sampling decision was made.", []string{"bucket"}, nil)
+	descSampled = prometheus.NewDesc(namespace+"_requests_sampled_total",
+		"The number of the requests sampled.", []string{"bucket"}, nil)
+	descLimited = prometheus.NewDesc(namespace+"_requests_limited_total",
+		"The number of the sampled requests not traced due to the rate limit.", []string{"bucket"}, nil)
+	descTraced = prometheus.NewDesc(namespace+"_requests_traced_total",
+		"The number of the requests traced.", []string{"bucket"}, nil)
+	descThrough = prometheus.NewDesc(namespace+"_requests_through_total",
+		"The number of the requests traced as continued from the upstream.", []string{"bucket"}, nil)
+)
+
+var allDescs = []*prometheus.Desc{
+	descMessagesSent, descMessagesFailed, descEventsOverflowed, descQueueDepth, descQueueCapacity,
+	descLastSent, descConnected, descOverflows,
+	descRequested, descSampled, descLimited, descTraced, descThrough,
+}
+
+// Collector is a prometheus.Collector of the agent's internal counters.
+type Collector struct {
+	stats func() ao.AgentStats
+}
+
+// NewCollector returns a Collector to be registered with a Prometheus registry.
+func NewCollector() *Collector {
+	return &Collector{stats: ao.GetAgentStats}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range allDescs {
+		ch <- d
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	s := c.stats()
+
+	counter := func(d *prometheus.Desc, v int64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, float64(v), labels...)
+	}
+	gauge := func(d *prometheus.Desc, v float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v, labels...)
+	}
+
+	counter(descMessagesSent, s.NumSent)
+	counter(descMessagesFailed, s.NumFailed)
+	counter(descEventsOverflowed, s.NumOverflowed)
+	gauge(descQueueDepth, float64(s.QueueDepth))
+	gauge(descQueueCapacity, float64(s.QueueCapacity))
+	if !s.LastSent.IsZero() {
+		gauge(descLastSent, float64(s.LastSent.UnixNano())/1e9)
+	}
+	var connected float64
+	if s.Connectivity == ao.ConnectivityConnected {
+		connected = 1
+	}
+	gauge(descConnected, connected, s.Reporter, string(s.Connectivity))
+	counter(descOverflows, s.TransactionNameOverflows)
+
+	for bucket, rc := range s.RateCounts {
+		counter(descRequested, rc.Requested, bucket)
+		counter(descSampled, rc.Sampled, bucket)
+		counter(descLimited, rc.Limited, bucket)
+		counter(descTraced, rc.Traced, bucket)
+		counter(descThrough, rc.Through, bucket)
+	}
+}
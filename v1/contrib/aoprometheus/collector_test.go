@@ -0,0 +1,77 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aoprometheus
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollector(t *testing.T) {
+	c := &Collector{stats: func() ao.AgentStats {
+		return ao.AgentStats{
+			ReporterHealth: ao.ReporterHealth{
+				Reporter:      "ssl",
+				Connectivity:  ao.ConnectivityConnected,
+				QueueDepth:    3,
+				QueueCapacity: 10000,
+				NumSent:       120,
+				NumFailed:     2,
+				NumOverflowed: 5,
+				LastSent:      time.Unix(1600000000, 0),
+			},
+			RateCounts: map[string]ao.RateCountTotals{
+				ao.RateCountsRegular: {Requested: 10, Sampled: 4, Limited: 1, Traced: 3, Through: 2},
+			},
+			TransactionNameOverflows: 1,
+		}
+	}}
+
+	expected := `
+# HELP appoptics_agent_connected Whether the agent is sending data to the collector (1) or not (0).
+# TYPE appoptics_agent_connected gauge
+appoptics_agent_connected{connectivity="connected",reporter="ssl"} 1
+# HELP appoptics_agent_event_queue_depth The number of the events waiting in the queue.
+# TYPE appoptics_agent_event_queue_depth gauge
+appoptics_agent_event_queue_depth 3
+# HELP appoptics_agent_events_overflowed_total The number of the events dropped as the event queue is full.
+# TYPE appoptics_agent_events_overflowed_total counter
+appoptics_agent_events_overflowed_total 5
+# HELP appoptics_agent_last_sent_timestamp_seconds The time of the last successful send to the collector.
+# TYPE appoptics_agent_last_sent_timestamp_seconds gauge
+appoptics_agent_last_sent_timestamp_seconds 1.6e+09
+# HELP appoptics_agent_messages_sent_total The number of the messages sent to the collector.
+# TYPE appoptics_agent_messages_sent_total counter
+appoptics_agent_messages_sent_total 120
+# HELP appoptics_agent_requests_sampled_total The number of the requests sampled.
+# TYPE appoptics_agent_requests_sampled_total counter
+appoptics_agent_requests_sampled_total{bucket="regular"} 4
+# HELP appoptics_agent_transaction_name_overflows_total The number of the metrics cycles in which the transaction names exceeded the limit.
+# TYPE appoptics_agent_transaction_name_overflows_total counter
+appoptics_agent_transaction_name_overflows_total 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"appoptics_agent_connected",
+		"appoptics_agent_event_queue_depth",
+		"appoptics_agent_events_overflowed_total",
+		"appoptics_agent_last_sent_timestamp_seconds",
+		"appoptics_agent_messages_sent_total",
+		"appoptics_agent_requests_sampled_total",
+		"appoptics_agent_transaction_name_overflows_total",
+	))
+	assert.Equal(t, 7+1+5, testutil.CollectAndCount(c)) // with the last sent time and a bucket
+}
+
+func TestCollectorNeverSent(t *testing.T) {
+	c := &Collector{stats: func() ao.AgentStats {
+		return ao.AgentStats{ReporterHealth: ao.ReporterHealth{Reporter: "none", Connectivity: ao.ConnectivityClosed}}
+	}}
+	// no last sent time and rate counts
+	assert.Equal(t, 7, testutil.CollectAndCount(c))
+	assert.Equal(t, 0, testutil.CollectAndCount(c, "appoptics_agent_last_sent_timestamp_seconds"))
+}
@@ -0,0 +1,11 @@
+module github.com/appoptics/appoptics-apm-go/v1/contrib/aoprometheus
+
+go 1.14
+
+require (
+	github.com/appoptics/appoptics-apm-go v1.14.0
+	github.com/prometheus/client_golang v1.12.2
+	github.com/stretchr/testify v1.6.1
+)
+
+replace github.com/appoptics/appoptics-apm-go => ../../..
@@ -0,0 +1,167 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aoredigo traces the commands of the redigo connections. Each command
+// sent with a context is reported as a cache span with its operation (KVOp),
+// key (KVKey) and the address of the Redis server (RemoteHost):
+//
+//	conn := aoredigo.Wrap(pool.Get(), "redis:6379")
+//	defer conn.Close()
+//	reply, err := conn.DoContext(ctx, "GET", "user:42")
+//
+// Or bind the context to the connection for the code which calls Do:
+//
+//	reply, err := aoredigo.Wrap(pool.Get(), "redis:6379").WithContext(ctx).Do("GET", "user:42")
+package aoredigo
+
+import (
+	"context"
+	"strings"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/gomodule/redigo/redis"
+)
+
+const spanName = "redis"
+
+// Conn is a redis.Conn which traces the commands sent with a context.
+type Conn struct {
+	redis.Conn
+	remoteHost string
+	ctx        context.Context // bound by WithContext
+}
+
+var (
+	_ redis.Conn            = (*Conn)(nil)
+	_ redis.ConnWithContext = (*Conn)(nil)
+)
+
+// Wrap returns a Conn of the connection to the Redis server at remoteHost.
+func Wrap(c redis.Conn, remoteHost string) *Conn {
+	return &Conn{Conn: c, remoteHost: remoteHost}
+}
+
+// WithContext returns a copy of the Conn whose Do traces the commands in the
+// trace of ctx.
+func (c *Conn) WithContext(ctx context.Context) *Conn {
+	cc := *c
+	cc.ctx = ctx
+	return &cc
+}
+
+// Do sends a command and returns the reply. It's traced only if a context is
+// bound by WithContext.
+func (c *Conn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	if c.ctx == nil {
+		return c.Conn.Do(commandName, args...)
+	}
+	return c.DoContext(c.ctx, commandName, args...)
+}
+
+// DoContext sends a command and returns the reply, reporting a span in the
+// trace of ctx. A nil reply of a read is reported as a miss by KVHit.
+func (c *Conn) DoContext(ctx context.Context, commandName string, args ...interface{}) (interface{}, error) {
+	if commandName == "" { // flushes the pending commands, not worth a span
+		return c.do(ctx, commandName, args...)
+	}
+
+	op := strings.ToLower(commandName)
+	span, _ := ao.BeginSpan(ctx, spanName,
+		"Spec", "cache",
+		"KVOp", op,
+		"KVKey", argsKey(op, args),
+		"RemoteHost", c.remoteHost)
+	reply, err := c.do(ctx, commandName, args...)
+	if err != nil {
+		span.Err(err)
+	}
+	if isRead(op) && err == nil {
+		span.AddEndArgs("KVHit", reply != nil)
+	}
+	span.End()
+	return reply, err
+}
+
+// ReceiveContext receives a single reply from the Redis server.
+func (c *Conn) ReceiveContext(ctx context.Context) (interface{}, error) {
+	if cwc, ok := c.Conn.(redis.ConnWithContext); ok {
+		return cwc.ReceiveContext(ctx)
+	}
+	return c.Conn.Receive()
+}
+
+// do sends the command with the context if the connection supports it.
+func (c *Conn) do(ctx context.Context, commandName string, args ...interface{}) (interface{}, error) {
+	if cwc, ok := c.Conn.(redis.ConnWithContext); ok {
+		return cwc.DoContext(ctx, commandName, args...)
+	}
+	return c.Conn.Do(commandName, args...)
+}
+
+// argsKey returns the key of the command op, which is its first argument, if
+// any and op is one of keyCommands.
+func argsKey(op string, args []interface{}) string {
+	if len(args) == 0 || !keyCommands[op] {
+		return ""
+	}
+	switch key := args[0].(type) {
+	case string:
+		return key
+	case []byte:
+		return string(key)
+	}
+	return ""
+}
+
+// keyCommands are the commands whose first argument is a key. The key of the
+// other commands is not reported, as their first argument may be anything, e.g.,
+// the password of AUTH or CONFIG SET requirepass.
+var keyCommands = map[string]bool{
+	// strings
+	"get": true, "set": true, "setnx": true, "setex": true, "psetex": true, "getset": true,
+	"getex": true, "getdel": true, "append": true, "strlen": true, "incr": true, "incrby": true,
+	"incrbyfloat": true, "decr": true, "decrby": true, "getrange": true, "setrange": true,
+	"mget": true, "mset": true, "msetnx": true, "getbit": true, "setbit": true, "bitcount": true,
+	"bitpos": true, "bitfield": true,
+	// generic
+	"del": true, "unlink": true, "exists": true, "expire": true, "expireat": true, "pexpire": true,
+	"pexpireat": true, "persist": true, "ttl": true, "pttl": true, "type": true, "rename": true,
+	"renamenx": true, "dump": true, "restore": true, "touch": true, "move": true, "copy": true,
+	"sort": true, "watch": true,
+	// hashes
+	"hget": true, "hset": true, "hsetnx": true, "hmset": true, "hmget": true, "hdel": true,
+	"hexists": true, "hgetall": true, "hkeys": true, "hvals": true, "hlen": true, "hincrby": true,
+	"hincrbyfloat": true, "hstrlen": true, "hscan": true, "hrandfield": true,
+	// lists
+	"lpush": true, "rpush": true, "lpushx": true, "rpushx": true, "lpop": true, "rpop": true,
+	"llen": true, "lrange": true, "lindex": true, "lset": true, "linsert": true, "lrem": true,
+	"ltrim": true, "rpoplpush": true, "lmove": true, "lpos": true, "blpop": true, "brpop": true,
+	"brpoplpush": true, "blmove": true,
+	// sets
+	"sadd": true, "srem": true, "smembers": true, "sismember": true, "smismember": true,
+	"scard": true, "spop": true, "srandmember": true, "smove": true, "sinter": true,
+	"sunion": true, "sdiff": true, "sinterstore": true, "sunionstore": true, "sdiffstore": true,
+	"sscan": true,
+	// sorted sets
+	"zadd": true, "zrem": true, "zscore": true, "zmscore": true, "zincrby": true, "zcard": true,
+	"zcount": true, "zrange": true, "zrangebyscore": true, "zrevrange": true,
+	"zrevrangebyscore": true, "zrank": true, "zrevrank": true, "zremrangebyrank": true,
+	"zremrangebyscore": true, "zrangebylex": true, "zrevrangebylex": true, "zlexcount": true,
+	"zremrangebylex": true, "zpopmin": true, "zpopmax": true, "bzpopmin": true, "bzpopmax": true,
+	"zrandmember": true, "zscan": true, "zunionstore": true, "zinterstore": true,
+	"zrangestore": true, "zdiffstore": true,
+	// hyperloglogs, geo and streams
+	"pfadd": true, "pfcount": true, "pfmerge": true, "geoadd": true, "geodist": true,
+	"geohash": true, "geopos": true, "georadius": true, "georadiusbymember": true,
+	"geosearch": true, "geosearchstore": true, "xadd": true, "xlen": true, "xrange": true,
+	"xrevrange": true, "xdel": true, "xtrim": true, "xack": true, "xpending": true,
+	"xclaim": true, "xautoclaim": true, "xsetid": true,
+}
+
+// isRead tells if the command reads a key, which may miss.
+func isRead(op string) bool {
+	switch op {
+	case "get", "getex", "getdel", "hget", "lindex", "getrange":
+		return true
+	}
+	return false
+}
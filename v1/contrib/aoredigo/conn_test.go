@@ -0,0 +1,52 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aoredigo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeConn struct {
+	redis.Conn
+	cmds []string
+}
+
+func (c *fakeConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	c.cmds = append(c.cmds, commandName)
+	if commandName == "GET" {
+		return nil, nil
+	}
+	return "OK", nil
+}
+
+func TestConn(t *testing.T) {
+	fc := &fakeConn{}
+	conn := Wrap(fc, "redis:6379")
+
+	reply, err := conn.Do("SET", "user:42", "v")
+	assert.NoError(t, err)
+	assert.Equal(t, "OK", reply)
+
+	reply, err = conn.WithContext(context.Background()).Do("GET", "user:42")
+	assert.NoError(t, err)
+	assert.Nil(t, reply)
+
+	_, err = conn.DoContext(context.Background(), "SET", []byte("user:42"), "v")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"SET", "GET", "SET"}, fc.cmds)
+}
+
+func TestArgsKey(t *testing.T) {
+	assert.Equal(t, "user:42", argsKey("set", []interface{}{"user:42", "v"}))
+	assert.Equal(t, "user:42", argsKey("get", []interface{}{[]byte("user:42")}))
+	assert.Equal(t, "", argsKey("get", nil))
+	assert.Equal(t, "", argsKey("get", []interface{}{42}))
+	// the credentials are not reported as keys
+	assert.Equal(t, "", argsKey("auth", []interface{}{"s3cr3t"}))
+	assert.Equal(t, "", argsKey("config", []interface{}{"set", "requirepass", "s3cr3t"}))
+	assert.Equal(t, "", argsKey("hello", []interface{}{3, "AUTH", "default", "s3cr3t"}))
+}
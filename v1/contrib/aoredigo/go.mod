@@ -0,0 +1,11 @@
+module github.com/appoptics/appoptics-apm-go/v1/contrib/aoredigo
+
+go 1.14
+
+require (
+	github.com/appoptics/appoptics-apm-go v1.14.0
+	github.com/gomodule/redigo v1.8.9
+	github.com/stretchr/testify v1.7.0
+)
+
+replace github.com/appoptics/appoptics-apm-go => ../../..
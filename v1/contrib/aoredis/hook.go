@@ -0,0 +1,169 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aoredis traces the commands of the go-redis clients. Each command,
+// or pipeline of commands, is reported as a cache span with its operation
+// (KVOp), key (KVKey) and the address of the Redis server (RemoteHost):
+//
+//	rdb := redis.NewClient(opt)
+//	rdb.AddHook(aoredis.NewHook(opt.Addr))
+//	val, err := rdb.Get(ctx, "user:42").Result() // traced if ctx has a trace
+package aoredis
+
+import (
+	"context"
+	"strings"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/go-redis/redis/v8"
+)
+
+const spanName = "redis"
+
+// opPipeline is the KVOp of a pipeline, whose commands are reported by KVOps.
+const opPipeline = "pipeline"
+
+type spanKey struct{}
+
+// Hook is a redis.Hook which traces the commands.
+type Hook struct {
+	remoteHost string
+}
+
+var _ redis.Hook = (*Hook)(nil)
+
+// NewHook returns a Hook of the client of the Redis server at remoteHost.
+func NewHook(remoteHost string) *Hook {
+	return &Hook{remoteHost: remoteHost}
+}
+
+func (h *Hook) beginSpan(ctx context.Context, op, key string, args ...interface{}) context.Context {
+	span, ctx := ao.BeginSpan(ctx, spanName, append([]interface{}{
+		"Spec", "cache",
+		"KVOp", op,
+		"KVKey", key,
+		"RemoteHost", h.remoteHost,
+	}, args...)...)
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+func endSpan(ctx context.Context, err error, args ...interface{}) {
+	span, ok := ctx.Value(spanKey{}).(ao.Span)
+	if !ok {
+		return
+	}
+	if err != nil && err != redis.Nil {
+		span.Err(err)
+	}
+	span.End(args...)
+}
+
+// BeforeProcess starts the span of a command.
+func (h *Hook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return h.beginSpan(ctx, cmd.Name(), cmdKey(cmd)), nil
+}
+
+// AfterProcess ends the span of a command. A nil reply (redis.Nil) is not an
+// error but a miss of the key, which is reported by KVHit for the reads.
+func (h *Hook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	var args []interface{}
+	if isRead(cmd.Name()) {
+		args = append(args, "KVHit", cmd.Err() != redis.Nil)
+	}
+	endSpan(ctx, cmd.Err(), args...)
+	return nil
+}
+
+// BeforeProcessPipeline starts the span of a pipeline.
+func (h *Hook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	var key string
+	names := make([]string, 0, len(cmds))
+	for _, cmd := range cmds {
+		names = append(names, cmd.Name())
+		if key == "" {
+			key = cmdKey(cmd)
+		}
+	}
+	return h.beginSpan(ctx, opPipeline, key, "KVOps", strings.Join(names, ",")), nil
+}
+
+// AfterProcessPipeline ends the span of a pipeline, which fails with the first
+// failed command.
+func (h *Hook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if e := cmd.Err(); e != nil && e != redis.Nil {
+			err = e
+			break
+		}
+	}
+	endSpan(ctx, err)
+	return nil
+}
+
+// cmdKey returns the key of the command, which is its first argument after the
+// command name, if any and the command is one of keyCommands.
+func cmdKey(cmd redis.Cmder) string {
+	if !keyCommands[strings.ToLower(cmd.Name())] {
+		return ""
+	}
+	if args := cmd.Args(); len(args) > 1 {
+		if key, ok := args[1].(string); ok {
+			return key
+		}
+	}
+	return ""
+}
+
+// keyCommands are the commands whose first argument is a key. The key of the
+// other commands is not reported, as their first argument may be anything, e.g.,
+// the password of AUTH or CONFIG SET requirepass.
+var keyCommands = map[string]bool{
+	// strings
+	"get": true, "set": true, "setnx": true, "setex": true, "psetex": true, "getset": true,
+	"getex": true, "getdel": true, "append": true, "strlen": true, "incr": true, "incrby": true,
+	"incrbyfloat": true, "decr": true, "decrby": true, "getrange": true, "setrange": true,
+	"mget": true, "mset": true, "msetnx": true, "getbit": true, "setbit": true, "bitcount": true,
+	"bitpos": true, "bitfield": true,
+	// generic
+	"del": true, "unlink": true, "exists": true, "expire": true, "expireat": true, "pexpire": true,
+	"pexpireat": true, "persist": true, "ttl": true, "pttl": true, "type": true, "rename": true,
+	"renamenx": true, "dump": true, "restore": true, "touch": true, "move": true, "copy": true,
+	"sort": true, "watch": true,
+	// hashes
+	"hget": true, "hset": true, "hsetnx": true, "hmset": true, "hmget": true, "hdel": true,
+	"hexists": true, "hgetall": true, "hkeys": true, "hvals": true, "hlen": true, "hincrby": true,
+	"hincrbyfloat": true, "hstrlen": true, "hscan": true, "hrandfield": true,
+	// lists
+	"lpush": true, "rpush": true, "lpushx": true, "rpushx": true, "lpop": true, "rpop": true,
+	"llen": true, "lrange": true, "lindex": true, "lset": true, "linsert": true, "lrem": true,
+	"ltrim": true, "rpoplpush": true, "lmove": true, "lpos": true, "blpop": true, "brpop": true,
+	"brpoplpush": true, "blmove": true,
+	// sets
+	"sadd": true, "srem": true, "smembers": true, "sismember": true, "smismember": true,
+	"scard": true, "spop": true, "srandmember": true, "smove": true, "sinter": true,
+	"sunion": true, "sdiff": true, "sinterstore": true, "sunionstore": true, "sdiffstore": true,
+	"sscan": true,
+	// sorted sets
+	"zadd": true, "zrem": true, "zscore": true, "zmscore": true, "zincrby": true, "zcard": true,
+	"zcount": true, "zrange": true, "zrangebyscore": true, "zrevrange": true,
+	"zrevrangebyscore": true, "zrank": true, "zrevrank": true, "zremrangebyrank": true,
+	"zremrangebyscore": true, "zrangebylex": true, "zrevrangebylex": true, "zlexcount": true,
+	"zremrangebylex": true, "zpopmin": true, "zpopmax": true, "bzpopmin": true, "bzpopmax": true,
+	"zrandmember": true, "zscan": true, "zunionstore": true, "zinterstore": true,
+	"zrangestore": true, "zdiffstore": true,
+	// hyperloglogs, geo and streams
+	"pfadd": true, "pfcount": true, "pfmerge": true, "geoadd": true, "geodist": true,
+	"geohash": true, "geopos": true, "georadius": true, "georadiusbymember": true,
+	"geosearch": true, "geosearchstore": true, "xadd": true, "xlen": true, "xrange": true,
+	"xrevrange": true, "xdel": true, "xtrim": true, "xack": true, "xpending": true,
+	"xclaim": true, "xautoclaim": true, "xsetid": true,
+}
+
+// isRead tells if the command reads a key, which may miss.
+func isRead(name string) bool {
+	switch name {
+	case "get", "getex", "getdel", "hget", "mget", "hmget", "lindex", "getrange":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aoredis
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCmdKey(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, "user:42", cmdKey(redis.NewStringCmd(ctx, "get", "user:42")))
+	assert.Equal(t, "", cmdKey(redis.NewStatusCmd(ctx, "ping")))
+	assert.Equal(t, "", cmdKey(redis.NewStringCmd(ctx, "get", 42)))
+	// the credentials are not reported as keys
+	assert.Equal(t, "", cmdKey(redis.NewStatusCmd(ctx, "auth", "s3cr3t")))
+	assert.Equal(t, "", cmdKey(redis.NewStatusCmd(ctx, "config", "set", "requirepass", "s3cr3t")))
+	assert.Equal(t, "", cmdKey(redis.NewSliceCmd(ctx, "hello", 3, "auth", "default", "s3cr3t")))
+}
+
+func TestHookWithoutTrace(t *testing.T) {
+	h := NewHook("redis:6379")
+	ctx := context.Background()
+
+	cmd := redis.NewStringCmd(ctx, "get", "user:42")
+	spanCtx, err := h.BeforeProcess(ctx, cmd)
+	assert.NoError(t, err)
+	cmd.SetErr(redis.Nil)
+	assert.NoError(t, h.AfterProcess(spanCtx, cmd))
+
+	cmds := []redis.Cmder{redis.NewStatusCmd(ctx, "set", "k", "v"), redis.NewStringCmd(ctx, "get", "k")}
+	spanCtx, err = h.BeforeProcessPipeline(ctx, cmds)
+	assert.NoError(t, err)
+	cmds[0].SetErr(errors.New("failed"))
+	assert.NoError(t, h.AfterProcessPipeline(spanCtx, cmds))
+}
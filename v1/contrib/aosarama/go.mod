@@ -0,0 +1,11 @@
+module github.com/appoptics/appoptics-apm-go/v1/contrib/aosarama
+
+go 1.14
+
+require (
+	github.com/Shopify/sarama v1.30.0
+	github.com/appoptics/appoptics-apm-go v1.14.0
+	github.com/stretchr/testify v1.7.0
+)
+
+replace github.com/appoptics/appoptics-apm-go => ../../..
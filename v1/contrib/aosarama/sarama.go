@@ -0,0 +1,188 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aosarama provides AppOptics tracing for the Kafka producers and
+// consumers of Sarama (github.com/Shopify/sarama). The trace context is carried
+// in the record headers, which require Kafka 0.11 or later, so the Version of
+// the sarama.Config should be at least sarama.V0_11_0_0.
+//
+// To trace the producer:
+//   producer, _ := sarama.NewSyncProducer(brokers, config)
+//   p := aosarama.WrapSyncProducer(producer, brokers)
+//   partition, offset, err := p.SendMessage(ctx, &sarama.ProducerMessage{
+//       Topic: "orders", Value: sarama.StringEncoder(order)})
+//
+// To trace the consumer, e.g., in the ConsumeClaim method of a
+// sarama.ConsumerGroupHandler:
+//   for msg := range claim.Messages() {
+//       aosarama.HandleMessage("billing", msg,
+//           func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+//               // process the message, the trace is bound to ctx
+//               return nil
+//           })
+//       sess.MarkMessage(msg, "")
+//   }
+//
+// The messages sent by an AsyncProducer can be traced by adding the X-Trace ID
+// of a span to them with SetHeader.
+package aosarama
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+)
+
+const (
+	producerSpanName = "kafka-producer"
+	consumerSpanName = "kafka-consumer"
+	flavor           = "kafka"
+)
+
+// HeaderKey is the key of the record header carrying the trace context.
+const HeaderKey = "x-trace"
+
+// SyncProducer traces the messages sent by a sarama.SyncProducer.
+type SyncProducer struct {
+	producer   sarama.SyncProducer
+	remoteHost string
+}
+
+// WrapSyncProducer wraps the producer, brokers are the addresses of the Kafka
+// brokers reported as the remote host.
+func WrapSyncProducer(producer sarama.SyncProducer, brokers []string) *SyncProducer {
+	return &SyncProducer{producer: producer, remoteHost: strings.Join(brokers, ",")}
+}
+
+// SendMessage sends the message in a span, with the trace context added to its
+// headers.
+func (p *SyncProducer) SendMessage(ctx context.Context, msg *sarama.ProducerMessage) (
+	partition int32, offset int64, err error) {
+	span, _ := ao.BeginSpan(ctx, producerSpanName,
+		"Spec", "pushq",
+		"Flavor", flavor,
+		"Op", "publish",
+		"Topic", msg.Topic,
+		"RemoteHost", p.remoteHost)
+	defer span.End()
+
+	SetHeader(msg, span.MetadataString())
+	partition, offset, err = p.producer.SendMessage(msg)
+	if err != nil {
+		span.Err(err)
+	} else {
+		span.AddEndArgs("Partition", partition, "Offset", offset)
+	}
+	return partition, offset, err
+}
+
+// SendMessages sends the batch of messages in one span, with the trace context
+// added to the headers of each of them.
+func (p *SyncProducer) SendMessages(ctx context.Context, msgs []*sarama.ProducerMessage) error {
+	span, _ := ao.BeginSpan(ctx, producerSpanName,
+		"Spec", "pushq",
+		"Flavor", flavor,
+		"Op", "publish",
+		"Topic", topics(msgs),
+		"MessageCount", len(msgs),
+		"RemoteHost", p.remoteHost)
+	defer span.End()
+
+	xt := span.MetadataString()
+	for _, msg := range msgs {
+		SetHeader(msg, xt)
+	}
+	err := p.producer.SendMessages(msgs)
+	if err != nil {
+		span.Err(err)
+	}
+	return err
+}
+
+// Close closes the underlying producer.
+func (p *SyncProducer) Close() error {
+	return p.producer.Close()
+}
+
+// topics returns the distinct topics of the messages separated by commas.
+func topics(msgs []*sarama.ProducerMessage) string {
+	seen := make(map[string]struct{})
+	var names []string
+	for _, msg := range msgs {
+		if _, ok := seen[msg.Topic]; !ok {
+			seen[msg.Topic] = struct{}{}
+			names = append(names, msg.Topic)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// SetHeader adds the X-Trace ID to the headers of the message, replacing the
+// one added before, e.g., when a message is retried. The message is unchanged
+// if xTraceID is empty.
+func SetHeader(msg *sarama.ProducerMessage, xTraceID string) {
+	if xTraceID == "" {
+		return
+	}
+	for i := range msg.Headers {
+		if string(msg.Headers[i].Key) == HeaderKey {
+			msg.Headers[i].Value = []byte(xTraceID)
+			return
+		}
+	}
+	msg.Headers = append(msg.Headers, sarama.RecordHeader{
+		Key:   []byte(HeaderKey),
+		Value: []byte(xTraceID),
+	})
+}
+
+// Header returns the X-Trace ID in the headers of the message, or an empty
+// string if the message has no trace context.
+func Header(msg *sarama.ConsumerMessage) string {
+	for _, h := range msg.Headers {
+		if h != nil && string(h.Key) == HeaderKey {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// HandleMessage starts a trace for the message consumed by the consumer group,
+// continuing the producer's trace if the context is found in its headers. The
+// group can be empty for the messages not consumed by a group. The handler is
+// called with the context bound to the trace and any error it returns is
+// reported and returned.
+func HandleMessage(group string, msg *sarama.ConsumerMessage,
+	handler func(ctx context.Context, msg *sarama.ConsumerMessage) error) error {
+	t := ao.NewTraceFromID(consumerSpanName, Header(msg), func() ao.KVMap {
+		kvs := ao.KVMap{
+			"Spec":      "pushq",
+			"Flavor":    flavor,
+			"Op":        "consume",
+			"Topic":     msg.Topic,
+			"Partition": msg.Partition,
+			"Offset":    msg.Offset,
+		}
+		if group != "" {
+			kvs["ConsumerGroup"] = group
+		}
+		return kvs
+	})
+	if group != "" {
+		t.SetTransactionName(msg.Topic + "." + group)
+	} else {
+		t.SetTransactionName(msg.Topic)
+	}
+	t.SetTransactionType(ao.TransactionTypeConsumer)
+	ctx := ao.NewContext(context.Background(), t)
+
+	err := handler(ctx, msg)
+	if err != nil {
+		t.Err(err)
+	}
+	t.End()
+	return err
+}
@@ -0,0 +1,92 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aosarama
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockProducer struct {
+	sarama.SyncProducer
+	msgs []*sarama.ProducerMessage
+	err  error
+}
+
+func (m *mockProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	m.msgs = append(m.msgs, msg)
+	return 1, int64(len(m.msgs)), m.err
+}
+
+func (m *mockProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	m.msgs = append(m.msgs, msgs...)
+	return m.err
+}
+
+func (m *mockProducer) Close() error { return nil }
+
+// consumed converts a produced message to the one received by the consumer.
+func consumed(msg *sarama.ProducerMessage) *sarama.ConsumerMessage {
+	c := &sarama.ConsumerMessage{Topic: msg.Topic, Partition: 1, Offset: 1}
+	for i := range msg.Headers {
+		c.Headers = append(c.Headers, &msg.Headers[i])
+	}
+	return c
+}
+
+func TestSetHeader(t *testing.T) {
+	xt := "2BF4CAA9BB8AF02BD4B1EC4F8CDB0C9A36C3B0BF34F8E67D9B6846A2C101"
+	msg := &sarama.ProducerMessage{Topic: "orders", Headers: []sarama.RecordHeader{
+		{Key: []byte("k"), Value: []byte("v")}}}
+
+	SetHeader(msg, "")
+	assert.Len(t, msg.Headers, 1)
+	assert.Empty(t, Header(consumed(msg)))
+
+	SetHeader(msg, "stale")
+	SetHeader(msg, xt)
+	assert.Len(t, msg.Headers, 2)
+	assert.Equal(t, xt, Header(consumed(msg)))
+
+	assert.Empty(t, Header(&sarama.ConsumerMessage{Headers: []*sarama.RecordHeader{nil}}))
+}
+
+func TestTopics(t *testing.T) {
+	assert.Equal(t, "", topics(nil))
+	assert.Equal(t, "a,b", topics([]*sarama.ProducerMessage{{Topic: "b"}, {Topic: "a"}, {Topic: "b"}}))
+}
+
+func TestProducerAndConsumer(t *testing.T) {
+	mp := &mockProducer{}
+	p := WrapSyncProducer(mp, []string{"kafka-1:9092", "kafka-2:9092"})
+	ctx := context.Background()
+
+	partition, offset, err := p.SendMessage(ctx, &sarama.ProducerMessage{Topic: "orders"})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, partition)
+	assert.EqualValues(t, 1, offset)
+	assert.NoError(t, p.SendMessages(ctx, []*sarama.ProducerMessage{{Topic: "orders"}, {Topic: "refunds"}}))
+	assert.Len(t, mp.msgs, 3)
+
+	var got *sarama.ConsumerMessage
+	msg := consumed(mp.msgs[0])
+	err = HandleMessage("billing", msg, func(ctx context.Context, m *sarama.ConsumerMessage) error {
+		got = m
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, msg, got)
+
+	errFailed := errors.New("failed")
+	mp.err = errFailed
+	_, _, err = p.SendMessage(ctx, &sarama.ProducerMessage{Topic: "orders"})
+	assert.Equal(t, errFailed, err)
+	assert.Equal(t, errFailed, p.SendMessages(ctx, []*sarama.ProducerMessage{{Topic: "orders"}}))
+	assert.Equal(t, errFailed, HandleMessage("", msg,
+		func(ctx context.Context, m *sarama.ConsumerMessage) error { return errFailed }))
+	assert.NoError(t, p.Close())
+}
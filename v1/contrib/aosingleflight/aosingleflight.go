@@ -0,0 +1,160 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aosingleflight provides a duplicate call suppression mechanism like
+// golang.org/x/sync/singleflight, which traces the shared work. The call
+// executing the function is reported as a span, and every call waiting for
+// its result is reported as a span linked to it, so the waiters don't look
+// misleadingly fast or lose track of where their time is spent:
+//   var g aosingleflight.Group
+//   v, err, shared := g.Do(ctx, "user:"+id, func(ctx context.Context) (interface{}, error) {
+//       return db.LoadUser(ctx, id)
+//   })
+package aosingleflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sync"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+)
+
+const (
+	execSpanName = "singleflight"
+	waitSpanName = "singleflight-wait"
+
+	keyKey     = "Key"
+	keyShared  = "Shared"
+	keyWaiters = "Waiters"
+)
+
+// errGoexit is the error of a call whose function called runtime.Goexit.
+var errGoexit = errors.New("runtime.Goexit was called")
+
+// panicError is the error of a call whose function panicked, which carries the
+// value and the stack of the panic to be re-panicked by all the callers.
+type panicError struct {
+	value interface{}
+	stack []byte
+}
+
+func (p *panicError) Error() string {
+	return fmt.Sprintf("%v\n\n%s", p.value, p.stack)
+}
+
+// call is an in-flight or completed Do call.
+type call struct {
+	wg sync.WaitGroup
+
+	val interface{}
+	err error
+
+	// the X-Trace ID of the span executing the function, empty if not traced
+	execID  string
+	waiters int
+}
+
+// Group represents a class of work and forms a namespace in which units of
+// work can be executed with duplicate suppression. The zero value is ready to
+// use.
+type Group struct {
+	mu sync.Mutex
+	m  map[string]*call
+}
+
+// Do executes and returns the results of fn, making sure that only one
+// execution is in-flight for a given key at a time. If a duplicate comes in,
+// the duplicate caller waits for the original to complete and receives the
+// same results. The return value shared reports whether v was given to
+// multiple callers.
+//
+// fn is called with the context of the first caller, bound to the span of the
+// execution. If fn panics, the panic is reported as the error of the span, and
+// all the callers panic with it.
+func (g *Group) Do(ctx context.Context, key string,
+	fn func(ctx context.Context) (interface{}, error)) (v interface{}, err error, shared bool) {
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+	if c, ok := g.m[key]; ok {
+		c.waiters++
+		g.mu.Unlock()
+		return g.wait(ctx, key, c)
+	}
+	c := new(call)
+	c.wg.Add(1)
+	span, spanCtx := ao.NewSpanBuilder(execSpanName).WithKVs(keyKey, key).Build(ctx)
+	c.execID = span.MetadataString()
+	g.m[key] = c
+	g.mu.Unlock()
+
+	g.doCall(spanCtx, span, key, c, fn)
+	return c.val, c.err, c.waiters > 0
+}
+
+// doCall handles the single call for a key. A panic or runtime.Goexit of fn is
+// passed on to the caller after the waiters are released.
+func (g *Group) doCall(ctx context.Context, span ao.Span, key string, c *call,
+	fn func(ctx context.Context) (interface{}, error)) {
+	normalReturn := false
+	defer func() {
+		if !normalReturn {
+			if r := recover(); r != nil {
+				c.err = &panicError{value: r, stack: debug.Stack()}
+			} else {
+				c.err = errGoexit
+			}
+		}
+		if c.err != nil {
+			span.Err(c.err)
+		}
+		g.mu.Lock()
+		if g.m[key] == c {
+			delete(g.m, key)
+		}
+		span.End(keyWaiters, c.waiters)
+		g.mu.Unlock()
+		c.wg.Done()
+
+		if e, ok := c.err.(*panicError); ok {
+			panic(e)
+		}
+		// the goroutine is exiting already on runtime.Goexit
+	}()
+
+	c.val, c.err = fn(ctx)
+	normalReturn = true
+}
+
+// wait waits for the call in-flight and reports the time spent waiting as a
+// span linked to the execution span.
+func (g *Group) wait(ctx context.Context, key string, c *call) (interface{}, error, bool) {
+	span, _ := ao.NewSpanBuilder(waitSpanName).
+		WithKVs(keyKey, key, keyShared, true).
+		WithLink(c.execID).
+		Build(ctx)
+	c.wg.Wait()
+	if c.err != nil {
+		span.Err(c.err)
+	}
+	span.End()
+	if e, ok := c.err.(*panicError); ok {
+		panic(e)
+	} else if c.err == errGoexit {
+		runtime.Goexit()
+	}
+	return c.val, c.err, true
+}
+
+// Forget tells the Group to forget about a key. Future calls to Do for this
+// key will call the function rather than waiting for an earlier call to
+// complete.
+func (g *Group) Forget(key string) {
+	g.mu.Lock()
+	delete(g.m, key)
+	g.mu.Unlock()
+}
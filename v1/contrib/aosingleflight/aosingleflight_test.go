@@ -0,0 +1,130 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aosingleflight
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDo(t *testing.T) {
+	var g Group
+	ctx := ao.NewContext(context.Background(), ao.NewTrace("test"))
+	v, err, shared := g.Do(ctx, "k", func(ctx context.Context) (interface{}, error) {
+		return "v", nil
+	})
+	assert.Equal(t, "v", v)
+	assert.NoError(t, err)
+	assert.False(t, shared)
+
+	errFailed := errors.New("failed")
+	_, err, _ = g.Do(ctx, "k", func(ctx context.Context) (interface{}, error) {
+		return nil, errFailed
+	})
+	assert.Equal(t, errFailed, err)
+	ao.EndTrace(ctx)
+}
+
+func TestDoShared(t *testing.T) {
+	var g Group
+	var calls int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		close(started)
+		<-release
+		return "v", nil
+	}
+
+	const n = 5
+	var wg sync.WaitGroup
+	results := make(chan bool, n)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ctx := ao.NewContext(context.Background(), ao.NewTrace("leader"))
+		_, _, shared := g.Do(ctx, "k", fn)
+		results <- shared
+		ao.EndTrace(ctx)
+	}()
+	<-started
+
+	for i := 1; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := ao.NewContext(context.Background(), ao.NewTrace("waiter"))
+			v, _, shared := g.Do(ctx, "k", fn)
+			assert.Equal(t, "v", v)
+			results <- shared
+			ao.EndTrace(ctx)
+		}()
+	}
+	// wait for all the waiters to join the call
+	for {
+		g.mu.Lock()
+		waiters := g.m["k"].waiters
+		g.mu.Unlock()
+		if waiters == n-1 {
+			break
+		}
+	}
+	close(release)
+	wg.Wait()
+	close(results)
+
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls))
+	for shared := range results {
+		assert.True(t, shared)
+	}
+}
+
+func TestDoPanic(t *testing.T) {
+	var g Group
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-release
+		panic("boom")
+	}
+
+	panics := make(chan interface{}, 2)
+	do := func() {
+		defer func() { panics <- recover() }()
+		g.Do(context.Background(), "k", fn)
+	}
+	go do()
+	<-started
+	go do()
+	for {
+		g.mu.Lock()
+		waiters := g.m["k"].waiters
+		g.mu.Unlock()
+		if waiters == 1 {
+			break
+		}
+	}
+	close(release)
+
+	// both the caller and the waiter panic
+	for i := 0; i < 2; i++ {
+		p, ok := (<-panics).(*panicError)
+		if assert.True(t, ok) {
+			assert.Equal(t, "boom", p.value)
+		}
+	}
+	// the key is released
+	v, err, _ := g.Do(context.Background(), "k", func(ctx context.Context) (interface{}, error) {
+		return "v", nil
+	})
+	assert.Equal(t, "v", v)
+	assert.NoError(t, err)
+}
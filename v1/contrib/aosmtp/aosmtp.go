@@ -0,0 +1,80 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aosmtp provides AppOptics tracing for sending emails, either by
+// net/smtp or by the client of a mail service. Each email sent is reported as
+// an exit span with the host of the mail server and the size of the message,
+// and counted by MetricName tagged with the provider and the result. No email
+// addresses are reported.
+//   err := aosmtp.SendMail(ctx, "smtp.example.com:587", auth, from, to, msg)
+//
+// To trace other mail libraries:
+//   err := aosmtp.Send(ctx, "sendgrid", "api.sendgrid.com", len(body), func() error {
+//       _, err := client.Send(message)
+//       return err
+//   })
+package aosmtp
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+)
+
+// MetricName is the name of the measurement counting the emails sent, tagged
+// with the Provider and the Status, which is either "sent" or "failed".
+const MetricName = "mail.send"
+
+// ProviderSMTP is the provider of the emails sent by SendMail.
+const ProviderSMTP = "smtp"
+
+const (
+	spanName = "mail"
+
+	keyMessageSize    = "MessageSize"
+	keyRecipientCount = "RecipientCount"
+)
+
+// SendMail sends the message by smtp.SendMail and traces it as a child span of
+// the span bound to ctx.
+func SendMail(ctx context.Context, addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+	return traceSend(ctx, ProviderSMTP, hostOf(addr), len(msg),
+		[]interface{}{keyRecipientCount, len(to)},
+		func() error { return smtpSendMail(addr, a, from, to, msg) })
+}
+
+// Send traces sending an email of size bytes to the provider's server host by
+// the send function, and returns the error returned by it.
+func Send(ctx context.Context, provider, host string, size int, send func() error) error {
+	return traceSend(ctx, provider, host, size, nil, send)
+}
+
+// smtpSendMail is replaced by the tests.
+var smtpSendMail = smtp.SendMail
+
+func traceSend(ctx context.Context, provider, host string, size int, args []interface{}, fn func() error) error {
+	args = append([]interface{}{keyMessageSize, size}, args...)
+	span := ao.BeginRPCSpan(ctx, spanName, provider, "send", host, args...)
+	err := fn()
+	status := "sent"
+	if err != nil {
+		status = "failed"
+		span.Err(err)
+	}
+	span.End()
+
+	ao.IncrementMetric(MetricName, ao.MetricOptions{
+		Count: 1,
+		Tags:  map[string]string{"Provider": provider, "Status": status},
+	})
+	return err
+}
+
+// hostOf strips the port from the SMTP server address.
+func hostOf(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,53 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aosmtp
+
+import (
+	"context"
+	"errors"
+	"net/smtp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendMail(t *testing.T) {
+	var sentTo []string
+	errFailed := errors.New("554 rejected")
+	smtpSendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		assert.Equal(t, "smtp.example.com:587", addr)
+		sentTo = to
+		if from == "bad@example.com" {
+			return errFailed
+		}
+		return nil
+	}
+	defer func() { smtpSendMail = smtp.SendMail }()
+
+	ctx := context.Background()
+	to := []string{"a@example.com", "b@example.com"}
+	assert.NoError(t, SendMail(ctx, "smtp.example.com:587", nil, "ops@example.com", to, []byte("hi")))
+	assert.Equal(t, to, sentTo)
+	assert.Equal(t, errFailed, SendMail(ctx, "smtp.example.com:587", nil, "bad@example.com", to, nil))
+}
+
+func TestSend(t *testing.T) {
+	ctx := context.Background()
+	called := false
+	assert.NoError(t, Send(ctx, "sendgrid", "api.sendgrid.com", 10, func() error {
+		called = true
+		return nil
+	}))
+	assert.True(t, called)
+
+	errFailed := errors.New("failed")
+	assert.Equal(t, errFailed, Send(ctx, "sendgrid", "api.sendgrid.com", 10, func() error {
+		return errFailed
+	}))
+}
+
+func TestHostOf(t *testing.T) {
+	assert.Equal(t, "smtp.example.com", hostOf("smtp.example.com:25"))
+	assert.Equal(t, "::1", hostOf("[::1]:25"))
+	assert.Equal(t, "smtp.example.com", hostOf("smtp.example.com"))
+}
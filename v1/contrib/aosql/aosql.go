@@ -0,0 +1,173 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aosql traces the queries issued through database/sql. Each query is
+// reported as a query span, and an Enricher registered for the database flavor
+// may add engine-specific KVs to it, e.g., the server version or query ID.
+//
+//	sqlDB, _ := sql.Open("mysql", dsn)
+//	db := aosql.Wrap(sqlDB, aosql.MySQL, "db.internal:3306")
+//	rows, err := db.QueryContext(ctx, "SELECT * FROM users WHERE id = ?", id)
+package aosql
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+)
+
+// The database flavors with built-in enrichers. The flavor is also used to pick
+// the SQL sanitizing rules.
+const (
+	MySQL      = "mysql"
+	PostgreSQL = "postgresql"
+	SQLServer  = "sqlserver"
+	ClickHouse = "clickhouse"
+)
+
+const spanName = "sql"
+
+// the timeout of Enricher.ServerKVs, which is bounded by its own context as it
+// shouldn't fail with the request which happens to trace the first query
+const serverKVsTimeout = 2 * time.Second
+
+// how long to wait before fetching the server KVs again after a failure
+var serverKVsRetryInterval = 30 * time.Second
+
+// Enricher adds engine-specific KVs to the query spans of a database flavor.
+type Enricher interface {
+	// ServerKVs returns the KVs describing the database server. It is called
+	// in the background for each wrapped DB when the first query is traced,
+	// with a context of its own rather than that of the query, and again a
+	// while later if it returns no KVs. The queries traced before it returns
+	// are reported without the server KVs.
+	ServerKVs(ctx context.Context, db *sql.DB) []interface{}
+	// QueryKVs returns the KVs specific to a query. It is called for each
+	// traced query.
+	QueryKVs(ctx context.Context, query string) []interface{}
+}
+
+var enrichers = struct {
+	sync.RWMutex
+	m map[string]Enricher
+}{m: make(map[string]Enricher)}
+
+// RegisterEnricher registers the enricher for the database flavor, replacing
+// the existing one if any. A nil enricher removes the registration.
+func RegisterEnricher(flavor string, e Enricher) {
+	enrichers.Lock()
+	defer enrichers.Unlock()
+	if e == nil {
+		delete(enrichers.m, flavor)
+		return
+	}
+	enrichers.m[flavor] = e
+}
+
+func getEnricher(flavor string) Enricher {
+	enrichers.RLock()
+	defer enrichers.RUnlock()
+	return enrichers.m[flavor]
+}
+
+// DB wraps a *sql.DB to trace its queries. The methods not overridden here are
+// not traced.
+type DB struct {
+	*sql.DB
+	flavor     string
+	remoteHost string
+
+	serverKVs atomic.Value // []interface{}, once fetched
+	serverMu  sync.Mutex
+	fetching  bool      // protected by serverMu
+	lastFetch time.Time // protected by serverMu
+}
+
+// Wrap returns a DB tracing the queries to the database of the flavor at
+// remoteHost.
+func Wrap(db *sql.DB, flavor, remoteHost string) *DB {
+	return &DB{DB: db, flavor: flavor, remoteHost: remoteHost}
+}
+
+// beginSpan starts the query span with the KVs from the enricher.
+func (db *DB) beginSpan(ctx context.Context, query string) ao.Span {
+	// don't bother the enricher if the request is not traced
+	if !ao.IsSampled(ctx) {
+		return ao.BeginQuerySpan(ctx, spanName, query, db.flavor, db.remoteHost)
+	}
+
+	var kvs []interface{}
+	if e := getEnricher(db.flavor); e != nil {
+		kvs = append(kvs, db.getServerKVs(e)...)
+		kvs = append(kvs, e.QueryKVs(ctx, query)...)
+	}
+	return ao.BeginQuerySpan(ctx, spanName, query, db.flavor, db.remoteHost, kvs...)
+}
+
+// getServerKVs returns the server KVs of the enricher, or nil if they are not
+// fetched yet, in which case it starts fetching them in the background unless
+// it's already in progress or it failed recently. The queries don't wait for
+// the server KVs.
+func (db *DB) getServerKVs(e Enricher) []interface{} {
+	if kvs, ok := db.serverKVs.Load().([]interface{}); ok {
+		return kvs
+	}
+	db.serverMu.Lock()
+	defer db.serverMu.Unlock()
+	if db.fetching || time.Since(db.lastFetch) < serverKVsRetryInterval {
+		return nil
+	}
+	db.fetching, db.lastFetch = true, time.Now()
+	go db.fetchServerKVs(e)
+	return nil
+}
+
+// fetchServerKVs fetches the server KVs of the enricher, which are kept only if
+// there are any. A canceled request must not leave the DB without the server
+// KVs, so the request context is not used.
+func (db *DB) fetchServerKVs(e Enricher) {
+	ctx, cancel := context.WithTimeout(context.Background(), serverKVsTimeout)
+	defer cancel()
+	if kvs := e.ServerKVs(ctx, db.DB); len(kvs) > 0 {
+		db.serverKVs.Store(kvs)
+	}
+	db.serverMu.Lock()
+	db.fetching = false
+	db.serverMu.Unlock()
+}
+
+func endSpan(span ao.Span, err error) {
+	if err != nil && err != sql.ErrNoRows {
+		span.Err(err)
+	}
+	span.End()
+}
+
+// QueryContext executes a query that returns rows in a query span.
+func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	span := db.beginSpan(ctx, query)
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	endSpan(span, err)
+	return rows, err
+}
+
+// QueryRowContext executes a query that returns at most one row in a query span.
+// The span ends before the row is scanned, and the errors of the query are only
+// returned by Scan, so they are not reported.
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	span := db.beginSpan(ctx, query)
+	row := db.DB.QueryRowContext(ctx, query, args...)
+	endSpan(span, nil)
+	return row
+}
+
+// ExecContext executes a query without returning any rows in a query span.
+func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	span := db.beginSpan(ctx, query)
+	res, err := db.DB.ExecContext(ctx, query, args...)
+	endSpan(span, err)
+	return res, err
+}
@@ -0,0 +1,164 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aosql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// versionDriver answers every query with a single row of its version.
+type versionDriver struct{ version string }
+
+func (d versionDriver) Open(name string) (driver.Conn, error) { return versionConn(d), nil }
+
+type versionConn versionDriver
+
+func (c versionConn) Prepare(query string) (driver.Stmt, error) { return versionStmt(c), nil }
+func (c versionConn) Close() error                              { return nil }
+func (c versionConn) Begin() (driver.Tx, error)                 { return nil, driver.ErrSkip }
+
+type versionStmt versionDriver
+
+func (s versionStmt) Close() error  { return nil }
+func (s versionStmt) NumInput() int { return -1 }
+func (s versionStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+func (s versionStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &versionRows{version: s.version}, nil
+}
+
+type versionRows struct {
+	version string
+	done    bool
+}
+
+func (r *versionRows) Columns() []string { return []string{"version"} }
+func (r *versionRows) Close() error      { return nil }
+func (r *versionRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.version
+	return nil
+}
+
+func init() {
+	sql.Register("aosql-test", versionDriver{version: "21.8.4"})
+}
+
+func TestVersionEnricher(t *testing.T) {
+	db, err := sql.Open("aosql-test", "")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	e := getEnricher(ClickHouse)
+	assert.NotNil(t, e)
+	assert.Equal(t, []interface{}{keyServerVersion, "21.8.4"}, e.ServerKVs(context.Background(), db))
+}
+
+func TestClickHouseQueryID(t *testing.T) {
+	e := getEnricher(ClickHouse)
+	ctx := context.Background()
+	assert.Nil(t, e.QueryKVs(ctx, "SELECT 1"))
+	assert.Equal(t, []interface{}{"QueryID", "q-1"}, e.QueryKVs(WithQueryID(ctx, "q-1"), "SELECT 1"))
+	assert.Nil(t, getEnricher(MySQL).QueryKVs(WithQueryID(ctx, "q-1"), "SELECT 1"))
+}
+
+type countingEnricher struct{ server, query int }
+
+func (e *countingEnricher) ServerKVs(ctx context.Context, db *sql.DB) []interface{} {
+	e.server++
+	return nil
+}
+
+func (e *countingEnricher) QueryKVs(ctx context.Context, query string) []interface{} {
+	e.query++
+	return nil
+}
+
+// ctxEnricher fails the first calls of ServerKVs, as many as fails, and the ones
+// without a live context of their own.
+type ctxEnricher struct {
+	countingEnricher
+	calls int32
+	fails int32
+}
+
+func (e *ctxEnricher) ServerKVs(ctx context.Context, db *sql.DB) []interface{} {
+	n := atomic.AddInt32(&e.calls, 1)
+	if _, ok := ctx.Deadline(); !ok || ctx.Err() != nil || n <= e.fails {
+		return nil
+	}
+	return []interface{}{keyServerVersion, "1.0"}
+}
+
+func TestGetServerKVs(t *testing.T) {
+	sqlDB, err := sql.Open("aosql-test", "")
+	assert.NoError(t, err)
+	defer sqlDB.Close()
+	db := Wrap(sqlDB, "custom", "localhost")
+
+	// fetched once in the background with a live context of its own
+	e := &ctxEnricher{}
+	assert.Nil(t, db.getServerKVs(e))
+	assert.Eventually(t, func() bool { return db.getServerKVs(e) != nil }, time.Second, time.Millisecond)
+	assert.Equal(t, []interface{}{keyServerVersion, "1.0"}, db.getServerKVs(e))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&e.calls))
+}
+
+func TestGetServerKVsRetry(t *testing.T) {
+	sqlDB, err := sql.Open("aosql-test", "")
+	assert.NoError(t, err)
+	defer sqlDB.Close()
+	db := Wrap(sqlDB, "custom", "localhost")
+
+	// not retried until the interval has passed
+	e := &ctxEnricher{fails: 1}
+	assert.Nil(t, db.getServerKVs(e))
+	assert.Eventually(t, func() bool {
+		db.serverMu.Lock()
+		defer db.serverMu.Unlock()
+		return !db.fetching
+	}, time.Second, time.Millisecond)
+	assert.Nil(t, db.getServerKVs(e))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&e.calls))
+
+	defer func(d time.Duration) { serverKVsRetryInterval = d }(serverKVsRetryInterval)
+	serverKVsRetryInterval = 0
+	assert.Eventually(t, func() bool { return db.getServerKVs(e) != nil }, time.Second, time.Millisecond)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&e.calls))
+}
+
+func TestRegisterEnricher(t *testing.T) {
+	e := &countingEnricher{}
+	RegisterEnricher("custom", e)
+	defer RegisterEnricher("custom", nil)
+	assert.Equal(t, e, getEnricher("custom"))
+
+	sqlDB, err := sql.Open("aosql-test", "")
+	assert.NoError(t, err)
+	defer sqlDB.Close()
+	db := Wrap(sqlDB, "custom", "localhost")
+
+	// the enricher is not called if the request is not traced
+	rows, err := db.QueryContext(context.Background(), "SELECT 1")
+	assert.NoError(t, err)
+	rows.Close()
+	_, err = db.ExecContext(context.Background(), "DELETE FROM t")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, e.server)
+	assert.Equal(t, 0, e.query)
+
+	RegisterEnricher("custom", nil)
+	assert.Nil(t, getEnricher("custom"))
+}
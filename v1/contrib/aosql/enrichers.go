@@ -0,0 +1,67 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aosql
+
+import (
+	"context"
+	"database/sql"
+)
+
+// the key to report the version of the database server
+const keyServerVersion = "ServerVersion"
+
+func init() {
+	RegisterEnricher(MySQL, VersionEnricher{Query: "SELECT VERSION()"})
+	RegisterEnricher(PostgreSQL, VersionEnricher{Query: "SHOW server_version"})
+	RegisterEnricher(SQLServer, VersionEnricher{Query: "SELECT @@VERSION"})
+	RegisterEnricher(ClickHouse, clickHouseEnricher{VersionEnricher{Query: "SELECT version()"}})
+}
+
+// VersionEnricher reports the server version returned by a query. It can be
+// registered for the engines without a built-in enricher.
+type VersionEnricher struct {
+	// Query returns the server version as a single string column.
+	Query string
+}
+
+// ServerKVs queries the server version. Nothing is reported if the query fails.
+func (e VersionEnricher) ServerKVs(ctx context.Context, db *sql.DB) []interface{} {
+	var version string
+	if err := db.QueryRowContext(ctx, e.Query).Scan(&version); err != nil || version == "" {
+		return nil
+	}
+	return []interface{}{keyServerVersion, version}
+}
+
+// QueryKVs reports nothing.
+func (e VersionEnricher) QueryKVs(ctx context.Context, query string) []interface{} {
+	return nil
+}
+
+type queryIDKey struct{}
+
+// WithQueryID returns a copy of ctx carrying the query ID, which is reported by
+// the ClickHouse enricher. It should be the same ID the query is sent with, e.g.,
+// using clickhouse.WithQueryID of clickhouse-go.
+func WithQueryID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, queryIDKey{}, id)
+}
+
+// QueryIDFromContext returns the query ID set by WithQueryID.
+func QueryIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(queryIDKey{}).(string)
+	return id
+}
+
+// clickHouseEnricher reports the server version and the query ID, which links
+// the span to the entry in ClickHouse's system.query_log.
+type clickHouseEnricher struct {
+	VersionEnricher
+}
+
+func (e clickHouseEnricher) QueryKVs(ctx context.Context, query string) []interface{} {
+	if id := QueryIDFromContext(ctx); id != "" {
+		return []interface{}{"QueryID", id}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aossh provides AppOptics tracing for the remote commands run over SSH
+// sessions, e.g., *ssh.Session of golang.org/x/crypto/ssh. Each command is
+// reported as an exit span with the target host and the exit status:
+//   session, err := client.NewSession()
+//   if err != nil {
+//       return err
+//   }
+//   defer session.Close()
+//   out, err := aossh.Wrap(session, "deploy-1:22").Output(ctx, "systemctl restart app")
+//
+// Only the name of the command, i.e., its first word, is reported, as the
+// arguments may contain credentials.
+package aossh
+
+import (
+	"context"
+	"strings"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+)
+
+const (
+	spanName = "ssh"
+	protocol = "ssh"
+
+	keyExitStatus = "ExitStatus"
+)
+
+// Session is the subset of the methods of *ssh.Session used to run commands.
+type Session interface {
+	Run(cmd string) error
+	Output(cmd string) ([]byte, error)
+	CombinedOutput(cmd string) ([]byte, error)
+}
+
+// exitStatuser is implemented by *ssh.ExitError, which is returned when the
+// remote command exits with a non-zero status.
+type exitStatuser interface {
+	ExitStatus() int
+}
+
+// TracedSession traces the commands run by the underlying Session.
+type TracedSession struct {
+	Session
+	host string
+}
+
+// Wrap returns a TracedSession running commands by the session on the remote
+// host, which is reported as the RemoteHost of the spans.
+func Wrap(session Session, host string) *TracedSession {
+	return &TracedSession{Session: session, host: host}
+}
+
+// Run runs cmd on the remote host as a child span of the span bound to ctx.
+func (s *TracedSession) Run(ctx context.Context, cmd string) error {
+	span := s.begin(ctx, cmd)
+	err := s.Session.Run(cmd)
+	end(span, err)
+	return err
+}
+
+// Output runs cmd on the remote host and returns its standard output.
+func (s *TracedSession) Output(ctx context.Context, cmd string) ([]byte, error) {
+	span := s.begin(ctx, cmd)
+	out, err := s.Session.Output(cmd)
+	end(span, err)
+	return out, err
+}
+
+// CombinedOutput runs cmd on the remote host and returns its combined standard
+// output and standard error.
+func (s *TracedSession) CombinedOutput(ctx context.Context, cmd string) ([]byte, error) {
+	span := s.begin(ctx, cmd)
+	out, err := s.Session.CombinedOutput(cmd)
+	end(span, err)
+	return out, err
+}
+
+func (s *TracedSession) begin(ctx context.Context, cmd string) ao.Span {
+	return ao.BeginRPCSpan(ctx, spanName, protocol, commandName(cmd), s.host)
+}
+
+// end reports the exit status of the command, which is unknown if the command
+// failed to start or the remote side didn't send it, and ends the span.
+func end(span ao.Span, err error) {
+	if err == nil {
+		span.AddEndArgs(keyExitStatus, 0)
+	} else {
+		if es, ok := err.(exitStatuser); ok {
+			span.AddEndArgs(keyExitStatus, es.ExitStatus())
+		}
+		span.Err(err)
+	}
+	span.End()
+}
+
+// commandName returns the first word of cmd.
+func commandName(cmd string) string {
+	fields := strings.Fields(cmd)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
@@ -0,0 +1,63 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aossh
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type exitError struct{ status int }
+
+func (e *exitError) Error() string   { return "process exited with a non-zero status" }
+func (e *exitError) ExitStatus() int { return e.status }
+
+type fakeSession struct {
+	cmds []string
+	err  error
+}
+
+func (s *fakeSession) Run(cmd string) error {
+	s.cmds = append(s.cmds, cmd)
+	return s.err
+}
+
+func (s *fakeSession) Output(cmd string) ([]byte, error) {
+	s.cmds = append(s.cmds, cmd)
+	return []byte("out"), s.err
+}
+
+func (s *fakeSession) CombinedOutput(cmd string) ([]byte, error) {
+	s.cmds = append(s.cmds, cmd)
+	return []byte("out+err"), s.err
+}
+
+func TestTracedSession(t *testing.T) {
+	ctx := context.Background()
+	fs := &fakeSession{}
+	s := Wrap(fs, "host:22")
+
+	assert.NoError(t, s.Run(ctx, "uptime"))
+	out, err := s.Output(ctx, "cat /etc/hostname")
+	assert.NoError(t, err)
+	assert.Equal(t, "out", string(out))
+	out, err = s.CombinedOutput(ctx, "ls -l")
+	assert.NoError(t, err)
+	assert.Equal(t, "out+err", string(out))
+	assert.Equal(t, []string{"uptime", "cat /etc/hostname", "ls -l"}, fs.cmds)
+
+	fs.err = &exitError{status: 3}
+	assert.Equal(t, fs.err, s.Run(ctx, "false"))
+	fs.err = errors.New("failed to start")
+	_, err = s.Output(ctx, "missing")
+	assert.Equal(t, fs.err, err)
+}
+
+func TestCommandName(t *testing.T) {
+	assert.Equal(t, "systemctl", commandName("  systemctl restart app"))
+	assert.Equal(t, "uptime", commandName("uptime"))
+	assert.Equal(t, "", commandName(" "))
+}